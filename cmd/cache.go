@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+)
+
+// newCacheCmd builds `keepup cache`, which inspects and prunes the local
+// cache-dir. A remote cache is never touched.
+func newCacheCmd(opts *runtimeOpts, stdout io.Writer) *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and prune the local cache",
+	}
+	cmd.PersistentFlags().BoolVar(&asJSON, "json", false, "Print JSON for scripting")
+	store := func(cmd *cobra.Command) (*cache.FileStore, error) {
+		if err := opts.load(cmd.OutOrStdout()); err != nil {
+			return nil, err
+		}
+		return cache.NewFileStore(opts.cfg.CacheDir()), nil
+	}
+	cmd.AddCommand(
+		newCacheLsCmd(store, stdout, &asJSON),
+		newCacheShowCmd(store, stdout, &asJSON),
+		newCacheClearCmd(store, stdout, &asJSON),
+		newCacheGCCmd(store, stdout, &asJSON),
+	)
+	return cmd
+}
+
+// localStore opens the local cache-dir of the loaded config.
+type localStore func(cmd *cobra.Command) (*cache.FileStore, error)
+
+// newCacheLsCmd builds `keepup cache ls`.
+func newCacheLsCmd(store localStore, stdout io.Writer, asJSON *bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   "ls",
+		Short: "List cached groups with their fingerprint, age, size and last duration",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			s, err := store(cmd)
+			if err != nil {
+				return err
+			}
+			entries, err := s.Entries()
+			if err != nil {
+				return err
+			}
+			if *asJSON {
+				if entries == nil {
+					entries = []cache.EntryInfo{}
+				}
+				return writeJSON(stdout, entries)
+			}
+			return printEntries(stdout, entries, time.Now())
+		},
+	}
+}
+
+// newCacheShowCmd builds `keepup cache show`.
+func newCacheShowCmd(store localStore, stdout io.Writer, asJSON *bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <group>",
+		Short: "Show a group's cache entry: its commands, output and stored files",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := store(cmd)
+			if err != nil {
+				return err
+			}
+			e, ok := s.Load(args[0])
+			if !ok {
+				return fmt.Errorf("no cache entry for group %q", args[0])
+			}
+			if *asJSON {
+				return writeJSON(stdout, e)
+			}
+			return printEntry(stdout, args[0], e)
+		},
+	}
+}
+
+// newCacheClearCmd builds `keepup cache clear`.
+func newCacheClearCmd(store localStore, stdout io.Writer, asJSON *bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   "clear [group...]",
+		Short: "Delete the entries of the given groups, or the whole cache",
+		Long: "Delete the cache entries of the given groups, or every entry and stored " +
+			"output when no group is given. Run state, durations and capture logs are kept.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := store(cmd)
+			if err != nil {
+				return err
+			}
+			var rep cache.GCReport
+			if len(args) == 0 {
+				rep, err = s.Clear()
+			} else {
+				rep, err = removeGroups(s, args)
+			}
+			if err != nil {
+				return err
+			}
+			return printReport(stdout, rep, *asJSON)
+		},
+	}
+}
+
+// removeGroups deletes the entries of groups, then the blobs only they
+// referred to.
+func removeGroups(s *cache.FileStore, groups []string) (cache.GCReport, error) {
+	rep := cache.GCReport{Removed: []string{}}
+	for _, g := range groups {
+		ok, err := s.Remove(g)
+		if err != nil {
+			return rep, err
+		}
+		if ok {
+			rep.Removed = append(rep.Removed, g)
+		}
+	}
+	gc, err := s.GC(cache.GCPolicy{})
+	if err != nil {
+		return rep, err
+	}
+	rep.Blobs, rep.Freed, rep.Kept, rep.Size = gc.Blobs, gc.Freed, gc.Kept, gc.Size
+	return rep, nil
+}
+
+// newCacheGCCmd builds `keepup cache gc`.
+func newCacheGCCmd(store localStore, stdout io.Writer, asJSON *bool) *cobra.Command {
+	var maxAge time.Duration
+	var maxSize string
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Prune stale entries and the stored outputs no entry refers to",
+		Long: "Delete the entries older than --max-age, then the oldest entries until the " +
+			"cache fits in --max-size, then every stored output no remaining entry refers to.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			p := cache.GCPolicy{MaxAge: maxAge}
+			if maxSize != "" {
+				n, err := config.ParseSize(maxSize)
+				if err != nil {
+					return fmt.Errorf("--max-size: %w", err)
+				}
+				p.MaxSize = n
+			}
+			s, err := store(cmd)
+			if err != nil {
+				return err
+			}
+			rep, err := s.GC(p)
+			if err != nil {
+				return err
+			}
+			return printReport(stdout, rep, *asJSON)
+		},
+	}
+	cmd.Flags().DurationVar(&maxAge, "max-age", 0, "Delete entries last written longer ago than this (e.g. 168h)")
+	cmd.Flags().StringVar(&maxSize, "max-size", "", "Delete the oldest entries until the cache fits (e.g. 2GB, 500MiB)")
+	return cmd
+}
+
+func printEntries(out io.Writer, entries []cache.EntryInfo, now time.Time) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tFINGERPRINT\tAGE\tSIZE\tDURATION")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Group, shortDigest(e.Fingerprint),
+			formatAge(now.Sub(e.UpdatedAt)), formatBytes(e.Size), formatMs(e.DurationMs))
+	}
+	return tw.Flush()
+}
+
+func printEntry(out io.Writer, group string, e *cache.Entry) error {
+	fmt.Fprintf(out, "group:       %s\n", group)
+	fmt.Fprintf(out, "fingerprint: %s\n", e.Fingerprint)
+	fmt.Fprintf(out, "updated:     %s\n", e.UpdatedAt.Local().Format(time.RFC3339))
+	fmt.Fprintf(out, "duration:    %s\n", formatMs(e.Result.DurationMs))
+	fmt.Fprintln(out, "commands:")
+	for _, c := range e.Commands {
+		fmt.Fprintf(out, "  %s\n", strings.Join(append([]string{c.Command}, c.Params...), " "))
+	}
+	if len(e.Artifacts) > 0 {
+		fmt.Fprintln(out, "outputs:")
+		for _, a := range e.Artifacts {
+			fmt.Fprintf(out, "  %s (%s, %s)\n", a.Path, formatBytes(a.Size), shortDigest(a.Digest))
+		}
+	}
+	fmt.Fprintln(out, "output:")
+	for _, line := range strings.Split(strings.TrimRight(e.Result.Output, "\n"), "\n") {
+		fmt.Fprintf(out, "  %s\n", line)
+	}
+	if e.Result.Truncated {
+		fmt.Fprintf(out, "  (truncated; complete output in %s)\n", e.Result.Log)
+	}
+	return nil
+}
+
+func printReport(out io.Writer, rep cache.GCReport, asJSON bool) error {
+	if asJSON {
+		return writeJSON(out, rep)
+	}
+	for _, g := range rep.Removed {
+		fmt.Fprintf(out, "removed %s\n", g)
+	}
+	fmt.Fprintf(out, "%d entries and %d stored outputs removed, %s freed; %d entries (%s) kept\n",
+		len(rep.Removed), rep.Blobs, formatBytes(rep.Freed), rep.Kept, formatBytes(rep.Size))
+	return nil
+}
+
+func writeJSON(out io.Writer, v any) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// shortDigest trims a "sha256:" digest to 12 hex digits.
+func shortDigest(d string) string {
+	hex := strings.TrimPrefix(d, "sha256:")
+	if len(hex) > 12 {
+		hex = hex[:12]
+	}
+	return hex
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatMs(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).String()
+}
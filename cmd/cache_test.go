@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cachedCfg writes a config whose two groups are cached under dir, and runs
+// its flow once to fill the cache.
+func cachedCfg(t *testing.T, dir string) string {
+	t.Helper()
+	in := filepath.ToSlash(filepath.Join(dir, "in.txt"))
+	require.NoError(t, os.WriteFile(in, []byte("x"), 0o600))
+	cfgPath := writeTempConfig(t, `
+version: 2
+settings:
+  cache-dir: `+filepath.ToSlash(filepath.Join(dir, "cache"))+`
+groups:
+  - name: build
+    command: echo
+    params: ["built"]
+    cache: {reads: ["`+in+`"]}
+  - name: test
+    command: echo
+    params: ["tested"]
+    cache: {reads: ["`+in+`"]}
+flows:
+  f:
+    steps:
+      - run: [build, test]
+`)
+	var out bytes.Buffer
+	cmd := newRootCmd(&out, &out)
+	cmd.SetArgs([]string{"run", "f", "--config", cfgPath})
+	require.NoError(t, cmd.Execute())
+	return cfgPath
+}
+
+func runCache(t *testing.T, cfgPath string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := newRootCmd(&out, &out)
+	cmd.SetArgs(append(append([]string{"cache"}, args...), "--config", cfgPath))
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestCacheCmd_LsAndShow(t *testing.T) {
+	t.Parallel()
+	cfgPath := cachedCfg(t, t.TempDir())
+
+	out, err := runCache(t, cfgPath, "ls")
+	require.NoError(t, err)
+	assert.Contains(t, out, "GROUP  FINGERPRINT")
+	assert.Regexp(t, `(?m)^build\s+[0-9a-f]{12}\s+just now\s+\d+ B\s+\S+$`, out)
+
+	out, err = runCache(t, cfgPath, "ls", "--json")
+	require.NoError(t, err)
+	var entries []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(out), &entries))
+	require.Len(t, entries, 2)
+	assert.Equal(t, "build", entries[0]["group"])
+	assert.Contains(t, entries[0], "durationMs")
+
+	out, err = runCache(t, cfgPath, "show", "test")
+	require.NoError(t, err)
+	assert.Contains(t, out, "commands:\n  echo tested\n")
+	assert.Contains(t, out, "output:\n  tested\n")
+
+	out, err = runCache(t, cfgPath, "show", "test", "--json")
+	require.NoError(t, err)
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(out), &entry))
+	assert.Equal(t, "test", entry["group"])
+
+	_, err = runCache(t, cfgPath, "show", "ghost")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `no cache entry for group "ghost"`)
+}
+
+func TestCacheCmd_ClearAndGC(t *testing.T) {
+	t.Parallel()
+	cfgPath := cachedCfg(t, t.TempDir())
+
+	out, err := runCache(t, cfgPath, "clear", "build", "ghost", "--json")
+	require.NoError(t, err)
+	var rep map[string]any
+	require.NoError(t, json.Unmarshal([]byte(out), &rep))
+	assert.Equal(t, []any{"build"}, rep["removed"])
+	assert.InDelta(t, 1, rep["kept"], 0)
+
+	out, err = runCache(t, cfgPath, "gc", "--max-age", "1h")
+	require.NoError(t, err)
+	assert.Contains(t, out, "0 entries and 0 stored outputs removed")
+
+	_, err = runCache(t, cfgPath, "gc", "--max-size", "lots")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--max-size")
+
+	out, err = runCache(t, cfgPath, "gc", "--max-size", "1")
+	require.NoError(t, err)
+	assert.Contains(t, out, "removed test\n")
+
+	out, err = runCache(t, cfgPath, "clear")
+	require.NoError(t, err)
+	assert.Contains(t, out, "0 entries and 0 stored outputs removed, 0 B freed; 0 entries (0 B) kept")
+}
@@ -36,40 +36,68 @@ func newGraphCmd(opts *runtimeOpts, stdout io.Writer) *cobra.Command {
 }
 
 // emitMermaid writes a Mermaid graph TD definition. Nodes are groups in the
-// flow; edges go from referenced group → referencing group (data direction).
+// flow, plus one node per embedded flow; edges go from referenced group →
+// referencing group (data direction), a "sub/x" reference drawing from the
+// embedded flow's node.
 // Step boundaries are not drawn — step mode and dag mode produce the same
 // data-flow picture, which is the semantically meaningful one.
 func emitMermaid(out io.Writer, flowName string, cfg *config.Config, flow *config.Flow) error {
-	members := flow.Members()
-	memberSet := make(map[string]struct{}, len(members))
-	for _, m := range members {
-		memberSet[m] = struct{}{}
-	}
-
 	if _, err := fmt.Fprintf(out, "%%%% flow: %s (mode: %s)\ngraph TD\n", flowName, flow.Mode); err != nil {
 		return err
 	}
 
 	// Declare nodes in declaration order so output is stable.
-	for _, m := range members {
-		g := cfg.GroupByName(m)
-		label := m
-		if g != nil && g.Description != "" {
-			label = fmt.Sprintf("%s<br/>%s", m, g.Description)
+	subs := make(map[string]bool)
+	for _, s := range flow.SubFlows() {
+		subs[s] = true
+	}
+	for _, m := range flow.Members() {
+		if _, err := fmt.Fprintf(out, "  %s[%q]\n", nodeID(m), nodeLabel(cfg, m, subs[m])); err != nil {
+			return err
 		}
-		if _, err := fmt.Fprintf(out, "  %s[%q]\n", nodeID(m), label); err != nil {
+	}
+	for _, e := range mermaidEdges(cfg, flow, subs) {
+		if _, err := fmt.Fprintf(out, "  %s --> %s\n", nodeID(e.from), nodeID(e.to)); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// nodeLabel is the text of member m's node: an embedded flow is labelled
+// as one, and a description goes on a second line.
+func nodeLabel(cfg *config.Config, m string, sub bool) string {
+	if sub {
+		label := "flow: " + m
+		if d := cfg.Flows[m].Description; d != "" {
+			label = fmt.Sprintf("%s<br/>%s", label, d)
+		}
+		return label
+	}
+	if g := cfg.GroupByName(m); g != nil && g.Description != "" {
+		return fmt.Sprintf("%s<br/>%s", m, g.Description)
+	}
+	return m
+}
+
+type mermaidEdge struct{ from, to string }
 
-	// Collect edges, sorted for determinism.
-	type edge struct{ from, to string }
-	edges := make([]edge, 0)
+// mermaidEdges collects the flow's data edges, sorted for determinism.
+func mermaidEdges(cfg *config.Config, flow *config.Flow, subs map[string]bool) []mermaidEdge {
+	members := flow.Members()
+	memberSet := make(map[string]struct{}, len(members))
 	for _, m := range members {
-		g := cfg.GroupByName(m)
+		memberSet[m] = struct{}{}
+	}
+	edges := make([]mermaidEdge, 0)
+	for _, m := range members {
+		if subs[m] {
+			continue // an embedded flow reads nothing from this one
+		}
 		seen := make(map[string]struct{})
-		refs, _ := config.ExtractRefs(g) // config already validated these templates
+		refs, _ := config.ExtractRefs(cfg.GroupByName(m)) // config already validated these templates
 		for _, ref := range refs {
+			ref = cfg.RefOwner(flow, ref)
 			if _, in := memberSet[ref]; !in {
 				continue
 			}
@@ -77,7 +105,7 @@ func emitMermaid(out io.Writer, flowName string, cfg *config.Config, flow *confi
 				continue
 			}
 			seen[ref] = struct{}{}
-			edges = append(edges, edge{from: ref, to: m})
+			edges = append(edges, mermaidEdge{from: ref, to: m})
 		}
 	}
 	sort.Slice(edges, func(i, j int) bool {
@@ -86,12 +114,7 @@ func emitMermaid(out io.Writer, flowName string, cfg *config.Config, flow *confi
 		}
 		return edges[i].to < edges[j].to
 	})
-	for _, e := range edges {
-		if _, err := fmt.Fprintf(out, "  %s --> %s\n", nodeID(e.from), nodeID(e.to)); err != nil {
-			return err
-		}
-	}
-	return nil
+	return edges
 }
 
 // nodeID turns a group name into a Mermaid-safe identifier. Hyphens are
@@ -9,14 +9,18 @@ import (
 	"os/signal"
 	"path/filepath"
 	"sort"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"go.yaml.in/yaml/v3"
 
+	"github.com/quike/keepup/internal/cache"
 	"github.com/quike/keepup/internal/config"
 	"github.com/quike/keepup/internal/engine"
 	"github.com/quike/keepup/internal/logger"
+	"github.com/quike/keepup/internal/plan"
 )
 
 const (
@@ -35,6 +39,9 @@ type runtimeOpts struct {
 	dryRun     bool
 	verbose    bool
 	noCache    bool
+	resume     bool
+	cacheRO    bool     // --cache-read-only
+	args       []string // raw --arg name=value pairs
 
 	cfg *config.Config
 	log logger.Logger
@@ -80,13 +87,17 @@ func newRootCmd(stdout, stderr io.Writer) *cobra.Command {
 	root.AddCommand(newListCmd(opts, stdout))
 	root.AddCommand(newValidateCmd(opts, stdout))
 	root.AddCommand(newGraphCmd(opts, stdout))
+	root.AddCommand(newCacheCmd(opts, stdout))
 	root.AddCommand(newMigrateCmd(stdout))
 	root.AddCommand(newVersionCmd())
 	return root
 }
 
 func newRunCmd(opts *runtimeOpts) *cobra.Command {
-	var eventsPath string
+	var (
+		eventsPath string
+		sel        plan.Selection
+	)
 	cmd := &cobra.Command{
 		Use:   "run [flow]",
 		Short: "Execute a flow (uses the configured default when no flow is given)",
@@ -99,10 +110,21 @@ func newRunCmd(opts *runtimeOpts) *cobra.Command {
 			if len(args) == 1 {
 				flowName = args[0]
 			}
+			flowArgs, err := parseArgFlags(opts.args)
+			if err != nil {
+				return err
+			}
+			store := cache.NewFileStore(opts.cfg.CacheDir())
 			engineOpts := []engine.Option{
 				engine.WithLogger(opts.log),
 				engine.WithDryRun(opts.dryRun || opts.cfg.Settings.DryRun),
+				engine.WithCache(opts.cacheStore(cmd.Context(), store)),
 				engine.WithNoCache(opts.noCache),
+				engine.WithRunStore(store),
+				engine.WithDurationStore(store),
+				engine.WithResume(opts.resume),
+				engine.WithSelection(sel),
+				engine.WithArgs(flowArgs),
 			}
 			if eventsPath != "" {
 				w, closeFn, err := openEventsWriter(eventsPath, cmd.OutOrStdout())
@@ -117,10 +139,62 @@ func newRunCmd(opts *runtimeOpts) *cobra.Command {
 		},
 	}
 	cmd.Flags().BoolVar(&opts.noCache, "no-cache", false, "Ignore cached results; run every group")
+	cmd.Flags().BoolVar(&opts.cacheRO, "cache-read-only", false,
+		"Fetch hits from settings.cache.remote but never upload to it")
+	cmd.Flags().BoolVar(&opts.resume, "resume", false,
+		"Resume the flow's last failed run: replay what completed and restart from the first failed step or node")
+	cmd.Flags().StringSliceVar(&sel.Only, "only", nil, "Run only these groups of the flow (repeatable or comma-separated)")
+	cmd.Flags().StringVar(&sel.From, "from", "", "Run this group and everything scheduled after it")
+	cmd.Flags().StringVar(&sel.Until, "until", "", "Run this group and everything scheduled before it")
+	cmd.Flags().StringSliceVar(&sel.Targets, "target", nil,
+		"Run these groups plus the groups whose outputs they transitively reference")
 	cmd.Flags().StringVar(&eventsPath, "events", "", "Write a JSON event stream to this file ('-' for stdout)")
+	addArgFlag(cmd, opts)
 	return cmd
 }
 
+// cacheStore returns the cache the engine runs with: local alone, or local
+// in front of settings.cache.remote. Remote requests end with ctx, and a
+// remote that stops answering is logged once and the run carries on
+// local-only.
+func (o *runtimeOpts) cacheStore(ctx context.Context, local *cache.FileStore) cache.Store {
+	r := o.cfg.Settings.Cache.Remote
+	if r == nil {
+		return local
+	}
+	timeout, _ := time.ParseDuration(r.Timeout)
+	var token string
+	if r.TokenEnv != "" {
+		token = os.Getenv(r.TokenEnv)
+	}
+	remote := cache.NewHTTPCache(r.URL, cache.HTTPOptions{Namespace: r.Namespace, Token: token, Timeout: timeout})
+	return cache.NewTiered(ctx, local, remote, r.ReadOnly || o.cacheRO, func(err error) {
+		o.log.Warn("remote cache unavailable; continuing with the local cache", "url", r.URL, "error", err)
+	})
+}
+
+// addArgFlag registers the repeatable --arg flag shared by run and watch.
+func addArgFlag(cmd *cobra.Command, opts *runtimeOpts) {
+	cmd.Flags().StringArrayVar(&opts.args, "arg", nil, "Set one of the flow's args as name=value (repeatable)")
+}
+
+// parseArgFlags turns repeated --arg name=value flags into a map. Values are
+// checked against the flow's declarations by the engine.
+func parseArgFlags(raw []string) (map[string]string, error) {
+	out := make(map[string]string, len(raw))
+	for _, kv := range raw {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("--arg %q: expected name=value", kv)
+		}
+		if _, dup := out[name]; dup {
+			return nil, fmt.Errorf("--arg %q is given more than once", name)
+		}
+		out[name] = value
+	}
+	return out, nil
+}
+
 // openEventsWriter resolves the --events target: "-" means stdout, otherwise a
 // file (truncated). The returned closer is a no-op for stdout.
 func openEventsWriter(path string, stdout io.Writer) (io.Writer, func(), error) {
@@ -191,10 +265,33 @@ func printFlows(out io.Writer, cfg *config.Config) error {
 			desc = noDescription
 		}
 		fmt.Fprintf(out, "%s %-20s [%s] %s\n", marker, n, f.Mode, desc)
+		for i := range f.Args {
+			printArg(out, &f.Args[i])
+		}
 	}
 	return nil
 }
 
+// printArg renders one declared arg under its flow in `keepup list`.
+func printArg(out io.Writer, a *config.Arg) {
+	kind := string(a.Type)
+	if a.Type == config.ArgEnum {
+		kind = strings.Join(a.Values, "|")
+	}
+	var note string
+	switch {
+	case a.Required:
+		note = " (required)"
+	case a.Default != nil:
+		note = fmt.Sprintf(" (default: %q)", *a.Default)
+	}
+	line := fmt.Sprintf("--arg %s=<%s>%s", a.Name, kind, note)
+	if a.Description != "" {
+		line += "  " + a.Description
+	}
+	fmt.Fprintf(out, "      %s\n", line)
+}
+
 func printGroups(out io.Writer, cfg *config.Config) error {
 	for i := range cfg.Groups {
 		g := &cfg.Groups[i]
@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/quike/keepup/internal/cache/cachetest"
 	"github.com/quike/keepup/internal/config"
 )
 
@@ -216,3 +217,105 @@ func TestExecute_BadFlagReturnsNonZero(t *testing.T) {
 	code := Execute()
 	assert.Equal(t, 1, code)
 }
+
+func TestRunCmd_SelectionFlags(t *testing.T) {
+	t.Parallel()
+	cfgPath := writeTempConfig(t, minimalCfg)
+
+	var out bytes.Buffer
+	cmd := newRootCmd(&out, &out)
+	cmd.SetArgs([]string{"run", "--config", cfgPath, "--dry-run", "--only", "echo"})
+	require.NoError(t, cmd.Execute())
+
+	cmd = newRootCmd(&out, &out)
+	cmd.SetArgs([]string{"run", "--config", cfgPath, "--target", "ghost"})
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `selected group "ghost"`)
+}
+
+const argsCfg = `
+version: 2
+groups:
+  - name: tag
+    command: echo
+    params: ['{{ arg "version" }}']
+flows:
+  release:
+    description: "tag a release"
+    args:
+      - {name: version, required: true, description: "Version to tag"}
+      - {name: channel, type: enum, values: [stable, beta], default: stable}
+    steps:
+      - run: [tag]
+`
+
+func TestListCmd_FlowArgs(t *testing.T) {
+	t.Parallel()
+	cfgPath := writeTempConfig(t, argsCfg)
+	var out bytes.Buffer
+	cmd := newRootCmd(&out, &out)
+	cmd.SetArgs([]string{"list", "--config", cfgPath})
+	require.NoError(t, cmd.Execute())
+	assert.Contains(t, out.String(), "--arg version=<string> (required)  Version to tag")
+	assert.Contains(t, out.String(), `--arg channel=<stable|beta> (default: "stable")`)
+}
+
+func TestRunCmd_ArgFlags(t *testing.T) {
+	t.Parallel()
+	cfgPath := writeTempConfig(t, argsCfg)
+
+	var out bytes.Buffer
+	cmd := newRootCmd(&out, &out)
+	cmd.SetArgs([]string{"run", "release", "--config", cfgPath, "--dry-run", "--arg", "version=1.4.0", "--arg", "channel=beta"})
+	require.NoError(t, cmd.Execute())
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--arg", "version"}, `--arg "version": expected name=value`},
+		{[]string{"--arg", "version=1", "--arg", "version=2"}, `--arg "version" is given more than once`},
+		{nil, `missing required arg "version"`},
+	} {
+		cmd = newRootCmd(&out, &out)
+		cmd.SetArgs(append([]string{"run", "release", "--config", cfgPath, "--dry-run"}, tc.args...))
+		err := cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tc.want)
+	}
+}
+
+func TestRunCmd_RemoteCache(t *testing.T) {
+	t.Parallel()
+	srv := cachetest.NewServer(t)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "in.txt"), []byte("x"), 0o600))
+	cfgPath := writeTempConfig(t, `
+version: 2
+settings:
+  cache-dir: `+filepath.Join(dir, "cache")+`
+  cache:
+    remote: `+srv.URL+`
+groups:
+  - name: echo
+    command: echo
+    cache: {reads: ["`+filepath.ToSlash(filepath.Join(dir, "in.txt"))+`"]}
+flows:
+  f:
+    steps:
+      - run: [echo]
+`)
+
+	var out bytes.Buffer
+	cmd := newRootCmd(&out, &out)
+	cmd.SetArgs([]string{"run", "f", "--config", cfgPath, "--cache-read-only"})
+	require.NoError(t, cmd.Execute())
+	assert.Zero(t, srv.Len("ac"), "--cache-read-only uploads nothing")
+
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "cache")))
+	cmd = newRootCmd(&out, &out)
+	cmd.SetArgs([]string{"run", "f", "--config", cfgPath})
+	require.NoError(t, cmd.Execute())
+	assert.Equal(t, 2, srv.Len("ac"), "the entry, and the group's latest")
+}
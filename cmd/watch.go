@@ -4,11 +4,15 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"path/filepath"
+	"slices"
 
 	"github.com/spf13/cobra"
 
+	"github.com/quike/keepup/internal/cache"
 	"github.com/quike/keepup/internal/config"
 	"github.com/quike/keepup/internal/engine"
+	"github.com/quike/keepup/internal/template"
 	"github.com/quike/keepup/internal/watch"
 )
 
@@ -27,6 +31,7 @@ func newWatchCmd(opts *runtimeOpts, _ io.Writer) *cobra.Command {
 	}
 	cmd.Flags().StringVar(&eventsPath, "events", "",
 		"Write a JSON event stream to this file ('-' for stdout)")
+	addArgFlag(cmd, opts)
 	return cmd
 }
 
@@ -88,6 +93,14 @@ func runWatch(cmd *cobra.Command, args []string, opts *runtimeOpts, eventsPath s
 	if err != nil {
 		return err
 	}
+	flowArgs, err := parseArgFlags(opts.args)
+	if err != nil {
+		return err
+	}
+	// Check the args once up front rather than failing on every tick.
+	if _, err := flow.ResolveArgs(flowArgs); err != nil {
+		return fmt.Errorf("flow %q: %w", flowName, err)
+	}
 
 	patterns := watchPatterns(opts.cfg, &flow)
 	if len(patterns) == 0 {
@@ -119,7 +132,7 @@ func runWatch(cmd *cobra.Command, args []string, opts *runtimeOpts, eventsPath s
 	}
 
 	w := watch.New(patterns, setup.src, watch.WithLogger(opts.log))
-	return w.Run(cmd.Context(), buildOnChange(emitter, opts, flowName))
+	return w.Run(cmd.Context(), buildOnChange(emitter, opts, flowName, flowArgs))
 }
 
 // buildOnChange returns the per-tick callback the watcher invokes on each
@@ -128,14 +141,20 @@ func runWatch(cmd *cobra.Command, args []string, opts *runtimeOpts, eventsPath s
 // flow runs; the initial startup tick passes nil files and emits no trigger.
 // Each tick runs the flow on a fresh engine sharing the one emitter so the
 // event stream is a continuous sequence of per-tick flow envelopes.
-func buildOnChange(emitter engine.Emitter, opts *runtimeOpts, flowName string) func(context.Context, []string) error {
+func buildOnChange(
+	emitter engine.Emitter, opts *runtimeOpts, flowName string, flowArgs map[string]string,
+) func(context.Context, []string) error {
 	return func(ctx context.Context, files []string) error {
 		if emitter != nil && len(files) > 0 {
 			emitter.Emit(engine.Event{Event: engine.EventWatchTrigger, Flow: flowName, Files: files})
 		}
+		store := cache.NewFileStore(opts.cfg.CacheDir())
 		engineOpts := []engine.Option{
 			engine.WithLogger(opts.log),
 			engine.WithDryRun(opts.dryRun || opts.cfg.Settings.DryRun),
+			engine.WithArgs(flowArgs),
+			engine.WithCache(opts.cacheStore(ctx, store)),
+			engine.WithDurationStore(store),
 		}
 		if emitter != nil {
 			engineOpts = append(engineOpts, engine.WithEmitter(emitter))
@@ -146,16 +165,21 @@ func buildOnChange(emitter engine.Emitter, opts *runtimeOpts, flowName string) f
 }
 
 // watchPatterns collects the de-duplicated cache.reads globs across every group
-// in the flow. These are the inputs worth watching.
+// in the flow, including the groups of flows it embeds. These are the inputs
+// worth watching. Relative globs are anchored at the group's working
+// directory, matching how the cache resolves them.
 func watchPatterns(cfg *config.Config, flow *config.Flow) []string {
 	seen := map[string]struct{}{}
 	out := []string{}
-	for _, member := range flow.Members() {
-		g := cfg.GroupByName(member)
-		if g == nil || g.Cache == nil {
+	for _, g := range flowGroups(cfg, flow) {
+		if g.Cache == nil {
 			continue
 		}
+		dir := watchDir(cfg, g)
 		for _, r := range g.Cache.Reads {
+			if dir != "" && !filepath.IsAbs(r) {
+				r = filepath.Join(dir, r)
+			}
 			if _, dup := seen[r]; dup {
 				continue
 			}
@@ -165,3 +189,30 @@ func watchPatterns(cfg *config.Config, flow *config.Flow) []string {
 	}
 	return out
 }
+
+// flowGroups returns the groups a flow runs as members, descending into
+// embedded flows.
+func flowGroups(cfg *config.Config, flow *config.Flow) []*config.Group {
+	subs := flow.SubFlows()
+	var out []*config.Group
+	for _, member := range flow.Members() {
+		if slices.Contains(subs, member) {
+			f := cfg.Flows[member]
+			out = append(out, flowGroups(cfg, &f)...)
+		} else if g := cfg.GroupByName(member); g != nil {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// watchDir resolves a group's working directory without running anything. A
+// dir: template is rendered against the group's env and matrix values only
+// (outputs do not exist yet); a render failure falls back to the flow-wide working directory.
+func watchDir(cfg *config.Config, g *config.Group) string {
+	dir, err := template.NewExpander().Expand(g.Dir, template.Data{Env: cfg.EnvFor(g), Matrix: g.MatrixValues})
+	if err != nil {
+		dir = ""
+	}
+	return cfg.ResolveGroupDir(g, dir)
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -201,7 +202,7 @@ func TestWatchCmd_EventsWiring(t *testing.T) {
 	// Build the engine opts the same way the command does, then exercise the
 	// REAL production closure (not a copy) so a wiring regression is caught.
 	opts := &runtimeOpts{cfg: cfg, log: logger.Nop()}
-	onChange := buildOnChange(emitter, opts, "dev")
+	onChange := buildOnChange(emitter, opts, "dev", nil)
 
 	ctx, cancel := context.WithCancel(t.Context())
 	defer cancel()
@@ -245,7 +246,7 @@ func TestWatchCmd_InitialRunThenTrigger(t *testing.T) {
 	sw := &syncBuf{}
 	emitter := engine.NewJSONEmitter(sw)
 	opts := &runtimeOpts{cfg: cfg, log: logger.Nop()}
-	onChange := buildOnChange(emitter, opts, "dev")
+	onChange := buildOnChange(emitter, opts, "dev", nil)
 
 	ctx, cancel := context.WithCancel(t.Context())
 	defer cancel()
@@ -305,3 +306,22 @@ flows:
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "not found")
 }
+
+func TestWatchPatterns_AnchoredAtGroupDir(t *testing.T) {
+	t.Parallel()
+	cfg := &config.Config{
+		BaseDir:  "/repo",
+		Settings: config.Settings{WorkingDir: "."},
+		Env:      map[string]string{"PKG": "api"},
+		Groups: []config.Group{
+			{Name: "web", Command: "npm", Dir: "web", Cache: &config.Cache{Reads: []string{"src/**/*.ts"}}},
+			{Name: "api", Command: "go", Dir: `{{ env "PKG" }}`, Cache: &config.Cache{Reads: []string{"**/*.go", "/abs/x"}}},
+		},
+	}
+	flow := &config.Flow{Mode: config.ModeStep, Steps: []config.Step{{Run: []string{"web", "api"}}}}
+	assert.Equal(t, []string{
+		filepath.FromSlash("/repo/web/src/**/*.ts"),
+		filepath.FromSlash("/repo/api/**/*.go"),
+		"/abs/x",
+	}, watchPatterns(cfg, flow))
+}
@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bmatcuk/doublestar/v4"
+
+	"github.com/quike/keepup/internal/config"
+)
+
+// Artifact is one file of a group's declared writes, kept by content so a
+// cache hit can put it back. Path is keyed like a fingerprint input: relative
+// to the group's directory, slash-separated.
+type Artifact struct {
+	Path   string      `json:"path"`
+	Digest string      `json:"digest"`
+	Mode   fs.FileMode `json:"mode"`
+	Size   int64       `json:"size"`
+}
+
+// BlobStore holds content-addressed blobs, keyed by the Digest of their
+// bytes. PutBlob may skip a blob it already has.
+type BlobStore interface {
+	PutBlob(digest string, r io.Reader) error
+	GetBlob(digest string) (io.ReadCloser, error)
+}
+
+// Collect stores every regular file matched by the spec's writes in blobs
+// and returns them as artifacts, sorted by path. A matched directory
+// contributes the files under it. Relative globs resolve against dir, as in
+// Compute. A file outside dir is left out: Restore would not write it back,
+// so a hit only counts while it is still in place (see WritesPresent).
+func Collect(spec *config.Cache, dir string, blobs BlobStore) ([]Artifact, error) {
+	matches, err := resolveGlobs(dir, spec.Writes)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]fs.FileInfo)
+	for _, m := range matches {
+		err := filepath.WalkDir(m, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files[path] = info
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("collect output %q: %w", m, err)
+		}
+	}
+	base := baseDir(dir)
+	out := make([]Artifact, 0, len(files))
+	for path, info := range files {
+		key := relKey(base, path)
+		if !filepath.IsLocal(key) {
+			continue
+		}
+		digest, err := fileDigest(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := putFile(blobs, digest, path); err != nil {
+			return nil, err
+		}
+		out = append(out, Artifact{
+			Path:   filepath.ToSlash(key),
+			Digest: digest,
+			Mode:   info.Mode().Perm(),
+			Size:   info.Size(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// Restore writes back every artifact that is missing from dir or differs
+// from the stored content, and returns how many it wrote. A blob whose
+// bytes do not match its digest is an error, and leaves the file alone.
+//
+// Entries may come from a shared remote, so an artifact is only written
+// inside dir, at a path the spec's writes match (see declaredPath); any
+// other path is an error and nothing more is restored.
+func Restore(spec *config.Cache, dir string, artifacts []Artifact, blobs BlobStore) (int, error) {
+	n := 0
+	for _, a := range artifacts {
+		path, err := declaredPath(spec, dir, a.Path)
+		if err != nil {
+			return n, fmt.Errorf("restore output %q: %w", a.Path, err)
+		}
+		if digest, err := fileDigest(path); err == nil && digest == a.Digest {
+			continue
+		}
+		if err := restoreFile(blobs, a, path); err != nil {
+			return n, fmt.Errorf("restore output %q: %w", a.Path, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// declaredPath resolves an artifact's path against dir. The path must stay
+// inside dir once cleaned, and it or a directory above it must match one of
+// the spec's writes, as Collect would have found it.
+func declaredPath(spec *config.Cache, dir, key string) (string, error) {
+	rel := filepath.FromSlash(key)
+	if !filepath.IsLocal(rel) {
+		return "", errors.New("not a path inside the group's directory")
+	}
+	base := baseDir(dir)
+	path := filepath.Join(base, rel)
+	for _, pattern := range spec.Writes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(base, pattern)
+		}
+		pattern = filepath.Clean(pattern)
+		for p := path; p != base; p = filepath.Dir(p) {
+			if ok, _ := doublestar.PathMatch(pattern, p); ok {
+				return path, nil
+			}
+		}
+	}
+	return "", errors.New("not declared in cache.writes")
+}
+
+// baseDir is the directory an artifact path is relative to: dir, or the
+// current directory the group runs in when dir is empty.
+func baseDir(dir string) string {
+	if dir == "" {
+		dir = "."
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return filepath.Clean(dir)
+}
+
+// putFile hands the file at path to blobs under digest.
+func putFile(blobs BlobStore, digest, path string) error {
+	f, err := os.Open(path) //nolint:gosec // path comes from user-declared globs
+	if err != nil {
+		return fmt.Errorf("open output %q: %w", path, err)
+	}
+	defer f.Close()
+	if err := blobs.PutBlob(digest, f); err != nil {
+		return fmt.Errorf("store output %q: %w", path, err)
+	}
+	return nil
+}
+
+// restoreFile copies a's blob to path through a temporary file beside it,
+// checking the digest before the rename puts it in place.
+func restoreFile(blobs BlobStore, a Artifact, path string) error {
+	r, err := blobs.GetBlob(a.Digest)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keepup-restore-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != a.Digest {
+		return fmt.Errorf("blob %s holds %s", a.Digest, got)
+	}
+	if err := os.Chmod(tmp.Name(), a.Mode.Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// fileDigest returns the Digest of the file at path.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path) //nolint:gosec // path comes from user-declared globs
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("read output %q: %w", path, err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
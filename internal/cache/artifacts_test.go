@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/config"
+)
+
+func TestCollectAndRestore(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "bin", "app"), "binary")
+	require.NoError(t, os.Chmod(filepath.Join(dir, "bin", "app"), 0o755))
+	writeFile(t, filepath.Join(dir, "out", "a.txt"), "a")
+	writeFile(t, filepath.Join(dir, "out", "sub", "b.txt"), "b")
+	store := NewFileStore(filepath.Join(dir, "cache"))
+	spec := &config.Cache{Writes: []string{"bin/app", "out"}}
+
+	arts, err := Collect(spec, dir, store)
+	require.NoError(t, err)
+	paths := make([]string, len(arts))
+	for i, a := range arts {
+		paths[i] = a.Path
+	}
+	assert.Equal(t, []string{"bin/app", "out/a.txt", "out/sub/b.txt"}, paths)
+	assert.Equal(t, Digest([]byte("binary")), arts[0].Digest)
+	assert.Equal(t, int64(6), arts[0].Size)
+
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "bin")))
+	writeFile(t, filepath.Join(dir, "out", "a.txt"), "stale")
+	n, err := Restore(spec, dir, arts, store)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "only the missing and the changed file are written")
+	got, err := os.ReadFile(filepath.Join(dir, "out", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(got))
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Join(dir, "bin", "app"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o755), info.Mode().Perm(), "the mode is restored")
+	}
+
+	n, err = Restore(spec, dir, arts, store)
+	require.NoError(t, err)
+	assert.Zero(t, n, "nothing to do when the outputs match")
+}
+
+func TestRestore_RejectsBadBlob(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	store := NewFileStore(filepath.Join(dir, "cache"))
+	want := Digest([]byte("good"))
+	sum := strings.TrimPrefix(want, "sha256:")
+	writeFile(t, filepath.Join(dir, "cache", "blobs", sum[:2], sum), "evil") // a corrupted blob
+	spec := &config.Cache{Writes: []string{"f"}}
+
+	_, err := Restore(spec, dir, []Artifact{{Path: "f", Digest: want, Mode: 0o644}}, store)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "holds sha256:")
+	assert.NoFileExists(t, filepath.Join(dir, "f"))
+
+	_, err = Restore(spec, dir, []Artifact{{Path: "f", Digest: Digest([]byte("gone"))}}, store)
+	require.Error(t, err, "a missing blob")
+}
+
+func TestRestore_RejectsHostilePaths(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	dir := filepath.Join(root, "work")
+	store := NewFileStore(filepath.Join(root, "cache"))
+	d := Digest([]byte("pwned"))
+	require.NoError(t, store.PutBlob(d, strings.NewReader("pwned")))
+	spec := &config.Cache{Writes: []string{"out", "bin/*"}}
+
+	for _, path := range []string{
+		"../escaped",
+		"out/../../escaped",
+		filepath.ToSlash(filepath.Join(root, "escaped")),
+		"",
+		"src/main.go",
+		"binary",
+	} {
+		_, err := Restore(spec, dir, []Artifact{{Path: path, Digest: d, Mode: 0o644}}, store)
+		require.Error(t, err, path)
+	}
+	assert.NoFileExists(t, filepath.Join(root, "escaped"))
+	assert.NoFileExists(t, filepath.Join(dir, "src", "main.go"))
+
+	arts := []Artifact{{Path: "out/sub/ok", Digest: d, Mode: 0o644}, {Path: "bin/app", Digest: d, Mode: 0o644}}
+	n, err := Restore(spec, dir, arts, store)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "paths the writes declare, or that sit under a declared directory")
+}
+
+func TestCollect_SkipsOutsideWrites(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "dist", "app"), "binary")
+	writeFile(t, filepath.Join(root, "work", "out"), "out")
+	spec := &config.Cache{Writes: []string{"../dist", "out"}}
+	arts, err := Collect(spec, filepath.Join(root, "work"), NewFileStore(t.TempDir()))
+	require.NoError(t, err)
+	require.Len(t, arts, 1, "only the file inside the group's directory is stored")
+	assert.Equal(t, "out", arts[0].Path)
+}
+
+func TestFileStore_Blobs(t *testing.T) {
+	t.Parallel()
+	store := NewFileStore(t.TempDir())
+	d := Digest([]byte("x"))
+	require.NoError(t, store.PutBlob(d, strings.NewReader("x")))
+	require.NoError(t, store.PutBlob(d, strings.NewReader("ignored")), "an existing blob is kept")
+	require.Error(t, store.PutBlob(Digest([]byte("y")), strings.NewReader("z")), "content must match the digest")
+	r, err := store.GetBlob(d)
+	require.NoError(t, err)
+	data := make([]byte, 8)
+	n, _ := r.Read(data)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "x", string(data[:n]))
+
+	for _, bad := range []string{"x", "sha256:zz", "sha256:../../etc/passwd"} {
+		require.Error(t, store.PutBlob(bad, strings.NewReader("")), bad)
+		_, err := store.GetBlob(bad)
+		require.Error(t, err, bad)
+	}
+}
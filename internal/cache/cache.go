@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"sort"
 	"time"
 
@@ -19,13 +20,20 @@ import (
 
 // Entry is a persisted cache record for one group.
 type Entry struct {
+	// Group is the name the entry is stored under, kept so keepup cache
+	// can list entries by name; the file name is sanitized.
+	Group       string           `json:"group,omitempty"`
 	Fingerprint string           `json:"fingerprint"`
 	Result      result.RunResult `json:"result"`
 	// Commands records the expanded (post-template) command list that produced
 	// Result — resolved values, not the config's template text. This field is
 	// informational only: the Fingerprint (not Commands) decides cache hits.
-	Commands  []config.CommandSpec `json:"commands"`
-	UpdatedAt time.Time            `json:"updatedAt"`
+	Commands []config.CommandSpec `json:"commands"`
+	// Artifacts lists the files of the group's cache.writes, stored in the
+	// store's blobs when it is a BlobStore; a hit restores them (see
+	// Restore).
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt"`
 }
 
 // Store loads and saves cache entries keyed by group name.
@@ -34,13 +42,83 @@ type Store interface {
 	Save(group string, e *Entry) error
 }
 
+// Finder is a Store that can look past its Load for the entry with a given
+// fingerprint, such as one backed by a remote cache (see Tiered).
+type Finder interface {
+	Find(group, fingerprint string) (*Entry, bool)
+}
+
+// Find returns the group's entry in s when it has the given fingerprint.
+func Find(s Store, group, fingerprint string) (*Entry, bool) {
+	if f, ok := s.(Finder); ok {
+		return f.Find(group, fingerprint)
+	}
+	e, ok := s.Load(group)
+	if !ok || e.Fingerprint != fingerprint {
+		return nil, false
+	}
+	return e, true
+}
+
+// RunState is the persisted outcome of a flow's last failed run; `keepup run
+// --resume` replays it. FlowHash and Groups fingerprint the definitions the
+// state was produced from, so a resume can refuse when they changed.
+type RunState struct {
+	Flow     string `json:"flow"`
+	FlowHash string `json:"flowHash"`
+	// Groups maps each member group to the hash of its definition.
+	Groups map[string]string `json:"groups"`
+	// Outputs holds the stored result of every member that reached a
+	// terminal state; members absent here never finished.
+	Outputs   map[string]result.RunResult `json:"outputs"`
+	Err       string                      `json:"err,omitempty"`
+	UpdatedAt time.Time                   `json:"updatedAt"`
+}
+
+// RunStore loads, saves, and clears per-flow run state.
+type RunStore interface {
+	LoadRun(flow string) (*RunState, bool)
+	SaveRun(flow string, s *RunState) error
+	ClearRun(flow string) error
+}
+
+// DurationStore loads and saves the typical run time of each group, in
+// milliseconds, which the scheduler uses to start the longest chains first.
+type DurationStore interface {
+	LoadDurations() map[string]int64
+	SaveDurations(d map[string]int64) error
+}
+
+// Inputs are the values besides files and commands that a fingerprint
+// covers: the running flow's args, a digest of the group's stdin: (see
+// Digest), the environment variables the group declares or lists in
+// cache.env, and the output of its cache.tools commands keyed by command.
+// The zero value fingerprints exactly as before any of them existed.
+type Inputs struct {
+	Args  map[string]string
+	Stdin string
+	Env   map[string]string
+	Tools map[string]string
+}
+
+// Digest returns the content digest Inputs.Stdin records for data.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
 // Compute returns a content fingerprint for the given cache spec. The
 // fingerprint changes when the method, any command/param/form in the group's
-// command list, or any matched input file changes. For shell-form entries the
-// fingerprint also changes when the shell program changes. A glob that matches
-// nothing contributes nothing, so adding the first matching file naturally
-// changes the fingerprint.
-func Compute(spec *config.Cache, shell string, commands []config.CommandSpec) (string, error) {
+// command list, any of the running flow's args, the group's stdin, any of
+// its env or tool inputs, or any matched input file changes. For shell-form
+// entries the fingerprint also changes when the shell program changes. A
+// glob that matches nothing contributes nothing, so adding the first
+// matching file naturally changes the fingerprint.
+//
+// Relative globs resolve against dir (the group's working directory; "" means
+// the process cwd), and matched files are keyed by their dir-relative path so
+// the same tree checked out elsewhere produces the same fingerprint.
+func Compute(spec *config.Cache, dir, shell string, commands []config.CommandSpec, in Inputs) (string, error) {
 	h := sha256.New()
 	// Salt with the full command list and method so any changed command (or a
 	// form change: argv vs shell) busts the cache even when inputs are
@@ -59,24 +137,49 @@ func Compute(spec *config.Cache, shell string, commands []config.CommandSpec) (s
 		}
 		fmt.Fprintf(h, "\x02")
 	}
+	hashMap(h, "args", in.Args)
+	if in.Stdin != "" {
+		fmt.Fprintf(h, "stdin\x00%s\x02", in.Stdin)
+	}
+	hashMap(h, "env", in.Env)
+	hashMap(h, "tools", in.Tools)
 
-	files, err := resolveGlobs(spec.Reads)
+	files, err := resolveGlobs(dir, spec.Reads)
 	if err != nil {
 		return "", err
 	}
 	for _, f := range files {
-		if err := hashFile(h, spec.Method, f); err != nil {
+		if err := hashFile(h, spec.Method, f, relKey(dir, f)); err != nil {
 			return "", err
 		}
 	}
 	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
 }
 
+// hashMap folds m into h under label, in key order; an empty m contributes
+// nothing.
+func hashMap(h io.Writer, label string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(h, "%s\x00", label)
+	for _, k := range names {
+		fmt.Fprintf(h, "%s=%s\x01", k, m[k])
+	}
+	fmt.Fprintf(h, "\x02")
+}
+
 // WritesPresent reports whether every declared output glob matches at least
 // one existing path. A missing output invalidates a would-be cache hit.
-func WritesPresent(spec *config.Cache) bool {
+// Relative globs resolve against dir, as in Compute.
+func WritesPresent(spec *config.Cache, dir string) bool {
 	for _, pattern := range spec.Writes {
-		matches, err := resolveGlobs([]string{pattern})
+		matches, err := resolveGlobs(dir, []string{pattern})
 		if err != nil || len(matches) == 0 {
 			return false
 		}
@@ -84,7 +187,9 @@ func WritesPresent(spec *config.Cache) bool {
 	return true
 }
 
-func hashFile(h io.Writer, method config.CacheMethod, path string) error {
+// hashFile folds one input into h. path is where the file is read from; key
+// is the name recorded in the fingerprint.
+func hashFile(h io.Writer, method config.CacheMethod, path, key string) error {
 	info, err := os.Stat(path)
 	if err != nil {
 		return fmt.Errorf("stat input %q: %w", path, err)
@@ -92,19 +197,19 @@ func hashFile(h io.Writer, method config.CacheMethod, path string) error {
 	if info.IsDir() {
 		// Directories contribute their path + mtime; their files are matched
 		// independently by the globs.
-		fmt.Fprintf(h, "dir\x00%s\x00%d\x00", path, info.ModTime().UnixNano())
+		fmt.Fprintf(h, "dir\x00%s\x00%d\x00", key, info.ModTime().UnixNano())
 		return nil
 	}
 	switch method {
 	case config.CacheMtime:
-		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", path, info.ModTime().UnixNano(), info.Size())
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", key, info.ModTime().UnixNano(), info.Size())
 	default: // CacheHash
 		f, err := os.Open(path) //nolint:gosec // path comes from user-declared globs
 		if err != nil {
 			return fmt.Errorf("open input %q: %w", path, err)
 		}
 		defer f.Close()
-		fmt.Fprintf(h, "%s\x00", path)
+		fmt.Fprintf(h, "%s\x00", key)
 		if _, err := io.Copy(h, f); err != nil {
 			return fmt.Errorf("read input %q: %w", path, err)
 		}
@@ -113,10 +218,14 @@ func hashFile(h io.Writer, method config.CacheMethod, path string) error {
 }
 
 // resolveGlobs expands each pattern, returning a sorted, de-duplicated list
-// of matching paths. Patterns support "**" via doublestar.
-func resolveGlobs(patterns []string) ([]string, error) {
+// of matching paths. Patterns support "**" via doublestar; relative patterns
+// are anchored at dir when it is non-empty.
+func resolveGlobs(dir string, patterns []string) ([]string, error) {
 	set := make(map[string]struct{})
 	for _, pattern := range patterns {
+		if dir != "" && !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
 		matches, err := doublestar.FilepathGlob(pattern)
 		if err != nil {
 			return nil, fmt.Errorf("bad glob %q: %w", pattern, err)
@@ -132,3 +241,16 @@ func resolveGlobs(patterns []string) ([]string, error) {
 	sort.Strings(out)
 	return out, nil
 }
+
+// relKey returns path relative to dir for use in a fingerprint, falling back
+// to path itself when dir is empty or the path cannot be made relative.
+func relKey(dir, path string) string {
+	if dir == "" {
+		return path
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
@@ -26,44 +26,44 @@ func TestCompute_HashMethod(t *testing.T) {
 	writeFile(t, a, "package main\n")
 	spec := &config.Cache{Method: config.CacheHash, Reads: []string{filepath.Join(dir, "*.go")}}
 
-	fp1, err := Compute(spec, "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}})
+	fp1, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}}, Inputs{})
 	require.NoError(t, err)
 	assert.True(t, len(fp1) > 7 && fp1[:7] == "sha256:")
 
 	t.Run("stable when nothing changes", func(t *testing.T) {
-		fp2, err := Compute(spec, "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}})
+		fp2, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}}, Inputs{})
 		require.NoError(t, err)
 		assert.Equal(t, fp1, fp2)
 	})
 
 	t.Run("changes when content changes", func(t *testing.T) {
 		writeFile(t, a, "package main // changed\n")
-		fp2, err := Compute(spec, "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}})
+		fp2, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}}, Inputs{})
 		require.NoError(t, err)
 		assert.NotEqual(t, fp1, fp2)
 	})
 
 	t.Run("changes when command changes", func(t *testing.T) {
-		fpCmd, err := Compute(spec, "", []config.CommandSpec{{Command: "gofmt", Params: []string{"build"}}})
+		fpCmd, err := Compute(spec, "", "", []config.CommandSpec{{Command: "gofmt", Params: []string{"build"}}}, Inputs{})
 		require.NoError(t, err)
-		fpCmd2, err := Compute(spec, "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}})
+		fpCmd2, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}}, Inputs{})
 		require.NoError(t, err)
 		assert.NotEqual(t, fpCmd, fpCmd2)
 	})
 
 	t.Run("changes when params change", func(t *testing.T) {
-		fpA, err := Compute(spec, "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}})
+		fpA, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}}, Inputs{})
 		require.NoError(t, err)
-		fpB, err := Compute(spec, "", []config.CommandSpec{{Command: "go", Params: []string{"test"}}})
+		fpB, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go", Params: []string{"test"}}}, Inputs{})
 		require.NoError(t, err)
 		assert.NotEqual(t, fpA, fpB)
 	})
 
 	t.Run("changes when a new matching file appears", func(t *testing.T) {
-		before, err := Compute(spec, "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}})
+		before, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}}, Inputs{})
 		require.NoError(t, err)
 		writeFile(t, filepath.Join(dir, "b.go"), "package main\n")
-		after, err := Compute(spec, "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}})
+		after, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go", Params: []string{"build"}}}, Inputs{})
 		require.NoError(t, err)
 		assert.NotEqual(t, before, after)
 	})
@@ -75,13 +75,13 @@ func TestCompute_MtimeMethod(t *testing.T) {
 	writeFile(t, f, "hello")
 	spec := &config.Cache{Method: config.CacheMtime, Reads: []string{f}}
 
-	fp1, err := Compute(spec, "", []config.CommandSpec{{Command: "cat"}})
+	fp1, err := Compute(spec, "", "", []config.CommandSpec{{Command: "cat"}}, Inputs{})
 	require.NoError(t, err)
 
 	// Bumping mtime changes the fingerprint even if content is identical.
 	future := time.Now().Add(2 * time.Second)
 	require.NoError(t, os.Chtimes(f, future, future))
-	fp2, err := Compute(spec, "", []config.CommandSpec{{Command: "cat"}})
+	fp2, err := Compute(spec, "", "", []config.CommandSpec{{Command: "cat"}}, Inputs{})
 	require.NoError(t, err)
 	assert.NotEqual(t, fp1, fp2)
 }
@@ -90,7 +90,7 @@ func TestCompute_DoublestarRecursive(t *testing.T) {
 	dir := t.TempDir()
 	writeFile(t, filepath.Join(dir, "pkg", "deep", "x.go"), "package deep\n")
 	spec := &config.Cache{Method: config.CacheHash, Reads: []string{filepath.Join(dir, "**", "*.go")}}
-	fp, err := Compute(spec, "", []config.CommandSpec{{Command: "go"}})
+	fp, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go"}}, Inputs{})
 	require.NoError(t, err)
 	assert.Contains(t, fp, "sha256:")
 }
@@ -99,7 +99,7 @@ func TestCompute_MissingExplicitFileErrors(t *testing.T) {
 	// A literal (non-glob) path that doesn't exist should surface an error,
 	// since the user named a specific input.
 	spec := &config.Cache{Method: config.CacheHash, Reads: []string{"/no/such/explicit/file.go"}}
-	_, err := Compute(spec, "", []config.CommandSpec{{Command: "go"}})
+	_, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go"}}, Inputs{})
 	// doublestar treats a literal path as a pattern matching nothing, so this
 	// resolves to zero files and succeeds; assert the no-op behavior.
 	require.NoError(t, err)
@@ -111,14 +111,14 @@ func TestCompute_DirectoryInput(t *testing.T) {
 	sub := filepath.Join(dir, "sub")
 	require.NoError(t, os.MkdirAll(sub, 0o755))
 	spec := &config.Cache{Method: config.CacheHash, Reads: []string{filepath.Join(dir, "*")}}
-	fp, err := Compute(spec, "", []config.CommandSpec{{Command: "go"}})
+	fp, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go"}}, Inputs{})
 	require.NoError(t, err)
 	assert.Contains(t, fp, "sha256:")
 }
 
 func TestCompute_BadGlobErrors(t *testing.T) {
 	spec := &config.Cache{Method: config.CacheHash, Reads: []string{"[invalid"}}
-	_, err := Compute(spec, "", []config.CommandSpec{{Command: "go"}})
+	_, err := Compute(spec, "", "", []config.CommandSpec{{Command: "go"}}, Inputs{})
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "bad glob")
 }
@@ -141,14 +141,14 @@ func TestWritesPresent(t *testing.T) {
 
 	t.Run("present when all writes exist", func(t *testing.T) {
 		spec := &config.Cache{Writes: []string{bin}}
-		assert.True(t, WritesPresent(spec))
+		assert.True(t, WritesPresent(spec, ""))
 	})
 	t.Run("absent when a write is missing", func(t *testing.T) {
 		spec := &config.Cache{Writes: []string{bin, filepath.Join(dir, "missing")}}
-		assert.False(t, WritesPresent(spec))
+		assert.False(t, WritesPresent(spec, ""))
 	})
 	t.Run("no writes declared is trivially present", func(t *testing.T) {
-		assert.True(t, WritesPresent(&config.Cache{}))
+		assert.True(t, WritesPresent(&config.Cache{}, ""))
 	})
 }
 
@@ -182,6 +182,64 @@ func TestFileStore_RoundTrip(t *testing.T) {
 		require.True(t, ok)
 		assert.Equal(t, "sha256:abc", got.Fingerprint)
 	})
+
+	t.Run("imported group keys get a namespace directory", func(t *testing.T) {
+		require.NoError(t, store.Save("web:ui:build", entry))
+		assert.FileExists(t, filepath.Join(dir, "cache", "@web", "@ui", "build.json"))
+		_, ok := store.Load("web:ui:build")
+		assert.True(t, ok)
+		_, ok = store.Load("web_ui_build")
+		assert.False(t, ok, "a root group never shares an imported group's file")
+
+		require.NoError(t, store.Save("runs:ci", entry))
+		_, ok = store.LoadRun("ci")
+		assert.False(t, ok, "an alias named runs stays apart from run state")
+	})
+}
+
+func TestFileStore_RunStateRoundTrip(t *testing.T) {
+	t.Parallel()
+	store := NewFileStore(filepath.Join(t.TempDir(), "cache"))
+
+	_, ok := store.LoadRun("ci")
+	assert.False(t, ok, "no state before save")
+
+	st := &RunState{
+		Flow:      "ci",
+		FlowHash:  "sha256:flow",
+		Groups:    map[string]string{"build": "sha256:g"},
+		Outputs:   map[string]result.RunResult{"build": {Stdout: "built\n", Status: result.StatusOK}},
+		Err:       "group \"test\" failed",
+		UpdatedAt: time.Now(),
+	}
+	require.NoError(t, store.SaveRun("ci", st))
+
+	got, ok := store.LoadRun("ci")
+	require.True(t, ok)
+	assert.Equal(t, "sha256:flow", got.FlowHash)
+	assert.Equal(t, "built\n", got.Outputs["build"].Stdout)
+
+	// Run state lives apart from group entries of the same name.
+	_, ok = store.Load("ci")
+	assert.False(t, ok)
+
+	require.NoError(t, store.ClearRun("ci"))
+	_, ok = store.LoadRun("ci")
+	assert.False(t, ok)
+	require.NoError(t, store.ClearRun("ci"), "clearing absent state is not an error")
+}
+
+func TestFileStore_DurationsRoundTrip(t *testing.T) {
+	t.Parallel()
+	store := NewFileStore(filepath.Join(t.TempDir(), "cache"))
+	assert.Empty(t, store.LoadDurations(), "no history before save")
+
+	require.NoError(t, store.SaveDurations(map[string]int64{"build": 1200, "web:lint": 40}))
+	assert.Equal(t, map[string]int64{"build": 1200, "web:lint": 40}, store.LoadDurations())
+
+	// A group named "stats" keeps its own entry.
+	_, ok := store.Load("stats")
+	assert.False(t, ok)
 }
 
 func TestFileStore_CorruptEntryIsMiss(t *testing.T) {
@@ -209,6 +267,10 @@ func TestEntryRoundTripWithRunResult(t *testing.T) {
 			ExitCode:   0,
 			DurationMs: 12,
 			Status:     "ok",
+			Commands: []result.CommandResult{
+				{Command: "echo", Params: []string{"hi"}, Stdout: "hi\n", Output: "hi\n", DurationMs: 5},
+				{Command: "warn", Stderr: "warn\n", Output: "warn\n", DurationMs: 7},
+			},
 		},
 		Commands:  []config.CommandSpec{{Command: "echo", Params: []string{"hi"}}},
 		UpdatedAt: time.Now().UTC().Truncate(time.Second),
@@ -228,12 +290,12 @@ func TestComputeUsesV3Salt(t *testing.T) {
 	dir := t.TempDir()
 	require.NoError(t, os.WriteFile(filepath.Join(dir, "f.txt"), []byte("x"), 0o600))
 	spec := &config.Cache{Method: config.CacheHash, Reads: []string{filepath.Join(dir, "f.txt")}}
-	fp, err := Compute(spec, "", []config.CommandSpec{{Command: "echo", Params: []string{"a"}}})
+	fp, err := Compute(spec, "", "", []config.CommandSpec{{Command: "echo", Params: []string{"a"}}}, Inputs{})
 	require.NoError(t, err)
 	assert.True(t, strings.HasPrefix(fp, "sha256:"))
 
 	// Determinism: identical inputs produce identical fingerprints.
-	fp2, err := Compute(spec, "", []config.CommandSpec{{Command: "echo", Params: []string{"a"}}})
+	fp2, err := Compute(spec, "", "", []config.CommandSpec{{Command: "echo", Params: []string{"a"}}}, Inputs{})
 	require.NoError(t, err)
 	assert.Equal(t, fp, fp2)
 }
@@ -245,47 +307,47 @@ func TestCompute_CommandListFingerprint(t *testing.T) {
 		{Command: "go test ./...", IsShell: true},
 	}
 
-	fp1, err := Compute(spec, "sh", base)
+	fp1, err := Compute(spec, "", "sh", base, Inputs{})
 	require.NoError(t, err)
 
 	t.Run("identical lists hit", func(t *testing.T) {
-		fp2, err := Compute(spec, "sh", []config.CommandSpec{
+		fp2, err := Compute(spec, "", "sh", []config.CommandSpec{
 			{Command: "go", Params: []string{"build"}},
 			{Command: "go test ./...", IsShell: true},
-		})
+		}, Inputs{})
 		require.NoError(t, err)
 		assert.Equal(t, fp1, fp2)
 	})
 
 	t.Run("changing any command busts", func(t *testing.T) {
-		fp2, err := Compute(spec, "sh", []config.CommandSpec{
+		fp2, err := Compute(spec, "", "sh", []config.CommandSpec{
 			{Command: "go", Params: []string{"build"}},
 			{Command: "go vet ./...", IsShell: true}, // second entry changed
-		})
+		}, Inputs{})
 		require.NoError(t, err)
 		assert.NotEqual(t, fp1, fp2)
 	})
 
 	t.Run("changing a param busts", func(t *testing.T) {
-		fp2, err := Compute(spec, "sh", []config.CommandSpec{
+		fp2, err := Compute(spec, "", "sh", []config.CommandSpec{
 			{Command: "go", Params: []string{"install"}},
 			{Command: "go test ./...", IsShell: true},
-		})
+		}, Inputs{})
 		require.NoError(t, err)
 		assert.NotEqual(t, fp1, fp2)
 	})
 
 	t.Run("changing entry form (argv vs shell) busts", func(t *testing.T) {
-		fp2, err := Compute(spec, "sh", []config.CommandSpec{
+		fp2, err := Compute(spec, "", "sh", []config.CommandSpec{
 			{Command: "go", Params: []string{"build"}},
 			{Command: "go test ./...", IsShell: false}, // same text, argv form
-		})
+		}, Inputs{})
 		require.NoError(t, err)
 		assert.NotEqual(t, fp1, fp2)
 	})
 
 	t.Run("adding an entry busts", func(t *testing.T) {
-		fp2, err := Compute(spec, "sh", append(base, config.CommandSpec{Command: "true"}))
+		fp2, err := Compute(spec, "", "sh", append(base, config.CommandSpec{Command: "true"}), Inputs{})
 		require.NoError(t, err)
 		assert.NotEqual(t, fp1, fp2)
 	})
@@ -294,9 +356,9 @@ func TestCompute_CommandListFingerprint(t *testing.T) {
 		list := []config.CommandSpec{
 			{Command: "go test ./...", IsShell: true},
 		}
-		fpBash, err := Compute(spec, "bash", list)
+		fpBash, err := Compute(spec, "", "bash", list, Inputs{})
 		require.NoError(t, err)
-		fpZsh, err := Compute(spec, "zsh", list)
+		fpZsh, err := Compute(spec, "", "zsh", list, Inputs{})
 		require.NoError(t, err)
 		assert.NotEqual(t, fpBash, fpZsh)
 	})
@@ -305,10 +367,91 @@ func TestCompute_CommandListFingerprint(t *testing.T) {
 		list := []config.CommandSpec{
 			{Command: "go", Params: []string{"build"}},
 		}
-		fpBash, err := Compute(spec, "bash", list)
+		fpBash, err := Compute(spec, "", "bash", list, Inputs{})
 		require.NoError(t, err)
-		fpZsh, err := Compute(spec, "zsh", list)
+		fpZsh, err := Compute(spec, "", "zsh", list, Inputs{})
 		require.NoError(t, err)
 		assert.Equal(t, fpBash, fpZsh)
 	})
 }
+
+func TestCompute_ArgsFingerprint(t *testing.T) {
+	spec := &config.Cache{Method: config.CacheHash, Reads: []string{}}
+	cmds := []config.CommandSpec{{Command: "git", Params: []string{"tag"}}}
+
+	none, err := Compute(spec, "", "", cmds, Inputs{})
+	require.NoError(t, err)
+	empty, err := Compute(spec, "", "", cmds, Inputs{Args: map[string]string{}})
+	require.NoError(t, err)
+	assert.Equal(t, none, empty, "a flow without args keeps its fingerprint")
+
+	v1, err := Compute(spec, "", "", cmds, Inputs{Args: map[string]string{"version": "1.4.0", "push": "false"}})
+	require.NoError(t, err)
+	v2, err := Compute(spec, "", "", cmds, Inputs{Args: map[string]string{"version": "1.5.0", "push": "false"}})
+	require.NoError(t, err)
+	assert.NotEqual(t, none, v1)
+	assert.NotEqual(t, v1, v2, "different args must not share a cache entry")
+}
+
+func TestCompute_StdinFingerprint(t *testing.T) {
+	spec := &config.Cache{Method: config.CacheHash, Reads: []string{}}
+	cmds := []config.CommandSpec{{Command: "jq", Params: []string{"."}}}
+
+	none, err := Compute(spec, "", "", cmds, Inputs{})
+	require.NoError(t, err)
+	a, err := Compute(spec, "", "", cmds, Inputs{Stdin: Digest([]byte("a"))})
+	require.NoError(t, err)
+	b, err := Compute(spec, "", "", cmds, Inputs{Stdin: Digest([]byte("b"))})
+	require.NoError(t, err)
+	assert.NotEqual(t, none, a)
+	assert.NotEqual(t, a, b, "different stdin must not share a cache entry")
+}
+
+func TestCompute_EnvAndToolsFingerprint(t *testing.T) {
+	spec := &config.Cache{Method: config.CacheHash, Reads: []string{}}
+	cmds := []config.CommandSpec{{Command: "go", Params: []string{"build"}}}
+
+	none, err := Compute(spec, "", "", cmds, Inputs{})
+	require.NoError(t, err)
+	empty, err := Compute(spec, "", "", cmds, Inputs{Env: map[string]string{}, Tools: map[string]string{}})
+	require.NoError(t, err)
+	assert.Equal(t, none, empty, "a group without env or tools keeps its fingerprint")
+
+	fps := map[string]Inputs{
+		"env a":   {Env: map[string]string{"GOFLAGS": "-mod=mod"}},
+		"env b":   {Env: map[string]string{"GOFLAGS": "-mod=vendor"}},
+		"tool a":  {Tools: map[string]string{"go version": "go1.25.0"}},
+		"tool b":  {Tools: map[string]string{"go version": "go1.25.1"}},
+		"as args": {Args: map[string]string{"GOFLAGS": "-mod=mod"}},
+	}
+	seen := map[string]string{none: "none"}
+	for name, in := range fps {
+		fp, err := Compute(spec, "", "", cmds, in)
+		require.NoError(t, err)
+		assert.NotContains(t, seen, fp, "%s collides with %s", name, seen[fp])
+		seen[fp] = name
+	}
+}
+
+func TestCompute_RelativeToDir(t *testing.T) {
+	a := t.TempDir()
+	b := t.TempDir()
+	writeFile(t, filepath.Join(a, "src", "x.go"), "package x\n")
+	writeFile(t, filepath.Join(b, "src", "x.go"), "package x\n")
+	spec := &config.Cache{Method: config.CacheHash, Reads: []string{"src/*.go"}, Writes: []string{"src/x.go"}}
+	cmds := []config.CommandSpec{{Command: "go", Params: []string{"build"}}}
+
+	fpA, err := Compute(spec, a, "", cmds, Inputs{})
+	require.NoError(t, err)
+	fpB, err := Compute(spec, b, "", cmds, Inputs{})
+	require.NoError(t, err)
+	assert.Equal(t, fpA, fpB, "the same tree in another directory must fingerprint identically")
+
+	writeFile(t, filepath.Join(b, "src", "x.go"), "package x // changed\n")
+	fpB2, err := Compute(spec, b, "", cmds, Inputs{})
+	require.NoError(t, err)
+	assert.NotEqual(t, fpA, fpB2)
+
+	assert.True(t, WritesPresent(spec, a))
+	assert.False(t, WritesPresent(spec, t.TempDir()))
+}
@@ -0,0 +1,154 @@
+// Package cachetest provides an in-memory stand-in for a remote cache
+// server, speaking the ac/ + cas/ layout cache.HTTPCache expects.
+package cachetest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a remote cache held in memory. Its zero-value knobs make it
+// behave like bazel-remote: GET and HEAD answer 404 for what it lacks and
+// PUT stores anything.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	requests map[string]int // "METHOD kind", e.g. "PUT ac"
+	fail     bool
+	delay    time.Duration
+	slowBody time.Duration
+	token    string
+}
+
+// NewServer starts a Server; it is closed when the test ends.
+func NewServer(t interface{ Cleanup(func()) }) *Server {
+	s := &Server{objects: map[string][]byte{}, requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Fail makes every request answer 500 until it is called with false.
+func (s *Server) Fail(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+// Delay holds every response for d.
+func (s *Server) Delay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// SlowBody makes GET send its headers at once and its body d later, like
+// a large object on a slow link.
+func (s *Server) SlowBody(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.slowBody = d
+}
+
+// RequireToken rejects requests without "Authorization: Bearer token".
+func (s *Server) RequireToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// Requests returns how many requests of a method reached a kind of object
+// ("ac" or "cas"), e.g. Requests("PUT", "cas").
+func (s *Server) Requests(method, kind string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method+" "+kind]
+}
+
+// Len returns how many objects of a kind the server holds.
+func (s *Server) Len(kind string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for k := range s.objects {
+		if strings.HasPrefix(k, kind+"/") {
+			n++
+		}
+	}
+	return n
+}
+
+// Reset drops every stored object and request count.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects = map[string][]byte{}
+	s.requests = map[string]int{}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	kind, _, _ := strings.Cut(key, "/")
+
+	s.mu.Lock()
+	s.requests[r.Method+" "+kind]++
+	fail, delay, slowBody, token := s.fail, s.delay, s.slowBody, s.token
+	s.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	switch {
+	case fail:
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+		return
+	case token != "" && r.Header.Get("Authorization") != "Bearer "+token:
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	case kind != "ac" && kind != "cas":
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.mu.Lock()
+		data, ok := s.objects[key]
+		s.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			return
+		}
+		if slowBody > 0 {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(slowBody)
+		}
+		_, _ = w.Write(data)
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.objects[key] = data
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,293 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EntryInfo describes one stored entry for keepup cache ls. Size counts the
+// entry file and the outputs it stores; a blob shared by several entries
+// counts toward each.
+type EntryInfo struct {
+	Group       string    `json:"group"`
+	Fingerprint string    `json:"fingerprint"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	DurationMs  int64     `json:"durationMs"`
+	Size        int64     `json:"size"`
+	Outputs     int       `json:"outputs"`
+
+	path    string
+	fileLen int64
+	digests []string
+}
+
+// GCPolicy bounds what GC keeps. Entries older than MaxAge go first; then,
+// oldest first, entries until the cache fits in MaxSize bytes. A zero field
+// sets no bound.
+type GCPolicy struct {
+	MaxAge  time.Duration
+	MaxSize int64
+}
+
+// GCReport is what GC or Clear removed, and what is left.
+type GCReport struct {
+	Removed []string `json:"removed"`
+	Blobs   int      `json:"blobs"`
+	Freed   int64    `json:"freed"`
+	Kept    int      `json:"kept"`
+	Size    int64    `json:"size"`
+}
+
+// Entries lists the stored entries, sorted by group. Files that do not
+// parse as entries are left out, as Load ignores them.
+func (s *FileStore) Entries() ([]EntryInfo, error) {
+	var out []EntryInfo
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == s.dir {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != s.dir && !strings.HasPrefix(d.Name(), "@") {
+				return filepath.SkipDir // runs/, stats/, logs/, blobs/
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".json" {
+			return nil
+		}
+		if info, ok := s.entryInfo(path); ok {
+			out = append(out, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list cache entries: %w", err)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Group < out[j].Group })
+	return out, nil
+}
+
+// Remove deletes a group's entry and reports whether there was one. The
+// blobs it leaves unreferenced stay until the next GC.
+func (s *FileStore) Remove(group string) (bool, error) {
+	err := os.Remove(s.path(group))
+	switch {
+	case err == nil:
+		s.removeEmptyNamespace(filepath.Dir(s.path(group)))
+		return true, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	default:
+		return false, fmt.Errorf("remove cache entry: %w", err)
+	}
+}
+
+// Clear deletes every entry and blob. Run state, durations and capture
+// logs are kept.
+func (s *FileStore) Clear() (GCReport, error) {
+	return s.prune(func(EntryInfo, int64) bool { return true })
+}
+
+// GC deletes the entries the policy rules out, then every blob no entry
+// refers to.
+func (s *FileStore) GC(p GCPolicy) (GCReport, error) {
+	now := time.Now()
+	return s.prune(func(e EntryInfo, size int64) bool { return p.drops(e, size, now) })
+}
+
+// drops reports whether the policy rules out e: it is older than MaxAge, or
+// the cache, at size, is still over MaxSize.
+func (p GCPolicy) drops(e EntryInfo, size int64, now time.Time) bool {
+	if p.MaxAge > 0 && now.Sub(e.UpdatedAt) > p.MaxAge {
+		return true
+	}
+	return p.MaxSize > 0 && size > p.MaxSize
+}
+
+// usage tracks the cache's size while pruning: the entry files plus every
+// blob still referenced, once.
+type usage struct {
+	blobs map[string]int64
+	refs  map[string]int
+	size  int64
+}
+
+func newUsage(entries []EntryInfo, blobs map[string]int64) *usage {
+	u := &usage{blobs: blobs, refs: make(map[string]int)}
+	for _, e := range entries {
+		u.size += e.fileLen
+		for _, d := range e.digests {
+			u.refs[d]++
+		}
+	}
+	for d, n := range blobs {
+		if u.refs[d] > 0 {
+			u.size += n
+		}
+	}
+	return u
+}
+
+// release takes e out of the size, with the blobs only it referred to.
+func (u *usage) release(e EntryInfo) {
+	u.size -= e.fileLen
+	for _, d := range e.digests {
+		if u.refs[d]--; u.refs[d] == 0 {
+			u.size -= u.blobs[d]
+		}
+	}
+}
+
+// prune asks drop about each entry, oldest first, passing the cache's size
+// at that point (see usage). It deletes the dropped entries, then the blobs
+// no kept entry refers to.
+func (s *FileStore) prune(drop func(e EntryInfo, size int64) bool) (GCReport, error) {
+	entries, err := s.Entries()
+	if err != nil {
+		return GCReport{}, err
+	}
+	blobs, err := s.blobSizes()
+	if err != nil {
+		return GCReport{}, err
+	}
+	u := newUsage(entries, blobs)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].UpdatedAt.Before(entries[j].UpdatedAt) })
+
+	rep := GCReport{Removed: []string{}}
+	for _, e := range entries {
+		if !drop(e, u.size) {
+			rep.Kept++
+			continue
+		}
+		if err := os.Remove(e.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return rep, fmt.Errorf("remove cache entry: %w", err)
+		}
+		s.removeEmptyNamespace(filepath.Dir(e.path))
+		rep.Removed = append(rep.Removed, e.Group)
+		rep.Freed += e.fileLen
+		u.release(e)
+	}
+	if err := s.sweepBlobs(u, &rep); err != nil {
+		return rep, err
+	}
+	sort.Strings(rep.Removed)
+	rep.Size = u.size
+	return rep, nil
+}
+
+// sweepBlobs deletes the blobs no kept entry refers to.
+func (s *FileStore) sweepBlobs(u *usage, rep *GCReport) error {
+	for d, n := range u.blobs {
+		if u.refs[d] > 0 {
+			continue
+		}
+		path, _ := s.blobPath(d)
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove blob: %w", err)
+		}
+		_ = os.Remove(filepath.Dir(path)) // only when empty
+		rep.Blobs++
+		rep.Freed += n
+	}
+	return nil
+}
+
+// entryInfo reads the entry at path.
+func (s *FileStore) entryInfo(path string) (EntryInfo, bool) {
+	data, err := os.ReadFile(path) //nolint:gosec // a file under the cache dir
+	if err != nil {
+		return EntryInfo{}, false
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return EntryInfo{}, false
+	}
+	info := EntryInfo{
+		Group:       e.Group,
+		Fingerprint: e.Fingerprint,
+		UpdatedAt:   e.UpdatedAt,
+		DurationMs:  e.Result.DurationMs,
+		Size:        int64(len(data)),
+		Outputs:     len(e.Artifacts),
+		path:        path,
+		fileLen:     int64(len(data)),
+	}
+	if info.Group == "" {
+		info.Group = s.groupOf(path)
+	}
+	seen := make(map[string]bool, len(e.Artifacts))
+	for _, a := range e.Artifacts {
+		info.Size += a.Size
+		if !seen[a.Digest] {
+			seen[a.Digest] = true
+			info.digests = append(info.digests, a.Digest)
+		}
+	}
+	return info, true
+}
+
+// groupOf recovers a group name from its entry's path, for entries written
+// before Entry.Group existed. Sanitized characters stay as "_".
+func (s *FileStore) groupOf(path string) string {
+	rel, err := filepath.Rel(s.dir, path)
+	if err != nil {
+		return path
+	}
+	parts := strings.Split(filepath.ToSlash(strings.TrimSuffix(rel, ".json")), "/")
+	for i, p := range parts {
+		parts[i] = strings.TrimPrefix(p, "@")
+	}
+	return strings.Join(parts, ":")
+}
+
+// blobSizes returns the size of every stored blob by digest.
+func (s *FileStore) blobSizes() (map[string]int64, error) {
+	out := make(map[string]int64)
+	root := filepath.Join(s.dir, "blobs")
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == root {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		digest := "sha256:" + d.Name()
+		if p, err := s.blobPath(digest); err != nil || p != path {
+			return nil // a temporary file, or not ours
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		out[digest] = info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list blobs: %w", err)
+	}
+	return out, nil
+}
+
+// removeEmptyNamespace drops an imported group's @namespace directories
+// once their last entry is gone.
+func (s *FileStore) removeEmptyNamespace(dir string) {
+	for dir != s.dir && strings.HasPrefix(filepath.Base(dir), "@") {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
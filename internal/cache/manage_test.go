@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/result"
+)
+
+// saveWithBlob stores an entry for group whose one output holds content.
+func saveWithBlob(t *testing.T, s *FileStore, group, content string, age time.Duration) {
+	t.Helper()
+	d := Digest([]byte(content))
+	require.NoError(t, s.PutBlob(d, strings.NewReader(content)))
+	require.NoError(t, s.Save(group, &Entry{
+		Group:       group,
+		Fingerprint: "sha256:" + group,
+		Result:      result.RunResult{DurationMs: 1500},
+		Artifacts:   []Artifact{{Path: "out", Digest: d, Size: int64(len(content))}},
+		UpdatedAt:   time.Now().Add(-age),
+	}))
+}
+
+func TestFileStore_Entries(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	entries, err := s.Entries()
+	require.NoError(t, err, "a cache dir that does not exist yet")
+	assert.Empty(t, entries)
+
+	saveWithBlob(t, s, "web:build", "bundle", time.Hour)
+	saveWithBlob(t, s, "test", "report", 0)
+	require.NoError(t, s.Save("lint", &Entry{Fingerprint: "sha256:lint"})) // written before Entry.Group
+	require.NoError(t, s.SaveRun("ci", &RunState{}))
+	require.NoError(t, s.SaveDurations(map[string]int64{"test": 1}))
+	writeFile(t, filepath.Join(dir, "broken.json"), "{")
+
+	entries, err = s.Entries()
+	require.NoError(t, err)
+	groups := make([]string, len(entries))
+	for i, e := range entries {
+		groups[i] = e.Group
+	}
+	assert.Equal(t, []string{"lint", "test", "web:build"}, groups, "runs, stats and unreadable files are left out")
+	assert.Equal(t, int64(1500), entries[1].DurationMs)
+	assert.Equal(t, 1, entries[1].Outputs)
+	assert.Greater(t, entries[1].Size, int64(len("report")), "the entry file and its outputs")
+}
+
+func TestFileStore_RemoveAndClear(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	saveWithBlob(t, s, "web:build", "bundle", 0)
+	saveWithBlob(t, s, "test", "report", 0)
+	require.NoError(t, s.SaveRun("ci", &RunState{}))
+
+	ok, err := s.Remove("web:build")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoDirExists(t, filepath.Join(dir, "@web"), "an emptied namespace goes too")
+	ok, err = s.Remove("web:build")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	rep, err := s.Clear()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test"}, rep.Removed)
+	assert.Equal(t, 2, rep.Blobs, "with the one web:build left behind")
+	assert.Zero(t, rep.Size)
+	_, ok = s.LoadRun("ci")
+	assert.True(t, ok, "run state is kept")
+}
+
+func TestFileStore_GC(t *testing.T) {
+	t.Parallel()
+	s := NewFileStore(t.TempDir())
+	saveWithBlob(t, s, "old", "old output", 30*24*time.Hour)
+	saveWithBlob(t, s, "mid", strings.Repeat("m", 4000), 2*time.Hour)
+	saveWithBlob(t, s, "new", strings.Repeat("n", 4000), time.Minute)
+	orphan := Digest([]byte("orphan"))
+	require.NoError(t, s.PutBlob(orphan, strings.NewReader("orphan")))
+
+	rep, err := s.GC(GCPolicy{})
+	require.NoError(t, err)
+	assert.Empty(t, rep.Removed, "no bound keeps every entry")
+	assert.Equal(t, 1, rep.Blobs, "but the orphaned blob goes")
+	_, err = s.GetBlob(orphan)
+	require.Error(t, err)
+
+	rep, err = s.GC(GCPolicy{MaxAge: 7 * 24 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"old"}, rep.Removed)
+	assert.Equal(t, 1, rep.Blobs)
+	assert.Equal(t, 2, rep.Kept)
+
+	rep, err = s.GC(GCPolicy{MaxSize: rep.Size - 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mid"}, rep.Removed, "the oldest goes first")
+	assert.Less(t, rep.Size, int64(5000))
+	_, ok := s.Load("new")
+	assert.True(t, ok)
+	blobs, err := s.blobSizes()
+	require.NoError(t, err)
+	assert.Len(t, blobs, 1)
+}
+
+func TestFileStore_GCKeepsSharedBlobs(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	saveWithBlob(t, s, "a", "same", 2*time.Hour)
+	saveWithBlob(t, s, "b", "same", 0)
+	writeFile(t, filepath.Join(dir, "blobs", "ab", ".tmp-123"), "partial")
+
+	rep, err := s.GC(GCPolicy{MaxAge: time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, rep.Removed)
+	assert.Zero(t, rep.Blobs, "b still refers to the blob")
+	_, err = os.Stat(filepath.Join(dir, "blobs", "ab", ".tmp-123"))
+	require.NoError(t, err, "files that are not blobs are left alone")
+}
@@ -0,0 +1,348 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRemoteTimeout bounds connecting to a remote cache and waiting for
+// each response when the remote sets no timeout of its own.
+const DefaultRemoteTimeout = 5 * time.Second
+
+// errNotFound is what a remote answers for an entry or blob it lacks.
+var errNotFound = errors.New("not found")
+
+// HTTPCache is a remote cache server: entries under base/ac/ and blobs under
+// base/cas/, each named by a SHA-256 hex digest. That is the layout of
+// bazel-remote's HTTP API, and any static file server that accepts PUT
+// serves it too. An entry is named by the digest of its namespace, group
+// and fingerprint, so entries for different inputs never overwrite each
+// other; a blob by the digest of its content.
+type HTTPCache struct {
+	base      string
+	namespace string
+	token     string
+	timeout   time.Duration
+	client    *http.Client
+}
+
+// HTTPOptions configures an HTTPCache. Timeout bounds connecting and
+// waiting for each response's headers (0 means DefaultRemoteTimeout), and
+// the whole of an entry's fetch; a blob's transfer is not bounded, so a
+// large one is not cut short. Token, when
+// set, is sent as a bearer token. Namespace, when set, keeps the entries of
+// projects sharing a server apart.
+type HTTPOptions struct {
+	Namespace string
+	Token     string
+	Timeout   time.Duration
+}
+
+// NewHTTPCache returns a client for the server at base.
+func NewHTTPCache(base string, opts HTTPOptions) *HTTPCache {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultRemoteTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	return &HTTPCache{
+		base:      strings.TrimSuffix(base, "/"),
+		namespace: opts.Namespace,
+		token:     opts.Token,
+		timeout:   timeout,
+		client:    &http.Client{Transport: transport},
+	}
+}
+
+// Fetch returns the remote entry for a group's fingerprint; errNotFound
+// when there is none.
+func (c *HTTPCache) Fetch(ctx context.Context, group, fingerprint string) (*Entry, error) {
+	return c.fetch(ctx, group, c.entryKey(group, fingerprint))
+}
+
+// Latest returns the entry last pushed for a group, whatever its
+// fingerprint; errNotFound when there is none.
+func (c *HTTPCache) Latest(ctx context.Context, group string) (*Entry, error) {
+	return c.fetch(ctx, group, c.latestKey(group))
+}
+
+// fetch reads an entry under the client's timeout: entries are small, and a
+// server that stalls after its headers must not hang the lookup.
+func (c *HTTPCache) fetch(ctx context.Context, group, path string) (*Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	body, err := c.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var e Entry
+	if err := json.NewDecoder(body).Decode(&e); err != nil {
+		return nil, fmt.Errorf("decode remote entry for %q: %w", group, err)
+	}
+	return &e, nil
+}
+
+// Push uploads the entry for a group under its fingerprint, and as the
+// group's latest.
+func (c *HTTPCache) Push(ctx context.Context, group string, e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode cache entry: %w", err)
+	}
+	for _, path := range []string{c.entryKey(group, e.Fingerprint), c.latestKey(group)} {
+		if err := c.put(ctx, path, bytes.NewReader(data), int64(len(data))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasBlob reports whether the server holds the blob.
+func (c *HTTPCache) HasBlob(ctx context.Context, digest string) (bool, error) {
+	path, err := blobKey(digest)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(ctx, http.MethodHead, path, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// GetBlob opens the blob stored under digest.
+func (c *HTTPCache) GetBlob(ctx context.Context, digest string) (io.ReadCloser, error) {
+	path, err := blobKey(digest)
+	if err != nil {
+		return nil, err
+	}
+	return c.get(ctx, path)
+}
+
+// PutBlob uploads size bytes of r under digest.
+func (c *HTTPCache) PutBlob(ctx context.Context, digest string, r io.Reader, size int64) error {
+	path, err := blobKey(digest)
+	if err != nil {
+		return err
+	}
+	return c.put(ctx, path, r, size)
+}
+
+func (c *HTTPCache) get(ctx context.Context, path string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %w", path, errNotFound)
+	default:
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+}
+
+func (c *HTTPCache) put(ctx context.Context, path string, r io.Reader, size int64) error {
+	resp, err := c.do(ctx, http.MethodPut, path, r, size)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: %s", path, resp.Status)
+	}
+	return nil
+}
+
+func (c *HTTPCache) do(ctx context.Context, method, path string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+"/"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.client.Do(req)
+}
+
+// entryKey returns the remote path of a group's entry for a fingerprint.
+func (c *HTTPCache) entryKey(group, fingerprint string) string {
+	return acKey("keepup-entry", c.namespace, group, fingerprint)
+}
+
+// latestKey returns the remote path of the entry last pushed for a group.
+func (c *HTTPCache) latestKey(group string) string {
+	return acKey("keepup-latest", c.namespace, group)
+}
+
+// acKey names an entry by the digest of its NUL-joined parts.
+func acKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return "ac/" + hex.EncodeToString(sum[:])
+}
+
+// blobKey returns the remote path of a blob.
+func blobKey(digest string) (string, error) {
+	sum, ok := strings.CutPrefix(digest, "sha256:")
+	if _, err := hex.DecodeString(sum); !ok || err != nil || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid blob digest %q", digest)
+	}
+	return "cas/" + sum, nil
+}
+
+// Tiered is a local FileStore in front of a remote HTTPCache. Lookups try
+// the local store first and copy the matching entries and blobs they fetch
+// from the remote into it; saves go to both unless the remote is
+// read-only. Every remote request runs under the context Tiered was made
+// with, so a canceled run stops waiting on the server. The first remote
+// request that fails is handed to onDown, and from then on Tiered works
+// local-only.
+type Tiered struct {
+	ctx      context.Context
+	local    *FileStore
+	remote   *HTTPCache
+	readOnly bool
+	onDown   func(error)
+	down     atomic.Bool
+}
+
+// NewTiered returns local backed by remote. onDown may be nil.
+func NewTiered(ctx context.Context, local *FileStore, remote *HTTPCache, readOnly bool, onDown func(error)) *Tiered {
+	if onDown == nil {
+		onDown = func(error) {}
+	}
+	return &Tiered{ctx: ctx, local: local, remote: remote, readOnly: readOnly, onDown: onDown}
+}
+
+// Load returns the local entry for a group, or else the one last pushed to
+// the remote. The remote one is not kept: the caller has yet to check that
+// it matches.
+func (t *Tiered) Load(group string) (*Entry, bool) {
+	if e, ok := t.local.Load(group); ok {
+		return e, true
+	}
+	if t.down.Load() {
+		return nil, false
+	}
+	e, err := t.remote.Latest(t.ctx, group)
+	if !t.check(err) {
+		return nil, false
+	}
+	return e, true
+}
+
+// Find returns the group's entry with the given fingerprint: the local one
+// when it matches, or else the remote's entry for that fingerprint, which
+// then replaces the local one.
+func (t *Tiered) Find(group, fingerprint string) (*Entry, bool) {
+	if e, ok := t.local.Load(group); ok && e.Fingerprint == fingerprint {
+		return e, true
+	}
+	if t.down.Load() {
+		return nil, false
+	}
+	e, err := t.remote.Fetch(t.ctx, group, fingerprint)
+	if !t.check(err) || e.Fingerprint != fingerprint {
+		return nil, false
+	}
+	_ = t.local.Save(group, e)
+	return e, true
+}
+
+// Save writes the entry locally, then uploads it. Only the local write can
+// fail the save.
+func (t *Tiered) Save(group string, e *Entry) error {
+	if err := t.local.Save(group, e); err != nil {
+		return err
+	}
+	if t.writable() {
+		t.check(t.remote.Push(t.ctx, group, e))
+	}
+	return nil
+}
+
+// PutBlob stores the blob locally, then uploads it unless the remote
+// already has it.
+func (t *Tiered) PutBlob(digest string, r io.Reader) error {
+	if err := t.local.PutBlob(digest, r); err != nil {
+		return err
+	}
+	if !t.writable() {
+		return nil
+	}
+	has, err := t.remote.HasBlob(t.ctx, digest)
+	if !t.check(err) || has {
+		return nil
+	}
+	path, err := t.local.blobPath(digest)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path) //nolint:gosec // path derives from a validated digest
+	if err != nil {
+		return fmt.Errorf("read blob: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("read blob: %w", err)
+	}
+	t.check(t.remote.PutBlob(t.ctx, digest, f, info.Size()))
+	return nil
+}
+
+// GetBlob opens the local blob, first fetching it from the remote when it
+// is missing.
+func (t *Tiered) GetBlob(digest string) (io.ReadCloser, error) {
+	if r, err := t.local.GetBlob(digest); err == nil || t.down.Load() {
+		return r, err
+	}
+	body, err := t.remote.GetBlob(t.ctx, digest)
+	if !t.check(err) {
+		return nil, err
+	}
+	defer body.Close()
+	if err := t.local.PutBlob(digest, body); err != nil {
+		return nil, err
+	}
+	return t.local.GetBlob(digest)
+}
+
+func (t *Tiered) writable() bool { return !t.readOnly && !t.down.Load() }
+
+// check reports whether a remote request succeeded. A missing entry or blob
+// is a plain miss, and so is a request the run's cancellation cut short;
+// any other error takes the remote down for the run.
+func (t *Tiered) check(err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errNotFound), t.ctx.Err() != nil:
+	case t.down.CompareAndSwap(false, true):
+		t.onDown(err)
+	}
+	return false
+}
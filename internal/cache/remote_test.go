@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/cache/cachetest"
+	"github.com/quike/keepup/internal/config"
+)
+
+func TestTiered_SharesEntriesAndBlobs(t *testing.T) {
+	t.Parallel()
+	srv := cachetest.NewServer(t)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "out", "app"), "binary")
+	spec := &config.Cache{Writes: []string{"out"}}
+
+	// One machine builds and uploads.
+	up := NewTiered(context.Background(), NewFileStore(t.TempDir()), NewHTTPCache(srv.URL, HTTPOptions{}), false, nil)
+	arts, err := Collect(spec, dir, up)
+	require.NoError(t, err)
+	require.NoError(t, up.Save("build", &Entry{Fingerprint: "fp1", Artifacts: arts}))
+	assert.Equal(t, 2, srv.Len("ac"), "the entry under its fingerprint, and as the group's latest")
+	assert.Equal(t, 1, srv.Len("cas"))
+
+	// Another starts with an empty local cache and gets the hit.
+	down := NewTiered(context.Background(), NewFileStore(t.TempDir()), NewHTTPCache(srv.URL+"/", HTTPOptions{}), false, nil)
+	_, ok := Find(down, "build", "other")
+	assert.False(t, ok, "a different fingerprint is a miss")
+	e, ok := Find(down, "build", "fp1")
+	require.True(t, ok)
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "out")))
+	n, err := Restore(spec, dir, e.Artifacts, down)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Both now sit in the local store: no more remote reads.
+	gets := srv.Requests("GET", "ac") + srv.Requests("GET", "cas")
+	e, ok = Find(down, "build", "fp1")
+	require.True(t, ok)
+	r, err := down.GetBlob(e.Artifacts[0].Digest)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, gets, srv.Requests("GET", "ac")+srv.Requests("GET", "cas"))
+
+	// A blob the server already has is not uploaded again.
+	puts := srv.Requests("PUT", "cas")
+	_, err = Collect(spec, dir, down)
+	require.NoError(t, err)
+	assert.Equal(t, puts, srv.Requests("PUT", "cas"))
+}
+
+func TestTiered_ReadOnly(t *testing.T) {
+	t.Parallel()
+	srv := cachetest.NewServer(t)
+	ro := NewTiered(context.Background(), NewFileStore(t.TempDir()), NewHTTPCache(srv.URL, HTTPOptions{}), true, nil)
+	d := Digest([]byte("x"))
+	require.NoError(t, ro.PutBlob(d, strings.NewReader("x")))
+	require.NoError(t, ro.Save("g", &Entry{Fingerprint: "fp"}))
+	assert.Zero(t, srv.Requests("PUT", "ac")+srv.Requests("PUT", "cas")+srv.Requests("HEAD", "cas"))
+	e, ok := ro.Load("g")
+	require.True(t, ok, "the local store still works")
+	assert.Equal(t, "fp", e.Fingerprint)
+}
+
+func TestTiered_DegradesToLocal(t *testing.T) {
+	t.Parallel()
+	for name, setup := range map[string]func(*cachetest.Server) time.Duration{
+		"server error": func(s *cachetest.Server) time.Duration { s.Fail(true); return 0 },
+		"timeout":      func(s *cachetest.Server) time.Duration { s.Delay(time.Second); return 50 * time.Millisecond },
+		"bad token":    func(s *cachetest.Server) time.Duration { s.RequireToken("secret"); return 0 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			srv := cachetest.NewServer(t)
+			timeout := setup(srv)
+			var downs []error
+			remote := NewHTTPCache(srv.URL, HTTPOptions{Token: "wrong", Timeout: timeout})
+			tc := NewTiered(context.Background(), NewFileStore(t.TempDir()), remote, false,
+				func(err error) { downs = append(downs, err) })
+
+			_, ok := Find(tc, "g", "fp")
+			assert.False(t, ok)
+			require.NoError(t, tc.Save("g", &Entry{Fingerprint: "fp"}), "the local save still succeeds")
+			_, ok = Find(tc, "g", "fp")
+			assert.True(t, ok)
+			_, err := tc.GetBlob(Digest([]byte("missing")))
+			require.Error(t, err)
+
+			assert.Len(t, downs, 1, "reported once")
+			assert.Equal(t, 1, srv.Requests("GET", "ac"), "no more requests once down")
+			assert.Zero(t, srv.Requests("PUT", "ac"))
+		})
+	}
+}
+
+func TestTiered_KeepsEntriesPerFingerprint(t *testing.T) {
+	t.Parallel()
+	srv := cachetest.NewServer(t)
+	main := NewTiered(context.Background(), NewFileStore(t.TempDir()), NewHTTPCache(srv.URL, HTTPOptions{}), false, nil)
+	require.NoError(t, main.Save("build", &Entry{Fingerprint: "fp-main"}))
+	branch := NewTiered(context.Background(), NewFileStore(t.TempDir()), NewHTTPCache(srv.URL, HTTPOptions{}), false, nil)
+	require.NoError(t, branch.Save("build", &Entry{Fingerprint: "fp-branch"}))
+
+	local := NewFileStore(t.TempDir())
+	require.NoError(t, local.Save("build", &Entry{Fingerprint: "fp-old"}))
+	tc := NewTiered(context.Background(), local, NewHTTPCache(srv.URL, HTTPOptions{}), false, nil)
+	_, ok := Find(tc, "build", "fp-other")
+	assert.False(t, ok)
+	e, ok := local.Load("build")
+	require.True(t, ok)
+	assert.Equal(t, "fp-old", e.Fingerprint, "a miss leaves the local entry alone")
+
+	e, ok = Find(tc, "build", "fp-main")
+	require.True(t, ok, "a later push for another fingerprint does not evict it")
+	assert.Equal(t, "fp-main", e.Fingerprint)
+	e, ok = local.Load("build")
+	require.True(t, ok)
+	assert.Equal(t, "fp-main", e.Fingerprint, "a remote hit is kept locally")
+
+	fresh := NewTiered(context.Background(), NewFileStore(t.TempDir()), NewHTTPCache(srv.URL, HTTPOptions{}), false, nil)
+	e, ok = fresh.Load("build")
+	require.True(t, ok)
+	assert.Equal(t, "fp-branch", e.Fingerprint, "Load falls back to the latest push")
+	_, ok = fresh.local.Load("build")
+	assert.False(t, ok, "which is not kept before it is checked")
+}
+
+func TestHTTPCache_Namespace(t *testing.T) {
+	t.Parallel()
+	srv := cachetest.NewServer(t)
+	ctx := context.Background()
+	api := NewHTTPCache(srv.URL, HTTPOptions{Namespace: "api"})
+	web := NewHTTPCache(srv.URL, HTTPOptions{Namespace: "web"})
+	require.NoError(t, api.Push(ctx, "build", &Entry{Fingerprint: "fp"}))
+	_, err := web.Fetch(ctx, "build", "fp")
+	require.ErrorIs(t, err, errNotFound)
+	_, err = web.Latest(ctx, "build")
+	require.ErrorIs(t, err, errNotFound)
+	e, err := api.Fetch(ctx, "build", "fp")
+	require.NoError(t, err)
+	assert.Equal(t, "fp", e.Fingerprint)
+}
+
+func TestHTTPCache_TimeoutSparesSlowBodies(t *testing.T) {
+	t.Parallel()
+	srv := cachetest.NewServer(t)
+	ctx := context.Background()
+	c := NewHTTPCache(srv.URL, HTTPOptions{Timeout: 50 * time.Millisecond})
+	d := Digest([]byte("large"))
+	require.NoError(t, c.PutBlob(ctx, d, strings.NewReader("large"), 5))
+	srv.SlowBody(200 * time.Millisecond)
+	r, err := c.GetBlob(ctx, d)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err, "the timeout bounds the wait for headers, not the transfer")
+	assert.Equal(t, "large", string(data))
+}
+
+func TestTiered_StalledEntryBodyTimesOut(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"fingerprint":`))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	var downs []error
+	tc := NewTiered(context.Background(), NewFileStore(t.TempDir()), NewHTTPCache(srv.URL, HTTPOptions{Timeout: 50 * time.Millisecond}),
+		false, func(err error) { downs = append(downs, err) })
+	start := time.Now()
+	_, ok := Find(tc, "g", "fp")
+	assert.False(t, ok)
+	assert.Less(t, time.Since(start), time.Second, "the entry's body is bounded too")
+	assert.Len(t, downs, 1, "a stalled server takes the remote down")
+}
+
+func TestTiered_CanceledRunIsNotAnOutage(t *testing.T) {
+	t.Parallel()
+	srv := cachetest.NewServer(t)
+	srv.Delay(time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	var downs []error
+	tc := NewTiered(ctx, NewFileStore(t.TempDir()), NewHTTPCache(srv.URL, HTTPOptions{}), false,
+		func(err error) { downs = append(downs, err) })
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, ok := Find(tc, "g", "fp")
+	assert.False(t, ok)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "the request stops with the run")
+	assert.Empty(t, downs)
+}
+
+func TestHTTPCache_Token(t *testing.T) {
+	t.Parallel()
+	srv := cachetest.NewServer(t)
+	srv.RequireToken("secret")
+	ctx := context.Background()
+	c := NewHTTPCache(srv.URL, HTTPOptions{Token: "secret"})
+	require.NoError(t, c.Push(ctx, "g", &Entry{Fingerprint: "fp"}))
+	e, err := c.Fetch(ctx, "g", "fp")
+	require.NoError(t, err)
+	assert.Equal(t, "fp", e.Fingerprint)
+
+	_, err = c.Fetch(ctx, "other", "fp")
+	require.ErrorIs(t, err, errNotFound)
+	_, err = c.GetBlob(ctx, "sha256:../x")
+	require.Error(t, err)
+}
+
+func TestTiered_RejectsCorruptRemoteBlob(t *testing.T) {
+	t.Parallel()
+	srv := cachetest.NewServer(t)
+	remote := NewHTTPCache(srv.URL, HTTPOptions{})
+	d := Digest([]byte("good"))
+	require.NoError(t, remote.PutBlob(context.Background(), d, strings.NewReader("evil"), 4))
+
+	tc := NewTiered(context.Background(), NewFileStore(t.TempDir()), remote, false, nil)
+	_, err := tc.GetBlob(d)
+	require.Error(t, err, "the blob does not match its digest")
+}
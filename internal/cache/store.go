@@ -1,14 +1,21 @@
 package cache
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-// FileStore persists one JSON entry per group under a directory.
+// FileStore persists one JSON entry per group under a directory, plus one
+// run-state file per flow under its runs/ subdirectory, the group durations
+// under stats/, the logs of truncated runs under logs/, and the blobs of
+// cached outputs under blobs/.
 type FileStore struct {
 	dir string
 }
@@ -31,25 +38,186 @@ func (s *FileStore) Load(group string) (*Entry, bool) {
 	return &e, true
 }
 
-// Save writes the entry for a group, creating the cache directory if needed.
+// Save writes the entry for a group, creating the cache directory (and an
+// imported group's namespace subdirectory) if needed.
 func (s *FileStore) Save(group string, e *Entry) error {
-	if err := os.MkdirAll(s.dir, 0o755); err != nil {
-		return fmt.Errorf("create cache dir %q: %w", s.dir, err)
+	path := s.path(group)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create cache dir %q: %w", filepath.Dir(path), err)
 	}
 	data, err := json.MarshalIndent(e, "", "  ")
 	if err != nil {
 		return fmt.Errorf("encode cache entry: %w", err)
 	}
-	if err := os.WriteFile(s.path(group), data, 0o600); err != nil {
+	if err := os.WriteFile(path, data, 0o600); err != nil {
 		return fmt.Errorf("write cache entry: %w", err)
 	}
 	return nil
 }
 
-// path returns the on-disk file for a group, sanitizing the name so it is
-// always a single safe filename component.
+// LoadRun returns the saved run state for a flow, or (nil, false) if absent
+// or unreadable.
+func (s *FileStore) LoadRun(flow string) (*RunState, bool) {
+	data, err := os.ReadFile(s.runPath(flow))
+	if err != nil {
+		return nil, false
+	}
+	var st RunState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, false
+	}
+	return &st, true
+}
+
+// SaveRun writes the run state for a flow, creating runs/ if needed.
+func (s *FileStore) SaveRun(flow string, st *RunState) error {
+	dir := filepath.Dir(s.runPath(flow))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create run state dir %q: %w", dir, err)
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode run state: %w", err)
+	}
+	if err := os.WriteFile(s.runPath(flow), data, 0o600); err != nil {
+		return fmt.Errorf("write run state: %w", err)
+	}
+	return nil
+}
+
+// ClearRun removes the saved run state for a flow; a missing file is not an
+// error.
+func (s *FileStore) ClearRun(flow string) error {
+	if err := os.Remove(s.runPath(flow)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove run state: %w", err)
+	}
+	return nil
+}
+
+// LoadDurations returns the saved group durations; a missing or unreadable
+// file yields an empty map.
+func (s *FileStore) LoadDurations() map[string]int64 {
+	out := make(map[string]int64)
+	data, err := os.ReadFile(s.durationsPath())
+	if err != nil {
+		return out
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return make(map[string]int64)
+	}
+	return out
+}
+
+// SaveDurations writes the group durations, creating stats/ if needed.
+func (s *FileStore) SaveDurations(d map[string]int64) error {
+	dir := filepath.Dir(s.durationsPath())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create stats dir %q: %w", dir, err)
+	}
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode durations: %w", err)
+	}
+	if err := os.WriteFile(s.durationsPath(), data, 0o600); err != nil {
+		return fmt.Errorf("write durations: %w", err)
+	}
+	return nil
+}
+
+// PutBlob stores r under digest unless a blob with that digest is already
+// there, refusing content that does not match the digest. The blob appears
+// atomically, so a concurrent reader never sees it half-written.
+func (s *FileStore) PutBlob(digest string, r io.Reader) error {
+	path, err := s.blobPath(digest)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create blob dir %q: %w", filepath.Dir(path), err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("write blob: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write blob: %w", err)
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("write blob: content is %s, not %s", got, digest)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write blob: %w", err)
+	}
+	return nil
+}
+
+// GetBlob opens the blob stored under digest.
+func (s *FileStore) GetBlob(digest string) (io.ReadCloser, error) {
+	path, err := s.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path) //nolint:gosec // path derives from a validated digest
+	if err != nil {
+		return nil, fmt.Errorf("read blob: %w", err)
+	}
+	return f, nil
+}
+
+// blobPath returns the on-disk file for a blob, fanned out by the first
+// two hex digits of its digest.
+func (s *FileStore) blobPath(digest string) (string, error) {
+	sum, ok := strings.CutPrefix(digest, "sha256:")
+	if _, err := hex.DecodeString(sum); !ok || err != nil || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid blob digest %q", digest)
+	}
+	return filepath.Join(s.dir, "blobs", sum[:2], sum), nil
+}
+
+func (s *FileStore) durationsPath() string {
+	return filepath.Join(s.dir, "stats", "durations.json")
+}
+
+// runPath returns the on-disk run-state file for a flow.
+func (s *FileStore) runPath(flow string) string {
+	return filepath.Join(s.dir, "runs", sanitize(flow)+".json")
+}
+
+// LogPath returns the file a group's complete output is spilled to when its
+// capture: limit truncates it. The caller creates it.
+func (s *FileStore) LogPath(group string) string {
+	return groupPath(filepath.Join(s.dir, "logs"), group, ".log")
+}
+
+// path returns the on-disk file for a group's entry.
 func (s *FileStore) path(group string) string {
-	return filepath.Join(s.dir, sanitize(group)+".json")
+	return groupPath(s.dir, group, ".json")
+}
+
+// groupPath returns a group's file under dir. Each namespace segment of an
+// imported group's qualified name becomes a directory marked with "@", so
+// "web:build" lives at @web/build.json and never meets a root group's file
+// or the runs/ directory; segments are sanitized so each is a single safe
+// path component.
+func groupPath(dir, group, ext string) string {
+	parts := strings.Split(group, ":")
+	for i, p := range parts {
+		parts[i] = sanitize(p)
+		if i < len(parts)-1 {
+			parts[i] = "@" + parts[i]
+		}
+	}
+	parts[len(parts)-1] += ext
+	return filepath.Join(append([]string{dir}, parts...)...)
 }
 
 func sanitize(name string) string {
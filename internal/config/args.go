@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ArgType is the type of a flow argument.
+type ArgType string
+
+// Argument types. An empty type means ArgString.
+const (
+	ArgString ArgType = "string"
+	ArgInt    ArgType = "int"
+	ArgBool   ArgType = "bool"
+	ArgEnum   ArgType = "enum"
+)
+
+// Arg declares one parameter a flow accepts from the command line
+// (`keepup run release --arg version=1.4.0`). Templates read it with
+// {{ arg "version" }}.
+//
+// Default is a pointer so an explicit empty default stays distinguishable
+// from none. An enum lists its allowed Values. An optional arg with no
+// default renders as "".
+type Arg struct {
+	Name        string   `yaml:"name"`
+	Type        ArgType  `yaml:"type,omitempty"`
+	Default     *string  `yaml:"default,omitempty"`
+	Required    bool     `yaml:"required,omitempty"`
+	Values      []string `yaml:"values,omitempty"`
+	Description string   `yaml:"description,omitempty"`
+}
+
+var argNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// validateArgs normalizes the flow's arg types and checks each declaration,
+// including that a default satisfies its own type.
+func validateArgs(name string, f *Flow) error {
+	seen := make(map[string]bool, len(f.Args))
+	for i := range f.Args {
+		a := &f.Args[i]
+		if !argNameRe.MatchString(a.Name) {
+			return fmt.Errorf("flow %q: args[%d]: invalid name %q", name, i, a.Name)
+		}
+		if seen[a.Name] {
+			return fmt.Errorf("flow %q: arg %q is declared twice", name, a.Name)
+		}
+		seen[a.Name] = true
+		if a.Type == "" {
+			a.Type = ArgString
+		}
+		switch a.Type {
+		case ArgString, ArgInt, ArgBool:
+			if len(a.Values) > 0 {
+				return fmt.Errorf("flow %q: arg %q: values: only applies to type enum", name, a.Name)
+			}
+		case ArgEnum:
+			if len(a.Values) == 0 {
+				return fmt.Errorf("flow %q: arg %q: type enum needs values:", name, a.Name)
+			}
+		default:
+			return fmt.Errorf("flow %q: arg %q: unknown type %q (use string, int, bool or enum)", name, a.Name, a.Type)
+		}
+		if a.Default == nil {
+			continue
+		}
+		if a.Required {
+			return fmt.Errorf("flow %q: arg %q: a required arg cannot have a default", name, a.Name)
+		}
+		if _, err := a.parse(*a.Default); err != nil {
+			return fmt.Errorf("flow %q: arg %q: default: %w", name, a.Name, err)
+		}
+	}
+	return nil
+}
+
+// parse checks a raw value against the arg's type and returns its canonical
+// form: ints and bools are normalized ("007" → "7", "yes" is rejected, "1" →
+// "true"), strings and enum values pass through.
+func (a *Arg) parse(raw string) (string, error) {
+	switch a.Type {
+	case ArgInt:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an int", raw)
+		}
+		return strconv.Itoa(n), nil
+	case ArgBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a bool (use true or false)", raw)
+		}
+		return strconv.FormatBool(b), nil
+	case ArgEnum:
+		if !slices.Contains(a.Values, raw) {
+			return "", fmt.Errorf("%q is not one of %s", raw, strings.Join(a.Values, ", "))
+		}
+	}
+	return raw, nil
+}
+
+// ResolveArgs validates values given on the command line against the flow's
+// declared args and returns every declared arg's value: the given one,
+// else its default, else "". An undeclared name, a missing required arg, or
+// a value of the wrong type is an error.
+func (f *Flow) ResolveArgs(given map[string]string) (map[string]string, error) {
+	declared := make(map[string]*Arg, len(f.Args))
+	for i := range f.Args {
+		declared[f.Args[i].Name] = &f.Args[i]
+	}
+	names := make([]string, 0, len(given))
+	for n := range given {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if _, ok := declared[n]; !ok {
+			return nil, fmt.Errorf("unknown arg %q%s", n, f.argList())
+		}
+	}
+
+	out := make(map[string]string, len(f.Args))
+	for i := range f.Args {
+		a := &f.Args[i]
+		raw, ok := given[a.Name]
+		switch {
+		case ok:
+			v, err := a.parse(raw)
+			if err != nil {
+				return nil, fmt.Errorf("arg %q: %w", a.Name, err)
+			}
+			out[a.Name] = v
+		case a.Required:
+			return nil, fmt.Errorf("missing required arg %q (pass --arg %s=<%s>)", a.Name, a.Name, a.Type)
+		case a.Default != nil:
+			out[a.Name], _ = a.parse(*a.Default) // validated at load
+		default:
+			out[a.Name] = ""
+		}
+	}
+	return out, nil
+}
+
+// argList names the declared args for an "unknown arg" error.
+func (f *Flow) argList() string {
+	if len(f.Args) == 0 {
+		return " (the flow declares no args)"
+	}
+	names := make([]string, len(f.Args))
+	for i := range f.Args {
+		names[i] = f.Args[i].Name
+	}
+	return " (declared: " + strings.Join(names, ", ") + ")"
+}
+
+// RequiresArgs reports whether the flow has an arg without a default that
+// must be given on the command line.
+func (f *Flow) RequiresArgs() bool {
+	return slices.ContainsFunc(f.Args, func(a Arg) bool { return a.Required })
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const argsCfg = `
+version: 2
+groups:
+  - {name: tag, command: git, params: [tag, 'v{{ arg "version" }}']}
+flows:
+  release:
+    args:
+      - {name: version, required: true, description: "Version to tag"}
+      - {name: jobs, type: int, default: "4"}
+      - {name: push, type: bool, default: "false"}
+      - {name: channel, type: enum, values: [stable, beta], default: stable}
+      - {name: note}
+    steps:
+      - run: [tag]
+`
+
+func TestNewConfig_Args(t *testing.T) {
+	t.Parallel()
+	cfg, err := NewConfig([]byte(argsCfg))
+	require.NoError(t, err)
+	f := cfg.Flows["release"]
+	require.Len(t, f.Args, 5)
+	assert.Equal(t, ArgString, f.Args[0].Type, "an untyped arg is a string")
+	assert.True(t, f.RequiresArgs())
+}
+
+func TestResolveArgs(t *testing.T) {
+	t.Parallel()
+	cfg, err := NewConfig([]byte(argsCfg))
+	require.NoError(t, err)
+	f := cfg.Flows["release"]
+
+	got, err := f.ResolveArgs(map[string]string{"version": "1.4.0", "jobs": "08", "push": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"version": "1.4.0", "jobs": "8", "push": "true", "channel": "stable", "note": "",
+	}, got)
+
+	cases := []struct {
+		name  string
+		given map[string]string
+		want  string
+	}{
+		{"missing required", nil, `missing required arg "version" (pass --arg version=<string>)`},
+		{"unknown", map[string]string{"version": "1", "dry": "true"}, `unknown arg "dry" (declared: version, jobs, push, channel, note)`},
+		{"bad int", map[string]string{"version": "1", "jobs": "many"}, `arg "jobs": "many" is not an int`},
+		{"bad bool", map[string]string{"version": "1", "push": "yes"}, `arg "push": "yes" is not a bool`},
+		{"bad enum", map[string]string{"version": "1", "channel": "nightly"}, `"nightly" is not one of stable, beta`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := f.ResolveArgs(tc.given)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
+
+func TestValidateArgs_Rejects(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		args string
+		want string
+	}{
+		{"bad name", `[{name: "1x"}]`, `invalid name "1x"`},
+		{"duplicate", `[{name: a}, {name: a}]`, `arg "a" is declared twice`},
+		{"unknown type", `[{name: a, type: float}]`, `unknown type "float"`},
+		{"enum without values", `[{name: a, type: enum}]`, "type enum needs values:"},
+		{"values on a string", `[{name: a, values: [x]}]`, "values: only applies to type enum"},
+		{"required with default", `[{name: a, required: true, default: x}]`, "a required arg cannot have a default"},
+		{"bad default", `[{name: a, type: int, default: x}]`, `default: "x" is not an int`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			body := "version: 2\ngroups:\n  - {name: g, command: echo}\nflows:\n  f:\n    args: " + tc.args +
+				"\n    steps:\n      - run: [g]\n"
+			_, err := NewConfig([]byte(body))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
+
+func TestValidateSubFlows_RequiredArgs(t *testing.T) {
+	t.Parallel()
+	body := `
+version: 2
+groups:
+  - {name: g, command: echo}
+flows:
+  release:
+    args: [{name: version, required: true}]
+    steps:
+      - run: [g]
+  ci:
+    steps:
+      - run: [{flow: release}]
+`
+	_, err := NewConfig([]byte(body))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `embedded flow "release" has required args`)
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Capture retention modes.
+const (
+	CaptureTail = "tail"
+	CaptureHead = "head"
+	CaptureNone = "none"
+)
+
+// Capture bounds how much of a group's output keepup keeps in memory, in
+// its result, and in its cache entry. Max is a size ("64KiB", "10MB", or
+// bytes) kept of each of stdout, stderr and the combined output; Keep picks
+// which part survives: the last bytes (tail, the default), the first
+// (head), or nothing (none). Whatever is cut is not lost: the complete
+// output goes to a log file instead.
+//
+// A scalar is shorthand: `capture: 1MiB` sets Max, `capture: none` sets Keep.
+type Capture struct {
+	Max  string `yaml:"max,omitempty"`
+	Keep string `yaml:"keep,omitempty"`
+}
+
+// UnmarshalYAML accepts a scalar (a size, or "none") or a {max, keep}
+// mapping.
+func (c *Capture) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if node.Value == CaptureNone {
+			c.Keep = CaptureNone
+		} else {
+			c.Max = node.Value
+		}
+		return nil
+	}
+	type plain Capture
+	if err := node.Decode((*plain)(c)); err != nil {
+		return fmt.Errorf("capture: %w", err)
+	}
+	return nil
+}
+
+// Limit returns the number of bytes kept of each stream: 0 for keep: none.
+// The size was validated at load.
+func (c *Capture) Limit() int64 {
+	if c.Keep == CaptureNone {
+		return 0
+	}
+	n, _ := ParseSize(c.Max)
+	return n
+}
+
+// validateCapture normalizes and checks a group's capture: block.
+func validateCapture(g *Group) error {
+	c := g.Capture
+	if c == nil {
+		return nil
+	}
+	switch c.Keep {
+	case "":
+		c.Keep = CaptureTail
+	case CaptureTail, CaptureHead:
+	case CaptureNone:
+		if c.Max != "" {
+			return fmt.Errorf("group %q: capture: keep: none takes no max", g.Name)
+		}
+		return nil
+	default:
+		return fmt.Errorf("group %q: capture: unknown keep %q (use 'tail', 'head' or 'none')", g.Name, c.Keep)
+	}
+	if c.Max == "" {
+		return fmt.Errorf("group %q: capture: max is required", g.Name)
+	}
+	if _, err := ParseSize(c.Max); err != nil {
+		return fmt.Errorf("group %q: capture: %w", g.Name, err)
+	}
+	return nil
+}
+
+// sizeUnits maps the accepted size suffixes to their multiplier: decimal
+// (KB, MB, GB) and binary (KiB, MiB, GiB).
+var sizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+// ParseSize parses a byte size such as "512", "64KiB" or "10MB".
+func ParseSize(s string) (int64, error) {
+	num, mult := strings.TrimSpace(s), int64(1)
+	for _, u := range sizeUnits {
+		if rest, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, mult = strings.TrimSpace(rest), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (use bytes or a KB, MB, GB, KiB, MiB, GiB suffix)", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfig_Capture(t *testing.T) {
+	t.Parallel()
+	cfg, err := NewConfig([]byte(`
+version: 2
+groups:
+  - {name: a, command: go, capture: 1MiB}
+  - {name: b, command: go, capture: none}
+  - {name: c, command: go, capture: {max: 10KB, keep: head}}
+flows:
+  f:
+    steps:
+      - run: [a, b, c]
+`))
+	require.NoError(t, err)
+	a, b, c := cfg.GroupByName("a").Capture, cfg.GroupByName("b").Capture, cfg.GroupByName("c").Capture
+	assert.Equal(t, &Capture{Max: "1MiB", Keep: CaptureTail}, a, "keep defaults to tail")
+	assert.Equal(t, int64(1<<20), a.Limit())
+	assert.Equal(t, int64(0), b.Limit())
+	assert.Equal(t, int64(10_000), c.Limit())
+}
+
+func TestParseSize(t *testing.T) {
+	t.Parallel()
+	for in, want := range map[string]int64{"512": 512, "1B": 1, "2 KiB": 2048, "3MB": 3_000_000, "1GiB": 1 << 30} {
+		got, err := ParseSize(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"", "0", "-1KB", "1.5MB", "10TB", "lots"} {
+		_, err := ParseSize(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestValidateCapture_Rejects(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name  string
+		group string
+		want  string
+	}{
+		{"no max", "{name: g, command: go, capture: {keep: head}}", "capture: max is required"},
+		{"bad max", "{name: g, command: go, capture: big}", `invalid size "big"`},
+		{"bad keep", "{name: g, command: go, capture: {max: 1KB, keep: middle}}", `unknown keep "middle"`},
+		{"none with max", "{name: g, command: go, capture: {max: 1KB, keep: none}}", "keep: none takes no max"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			body := "version: 2\ngroups:\n  - " + tc.group + "\nflows:\n  f:\n    steps:\n      - run: [g]\n"
+			_, err := NewConfig([]byte(body))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// SubFlowSep joins an embedding member's name to the outputs of the flow it
+// embeds: in a flow that embeds "lint", {{ output "lint/vet" }} reads the
+// output of lint's group "vet".
+const SubFlowSep = "/"
+
+// UnmarshalYAML decodes a step, turning each {flow: name} run entry into the
+// flow's name in Run and recording it in Flows.
+func (s *Step) UnmarshalYAML(node *yaml.Node) error {
+	type plain Step
+	if node.Kind == yaml.MappingNode {
+		shadow := *node
+		shadow.Content = slices.Clone(node.Content)
+		for i := 0; i+1 < len(shadow.Content); i += 2 {
+			if shadow.Content[i].Value != "run" || shadow.Content[i+1].Kind != yaml.SequenceNode {
+				continue
+			}
+			run, flows, err := stepRun(shadow.Content[i+1])
+			if err != nil {
+				return err
+			}
+			shadow.Content[i+1] = run
+			s.Flows = flows
+		}
+		node = &shadow
+	}
+	flows := s.Flows
+	if err := node.Decode((*plain)(s)); err != nil {
+		return err
+	}
+	s.Flows = flows
+	return nil
+}
+
+// stepRun rewrites a step's run sequence to plain names.
+func stepRun(seq *yaml.Node) (*yaml.Node, []string, error) {
+	out := *seq
+	out.Content = make([]*yaml.Node, len(seq.Content))
+	var flows []string
+	for i, item := range seq.Content {
+		if item.Kind != yaml.MappingNode {
+			out.Content[i] = item
+			continue
+		}
+		name := mappingValue(item, "flow")
+		if name == "" || len(item.Content) != 2 {
+			return nil, nil, fmt.Errorf("step run entry: must be a group name or a {flow: name} map")
+		}
+		out.Content[i] = &yaml.Node{Kind: yaml.ScalarNode, Value: name}
+		flows = append(flows, name)
+	}
+	return &out, flows, nil
+}
+
+// MarshalYAML writes embedded flows back as {flow: name} run entries.
+func (s Step) MarshalYAML() (any, error) { //nolint:gocritic // value receiver so both Step and *Step marshal
+	type plain Step
+	if len(s.Flows) == 0 {
+		return plain(s), nil
+	}
+	var node yaml.Node
+	if err := node.Encode(plain(s)); err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "run" {
+			continue
+		}
+		for j, item := range node.Content[i+1].Content {
+			if slices.Contains(s.Flows, item.Value) {
+				node.Content[i+1].Content[j] = &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+					{Kind: yaml.ScalarNode, Value: "flow"}, {Kind: yaml.ScalarNode, Value: item.Value},
+				}}
+			}
+		}
+	}
+	return &node, nil
+}
+
+// SubFlows returns the members of the flow that embed another flow, in
+// declaration order.
+func (f *Flow) SubFlows() []string {
+	var out []string
+	if f.Mode == ModeDAG {
+		for i := range f.Run {
+			if f.Run[i].Flow != "" {
+				out = append(out, f.Run[i].Flow)
+			}
+		}
+		return out
+	}
+	for _, s := range f.Steps {
+		out = append(out, s.Flows...)
+	}
+	return out
+}
+
+func (f *Flow) subFlowSet() map[string]bool {
+	out := make(map[string]bool)
+	for _, name := range f.SubFlows() {
+		out[name] = true
+	}
+	return out
+}
+
+// validateSubFlows checks that every embedded flow exists and is embedded
+// once; cycles are checked across all flows by checkFlowCycles.
+func (c *Config) validateSubFlows(name string, f *Flow) error {
+	seen := make(map[string]bool)
+	for _, sub := range f.SubFlows() {
+		sf, ok := c.Flows[sub]
+		if !ok {
+			return fmt.Errorf("flow %q: embedded flow %q is not defined", name, sub)
+		}
+		if sf.RequiresArgs() {
+			return fmt.Errorf("flow %q: embedded flow %q has required args, which only the command line can set", name, sub)
+		}
+		if seen[sub] {
+			return fmt.Errorf("flow %q: flow %q is embedded more than once", name, sub)
+		}
+		seen[sub] = true
+	}
+	count := make(map[string]int)
+	for _, m := range f.Members() {
+		if count[m]++; seen[m] && count[m] > 1 {
+			return fmt.Errorf("flow %q: %q names both a group and an embedded flow", name, m)
+		}
+	}
+	return nil
+}
+
+// checkFlowCycles rejects a flow that embeds itself, directly or through
+// other flows, naming the chain: "flow cycle: ci -> lint -> ci".
+func (c *Config) checkFlowCycles() error {
+	names := make([]string, 0, len(c.Flows))
+	for name := range c.Flows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(names))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			start := slices.Index(path, name)
+			return fmt.Errorf("flow cycle: %s", strings.Join(append(path[start:], name), " -> "))
+		case done:
+			return nil
+		}
+		state[name] = visiting
+		f := c.Flows[name]
+		for _, sub := range f.SubFlows() {
+			if err := visit(sub, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return c.at("flows", name, err)
+		}
+	}
+	return nil
+}
+
+// FlowOutputs returns every output name a run of the flow publishes: its
+// groups and hook groups, and for each embedded flow its own name plus that
+// flow's outputs under "name/".
+func (c *Config) FlowOutputs(name string) map[string]bool {
+	f := c.Flows[name]
+	out := make(map[string]bool)
+	for _, m := range f.Members() {
+		out[m] = true
+	}
+	for _, h := range f.Hooks() {
+		out[h.Group] = true
+	}
+	for _, sub := range f.SubFlows() {
+		for o := range c.FlowOutputs(sub) {
+			out[sub+SubFlowSep+o] = true
+		}
+	}
+	return out
+}
+
+// RefOwner returns the member of f that publishes the output ref: the
+// embedded flow when ref is one of its outputs ("lint/vet"), ref itself
+// otherwise.
+func (c *Config) RefOwner(f *Flow, ref string) string {
+	for _, sub := range f.SubFlows() {
+		if rest, ok := strings.CutPrefix(ref, sub+SubFlowSep); ok && c.FlowOutputs(sub)[rest] {
+			return sub
+		}
+	}
+	return ref
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.yaml.in/yaml/v3"
+)
+
+const composeCfg = `
+version: 2
+groups:
+  - {name: fmt, command: gofmt}
+  - {name: vet, command: go, params: [vet]}
+  - {name: build, command: go, params: [build, '{{ output "lint/vet" }}']}
+flows:
+  lint:
+    steps:
+      - run: [fmt]
+      - run: [vet]
+  ci:
+    steps:
+      - run: [{flow: lint}]
+      - run: [build]
+  release:
+    mode: dag
+    run:
+      - build
+      - {flow: lint, allow-failure: true}
+`
+
+func TestNewConfig_EmbeddedFlows(t *testing.T) {
+	t.Parallel()
+	cfg, err := NewConfig([]byte(composeCfg))
+	require.NoError(t, err)
+
+	ci := cfg.Flows["ci"]
+	assert.Equal(t, []string{"lint", "build"}, ci.Members())
+	assert.Equal(t, []string{"lint"}, ci.SubFlows())
+	assert.Equal(t, "lint", cfg.RefOwner(&ci, "lint/vet"))
+	assert.Equal(t, "lint/nope", cfg.RefOwner(&ci, "lint/nope"))
+
+	release := cfg.Flows["release"]
+	assert.Equal(t, []string{"build", "lint"}, release.Members())
+	assert.Equal(t, RunEntry{Flow: "lint", AllowFailure: true}, release.Run[1])
+
+	assert.Equal(t, map[string]bool{"fmt": true, "vet": true}, cfg.FlowOutputs("lint"))
+	assert.True(t, cfg.FlowOutputs("ci")["lint/vet"])
+}
+
+func TestStep_MarshalKeepsFlowEntries(t *testing.T) {
+	t.Parallel()
+	cfg, err := NewConfig([]byte(composeCfg))
+	require.NoError(t, err)
+	out, err := yaml.Marshal(cfg.Flows["ci"].Steps)
+	require.NoError(t, err)
+	var back []Step
+	require.NoError(t, yaml.Unmarshal(out, &back))
+	assert.Equal(t, cfg.Flows["ci"].Steps, back)
+}
+
+func TestNewConfig_EmbeddedFlowErrors(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		flows string
+		want  string
+	}{
+		"undefined flow": {
+			flows: "  a:\n    steps: [{run: [{flow: nope}]}]\n",
+			want:  `embedded flow "nope" is not defined`,
+		},
+		"cycle": {
+			flows: "  a:\n    steps: [{run: [{flow: b}]}]\n  b:\n    mode: dag\n    run: [g, {flow: a}]\n",
+			want:  "flow cycle: a -> b -> a",
+		},
+		"self": {
+			flows: "  a:\n    steps: [{run: [g]}, {run: [{flow: a}]}]\n",
+			want:  "flow cycle: a -> a",
+		},
+		"embedded twice": {
+			flows: "  a:\n    steps: [{run: [g]}]\n  b:\n    steps: [{run: [{flow: a}]}, {run: [{flow: a}]}]\n",
+			want:  `flow "a" is embedded more than once`,
+		},
+		"group and flow share a name": {
+			flows: "  g:\n    steps: [{run: [g]}]\n  b:\n    steps: [{run: [g]}, {run: [{flow: g}]}]\n",
+			want:  `"g" names both a group and an embedded flow`,
+		},
+		"unknown sub-flow output": {
+			flows: "  a:\n    steps: [{run: [g]}]\n  b:\n    steps: [{run: [{flow: a}]}, {run: [use]}]\n",
+			want:  `"a/nope" is not part of this flow`,
+		},
+		"bad step entry": {
+			flows: "  a:\n    steps: [{run: [{group: g}]}]\n",
+			want:  "must be a group name or a {flow: name} map",
+		},
+		"group and flow in one run entry": {
+			flows: "  a:\n    mode: dag\n    run: [{group: g, flow: b}]\n",
+			want:  "set either 'group' or 'flow'",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			doc := "version: 2\ngroups:\n  - {name: g, command: echo}\n" +
+				"  - {name: use, command: echo, params: ['{{ output \"a/nope\" }}']}\nflows:\n" + tc.flows
+			_, err := NewConfig([]byte(doc))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
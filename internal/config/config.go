@@ -35,13 +35,37 @@ const (
 )
 
 // Config is the top-level keepup configuration document.
+//
+// Include lists further keepup files (paths or globs, relative to the file
+// that includes them) whose groups, flows and env are merged in by
+// LoadConfig; it is emptied once they are.
+//
+// Imports maps an alias to another project's keepup file. LoadConfig loads it
+// as a config of its own and adds its groups as "alias:name", each running
+// with its file's env and working directory (see Scope).
 type Config struct {
 	Version  int               `yaml:"version"`
+	Include  []string          `yaml:"include,omitempty"`
+	Imports  map[string]string `yaml:"imports,omitempty"`
 	Settings Settings          `yaml:"settings"`
 	Env      map[string]string `yaml:"env,omitempty"`
 	Groups   []Group           `yaml:"groups"`
 	Flows    map[string]Flow   `yaml:"flows"`
 	Default  string            `yaml:"default,omitempty"`
+
+	// origins maps "groups/NAME", "flows/NAME" and "env/KEY" to the file:line
+	// that declared them; it is only set for configs that use include:.
+	origins map[string]string
+
+	// matrix maps each matrix group's name to its instance names; see
+	// expandMatrices.
+	matrix map[string][]string
+
+	// BaseDir is the directory of the file the config was loaded from; a
+	// relative settings.working-dir resolves against it. It is empty for
+	// configs parsed from bytes (NewConfig), leaving the process cwd as the
+	// anchor.
+	BaseDir string `yaml:"-"`
 }
 
 // Logging configures the keepup logger.
@@ -65,12 +89,27 @@ const (
 )
 
 // Settings holds global runtime settings.
+//
+// WorkingDir is the directory every group runs in unless it declares its own
+// dir:. A relative value resolves against the config file's directory; an
+// empty value keeps the caller's cwd.
+//
+// KillGrace is how long a stopped group's processes get to exit after
+// SIGTERM before they are killed; a group's own kill-grace overrides it.
+//
+// Resources declares named pools (a shared database, CPU slots) with their
+// capacity; a group claims units of them with uses:.
+//
+// Cache adds a remote cache shared by a team and its CI (see RemoteCache).
 type Settings struct {
-	DryRun         bool    `yaml:"dry-run"`
-	Logging        Logging `yaml:"logging"`
-	WorkingDir     string  `yaml:"working-dir"`
-	MaxConcurrency int     `yaml:"max-concurrency"`
-	CacheDir       string  `yaml:"cache-dir,omitempty"`
+	DryRun         bool           `yaml:"dry-run"`
+	Logging        Logging        `yaml:"logging"`
+	WorkingDir     string         `yaml:"working-dir"`
+	MaxConcurrency int            `yaml:"max-concurrency"`
+	CacheDir       string         `yaml:"cache-dir,omitempty"`
+	Cache          CacheSettings  `yaml:"cache,omitempty"`
+	KillGrace      string         `yaml:"kill-grace,omitempty"`
+	Resources      map[string]int `yaml:"resources,omitempty"`
 }
 
 // Group is an atomic, reusable command unit. Groups know nothing about flows;
@@ -89,25 +128,94 @@ type Settings struct {
 //
 // Gating (Require, SkipIf) and Cache are optional short-circuits evaluated
 // before the command runs; see the engine for ordering semantics.
+//
+// Dir overrides the working directory for this group. It is a template
+// (rendered like params) and a relative result resolves against the
+// effective settings.working-dir; cache globs resolve against the same base.
+//
+// AllowFailure turns a failing command into a soft failure: the result is
+// stored with Status "failed" and its real exit code, and the flow goes on.
+//
+// Matrix expands the group at load time into one instance per combination
+// of axis values; each instance carries its combination in MatrixValues,
+// which templates read with {{ matrix "axis" }}.
+//
+// Uses names the units of settings.resources the group holds while it runs;
+// the scheduler starts it only once they are free. Priority overrides the
+// order in which ready groups start: higher first, ahead of the scheduler's
+// own critical-path ranking.
+//
+// KillGrace overrides settings.kill-grace for this group.
+//
+// Service starts the command in the background instead of waiting for it to
+// exit. The group completes once its Ready check passes, and the command is
+// stopped when the flow ends.
+//
+// Stdin feeds the group's first command: a template, a file, or another
+// group's stdout, stored or streamed live (see Stdin).
+//
+// Capture bounds how much of the group's output is kept; the rest is
+// spilled to a log file (see Capture).
+//
+// Outputs parses the group's stdout into named values for templates (see
+// Outputs).
+//
+// Timeout, Retries, Backoff and RetryOn override the envelope of the flow
+// or step the group runs in (see Flow). RetryScope picks what a retry runs
+// again: the whole Commands sequence (group, the default) or only the
+// command that failed, after the ones that already succeeded (command).
 type Group struct {
 	Name        string            `yaml:"name"`
 	Command     string            `yaml:"command"`
 	Params      []string          `yaml:"params,omitempty"`
 	Commands    []CommandSpec     `yaml:"commands,omitempty"`
 	Shell       string            `yaml:"shell,omitempty"`
+	Dir         string            `yaml:"dir,omitempty"`
 	Description string            `yaml:"description,omitempty"`
 	Env         map[string]string `yaml:"env,omitempty"`
 	Require     string            `yaml:"require,omitempty"`
 	SkipIf      string            `yaml:"skip-if,omitempty"`
 	Cache       *Cache            `yaml:"cache,omitempty"`
+
+	AllowFailure bool           `yaml:"allow-failure,omitempty"`
+	Uses         map[string]int `yaml:"uses,omitempty"`
+	Priority     int            `yaml:"priority,omitempty"`
+	KillGrace    string         `yaml:"kill-grace,omitempty"`
+
+	Service bool   `yaml:"service,omitempty"`
+	Ready   *Ready `yaml:"ready,omitempty"`
+
+	Stdin   *Stdin   `yaml:"stdin,omitempty"`
+	Capture *Capture `yaml:"capture,omitempty"`
+	Outputs *Outputs `yaml:"outputs,omitempty"`
+
+	Timeout string   `yaml:"timeout,omitempty"`
+	Retries int      `yaml:"retries,omitempty"`
+	Backoff *Backoff `yaml:"backoff,omitempty"`
+	RetryOn *RetryOn `yaml:"retry-on,omitempty"`
+
+	RetryScope string `yaml:"retry-scope,omitempty"`
+
+	Matrix       *Matrix           `yaml:"matrix,omitempty"`
+	MatrixValues map[string]string `yaml:"-"`
+
+	// Scope is set on groups adopted through imports:.
+	Scope *Scope `yaml:"-"`
 }
 
 // Cache declares the inputs (and optional outputs) that decide whether a
 // group can be skipped because nothing changed since the last run.
+//
+// Besides the Reads files, Env names environment variables whose values
+// (after the config's and the group's env: are applied) are inputs, and
+// Tools lists commands, such as "go version", whose output is. The group's
+// own env: map is always an input.
 type Cache struct {
 	Method CacheMethod `yaml:"method,omitempty"`
 	Reads  []string    `yaml:"reads"`
 	Writes []string    `yaml:"writes,omitempty"`
+	Env    []string    `yaml:"env,omitempty"`
+	Tools  []string    `yaml:"tools,omitempty"`
 }
 
 // UseShell reports whether the group opted into shell mode.
@@ -134,42 +242,86 @@ func (g *Group) CommandList() []CommandSpec {
 //   - Mode == ModeDAG:            use Run
 //
 // Timeout and Retries form a default control envelope applied to every group
-// in the flow. In step mode a Step may override them for its own wave; in dag
-// mode the flow-level values are the only knob (there are no steps).
+// in the flow, with Backoff spacing out the retries and RetryOn picking the
+// failures that are retried. A Step may override them for its own wave, a
+// Group for itself, and a dag RunEntry for its group in this flow, in that
+// order of precedence.
+//
+// OnSuccess / OnFailure and Finally are hook lists run after the main plan,
+// in that order and one group at a time: the first two depending on the
+// outcome, Finally always. Hook groups are not Members of the flow.
+//
+// Args declares the typed parameters the flow accepts from the command line.
 type Flow struct {
 	Description string     `yaml:"description,omitempty"`
 	Mode        Mode       `yaml:"mode,omitempty"`
+	Args        []Arg      `yaml:"args,omitempty"`
 	Steps       []Step     `yaml:"steps,omitempty"`
 	Run         []RunEntry `yaml:"run,omitempty"`
 	Timeout     string     `yaml:"timeout,omitempty"`
 	Retries     int        `yaml:"retries,omitempty"`
+	Backoff     *Backoff   `yaml:"backoff,omitempty"`
+	RetryOn     *RetryOn   `yaml:"retry-on,omitempty"`
+	OnSuccess   []string   `yaml:"on-success,omitempty"`
+	OnFailure   []string   `yaml:"on-failure,omitempty"`
+	Finally     []string   `yaml:"finally,omitempty"`
 }
 
 // Step is one execution wave inside a step-mode Flow.
 //
-// Timeout (a Go duration string, e.g. "30s") and Retries override the flow's
-// envelope for this wave. An empty Timeout / zero Retries means "inherit".
+// Timeout (a Go duration string, e.g. "30s"), Retries, Backoff and RetryOn
+// override the flow's envelope for this wave. An empty Timeout / zero
+// Retries / nil Backoff or RetryOn means "inherit".
+//
+// Run lists group names; a {flow: name} entry embeds another flow, whose
+// name then appears in Run and in Flows (see compose.go).
 type Step struct {
 	Run     []string `yaml:"run"`
 	Timeout string   `yaml:"timeout,omitempty"`
 	Retries int      `yaml:"retries,omitempty"`
+	Backoff *Backoff `yaml:"backoff,omitempty"`
+	RetryOn *RetryOn `yaml:"retry-on,omitempty"`
 	// When is an optional template predicate. The step is skipped when it
 	// renders to a falsey value ("", "false", "0", "no", "off"). It is
 	// evaluated against the outputs of earlier steps plus the environment.
 	When string `yaml:"when,omitempty"`
+	// Flows names the Run entries that embed a flow rather than a group.
+	Flows []string `yaml:"-"`
 }
 
 // RunEntry is one member of a dag-mode flow's run list. It is either a bare
-// group-name scalar or a {group, when} mapping; both forms reference a group
-// defined in top-level groups: (never an inline definition).
+// group-name scalar or a {group, when, allow-failure} mapping; both forms
+// reference a group defined in top-level groups: (never an inline definition).
+// A {flow, when, allow-failure} mapping embeds another flow instead. Either
+// mapping may also override the envelope for its member in this flow:
+// timeout, retries, backoff, retry-on.
 type RunEntry struct {
-	Group string `yaml:"group"`
+	Group string `yaml:"group,omitempty"`
+	Flow  string `yaml:"flow,omitempty"`
 	// When is an optional template predicate. The group is skipped when it
 	// renders falsey ("", "false", "0", "no", "off"); see the engine.
 	When string `yaml:"when,omitempty"`
+	// AllowFailure soft-fails the group in this flow only, on top of the
+	// group's own allow-failure setting.
+	AllowFailure bool `yaml:"allow-failure,omitempty"`
+
+	Timeout string   `yaml:"timeout,omitempty"`
+	Retries int      `yaml:"retries,omitempty"`
+	Backoff *Backoff `yaml:"backoff,omitempty"`
+	RetryOn *RetryOn `yaml:"retry-on,omitempty"`
+}
+
+// Member returns the name the entry runs under: its group, or the flow it
+// embeds.
+func (r *RunEntry) Member() string {
+	if r.Flow != "" {
+		return r.Flow
+	}
+	return r.Group
 }
 
-// UnmarshalYAML accepts a scalar (group name) or a mapping ({group, when}).
+// UnmarshalYAML accepts a scalar (group name) or a mapping ({group|flow,
+// when, allow-failure}).
 // Any other shape, an empty group, or an unexpected key is a load error.
 func (r *RunEntry) UnmarshalYAML(node *yaml.Node) error {
 	switch node.Kind {
@@ -181,24 +333,14 @@ func (r *RunEntry) UnmarshalYAML(node *yaml.Node) error {
 		return nil
 	case yaml.MappingNode:
 		for i := 0; i+1 < len(node.Content); i += 2 {
-			key := node.Content[i].Value
-			valNode := node.Content[i+1]
-			switch key {
-			case "group":
-				if valNode.Kind != yaml.ScalarNode {
-					return fmt.Errorf(`run entry: "group" must be a string`)
-				}
-				r.Group = valNode.Value
-			case "when":
-				if valNode.Kind != yaml.ScalarNode {
-					return fmt.Errorf(`run entry: "when" must be a string`)
-				}
-				r.When = valNode.Value
-			default:
-				return fmt.Errorf("run entry: unexpected key %q (commands are defined in groups:)", key)
+			if err := r.decodeKey(node.Content[i].Value, node.Content[i+1]); err != nil {
+				return err
 			}
 		}
-		if r.Group == "" {
+		if r.Group != "" && r.Flow != "" {
+			return fmt.Errorf("run entry: set either 'group' or 'flow', not both")
+		}
+		if r.Group == "" && r.Flow == "" {
 			return fmt.Errorf("run entry: missing 'group'")
 		}
 		return nil
@@ -208,6 +350,39 @@ func (r *RunEntry) UnmarshalYAML(node *yaml.Node) error {
 	return fmt.Errorf("run entry: must be a group name or a {group, when} map")
 }
 
+// decodeKey decodes one key of a run entry mapping into r.
+func (r *RunEntry) decodeKey(key string, val *yaml.Node) error {
+	scalars := map[string]*string{"group": &r.Group, "flow": &r.Flow, "when": &r.When, "timeout": &r.Timeout}
+	if dst, ok := scalars[key]; ok {
+		if val.Kind != yaml.ScalarNode {
+			return fmt.Errorf("run entry: %q must be a string", key)
+		}
+		*dst = val.Value
+		return nil
+	}
+	switch key {
+	case "allow-failure":
+		if err := val.Decode(&r.AllowFailure); err != nil {
+			return fmt.Errorf(`run entry: "allow-failure" must be a bool`)
+		}
+	case "retries":
+		if err := val.Decode(&r.Retries); err != nil {
+			return fmt.Errorf(`run entry: "retries" must be an integer`)
+		}
+	case "backoff":
+		if err := val.Decode(&r.Backoff); err != nil {
+			return fmt.Errorf("run entry: backoff: %w", err)
+		}
+	case "retry-on":
+		if err := val.Decode(&r.RetryOn); err != nil {
+			return fmt.Errorf("run entry: %w", err)
+		}
+	default:
+		return fmt.Errorf("run entry: unexpected key %q (commands are defined in groups:)", key)
+	}
+	return nil
+}
+
 // CommandSpec is one entry in a group's commands: list. The YAML shape of the
 // entry selects its execution mode (same form-signals-mode rule as RunEntry):
 //   - a {command, params} mapping → safe argv exec, never a shell
@@ -270,13 +445,17 @@ func NewConfig(b []byte) (*Config, error) {
 	if err := yaml.Unmarshal(b, &cfg); err != nil {
 		return nil, fmt.Errorf("unmarshal configuration: %w", err)
 	}
+	if len(cfg.Include) > 0 || len(cfg.Imports) > 0 {
+		return nil, errIncludeFromBytes
+	}
 	if err := cfg.normalizeAndValidate(); err != nil {
 		return nil, err
 	}
 	return &cfg, nil
 }
 
-// LoadConfig reads a YAML config from disk. Supports a leading "~/" expansion.
+// LoadConfig reads a YAML config from disk, merging any include: files and
+// adopting the groups of any imports:. Supports a leading "~/" expansion.
 func LoadConfig(path string) (*Config, error) {
 	if path == "" {
 		return nil, errors.New("config path is empty")
@@ -285,11 +464,38 @@ func LoadConfig(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	data, err := os.ReadFile(filepath.Clean(expanded))
+	abs, err := filepath.Abs(expanded)
 	if err != nil {
-		return nil, fmt.Errorf("read config file %q: %w", expanded, err)
+		return nil, fmt.Errorf("resolve config path %q: %w", expanded, err)
+	}
+	return loadConfig(abs, nil)
+}
+
+// loadConfig loads one absolute config path; stack holds the files whose
+// imports are being resolved.
+func loadConfig(abs string, stack []string) (*Config, error) {
+	if err := checkImportStack(abs, stack); err != nil {
+		return nil, err
+	}
+	cfg, doc, err := parseFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Include) > 0 {
+		if err := cfg.loadIncludes(abs, doc); err != nil {
+			return nil, err
+		}
 	}
-	return NewConfig(data)
+	if len(cfg.Imports) > 0 {
+		if err := cfg.loadImports(abs, append(stack, abs)); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.normalizeAndValidate(); err != nil {
+		return nil, err
+	}
+	cfg.BaseDir = filepath.Dir(abs)
+	return cfg, nil
 }
 
 // normalizeAndValidate enforces structural rules and runs reference checks
@@ -306,27 +512,58 @@ func (c *Config) normalizeAndValidate() error {
 		)
 	}
 
+	if err := c.validateSettings(); err != nil {
+		return err
+	}
+	if err := c.expandMatrices(); err != nil {
+		return err
+	}
 	groupIndex, err := c.indexGroups()
 	if err != nil {
 		return err
 	}
+	if err := c.validateFlows(groupIndex); err != nil {
+		return err
+	}
+	if err := c.ValidateReferences(); err != nil {
+		return err
+	}
+	return c.checkValueRefs()
+}
 
+// validateSettings checks the settings: block.
+func (c *Config) validateSettings() error {
+	if err := checkDuration("kill-grace", c.Settings.KillGrace); err != nil {
+		return fmt.Errorf("settings: %w", err)
+	}
+	if err := c.validateResources(); err != nil {
+		return err
+	}
+	return c.validateRemoteCache()
+}
+
+// validateFlows checks every flow, the flows they embed, and default:.
+func (c *Config) validateFlows(groupIndex map[string]*Group) error {
 	if len(c.Flows) == 0 {
 		return errors.New("flows: at least one flow must be defined")
 	}
 
 	for name, flow := range c.Flows {
 		if err := c.validateFlow(name, &flow, groupIndex); err != nil {
-			return err
+			return c.at("flows", name, err)
 		}
 	}
 
+	if err := c.checkFlowCycles(); err != nil {
+		return err
+	}
+
 	if c.Default != "" {
 		if _, ok := c.Flows[c.Default]; !ok {
 			return fmt.Errorf("default: %q is not a declared flow", c.Default)
 		}
 	}
-	return c.ValidateReferences()
+	return nil
 }
 
 func (c *Config) indexGroups() (map[string]*Group, error) {
@@ -336,20 +573,54 @@ func (c *Config) indexGroups() (map[string]*Group, error) {
 		if g.Name == "" {
 			return nil, fmt.Errorf("groups[%d]: missing name", i)
 		}
+		if base, _, _ := strings.Cut(g.Name, "["); g.Scope == nil && strings.Contains(base, NamespaceSep) {
+			return nil, c.at("groups", g.Name, fmt.Errorf(
+				"group %q: %q is reserved for imported groups (alias:group)", g.Name, NamespaceSep))
+		}
 		if err := validateGroupCommands(i, g); err != nil {
-			return nil, err
+			return nil, c.at("groups", g.Name, err)
 		}
 		if _, dup := out[g.Name]; dup {
 			return nil, fmt.Errorf("groups: duplicate name %q", g.Name)
 		}
-		if err := validateCache(g); err != nil {
-			return nil, err
+		if err := c.validateGroup(g); err != nil {
+			return nil, c.at("groups", g.Name, err)
 		}
 		out[g.Name] = g
 	}
 	return out, nil
 }
 
+// validateGroup checks a group's optional blocks: cache, uses, service,
+// stdin, capture, outputs and its retry envelope.
+func (c *Config) validateGroup(g *Group) error {
+	if err := validateCache(g); err != nil {
+		return err
+	}
+	if err := c.validateUses(g); err != nil {
+		return err
+	}
+	if err := checkDuration("kill-grace", g.KillGrace); err != nil {
+		return fmt.Errorf("group %q: %w", g.Name, err)
+	}
+	if err := validateService(g); err != nil {
+		return err
+	}
+	if err := validateStdin(g); err != nil {
+		return err
+	}
+	if err := validateCapture(g); err != nil {
+		return err
+	}
+	if err := validateOutputs(g); err != nil {
+		return err
+	}
+	if err := checkRetry(g.Timeout, g.Retries, g.Backoff, g.RetryOn); err != nil {
+		return fmt.Errorf("group %q: %w", g.Name, err)
+	}
+	return validateRetryScope(g)
+}
+
 // validateCache normalizes and checks a group's optional cache block.
 func validateCache(g *Group) error {
 	if g.Cache == nil {
@@ -366,6 +637,16 @@ func validateCache(g *Group) error {
 	default:
 		return fmt.Errorf("group %q: unknown cache.method %q (use 'hash' or 'mtime')", g.Name, g.Cache.Method)
 	}
+	for _, name := range g.Cache.Env {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("group %q: cache.env: %q is not a variable name", g.Name, name)
+		}
+	}
+	for _, tool := range g.Cache.Tools {
+		if strings.TrimSpace(tool) == "" {
+			return fmt.Errorf("group %q: cache.tools: empty command", g.Name)
+		}
+	}
 	return nil
 }
 
@@ -399,6 +680,39 @@ func (c *Config) validateFlow(name string, f *Flow, groups map[string]*Group) er
 	if f.Mode == "" {
 		f.Mode = ModeStep
 	}
+	if err := validateMode(name, f); err != nil {
+		return err
+	}
+	// All referenced groups (and embedded flows) must exist.
+	subs := f.subFlowSet()
+	for _, member := range f.Members() {
+		if _, ok := groups[member]; !ok && !subs[member] {
+			return fmt.Errorf("flow %q: group %q is not defined", name, member)
+		}
+	}
+	if err := c.validateSubFlows(name, f); err != nil {
+		return err
+	}
+	if err := validateHooks(name, f, groups); err != nil {
+		return err
+	}
+	if err := validateStreams(name, f, groups); err != nil {
+		return err
+	}
+	if err := validateEnvelope(name, f); err != nil {
+		return err
+	}
+	if err := validateArgs(name, f); err != nil {
+		return err
+	}
+	// Persist the normalised Mode back to the map.
+	c.Flows[name] = *f
+	return nil
+}
+
+// validateMode checks that the flow lists its members the way its mode
+// reads them: steps: in step mode, run: in dag mode.
+func validateMode(name string, f *Flow) error {
 	switch f.Mode {
 	case ModeStep:
 		if len(f.Run) > 0 {
@@ -417,51 +731,76 @@ func (c *Config) validateFlow(name string, f *Flow, groups map[string]*Group) er
 	default:
 		return fmt.Errorf("flow %q: unknown mode %q (use 'step' or 'dag')", name, f.Mode)
 	}
-	// All referenced groups must exist.
-	for _, member := range f.Members() {
-		if _, ok := groups[member]; !ok {
-			return fmt.Errorf("flow %q: group %q is not defined", name, member)
+	return nil
+}
+
+// validateHooks checks the on-success / on-failure / finally lists: every
+// entry must be a defined group that is not a service or also part of the
+// main plan, and no group may appear twice across the hook lists.
+func validateHooks(name string, f *Flow, groups map[string]*Group) error {
+	members := make(map[string]struct{})
+	for _, m := range f.Members() {
+		members[m] = struct{}{}
+	}
+	seen := make(map[string]string)
+	for _, h := range f.Hooks() {
+		g, ok := groups[h.Group]
+		if !ok {
+			return fmt.Errorf("flow %q %s: group %q is not defined", name, h.Phase, h.Group)
 		}
+		if g.Service {
+			return fmt.Errorf("flow %q %s: group %q is a service and cannot be a hook", name, h.Phase, h.Group)
+		}
+		if _, ok := members[h.Group]; ok {
+			return fmt.Errorf("flow %q %s: group %q is already part of the flow's main plan", name, h.Phase, h.Group)
+		}
+		if prev, dup := seen[h.Group]; dup {
+			return fmt.Errorf("flow %q %s: group %q is already listed in %s", name, h.Phase, h.Group, prev)
+		}
+		seen[h.Group] = h.Phase
 	}
-	if err := validateEnvelope(name, f); err != nil {
-		return err
-	}
-	// Persist the normalised Mode back to the map.
-	c.Flows[name] = *f
 	return nil
 }
 
-// validateEnvelope checks the timeout/retries control envelope on a flow and
-// its steps: timeouts must be valid Go durations and retries non-negative.
+// validateEnvelope checks the control envelope on a flow, its steps, and its
+// run entries: timeouts must be valid Go durations, retries non-negative,
+// and backoff / retry-on well formed.
 func validateEnvelope(name string, f *Flow) error {
-	if err := checkTimeout(f.Timeout); err != nil {
+	if err := checkRetry(f.Timeout, f.Retries, f.Backoff, f.RetryOn); err != nil {
 		return fmt.Errorf("flow %q: %w", name, err)
 	}
-	if f.Retries < 0 {
-		return fmt.Errorf("flow %q: retries must be >= 0", name)
-	}
 	for i := range f.Steps {
 		s := &f.Steps[i]
-		if err := checkTimeout(s.Timeout); err != nil {
+		if err := checkRetry(s.Timeout, s.Retries, s.Backoff, s.RetryOn); err != nil {
 			return fmt.Errorf("flow %q step %d: %w", name, i+1, err)
 		}
-		if s.Retries < 0 {
-			return fmt.Errorf("flow %q step %d: retries must be >= 0", name, i+1)
+	}
+	for i := range f.Run {
+		r := &f.Run[i]
+		if err := checkRetry(r.Timeout, r.Retries, r.Backoff, r.RetryOn); err != nil {
+			return fmt.Errorf("flow %q: %s: %w", name, r.Member(), err)
+		}
+		if r.Flow != "" && (r.Timeout != "" || r.Retries != 0 || r.Backoff != nil || r.RetryOn != nil) {
+			return fmt.Errorf("flow %q: embedded flow %q runs under its own timeout and retries; set them on that flow",
+				name, r.Flow)
 		}
 	}
 	return nil
 }
 
-func checkTimeout(s string) error {
+func checkTimeout(s string) error { return checkDuration("timeout", s) }
+
+// checkDuration validates an optional, non-negative Go duration setting.
+func checkDuration(key, s string) error {
 	if s == "" {
 		return nil
 	}
 	d, err := time.ParseDuration(s)
 	if err != nil {
-		return fmt.Errorf("invalid timeout %q: %w", s, err)
+		return fmt.Errorf("invalid %s %q: %w", key, s, err)
 	}
 	if d < 0 {
-		return fmt.Errorf("timeout %q must not be negative", s)
+		return fmt.Errorf("%s %q must not be negative", key, s)
 	}
 	return nil
 }
@@ -476,12 +815,54 @@ func (c *Config) GroupByName(name string) *Group {
 	return nil
 }
 
-// Members returns the groups referenced by a flow, regardless of mode.
+// CacheDir returns settings.cache-dir, or DefaultCacheDir when unset.
+func (c *Config) CacheDir() string {
+	if c.Settings.CacheDir == "" {
+		return DefaultCacheDir
+	}
+	return c.Settings.CacheDir
+}
+
+// WorkDir returns the effective working directory for every group: the
+// settings.working-dir value (with "~/" expanded) resolved against BaseDir.
+// An empty result means "the process cwd".
+func (c *Config) WorkDir() string {
+	wd := c.Settings.WorkingDir
+	if wd == "" {
+		return ""
+	}
+	if expanded, err := expandHome(wd); err == nil {
+		wd = expanded
+	}
+	if filepath.IsAbs(wd) || c.BaseDir == "" {
+		return filepath.Clean(wd)
+	}
+	return filepath.Join(c.BaseDir, wd)
+}
+
+// ResolveDir returns the directory a group runs in given its rendered dir:
+// value. An empty dir inherits WorkDir; a relative one is joined onto it.
+func (c *Config) ResolveDir(dir string) string {
+	base := c.WorkDir()
+	if dir == "" {
+		return base
+	}
+	if expanded, err := expandHome(dir); err == nil {
+		dir = expanded
+	}
+	if filepath.IsAbs(dir) || base == "" {
+		return filepath.Clean(dir)
+	}
+	return filepath.Join(base, dir)
+}
+
+// Members returns the groups referenced by a flow, regardless of mode,
+// including the names of the flows it embeds (see SubFlows).
 func (f *Flow) Members() []string {
 	if f.Mode == ModeDAG {
 		out := make([]string, len(f.Run))
 		for i := range f.Run {
-			out[i] = f.Run[i].Group
+			out[i] = f.Run[i].Member()
 		}
 		return out
 	}
@@ -492,6 +873,35 @@ func (f *Flow) Members() []string {
 	return out
 }
 
+// Hook phase names, as written in the flow's YAML keys.
+const (
+	PhaseOnSuccess = "on-success"
+	PhaseOnFailure = "on-failure"
+	PhaseFinally   = "finally"
+)
+
+// Hook is one entry of a flow's on-success / on-failure / finally lists.
+type Hook struct {
+	Phase string
+	Group string
+}
+
+// Hooks returns every hook group of the flow tagged with its phase, in
+// execution order: on-success, on-failure, then finally.
+func (f *Flow) Hooks() []Hook {
+	out := make([]Hook, 0, len(f.OnSuccess)+len(f.OnFailure)+len(f.Finally))
+	for _, g := range f.OnSuccess {
+		out = append(out, Hook{Phase: PhaseOnSuccess, Group: g})
+	}
+	for _, g := range f.OnFailure {
+		out = append(out, Hook{Phase: PhaseOnFailure, Group: g})
+	}
+	for _, g := range f.Finally {
+		out = append(out, Hook{Phase: PhaseFinally, Group: g})
+	}
+	return out
+}
+
 func expandHome(path string) (string, error) {
 	if !strings.HasPrefix(path, "~/") {
 		return path, nil
@@ -242,6 +242,62 @@ flows:
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "unknown cache.method")
 	})
+
+	t.Run("cache env and tools are checked", func(t *testing.T) {
+		for field, want := range map[string]string{
+			`env: ["A=1"]`: `cache.env: "A=1" is not a variable name`,
+			`tools: [" "]`: "cache.tools: empty command",
+		} {
+			_, err := NewConfig([]byte(`
+version: 2
+groups:
+  - name: build
+    command: go
+    cache: {reads: ["main.go"], ` + field + `}
+flows:
+  f:
+    steps:
+      - run: [build]
+`))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), want)
+		}
+	})
+
+	t.Run("settings.cache.remote", func(t *testing.T) {
+		load := func(remote string) (*Config, error) {
+			return NewConfig([]byte(`
+version: 2
+settings:
+  cache:
+    remote: ` + remote + `
+groups:
+  - name: build
+    command: go
+flows:
+  f:
+    steps:
+      - run: [build]
+`))
+		}
+		cfg, err := load("http://cache.internal:8080")
+		require.NoError(t, err)
+		assert.Equal(t, &RemoteCache{URL: "http://cache.internal:8080"}, cfg.Settings.Cache.Remote)
+
+		cfg, err = load("{url: https://cache.example, namespace: api, read-only: true, timeout: 2s, token-env: CACHE_TOKEN}")
+		require.NoError(t, err)
+		assert.Equal(t, &RemoteCache{URL: "https://cache.example", Namespace: "api", ReadOnly: true, Timeout: "2s", TokenEnv: "CACHE_TOKEN"},
+			cfg.Settings.Cache.Remote)
+
+		for remote, want := range map[string]string{
+			"ftp://cache":                    `url "ftp://cache" must be an http or https URL`,
+			"{url: http://c, timeout: fast}": "cache.remote.timeout",
+		} {
+			_, err := load(remote)
+			require.Error(t, err, remote)
+			assert.Contains(t, err.Error(), want)
+		}
+	})
 }
 
 func TestNewConfig_Envelope(t *testing.T) {
@@ -307,6 +363,16 @@ flows:
 			yaml:    "version: 2\ngroups:\n  - {name: a, command: echo}\nflows:\n  f:\n    steps:\n      - run: [a]\n        retries: -2\n",
 			wantErr: "retries must be >= 0",
 		},
+		{
+			name:    "invalid settings kill-grace",
+			yaml:    "version: 2\nsettings:\n  kill-grace: soon\ngroups:\n  - {name: a, command: echo}\nflows: {f: {steps: [{run: [a]}]}}\n",
+			wantErr: "settings: invalid kill-grace",
+		},
+		{
+			name:    "negative group kill-grace",
+			yaml:    "version: 2\ngroups:\n  - {name: a, command: echo, kill-grace: -1s}\nflows:\n  f:\n    steps:\n      - run: [a]\n",
+			wantErr: "kill-grace \"-1s\" must not be negative",
+		},
 	}
 	for _, tc := range errCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -669,11 +735,12 @@ flows:
     run:
       - build
       - group: deploy
-        when: 'x'`
+        when: 'x'
+        allow-failure: true`
 	cfg, err := NewConfig([]byte(doc))
 	require.NoError(t, err)
 	assert.Equal(t,
-		[]RunEntry{{Group: "build"}, {Group: "deploy", When: "x"}},
+		[]RunEntry{{Group: "build"}, {Group: "deploy", When: "x", AllowFailure: true}},
 		cfg.Flows["f"].Run,
 	)
 }
@@ -756,6 +823,18 @@ flows:
       - group: [a, b]`,
 			wantErr: `"group" must be a string`,
 		},
+		{
+			name: "allow-failure is not a bool",
+			doc: `version: 2
+groups: [{name: deploy, command: echo}]
+flows:
+  f:
+    mode: dag
+    run:
+      - group: deploy
+        allow-failure: sometimes`,
+			wantErr: `"allow-failure" must be a bool`,
+		},
 	}
 	for _, tc := range errCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -922,3 +1001,105 @@ func TestLoadOutStructFieldsFixture(t *testing.T) {
 		}
 	}
 }
+
+func TestWorkingDir(t *testing.T) {
+	t.Run("LoadConfig anchors a relative working-dir at the config file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "keepup.yml")
+		yml := "version: 2\nsettings:\n  working-dir: pkg\ngroups:\n  - name: x\n    command: echo\nflows:\n  f:\n    steps:\n      - run: [x]\n"
+		require.NoError(t, os.WriteFile(path, []byte(yml), 0o600))
+		cfg, err := LoadConfig(path)
+		require.NoError(t, err)
+		assert.Equal(t, dir, cfg.BaseDir)
+		assert.Equal(t, filepath.Join(dir, "pkg"), cfg.WorkDir())
+	})
+
+	tests := []struct {
+		name     string
+		base     string
+		wd       string
+		groupDir string
+		want     string
+	}{
+		{"nothing set keeps the process cwd", "", "", "", ""},
+		{"unset working-dir ignores the config dir", "/repo", "", "", ""},
+		{"relative working-dir joins base", "/repo", "build", "", "/repo/build"},
+		{"absolute working-dir wins over base", "/repo", "/srv", "", "/srv"},
+		{"relative group dir joins working-dir", "/repo", "pkgs", "web", "/repo/pkgs/web"},
+		{"absolute group dir wins", "/repo", "pkgs", "/opt/web", "/opt/web"},
+		{"group dir without working-dir stays cwd-relative", "/repo", "", "web", "web"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{BaseDir: filepath.FromSlash(tc.base), Settings: Settings{WorkingDir: filepath.FromSlash(tc.wd)}}
+			assert.Equal(t, filepath.FromSlash(tc.want), cfg.ResolveDir(filepath.FromSlash(tc.groupDir)))
+		})
+	}
+}
+
+func TestExtractRefs_IncludesDir(t *testing.T) {
+	g := &Group{Name: "b", Command: "make", Dir: `{{ output "where" }}`}
+	refs, err := ExtractRefs(g)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"where"}, refs)
+}
+
+func TestNewConfig_GroupAllowFailure(t *testing.T) {
+	cfg, err := NewConfig([]byte(`
+version: 2
+groups:
+  - name: lint
+    command: golangci-lint
+    allow-failure: true
+flows:
+  f:
+    steps:
+      - run: [lint]
+`))
+	require.NoError(t, err)
+	assert.True(t, cfg.GroupByName("lint").AllowFailure)
+}
+
+func TestNewConfig_Hooks(t *testing.T) {
+	const head = `version: 2
+groups:
+  - { name: up, command: docker }
+  - { name: test, command: go }
+  - { name: down, command: docker, params: ['{{ (out "test").Status }}'] }
+  - { name: notify, command: echo, params: ['{{ output "ghost" }}'] }
+  - { name: ghost, command: echo }
+flows:
+  f:
+    steps:
+      - run: [up]
+      - run: [test]
+`
+	t.Run("parses hook lists in execution order", func(t *testing.T) {
+		cfg, err := NewConfig([]byte(head + "    on-failure: [ghost]\n    finally: [down]\n"))
+		require.NoError(t, err)
+		f := cfg.Flows["f"]
+		assert.Equal(t, []Hook{
+			{Phase: PhaseOnFailure, Group: "ghost"},
+			{Phase: PhaseFinally, Group: "down"},
+		}, f.Hooks())
+		assert.Equal(t, []string{"up", "test"}, f.Members(), "hooks are not members")
+	})
+
+	errCases := []struct {
+		name    string
+		hooks   string
+		wantErr string
+	}{
+		{"undefined group", "    finally: [nope]\n", `finally: group "nope" is not defined`},
+		{"member reused as hook", "    finally: [up]\n", "already part of the flow's main plan"},
+		{"listed twice", "    on-success: [down]\n    finally: [down]\n", "already listed in on-success"},
+		{"reference outside the flow", "    finally: [notify]\n", `"ghost" is not part of this flow`},
+	}
+	for _, tc := range errCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewConfig([]byte(head + tc.hooks))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.wantErr)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NamespaceSep separates an import alias from a group name, as in
+// "web:build". Nested imports chain it: "web:ui:build".
+const NamespaceSep = ":"
+
+// Scope is the context an imported group runs in: the alias path it is
+// addressed under, plus the env and working directory of the file that
+// declared it. Root groups have no Scope.
+type Scope struct {
+	Namespace string
+	Env       map[string]string
+	WorkDir   string
+}
+
+// Qualify resolves a group reference made by g. References inside an
+// imported file are relative to that file, so they gain its namespace.
+func (g *Group) Qualify(ref string) string {
+	if g.Scope == nil || g.Scope.Namespace == "" {
+		return ref
+	}
+	return g.Scope.Namespace + NamespaceSep + ref
+}
+
+// EnvFor returns the global env a group runs with: its own file's env for an
+// imported group, the config's env otherwise.
+func (c *Config) EnvFor(g *Group) map[string]string {
+	if g != nil && g.Scope != nil {
+		return g.Scope.Env
+	}
+	return c.Env
+}
+
+// ResolveGroupDir resolves a group's rendered dir: against its own file's
+// working directory for an imported group, like ResolveDir otherwise.
+func (c *Config) ResolveGroupDir(g *Group, dir string) string {
+	if g == nil || g.Scope == nil {
+		return c.ResolveDir(dir)
+	}
+	switch {
+	case dir == "":
+		return g.Scope.WorkDir
+	case filepath.IsAbs(dir):
+		return dir
+	default:
+		return filepath.Join(g.Scope.WorkDir, dir)
+	}
+}
+
+// loadImports loads each file named by imports: (relative to the importing
+// file) as a complete config of its own and adds its groups under
+// "alias:name". stack holds the files being loaded, for cycle detection.
+func (c *Config) loadImports(path string, stack []string) error {
+	aliases := make([]string, 0, len(c.Imports))
+	for alias := range c.Imports {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if alias == "" || strings.ContainsAny(alias, NamespaceSep+" \t") {
+			return fmt.Errorf("imports: invalid alias %q (no spaces or %q)", alias, NamespaceSep)
+		}
+		target := c.Imports[alias]
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		sub, err := loadConfig(filepath.Clean(target), stack)
+		if err != nil {
+			return fmt.Errorf("imports: %s: %w", alias, err)
+		}
+		c.adopt(alias, sub)
+	}
+	return nil
+}
+
+// adopt appends an imported config's groups, qualified by alias. Groups the
+// import itself imported keep their own scope under a longer namespace.
+func (c *Config) adopt(alias string, sub *Config) {
+	workDir := sub.BaseDir
+	if sub.Settings.WorkingDir != "" {
+		workDir = sub.WorkDir()
+	}
+	for i := range sub.Groups {
+		g := sub.Groups[i]
+		g.Name = alias + NamespaceSep + g.Name
+		if g.Scope == nil {
+			g.Scope = &Scope{Namespace: alias, Env: sub.Env, WorkDir: workDir}
+		} else {
+			scope := *g.Scope
+			scope.Namespace = alias + NamespaceSep + scope.Namespace
+			g.Scope = &scope
+		}
+		c.Groups = append(c.Groups, g)
+	}
+	for base, inst := range sub.matrix {
+		qualified := make([]string, len(inst))
+		for i, n := range inst {
+			qualified[i] = alias + NamespaceSep + n
+		}
+		if c.matrix == nil {
+			c.matrix = make(map[string][]string)
+		}
+		c.matrix[alias+NamespaceSep+base] = qualified
+	}
+}
+
+// checkImportStack fails when path is already being loaded.
+func checkImportStack(path string, stack []string) error {
+	for i, open := range stack {
+		if open == path {
+			chain := append(append([]string(nil), stack[i:]...), path)
+			return fmt.Errorf("import cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const importsRoot = `version: 2
+imports:
+  web: ./web/keepup.yml
+env:
+  WHO: root
+groups:
+  - name: deploy
+    command: echo
+    params: ['{{ output "web:build" }}']
+flows:
+  ship:
+    mode: dag
+    run: [web:lib, web:build, deploy]
+`
+
+const importsWeb = `version: 2
+env:
+  WHO: web
+groups:
+  - name: lib
+    command: echo
+    params: [lib]
+  - name: build
+    command: echo
+    params: ['{{ output "lib" }}']
+flows:
+  local:
+    mode: dag
+    run: [lib, build]
+`
+
+func TestLoadConfig_ImportsQualifyGroups(t *testing.T) {
+	t.Parallel()
+	dir := writeTree(t, map[string]string{"keepup.yml": importsRoot, "web/keepup.yml": importsWeb})
+	cfg, err := LoadConfig(filepath.Join(dir, "keepup.yml"))
+	require.NoError(t, err)
+
+	build := cfg.GroupByName("web:build")
+	require.NotNil(t, build)
+	refs, err := ExtractRefs(build)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web:lib"}, refs, "references inside an import are relative to it")
+
+	require.NotNil(t, build.Scope)
+	assert.Equal(t, "web", build.Scope.Namespace)
+	assert.Equal(t, filepath.Join(dir, "web"), build.Scope.WorkDir)
+	assert.Equal(t, "web", cfg.EnvFor(build)["WHO"])
+	assert.Equal(t, "root", cfg.EnvFor(cfg.GroupByName("deploy"))["WHO"])
+	assert.Equal(t, filepath.Join(dir, "web", "src"), cfg.ResolveGroupDir(build, "src"))
+
+	_, hasFlow := cfg.Flows["local"]
+	assert.False(t, hasFlow, "flows of an import are not adopted")
+}
+
+func TestLoadConfig_ImportRefOutsideFlow(t *testing.T) {
+	t.Parallel()
+	root := `version: 2
+imports: {web: web/keepup.yml}
+flows:
+  ship:
+    mode: dag
+    run: [web:build]
+`
+	dir := writeTree(t, map[string]string{"keepup.yml": root, "web/keepup.yml": importsWeb})
+	_, err := LoadConfig(filepath.Join(dir, "keepup.yml"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"web:lib" is not part of this flow`)
+}
+
+func TestLoadConfig_NestedImports(t *testing.T) {
+	t.Parallel()
+	dir := writeTree(t, map[string]string{
+		"keepup.yml":        "version: 2\nimports: {web: web/keepup.yml}\nflows: {ui: {mode: dag, run: [web:ui:build, web:ui:lib]}}\n",
+		"web/keepup.yml":    "version: 2\nimports: {ui: ui/keepup.yml}\nflows: {ui: {mode: dag, run: [ui:lib, ui:build]}}\n",
+		"web/ui/keepup.yml": importsWeb,
+	})
+	cfg, err := LoadConfig(filepath.Join(dir, "keepup.yml"))
+	require.NoError(t, err)
+	build := cfg.GroupByName("web:ui:build")
+	require.NotNil(t, build)
+	assert.Equal(t, "web:ui", build.Scope.Namespace)
+	assert.Equal(t, filepath.Join(dir, "web", "ui"), build.Scope.WorkDir)
+	refs, err := ExtractRefs(build)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web:ui:lib"}, refs)
+}
+
+func TestLoadConfig_ImportErrors(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		files map[string]string
+		want  string
+	}{
+		"cycle": {
+			files: map[string]string{
+				"keepup.yml":   "version: 2\nimports: {a: a/keepup.yml}\n",
+				"a/keepup.yml": "version: 2\nimports: {root: ../keepup.yml}\n",
+			},
+			want: "import cycle",
+		},
+		"invalid alias": {
+			files: map[string]string{"keepup.yml": "version: 2\nimports: {\"a:b\": x.yml}\n"},
+			want:  `invalid alias "a:b"`,
+		},
+		"missing file": {
+			files: map[string]string{"keepup.yml": "version: 2\nimports: {web: nope.yml}\n"},
+			want:  "imports: web:",
+		},
+		"reserved separator": {
+			files: map[string]string{"keepup.yml": "version: 2\ngroups:\n  - {name: \"a:b\", command: echo}\n"},
+			want:  "reserved for imported groups",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			dir := writeTree(t, tc.files)
+			_, err := LoadConfig(filepath.Join(dir, "keepup.yml"))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
+
+func TestNewConfig_RejectsImports(t *testing.T) {
+	t.Parallel()
+	_, err := NewConfig([]byte("version: 2\nimports: {web: web.yml}\n"))
+	assert.ErrorIs(t, err, errIncludeFromBytes)
+}
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// fragmentKeys are the top-level keys an included file may declare. Settings
+// and the default flow belong to the root file alone.
+var fragmentKeys = map[string]bool{"version": true, "include": true, "env": true, "groups": true, "flows": true}
+
+// includeLoader merges the files named by include: into the root config.
+type includeLoader struct {
+	root    *Config
+	rootDir string
+	merged  map[string]bool // files already merged (a diamond includes once)
+	stack   []string        // files being loaded, for cycle detection
+}
+
+// parseFile reads and decodes one config file, returning its document node
+// alongside the decoded Config so callers can recover line numbers.
+func parseFile(path string) (*Config, *yaml.Node, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read config file %q: %w", path, err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal configuration %q: %w", path, err)
+	}
+	var cfg Config
+	if err := doc.Decode(&cfg); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal configuration %q: %w", path, err)
+	}
+	return &cfg, &doc, nil
+}
+
+// loadIncludes merges every file reachable through the root's include: list,
+// depth-first in declaration order, and records where each group, flow and
+// env key was declared so duplicates and validation errors can name a
+// file:line.
+func (c *Config) loadIncludes(path string, doc *yaml.Node) error {
+	l := &includeLoader{root: c, rootDir: filepath.Dir(path), merged: map[string]bool{path: true}, stack: []string{path}}
+	c.origins = make(map[string]string)
+	if err := l.record(path, doc); err != nil {
+		return err
+	}
+	includes := c.Include
+	c.Include = nil
+	return l.includeAll(path, includes)
+}
+
+func (l *includeLoader) includeAll(from string, patterns []string) error {
+	for _, pattern := range patterns {
+		files, err := l.resolve(from, pattern)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := l.load(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve expands one include: entry relative to the including file. A glob
+// may match nothing; a plain path must exist.
+func (l *includeLoader) resolve(from, pattern string) ([]string, error) {
+	p := pattern
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(filepath.Dir(from), p)
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		if _, err := os.Stat(p); err != nil {
+			return nil, fmt.Errorf("%s: include %q: %w", l.display(from), pattern, err)
+		}
+		return []string{p}, nil
+	}
+	files, err := filepath.Glob(p)
+	if err != nil {
+		return nil, fmt.Errorf("%s: include %q: %w", l.display(from), pattern, err)
+	}
+	return files, nil
+}
+
+func (l *includeLoader) load(path string) error {
+	for _, open := range l.stack {
+		if open == path {
+			chain := make([]string, 0, len(l.stack)+1)
+			for _, s := range append(l.stack, path) {
+				chain = append(chain, l.display(s))
+			}
+			return fmt.Errorf("include cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+	if l.merged[path] {
+		return nil
+	}
+	l.merged[path] = true
+
+	frag, doc, err := parseFile(path)
+	if err != nil {
+		return err
+	}
+	if err := l.checkFragment(path, doc, frag); err != nil {
+		return err
+	}
+	if err := l.record(path, doc); err != nil {
+		return err
+	}
+	l.root.Groups = append(l.root.Groups, frag.Groups...)
+	if len(frag.Flows) > 0 && l.root.Flows == nil {
+		l.root.Flows = make(map[string]Flow, len(frag.Flows))
+	}
+	for name, f := range frag.Flows {
+		l.root.Flows[name] = f
+	}
+	if len(frag.Env) > 0 && l.root.Env == nil {
+		l.root.Env = make(map[string]string, len(frag.Env))
+	}
+	for k, v := range frag.Env {
+		l.root.Env[k] = v
+	}
+
+	l.stack = append(l.stack, path)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+	return l.includeAll(path, frag.Include)
+}
+
+// checkFragment rejects root-only keys and a mismatched schema version.
+func (l *includeLoader) checkFragment(path string, doc *yaml.Node, frag *Config) error {
+	body := docMapping(doc)
+	if body == nil {
+		return nil
+	}
+	for i := 0; i+1 < len(body.Content); i += 2 {
+		k := body.Content[i]
+		if !fragmentKeys[k.Value] {
+			return fmt.Errorf("%s:%d: %q is only allowed in the root config", l.display(path), k.Line, k.Value)
+		}
+	}
+	if frag.Version != 0 && frag.Version != SchemaVersion {
+		return fmt.Errorf("%s: unsupported schema version %d: this binary only supports version %d",
+			l.display(path), frag.Version, SchemaVersion)
+	}
+	return nil
+}
+
+// record notes the file:line of every group, flow and env key a file
+// declares, failing on any name already declared elsewhere.
+func (l *includeLoader) record(path string, doc *yaml.Node) error {
+	body := docMapping(doc)
+	if body == nil {
+		return nil
+	}
+	for i := 0; i+1 < len(body.Content); i += 2 {
+		key, val := body.Content[i].Value, body.Content[i+1]
+		switch key {
+		case "groups":
+			for _, item := range val.Content {
+				if name := mappingValue(item, "name"); name != "" {
+					if err := l.declare("groups", name, path, item.Line); err != nil {
+						return err
+					}
+				}
+			}
+		case "flows", "env":
+			for j := 0; j+1 < len(val.Content); j += 2 {
+				k := val.Content[j]
+				if err := l.declare(key, k.Value, path, k.Line); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (l *includeLoader) declare(kind, name, path string, line int) error {
+	at := fmt.Sprintf("%s:%d", l.display(path), line)
+	id := originKey(kind, name)
+	if prev, dup := l.root.origins[id]; dup {
+		return fmt.Errorf("%s: duplicate name %q (%s and %s)", kind, name, prev, at)
+	}
+	l.root.origins[id] = at
+	return nil
+}
+
+// display renders a path relative to the root config's directory when it
+// lives below it.
+func (l *includeLoader) display(path string) string {
+	if rel, err := filepath.Rel(l.rootDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+func docMapping(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) == 1 && doc.Content[0].Kind == yaml.MappingNode {
+		return doc.Content[0]
+	}
+	return nil
+}
+
+func mappingValue(n *yaml.Node, key string) string {
+	if n.Kind != yaml.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+func originKey(kind, name string) string { return kind + "/" + name }
+
+// at prefixes err with the file:line that declared a group or flow, when the
+// config was assembled from several files. A matrix instance reports its
+// group's declaration.
+func (c *Config) at(kind, name string, err error) error {
+	if err == nil || c.origins == nil {
+		return err
+	}
+	if kind == "groups" {
+		if base, _, ok := strings.Cut(name, "["); ok {
+			name = base
+		}
+	}
+	if loc, ok := c.origins[originKey(kind, name)]; ok {
+		return fmt.Errorf("%s: %w", loc, err)
+	}
+	return err
+}
+
+// errIncludeFromBytes is returned by NewConfig for a document with include:
+// or imports:, which need a file location to resolve against.
+var errIncludeFromBytes = errors.New("include: and imports: are only supported in config files loaded from disk")
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTree writes files (relative path → content) under a temp dir and
+// returns the dir.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for rel, body := range files {
+		p := filepath.Join(dir, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(body), 0o600))
+	}
+	return dir
+}
+
+const includeRoot = `version: 2
+include: [keepup.d/*.yml]
+env:
+  ROOT: "1"
+groups:
+  - { name: build, command: go }
+flows:
+  ci:
+    steps:
+      - run: [build]
+      - run: [test, lint]
+`
+
+func TestLoadConfig_Include(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"keepup.yml": includeRoot,
+		"keepup.d/a.yml": `groups:
+  - { name: test, command: go, params: ['{{ output "build" }}'] }
+env:
+  TEAM_A: "1"
+`,
+		"keepup.d/b.yml": `version: 2
+include: [../shared/lint.yml]
+groups:
+  - { name: release, command: goreleaser }
+flows:
+  release:
+    steps:
+      - run: [release]
+`,
+		"shared/lint.yml": `groups:
+  - { name: lint, command: golangci-lint }
+`,
+	})
+	cfg, err := LoadConfig(filepath.Join(dir, "keepup.yml"))
+	require.NoError(t, err)
+
+	names := make([]string, len(cfg.Groups))
+	for i := range cfg.Groups {
+		names[i] = cfg.Groups[i].Name
+	}
+	assert.Equal(t, []string{"build", "test", "release", "lint"}, names)
+	assert.Contains(t, cfg.Flows, "release")
+	assert.Equal(t, map[string]string{"ROOT": "1", "TEAM_A": "1"}, cfg.Env)
+	assert.Empty(t, cfg.Include, "include: is consumed by the merge")
+	assert.Equal(t, dir, cfg.BaseDir)
+}
+
+func TestLoadConfig_IncludeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name: "duplicate group names both locations",
+			files: map[string]string{
+				"keepup.d/a.yml": "groups:\n  - { name: test, command: go }\n",
+				"keepup.d/b.yml": "groups:\n  - { name: lint, command: x }\n  - { name: test, command: go }\n",
+			},
+			wantErr: `groups: duplicate name "test" (keepup.d/a.yml:2 and keepup.d/b.yml:3)`,
+		},
+		{
+			name: "duplicate flow with the root",
+			files: map[string]string{
+				"keepup.d/a.yml": "groups:\n  - { name: test, command: go }\n  - { name: lint, command: x }\n" +
+					"flows:\n  ci:\n    steps:\n      - run: [test]\n",
+			},
+			wantErr: `flows: duplicate name "ci" (keepup.yml:8 and keepup.d/a.yml:5)`,
+		},
+		{
+			name: "root-only key in a fragment",
+			files: map[string]string{
+				"keepup.d/a.yml": "settings:\n  dry-run: true\n",
+			},
+			wantErr: `keepup.d/a.yml:1: "settings" is only allowed in the root config`,
+		},
+		{
+			name: "validation error names the declaring file",
+			files: map[string]string{
+				"keepup.d/a.yml": "groups:\n  - { name: test, command: go }\n" +
+					"  - { name: lint, command: x, cache: { reads: [] } }\n",
+			},
+			wantErr: `keepup.d/a.yml:3: group "lint": cache.reads`,
+		},
+		{
+			name: "missing plain include",
+			files: map[string]string{
+				"keepup.d/a.yml": "include: [nope.yml]\n",
+			},
+			wantErr: `keepup.d/a.yml: include "nope.yml"`,
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"keepup.d/a.yml": "include: [../keepup.yml]\n",
+			},
+			wantErr: "include cycle: keepup.yml -> keepup.d/a.yml -> keepup.yml",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			files := map[string]string{"keepup.yml": includeRoot}
+			for k, v := range tc.files {
+				files[k] = v
+			}
+			_, err := LoadConfig(filepath.Join(writeTree(t, files), "keepup.yml"))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.wantErr)
+		})
+	}
+}
+
+func TestNewConfig_IncludeNeedsAFile(t *testing.T) {
+	_, err := NewConfig([]byte("version: 2\ninclude: [a.yml]\n"))
+	require.ErrorIs(t, err, errIncludeFromBytes)
+}
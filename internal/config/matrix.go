@@ -0,0 +1,324 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Matrix expands one group into an instance per combination of axis values.
+//
+// In YAML every key except include/exclude is an axis listing its values:
+//
+//	matrix:
+//	  go: ["1.22", "1.23"]
+//	  os: [linux, darwin]
+//	  exclude: [{go: "1.22", os: darwin}]
+//	  include: [{go: "1.21", os: linux}]
+//
+// Exclude drops every combination matching all of an entry's pairs; Include
+// then adds combinations, each of which must set every axis. Axes keep their
+// declaration order, which fixes both instance order and instance names.
+type Matrix struct {
+	Axes    []MatrixAxis
+	Include []map[string]string
+	Exclude []map[string]string
+}
+
+// MatrixAxis is one named dimension of a Matrix.
+type MatrixAxis struct {
+	Name   string
+	Values []string
+}
+
+// Matrix keys with a meaning other than "axis".
+const (
+	matrixInclude = "include"
+	matrixExclude = "exclude"
+)
+
+// UnmarshalYAML reads the axis mapping, keeping scalar values verbatim so
+// "1.20" stays "1.20" rather than round-tripping through a float.
+func (m *Matrix) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return errors.New("matrix: must be a mapping of axis → values")
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, val := node.Content[i].Value, node.Content[i+1]
+		switch key {
+		case matrixInclude, matrixExclude:
+			var entries []map[string]string
+			if err := val.Decode(&entries); err != nil {
+				return fmt.Errorf("matrix: %q must be a list of axis → value maps", key)
+			}
+			if key == matrixInclude {
+				m.Include = entries
+			} else {
+				m.Exclude = entries
+			}
+		default:
+			if val.Kind != yaml.SequenceNode || len(val.Content) == 0 {
+				return fmt.Errorf("matrix: axis %q must be a non-empty list of values", key)
+			}
+			axis := MatrixAxis{Name: key}
+			for _, v := range val.Content {
+				if v.Kind != yaml.ScalarNode {
+					return fmt.Errorf("matrix: axis %q values must be scalars", key)
+				}
+				axis.Values = append(axis.Values, v.Value)
+			}
+			m.Axes = append(m.Axes, axis)
+		}
+	}
+	return nil
+}
+
+// MarshalYAML writes the matrix back in its YAML shape.
+func (m *Matrix) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(key string, v any) error {
+		var val yaml.Node
+		if err := val.Encode(v); err != nil {
+			return err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &val)
+		return nil
+	}
+	for _, a := range m.Axes {
+		if err := add(a.Name, a.Values); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.Exclude) > 0 {
+		if err := add(matrixExclude, m.Exclude); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.Include) > 0 {
+		if err := add(matrixInclude, m.Include); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// combinations returns the axis assignments the matrix expands to, in order:
+// the cartesian product (first axis outermost) minus excludes, then includes.
+func (m *Matrix) combinations() ([]map[string]string, error) {
+	if len(m.Axes) == 0 {
+		return nil, errors.New("at least one axis is required")
+	}
+	if err := m.checkEntries(); err != nil {
+		return nil, err
+	}
+	out := m.filter(m.product())
+	if len(out) == 0 {
+		return nil, errors.New("every combination is excluded")
+	}
+	return out, nil
+}
+
+// checkEntries checks that excludes name only declared axes and that each
+// include sets every axis.
+func (m *Matrix) checkEntries() error {
+	axes := make(map[string]bool, len(m.Axes))
+	for _, a := range m.Axes {
+		axes[a.Name] = true
+	}
+	for _, ex := range m.Exclude {
+		for k := range ex {
+			if !axes[k] {
+				return fmt.Errorf("exclude: unknown axis %q", k)
+			}
+		}
+	}
+	for _, inc := range m.Include {
+		if len(inc) != len(m.Axes) {
+			return fmt.Errorf("include: every entry must set each axis (%s)", m.axisNames())
+		}
+		for k := range inc {
+			if !axes[k] {
+				return fmt.Errorf("include: unknown axis %q", k)
+			}
+		}
+	}
+	return nil
+}
+
+// product returns the cartesian product of the axes, first axis outermost.
+func (m *Matrix) product() []map[string]string {
+	combos := []map[string]string{{}}
+	for _, a := range m.Axes {
+		next := make([]map[string]string, 0, len(combos)*len(a.Values))
+		for _, c := range combos {
+			for _, v := range a.Values {
+				n := make(map[string]string, len(c)+1)
+				for k, cv := range c {
+					n[k] = cv
+				}
+				n[a.Name] = v
+				next = append(next, n)
+			}
+		}
+		combos = next
+	}
+	return combos
+}
+
+// filter drops the excluded combos and appends the includes not already
+// among them.
+func (m *Matrix) filter(combos []map[string]string) []map[string]string {
+	out := make([]map[string]string, 0, len(combos)+len(m.Include))
+	seen := make(map[string]bool, cap(out))
+	for _, c := range combos {
+		if !m.excluded(c) {
+			out = append(out, c)
+			seen[m.key(c)] = true
+		}
+	}
+	for _, inc := range m.Include {
+		if k := m.key(inc); !seen[k] {
+			seen[k] = true
+			out = append(out, inc)
+		}
+	}
+	return out
+}
+
+func (m *Matrix) excluded(combo map[string]string) bool {
+	for _, ex := range m.Exclude {
+		match := true
+		for k, v := range ex {
+			if combo[k] != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// key renders a combination as "a=1,b=2" in axis order.
+func (m *Matrix) key(combo map[string]string) string {
+	parts := make([]string, len(m.Axes))
+	for i, a := range m.Axes {
+		parts[i] = a.Name + "=" + combo[a.Name]
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *Matrix) axisNames() string {
+	names := make([]string, len(m.Axes))
+	for i, a := range m.Axes {
+		names[i] = a.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// expandMatrices replaces every matrix group with its instances, named
+// "group[axis=value,...]", and rewrites flow steps, run entries and hooks
+// that name the group to list every instance in its place. Instances can
+// also be referenced one by one, e.g. {{ output "test[go=1.22]" }}.
+func (c *Config) expandMatrices() error {
+	groups := make([]Group, 0, len(c.Groups))
+	instances := make(map[string][]string)
+	for i := range c.Groups {
+		g := c.Groups[i]
+		if g.Matrix == nil || g.Name == "" {
+			groups = append(groups, g)
+			continue
+		}
+		combos, err := g.Matrix.combinations()
+		if err != nil {
+			return c.at("groups", g.Name, fmt.Errorf("group %q: matrix: %w", g.Name, err))
+		}
+		for _, combo := range combos {
+			inst := g
+			inst.Name = g.Name + "[" + g.Matrix.key(combo) + "]"
+			inst.Matrix = nil
+			inst.MatrixValues = combo
+			groups = append(groups, inst)
+			instances[g.Name] = append(instances[g.Name], inst.Name)
+		}
+	}
+	if len(instances) == 0 {
+		return nil
+	}
+	c.Groups = groups
+	if c.matrix == nil {
+		c.matrix = make(map[string][]string, len(instances))
+	}
+	for base, inst := range instances {
+		c.matrix[base] = inst
+	}
+	for name, f := range c.Flows {
+		f.expandMatrix(instances)
+		c.Flows[name] = f
+	}
+	return nil
+}
+
+// expandMatrix substitutes matrix group names with their instances.
+func (f *Flow) expandMatrix(instances map[string][]string) {
+	expand := func(names, flows []string) []string {
+		if names == nil {
+			return nil
+		}
+		out := make([]string, 0, len(names))
+		for _, n := range names {
+			if inst, ok := instances[n]; ok && !slices.Contains(flows, n) {
+				out = append(out, inst...)
+			} else {
+				out = append(out, n)
+			}
+		}
+		return out
+	}
+	steps := make([]Step, len(f.Steps))
+	for i, s := range f.Steps {
+		s.Run = expand(s.Run, s.Flows)
+		steps[i] = s
+	}
+	if f.Steps != nil {
+		f.Steps = steps
+	}
+	var run []RunEntry
+	for _, r := range f.Run {
+		inst, ok := instances[r.Group]
+		if !ok {
+			run = append(run, r)
+			continue
+		}
+		for _, name := range inst {
+			e := r
+			e.Group = name
+			run = append(run, e)
+		}
+	}
+	f.Run = run
+	f.OnSuccess = expand(f.OnSuccess, nil)
+	f.OnFailure = expand(f.OnFailure, nil)
+	f.Finally = expand(f.Finally, nil)
+}
+
+// MatrixInstances returns the instance names a matrix group expanded to, or
+// nil when name is not a matrix group.
+func (c *Config) MatrixInstances(name string) []string {
+	return c.matrix[name]
+}
+
+// matrixHint points a reference to a matrix group's bare name at its
+// instances, which are what flows and outputs actually contain.
+func (c *Config) matrixHint(name string) string {
+	inst := c.matrix[name]
+	if len(inst) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%q is a matrix group; reference an instance such as %q)", name, inst[0])
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.yaml.in/yaml/v3"
+)
+
+const matrixHead = `version: 2
+groups:
+  - name: test
+    command: go
+    params: ["test", "{{ matrix \"go\" }}"]
+    matrix:
+      go: ["1.22", "1.23"]
+      os: [linux, darwin]
+      exclude: [{go: "1.22", os: darwin}]
+      include: [{go: "1.21", os: linux}]
+  - name: report
+    command: echo
+    params: ['{{ output "test[go=1.23,os=darwin]" }}']
+`
+
+func TestNewConfig_MatrixExpansion(t *testing.T) {
+	t.Run("step flow lists every instance in the group's wave", func(t *testing.T) {
+		cfg, err := NewConfig([]byte(matrixHead + `flows:
+  f:
+    steps:
+      - run: [test]
+      - run: [report]
+`))
+		require.NoError(t, err)
+		want := []string{"test[go=1.22,os=linux]", "test[go=1.23,os=linux]", "test[go=1.23,os=darwin]", "test[go=1.21,os=linux]"}
+		assert.Equal(t, want, cfg.Flows["f"].Steps[0].Run)
+		assert.Equal(t, want, cfg.MatrixInstances("test"))
+		assert.Nil(t, cfg.GroupByName("test"), "the matrix group itself is replaced by its instances")
+
+		inst := cfg.GroupByName("test[go=1.21,os=linux]")
+		require.NotNil(t, inst)
+		assert.Equal(t, map[string]string{"go": "1.21", "os": "linux"}, inst.MatrixValues)
+		assert.Nil(t, inst.Matrix)
+	})
+
+	t.Run("dag run entry is copied per instance", func(t *testing.T) {
+		cfg, err := NewConfig([]byte(matrixHead + `flows:
+  f:
+    mode: dag
+    run:
+      - { group: test, allow-failure: true }
+      - report
+`))
+		require.NoError(t, err)
+		run := cfg.Flows["f"].Run
+		require.Len(t, run, 5)
+		for _, r := range run[:4] {
+			assert.True(t, r.AllowFailure, r.Group)
+		}
+	})
+
+	t.Run("values are kept verbatim", func(t *testing.T) {
+		cfg, err := NewConfig([]byte(`version: 2
+groups:
+  - { name: t, command: go, matrix: { go: [1.20] } }
+flows:
+  f:
+    steps:
+      - run: [t]
+`))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"t[go=1.20]"}, cfg.Flows["f"].Steps[0].Run)
+	})
+}
+
+func TestNewConfig_MatrixErrors(t *testing.T) {
+	const tail = `flows:
+  f:
+    steps:
+      - run: [t]
+`
+	tests := []struct {
+		name    string
+		matrix  string
+		wantErr string
+	}{
+		{"empty axis", "{ go: [] }", `axis "go" must be a non-empty list`},
+		{"no axes", "{ exclude: [] }", "at least one axis"},
+		{"unknown exclude axis", "{ go: [a], exclude: [{os: x}] }", `exclude: unknown axis "os"`},
+		{"partial include", "{ go: [a], os: [b], include: [{go: c}] }", "must set each axis (go, os)"},
+		{"all excluded", "{ go: [a], exclude: [{go: a}] }", "every combination is excluded"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewConfig([]byte("version: 2\ngroups:\n  - { name: t, command: go, matrix: " + tc.matrix + " }\n" + tail))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.wantErr)
+		})
+	}
+
+	t.Run("bare matrix name in a reference points at instances", func(t *testing.T) {
+		_, err := NewConfig([]byte(`version: 2
+groups:
+  - { name: t, command: go, matrix: { go: [a, b] } }
+  - { name: r, command: echo, params: ['{{ output "t" }}'] }
+flows:
+  f:
+    steps:
+      - run: [t]
+      - run: [r]
+`))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `"t" is a matrix group; reference an instance such as "t[go=a]"`)
+	})
+}
+
+func TestMatrix_MarshalRoundTrip(t *testing.T) {
+	in := "go:\n    - \"1.22\"\nos:\n    - linux\nexclude:\n    - go: \"1.22\"\n"
+	var m Matrix
+	require.NoError(t, yaml.Unmarshal([]byte(in), &m))
+	out, err := yaml.Marshal(&m)
+	require.NoError(t, err)
+	assert.Equal(t, in, string(out))
+}
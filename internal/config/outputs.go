@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/quike/keepup/internal/template"
+)
+
+// Output parse formats.
+const (
+	ParseJSON   = "json"
+	ParseYAML   = "yaml"
+	ParseDotenv = "dotenv"
+)
+
+// Outputs parses a group's stdout into named values that templates read as
+// {{ (out "x").Values.key }}. Parse reads the whole stdout as a JSON or YAML
+// object, or as dotenv KEY=value lines; Regex maps a value name to a pattern
+// matched against stdout, whose first capture group (or whole match, without
+// one) becomes the value. Both may be set. A group that declares neither can
+// still produce values by writing them to $KEEPUP_OUTPUT.
+//
+// Keys lists the top-level keys the group promises to produce, from Parse or
+// $KEEPUP_OUTPUT. A listed key that is missing fails the group, and, together
+// with the Regex names, it lets the loader reject reads of keys that do not
+// exist.
+//
+// A scalar is shorthand for Parse: `outputs: json`.
+type Outputs struct {
+	Parse string            `yaml:"parse,omitempty"`
+	Keys  []string          `yaml:"keys,omitempty"`
+	Regex map[string]string `yaml:"regex,omitempty"`
+}
+
+// UnmarshalYAML accepts a scalar (a parse format) or a {parse, keys, regex}
+// mapping.
+func (o *Outputs) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		o.Parse = node.Value
+		return nil
+	}
+	type plain Outputs
+	if err := node.Decode((*plain)(o)); err != nil {
+		return fmt.Errorf("outputs: %w", err)
+	}
+	return nil
+}
+
+// Declared returns the value names a group's outputs are known to produce,
+// sorted, and whether that list is complete: it is not when Parse is set
+// without Keys, since any key stdout holds is then readable.
+func (o *Outputs) Declared() ([]string, bool) {
+	names := append([]string(nil), o.Keys...)
+	for name := range o.Regex {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, o.Parse == "" || len(o.Keys) > 0
+}
+
+// validateOutputs checks a group's outputs: block.
+func validateOutputs(g *Group) error {
+	o := g.Outputs
+	if o == nil {
+		return nil
+	}
+	switch o.Parse {
+	case ParseJSON, ParseYAML, ParseDotenv:
+	case "":
+		if len(o.Regex) == 0 && len(o.Keys) == 0 {
+			return fmt.Errorf("group %q: outputs: set parse, keys, or regex", g.Name)
+		}
+	default:
+		return fmt.Errorf("group %q: outputs: unknown parse %q (use 'json', 'yaml' or 'dotenv')", g.Name, o.Parse)
+	}
+	for _, k := range o.Keys {
+		if _, dup := o.Regex[k]; dup {
+			return fmt.Errorf("group %q: outputs: %q is both a key and a regex", g.Name, k)
+		}
+	}
+	for name, pattern := range o.Regex {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("group %q: outputs: regex %q: %w", g.Name, name, err)
+		}
+	}
+	if g.Capture != nil && g.Capture.Keep == CaptureNone {
+		return fmt.Errorf("group %q: outputs: nothing to parse with capture: none", g.Name)
+	}
+	return nil
+}
+
+// checkValueRefs rejects a template that reads (out "x").Values.key when x
+// declares a complete list of values without key (see Outputs.Declared). A
+// group without outputs: may write any value to $KEEPUP_OUTPUT, so reads of
+// it are not checked, and neither are reads through index or a variable.
+func (c *Config) checkValueRefs() error {
+	check := func(where string, qualify func(string) string, s string) error {
+		refs, err := template.ValueRefs(s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", where, err)
+		}
+		for _, r := range refs {
+			name := qualify(r.Group)
+			g := c.GroupByName(name)
+			if g == nil || g.Outputs == nil {
+				continue // an unknown group is reported by ValidateReferences
+			}
+			if declared, complete := g.Outputs.Declared(); complete && !slices.Contains(declared, r.Key) {
+				return fmt.Errorf("%s reads (out %q).Values.%s, but %q only declares %s",
+					where, r.Group, r.Key, name, strings.Join(declared, ", "))
+			}
+		}
+		return nil
+	}
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		for _, s := range g.templates() {
+			if err := check(fmt.Sprintf("group %q", g.Name), g.Qualify, s); err != nil {
+				return c.at("groups", g.Name, err)
+			}
+		}
+	}
+	same := func(s string) string { return s }
+	for name, f := range c.Flows {
+		for i, step := range f.Steps {
+			if err := check(fmt.Sprintf("flow %q step %d: when", name, i+1), same, step.When); err != nil {
+				return c.at("flows", name, err)
+			}
+		}
+		for _, r := range f.Run {
+			if err := check(fmt.Sprintf("flow %q: group %q: when", name, r.Member()), same, r.When); err != nil {
+				return c.at("flows", name, err)
+			}
+		}
+	}
+	return nil
+}
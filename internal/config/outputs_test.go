@@ -0,0 +1,113 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfig_Outputs(t *testing.T) {
+	t.Parallel()
+	cfg, err := NewConfig([]byte(`
+version: 2
+groups:
+  - {name: list, command: go, outputs: json}
+  - name: tf
+    command: terraform
+    outputs: {parse: json, keys: [vpc_id]}
+  - name: ver
+    command: go
+    outputs: {regex: {version: 'go(\S+)'}}
+  - {name: sh, command: ./tag.sh}
+  - name: use
+    command: echo
+    params:
+      - '{{ (out "sh").Values.TAG }} {{ (out "list").Values.anything }}'
+      - '{{ (out "tf").Values.vpc_id }} {{ (out "ver").Values.version }}'
+flows:
+  f:
+    steps:
+      - run: [list, tf, ver, sh]
+      - run: [use]
+        when: '{{ (out "tf").Values.vpc_id }}'
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &Outputs{Parse: ParseJSON}, cfg.GroupByName("list").Outputs)
+	declared, complete := cfg.GroupByName("tf").Outputs.Declared()
+	assert.Equal(t, []string{"vpc_id"}, declared)
+	assert.True(t, complete)
+	_, complete = cfg.GroupByName("list").Outputs.Declared()
+	assert.False(t, complete, "parse without keys makes any key readable")
+}
+
+func TestValidateOutputs_Rejects(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name  string
+		group string
+		want  string
+	}{
+		{"empty", "{name: g, command: x, outputs: {}}", "set parse, keys, or regex"},
+		{"unknown parse", "{name: g, command: x, outputs: toml}", `unknown parse "toml"`},
+		{"key and regex", "{name: g, command: x, outputs: {parse: json, keys: [a], regex: {a: a}}}", `"a" is both a key and a regex`},
+		{"bad regex", "{name: g, command: x, outputs: {regex: {a: '('}}}", `regex "a"`},
+		{"capture none", "{name: g, command: x, outputs: json, capture: none}", "nothing to parse with capture: none"},
+		{"service", "{name: g, command: x, service: true, outputs: json}", "a service cannot declare outputs:"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			body := "version: 2\ngroups:\n  - " + tc.group + "\nflows:\n  f:\n    steps:\n      - run: [g]\n"
+			_, err := NewConfig([]byte(body))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
+
+func TestCheckValueRefs_Rejects(t *testing.T) {
+	t.Parallel()
+	const groups = `
+version: 2
+groups:
+  - {name: tf, command: terraform, outputs: {parse: json, keys: [vpc_id, region]}}
+  - {name: plain, command: date}
+  - {name: env, command: ./tag.sh, outputs: {keys: [TAG]}}
+`
+	cases := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			"typo in a key",
+			`  - {name: use, command: echo, params: ['{{ (out "tf").Values.vpcid }}']}`,
+			`group "use" reads (out "tf").Values.vpcid, but "tf" only declares region, vpc_id`,
+		},
+		{
+			"keys of an output file",
+			`  - {name: use, command: echo, stdin: '{{ (out "env").Values.TAGG }}'}`,
+			`group "use" reads (out "env").Values.TAGG, but "env" only declares TAG`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			body := groups + tc.body + "\nflows:\n  f:\n    steps:\n      - run: [tf, plain, env]\n      - run: [use]\n"
+			_, err := NewConfig([]byte(body))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+
+	_, err := NewConfig([]byte(groups + `flows:
+  f:
+    mode: dag
+    run:
+      - tf
+      - {group: plain, when: '{{ eq (out "tf").Values.zone "a" }}'}
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `flow "f": group "plain": when reads (out "tf").Values.zone`)
+}
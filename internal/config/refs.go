@@ -2,13 +2,17 @@ package config
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/quike/keepup/internal/template"
 )
 
 // ExtractRefs returns every group name referenced by a group's commands via
 // the template output() function (or the legacy "{{ output.X }}" form),
-// across every entry in CommandList(). Duplicates are preserved by position.
+// across every entry in CommandList(), the group's dir: template, and its
+// stdin: (the template or file path, or the from: group unless it streams).
+// Duplicates are preserved by position. An imported group's references are
+// qualified with its namespace (see Group.Qualify).
 // An error is returned when any template string is malformed, surfacing the
 // problem at config-load time.
 func ExtractRefs(g *Group) ([]string, error) {
@@ -18,22 +22,51 @@ func ExtractRefs(g *Group) ([]string, error) {
 		if err != nil {
 			return fmt.Errorf("group %q: %w", g.Name, err)
 		}
-		out = append(out, refs...)
+		for _, r := range refs {
+			out = append(out, g.Qualify(r))
+		}
 		return nil
 	}
-	for _, cs := range g.CommandList() {
-		if err := collect(cs.Command); err != nil {
+	for _, s := range g.templates() {
+		if err := collect(s); err != nil {
 			return nil, err
 		}
-		for _, p := range cs.Params {
-			if err := collect(p); err != nil {
-				return nil, err
-			}
-		}
+	}
+	if in := g.Stdin; in != nil && in.From != "" && !in.Stream {
+		out = append(out, g.Qualify(in.From))
 	}
 	return out, nil
 }
 
+// ArgRefs returns the flow args g's templates read with arg. all is set
+// when one of them computes an arg's name, so any arg may be read.
+func (g *Group) ArgRefs() (names []string, all bool, err error) {
+	for _, s := range g.templates() {
+		refs, computed, err := template.ArgRefs(s)
+		if err != nil {
+			return nil, false, fmt.Errorf("group %q: %w", g.Name, err)
+		}
+		names = append(names, refs...)
+		all = all || computed
+	}
+	return names, all, nil
+}
+
+// templates returns every template string of g, in the order ExtractRefs
+// reports their references: commands and params, dir, stdin.
+func (g *Group) templates() []string {
+	var out []string
+	for _, cs := range g.CommandList() {
+		out = append(out, cs.Command)
+		out = append(out, cs.Params...)
+	}
+	out = append(out, g.Dir)
+	if in := g.Stdin; in != nil {
+		out = append(out, in.Template, in.File)
+	}
+	return out
+}
+
 // selfRefHint explains the intra-group limitation for multi-command groups: a
 // commands: entry cannot consume an earlier entry's output, which is the
 // usual intent behind a self-reference.
@@ -52,6 +85,8 @@ func selfRefHint(g *Group) string {
 //     inside `when:` predicates, which are treated as graph edges and
 //     cycle-checked).
 //   - dag mode additionally rejects cycles.
+//   - a reference to an embedded flow's output ("lint/vet") counts as a
+//     reference to the embedding member (see RefOwner).
 //
 // It is invoked from normalizeAndValidate so a single LoadConfig surfaces
 // every error.
@@ -59,7 +94,49 @@ func (c *Config) ValidateReferences() error {
 	for name, flow := range c.Flows {
 		members := flow.Members()
 		if err := c.checkFlowRefs(name, &flow, members); err != nil {
-			return err
+			return c.at("flows", name, err)
+		}
+		if err := c.checkHookRefs(name, &flow, members); err != nil {
+			return c.at("flows", name, err)
+		}
+	}
+	return nil
+}
+
+// checkHookRefs validates references made by on-success / on-failure /
+// finally groups. Hooks run after the main plan, so they may read any member
+// of the flow or any other hook group; what they read may be absent (a
+// member that never ran renders empty), which is why hooks should prefer
+// `out "x"` and branch on its Status.
+func (c *Config) checkHookRefs(flowName string, f *Flow, members []string) error {
+	known := make(map[string]struct{}, len(members))
+	for _, m := range members {
+		known[m] = struct{}{}
+	}
+	hooks := f.Hooks()
+	for _, h := range hooks {
+		known[h.Group] = struct{}{}
+	}
+	for _, h := range hooks {
+		g := c.GroupByName(h.Group)
+		if g == nil {
+			return fmt.Errorf("flow %q %s: group %q is not defined", flowName, h.Phase, h.Group)
+		}
+		refs, err := ExtractRefs(g)
+		if err != nil {
+			return fmt.Errorf("flow %q %s: %w", flowName, h.Phase, err)
+		}
+		for _, ref := range refs {
+			if ref == h.Group {
+				return fmt.Errorf("flow %q %s: group %q references its own output%s",
+					flowName, h.Phase, h.Group, selfRefHint(g))
+			}
+			if _, ok := known[c.RefOwner(f, ref)]; !ok {
+				return fmt.Errorf(
+					"flow %q %s: group %q references {{ output.%s }}, but %q is not part of this flow%s",
+					flowName, h.Phase, h.Group, ref, ref, c.matrixHint(ref),
+				)
+			}
 		}
 	}
 	return nil
@@ -83,6 +160,9 @@ func (c *Config) checkStepRefs(flowName string, f *Flow, memberSet map[string]st
 	seen := make(map[string]struct{})
 	for stepIdx, step := range f.Steps {
 		for _, member := range step.Run {
+			if slices.Contains(step.Flows, member) {
+				continue // an embedded flow reads no outputs of this one
+			}
 			g := c.GroupByName(member)
 			if g == nil {
 				return fmt.Errorf("flow %q step %d: group %q is not defined", flowName, stepIdx+1, member)
@@ -92,19 +172,20 @@ func (c *Config) checkStepRefs(flowName string, f *Flow, memberSet map[string]st
 				return fmt.Errorf("flow %q step %d: %w", flowName, stepIdx+1, err)
 			}
 			for _, ref := range refs {
+				owner := c.RefOwner(f, ref)
 				if ref == member {
 					return fmt.Errorf(
 						"flow %q step %d: group %q references its own output%s",
 						flowName, stepIdx+1, member, selfRefHint(g),
 					)
 				}
-				if _, ok := memberSet[ref]; !ok {
+				if _, ok := memberSet[owner]; !ok {
 					return fmt.Errorf(
-						"flow %q step %d: group %q references {{ output.%s }}, but %q is not part of this flow",
-						flowName, stepIdx+1, member, ref, ref,
+						"flow %q step %d: group %q references {{ output.%s }}, but %q is not part of this flow%s",
+						flowName, stepIdx+1, member, ref, ref, c.matrixHint(ref),
 					)
 				}
-				if _, ok := seen[ref]; !ok {
+				if _, ok := seen[owner]; !ok {
 					return fmt.Errorf(
 						"flow %q step %d: group %q references {{ output.%s }}, but %q is not produced by an earlier step",
 						flowName, stepIdx+1, member, ref, ref,
@@ -112,7 +193,7 @@ func (c *Config) checkStepRefs(flowName string, f *Flow, memberSet map[string]st
 				}
 			}
 		}
-		if err := c.checkWhenRefs(flowName, stepIdx, step.When, memberSet, seen); err != nil {
+		if err := c.checkWhenRefs(flowName, stepIdx, f, memberSet, seen); err != nil {
 			return err
 		}
 		for _, member := range step.Run {
@@ -124,7 +205,8 @@ func (c *Config) checkStepRefs(flowName string, f *Flow, memberSet map[string]st
 
 // checkWhenRefs validates that a step's `when` predicate only references groups
 // produced by earlier steps (the same rule as param/command references).
-func (c *Config) checkWhenRefs(flowName string, stepIdx int, when string, memberSet, seen map[string]struct{}) error {
+func (c *Config) checkWhenRefs(flowName string, stepIdx int, f *Flow, memberSet, seen map[string]struct{}) error {
+	when := f.Steps[stepIdx].When
 	if when == "" {
 		return nil
 	}
@@ -133,11 +215,12 @@ func (c *Config) checkWhenRefs(flowName string, stepIdx int, when string, member
 		return fmt.Errorf("flow %q step %d: when: %w", flowName, stepIdx+1, err)
 	}
 	for _, ref := range refs {
-		if _, ok := memberSet[ref]; !ok {
-			return fmt.Errorf("flow %q step %d: when references {{ output.%s }}, but %q is not part of this flow",
-				flowName, stepIdx+1, ref, ref)
+		owner := c.RefOwner(f, ref)
+		if _, ok := memberSet[owner]; !ok {
+			return fmt.Errorf("flow %q step %d: when references {{ output.%s }}, but %q is not part of this flow%s",
+				flowName, stepIdx+1, ref, ref, c.matrixHint(ref))
 		}
-		if _, ok := seen[ref]; !ok {
+		if _, ok := seen[owner]; !ok {
 			return fmt.Errorf("flow %q step %d: when references {{ output.%s }}, but %q is not produced by an earlier step",
 				flowName, stepIdx+1, ref, ref)
 		}
@@ -146,41 +229,19 @@ func (c *Config) checkWhenRefs(flowName string, stepIdx int, when string, member
 }
 
 func (c *Config) checkDAGRefs(flowName string, f *Flow, members []string, memberSet map[string]struct{}) error {
-	adj := make(map[string][]string, len(members))
-	inDeg := make(map[string]int, len(members))
+	g := &dagGraph{
+		c: c, flowName: flowName, flow: f, memberSet: memberSet,
+		adj: make(map[string][]string, len(members)), inDeg: make(map[string]int, len(members)),
+	}
 	for _, m := range members {
-		inDeg[m] = 0
-	}
-	// addEdge records ref -> m in the data graph. fromWhen toggles the error
-	// phrasing so a `when:` reference reads "when references {{ output.X }}"
-	// (mirroring step mode), distinguishing it from a command/param reference.
-	addEdge := func(ref, m string, fromWhen bool) error {
-		if _, ok := memberSet[ref]; !ok {
-			if fromWhen {
-				return fmt.Errorf(
-					"flow %q: group %q: when references {{ output.%s }}, but %q is not part of this flow",
-					flowName, m, ref, ref,
-				)
-			}
-			return fmt.Errorf(
-				"flow %q: group %q references {{ output.%s }}, but %q is not part of this flow",
-				flowName, m, ref, ref,
-			)
-		}
-		if ref == m {
-			return fmt.Errorf("flow %q: group %q references its own output%s",
-				flowName, m, selfRefHint(c.GroupByName(m)))
-		}
-		adj[ref] = append(adj[ref], m)
-		inDeg[m]++
-		return nil
+		g.inDeg[m] = 0
 	}
 	// seen catches the same group listed twice in run: — both bare strings and
 	// {group, when} maps. With per-entry `when:` predicates, last-wins would
 	// silently swallow a predicate; reject it at load.
 	seen := make(map[string]struct{}, len(f.Run))
 	for i := range f.Run {
-		m := f.Run[i].Group
+		m := f.Run[i].Member()
 		if _, dup := seen[m]; dup {
 			return fmt.Errorf(
 				"flow %q: group %q is listed more than once in run: (duplicate dag entries are not allowed)",
@@ -188,34 +249,91 @@ func (c *Config) checkDAGRefs(flowName string, f *Flow, members []string, member
 			)
 		}
 		seen[m] = struct{}{}
+		if err := g.addEntry(&f.Run[i]); err != nil {
+			return err
+		}
+	}
+	return topoCheck(flowName, members, g.adj, g.inDeg)
+}
 
-		g := c.GroupByName(m)
-		if g == nil {
-			return fmt.Errorf("flow %q: group %q is not defined", flowName, m)
+// dagGraph is the data graph checkDAGRefs builds for a dag-mode flow.
+type dagGraph struct {
+	c         *Config
+	flowName  string
+	flow      *Flow
+	memberSet map[string]struct{}
+	adj       map[string][]string
+	inDeg     map[string]int
+}
+
+// addEntry records the edges into run entry r's member: from the groups its
+// commands and params reference, from its stream's producer, and from the
+// groups its when: references.
+func (g *dagGraph) addEntry(r *RunEntry) error {
+	m := r.Member()
+	if r.Flow == "" {
+		group := g.c.GroupByName(m)
+		if group == nil {
+			return fmt.Errorf("flow %q: group %q is not defined", g.flowName, m)
 		}
-		refs, err := ExtractRefs(g)
+		refs, err := ExtractRefs(group)
 		if err != nil {
-			return fmt.Errorf("flow %q: %w", flowName, err)
+			return fmt.Errorf("flow %q: %w", g.flowName, err)
 		}
 		for _, ref := range refs {
-			if err := addEdge(ref, m, false); err != nil {
+			if err := g.addEdge(ref, m, false); err != nil {
 				return err
 			}
 		}
-		// when: references create edges exactly like command/param refs.
-		if w := f.Run[i].When; w != "" {
-			whenRefs, werr := template.Refs(w)
-			if werr != nil {
-				return fmt.Errorf("flow %q: group %q: when: %w", flowName, m, werr)
-			}
-			for _, ref := range whenRefs {
-				if err := addEdge(ref, m, true); err != nil {
-					return err
-				}
-			}
+		// A stream is no data edge (both ends run at once), but its consumer
+		// cannot start before its producer, so it takes part in the cycle
+		// check all the same.
+		if src := group.StreamSource(); src != "" {
+			g.adj[src] = append(g.adj[src], m)
+			g.inDeg[m]++
+		}
+	}
+	// when: references create edges exactly like command/param refs.
+	if r.When == "" {
+		return nil
+	}
+	whenRefs, err := template.Refs(r.When)
+	if err != nil {
+		return fmt.Errorf("flow %q: group %q: when: %w", g.flowName, m, err)
+	}
+	for _, ref := range whenRefs {
+		if err := g.addEdge(ref, m, true); err != nil {
+			return err
 		}
 	}
-	return topoCheck(flowName, members, adj, inDeg)
+	return nil
+}
+
+// addEdge records ref -> m in the data graph. fromWhen toggles the error
+// phrasing so a `when:` reference reads "when references {{ output.X }}"
+// (mirroring step mode), distinguishing it from a command/param reference.
+func (g *dagGraph) addEdge(ref, m string, fromWhen bool) error {
+	c := g.c
+	owner := c.RefOwner(g.flow, ref)
+	if _, ok := g.memberSet[owner]; !ok {
+		if fromWhen {
+			return fmt.Errorf(
+				"flow %q: group %q: when references {{ output.%s }}, but %q is not part of this flow%s",
+				g.flowName, m, ref, ref, c.matrixHint(ref),
+			)
+		}
+		return fmt.Errorf(
+			"flow %q: group %q references {{ output.%s }}, but %q is not part of this flow%s",
+			g.flowName, m, ref, ref, c.matrixHint(ref),
+		)
+	}
+	if ref == m {
+		return fmt.Errorf("flow %q: group %q references its own output%s",
+			g.flowName, m, selfRefHint(c.GroupByName(m)))
+	}
+	g.adj[owner] = append(g.adj[owner], m)
+	g.inDeg[m]++
+	return nil
 }
 
 // topoCheck runs Kahn's algorithm; an unvisited node after the sweep means
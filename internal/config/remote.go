@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// CacheSettings configures the cache beyond its local directory.
+type CacheSettings struct {
+	Remote *RemoteCache `yaml:"remote,omitempty"`
+}
+
+// RemoteCache shares cache entries and stored outputs through an HTTP
+// server, in front of which the local cache-dir keeps working. URL is the
+// server's base; entries go to URL/ac/ and outputs to URL/cas/. Namespace
+// keeps the entries of projects sharing a server apart. ReadOnly fetches
+// hits but never uploads. Timeout bounds connecting and waiting for each
+// response (default 5s), not transfers; the first request that fails
+// switches the run to local-only. TokenEnv names an environment variable
+// whose value is sent as a bearer token.
+//
+// A scalar is shorthand for the URL.
+type RemoteCache struct {
+	URL       string `yaml:"url"`
+	Namespace string `yaml:"namespace,omitempty"`
+	ReadOnly  bool   `yaml:"read-only,omitempty"`
+	Timeout   string `yaml:"timeout,omitempty"`
+	TokenEnv  string `yaml:"token-env,omitempty"`
+}
+
+// UnmarshalYAML accepts a URL or a {url, namespace, read-only, timeout,
+// token-env} mapping.
+func (r *RemoteCache) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		r.URL = node.Value
+		return nil
+	}
+	type plain RemoteCache
+	if err := node.Decode((*plain)(r)); err != nil {
+		return fmt.Errorf("remote: %w", err)
+	}
+	return nil
+}
+
+// validateRemoteCache checks settings.cache.remote.
+func (c *Config) validateRemoteCache() error {
+	r := c.Settings.Cache.Remote
+	if r == nil {
+		return nil
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("settings: cache.remote: url %q must be an http or https URL", r.URL)
+	}
+	if err := checkDuration("cache.remote.timeout", r.Timeout); err != nil {
+		return fmt.Errorf("settings: %w", err)
+	}
+	return nil
+}
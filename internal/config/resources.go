@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// validateResources checks the pools declared in settings.resources.
+func (c *Config) validateResources() error {
+	for name, capacity := range c.Settings.Resources {
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("settings.resources: invalid name %q", name)
+		}
+		if capacity < 1 {
+			return fmt.Errorf("settings.resources: %q must have a capacity of at least 1, got %d", name, capacity)
+		}
+	}
+	return nil
+}
+
+// validateUses checks a group's uses: against the declared pools. A group
+// asking for more than a pool holds could never start, so that is an error
+// too. Groups adopted through imports: are checked against the importing
+// config's pools.
+func (c *Config) validateUses(g *Group) error {
+	names := make([]string, 0, len(g.Uses))
+	for name := range g.Uses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		n := g.Uses[name]
+		capacity, ok := c.Settings.Resources[name]
+		switch {
+		case !ok:
+			return fmt.Errorf("group %q: uses: resource %q is not declared in settings.resources", g.Name, name)
+		case n < 1:
+			return fmt.Errorf("group %q: uses: %q must be at least 1, got %d", g.Name, name, n)
+		case n > capacity:
+			return fmt.Errorf("group %q: uses: %q asks for %d but the pool holds %d", g.Name, name, n, capacity)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfig_Resources(t *testing.T) {
+	t.Parallel()
+	cfg, err := NewConfig([]byte(`
+version: 2
+settings:
+  resources: {db: 1, cpu: 8}
+groups:
+  - {name: it, command: go, uses: {db: 1, cpu: 4}}
+flows:
+  f:
+    steps:
+      - run: [it]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"db": 1, "cpu": 4}, cfg.Groups[0].Uses)
+}
+
+func TestValidateResources_Rejects(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name      string
+		resources string
+		uses      string
+		want      string
+	}{
+		{"zero capacity", "{db: 0}", "{}", `"db" must have a capacity of at least 1`},
+		{"undeclared", "{db: 1}", "{cpu: 1}", `resource "cpu" is not declared in settings.resources`},
+		{"zero weight", "{db: 1}", "{db: 0}", `uses: "db" must be at least 1`},
+		{"over capacity", "{cpu: 4}", "{cpu: 8}", `uses: "cpu" asks for 8 but the pool holds 4`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			body := "version: 2\nsettings:\n  resources: " + tc.resources +
+				"\ngroups:\n  - {name: g, command: echo, uses: " + tc.uses + "}\nflows:\n  f:\n    steps:\n      - run: [g]\n"
+			_, err := NewConfig([]byte(body))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Retry scopes: what a group's retry runs again.
+const (
+	RetryScopeGroup   = "group"
+	RetryScopeCommand = "command"
+)
+
+// Backoff spaces out retry attempts exponentially: the wait before retry N
+// is Delay * Factor^(N-1), capped at Max. Jitter randomizes each wait
+// between half and all of it, so retries started together spread out.
+// Without a Backoff the wait grows linearly with the attempt number.
+type Backoff struct {
+	Delay  string  `yaml:"delay,omitempty"`
+	Max    string  `yaml:"max,omitempty"`
+	Factor float64 `yaml:"factor,omitempty"`
+	Jitter bool    `yaml:"jitter,omitempty"`
+}
+
+// RetryOn limits retries to the failures worth retrying: an attempt is
+// retried when it matches any of the set conditions — one of ExitCodes, a
+// stderr matching the Stderr regular expression, or a Timeout of the
+// attempt. Without a RetryOn every failure is retried.
+//
+// The scalar "timeout" is shorthand for {timeout: true}.
+type RetryOn struct {
+	ExitCodes []int  `yaml:"exit-codes,omitempty"`
+	Stderr    string `yaml:"stderr,omitempty"`
+	Timeout   bool   `yaml:"timeout,omitempty"`
+}
+
+// UnmarshalYAML accepts the scalar "timeout" or an {exit-codes, stderr,
+// timeout} mapping.
+func (r *RetryOn) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if node.Value != "timeout" {
+			return fmt.Errorf("retry-on: unknown shorthand %q (use 'timeout' or a mapping)", node.Value)
+		}
+		r.Timeout = true
+		return nil
+	}
+	type plain RetryOn
+	if err := node.Decode((*plain)(r)); err != nil {
+		return fmt.Errorf("retry-on: %w", err)
+	}
+	return nil
+}
+
+// checkRetry validates the retry settings shared by flows, steps, groups,
+// and run entries.
+func checkRetry(timeout string, retries int, b *Backoff, on *RetryOn) error {
+	if err := checkTimeout(timeout); err != nil {
+		return err
+	}
+	if retries < 0 {
+		return fmt.Errorf("retries must be >= 0")
+	}
+	if b != nil {
+		if err := checkDuration("backoff.delay", b.Delay); err != nil {
+			return err
+		}
+		if err := checkDuration("backoff.max", b.Max); err != nil {
+			return err
+		}
+		if b.Factor != 0 && b.Factor < 1 {
+			return fmt.Errorf("backoff.factor must be >= 1")
+		}
+	}
+	if on != nil {
+		if len(on.ExitCodes) == 0 && on.Stderr == "" && !on.Timeout {
+			return fmt.Errorf("retry-on: set exit-codes, stderr, or timeout")
+		}
+		for _, c := range on.ExitCodes {
+			if c <= 0 {
+				return fmt.Errorf("retry-on: exit code %d is not a failure", c)
+			}
+		}
+		if _, err := regexp.Compile(on.Stderr); err != nil {
+			return fmt.Errorf("retry-on: stderr: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateRetryScope checks a group's retry-scope:; empty means group.
+func validateRetryScope(g *Group) error {
+	switch g.RetryScope {
+	case "", RetryScopeGroup, RetryScopeCommand:
+	default:
+		return fmt.Errorf("group %q: unknown retry-scope %q (use 'group' or 'command')", g.Name, g.RetryScope)
+	}
+	return nil
+}
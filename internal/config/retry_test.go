@@ -0,0 +1,81 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfig_Retry(t *testing.T) {
+	t.Parallel()
+	cfg, err := NewConfig([]byte(`
+version: 2
+groups:
+  - name: fetch
+    command: curl
+    timeout: 30s
+    retries: 4
+    backoff: {delay: 1s, max: 20s, factor: 3, jitter: true}
+    retry-on: {exit-codes: [6, 7, 28], stderr: 'connection (reset|refused)'}
+  - {name: test, command: go, retry-on: timeout, retry-scope: command}
+flows:
+  f:
+    mode: dag
+    retries: 1
+    retry-on: {timeout: true}
+    run:
+      - fetch
+      - {group: test, timeout: 5m, retries: 2, backoff: {delay: 2s}}
+`))
+	require.NoError(t, err)
+	fetch := cfg.GroupByName("fetch")
+	assert.Equal(t, "30s", fetch.Timeout)
+	assert.Equal(t, 4, fetch.Retries)
+	assert.Equal(t, &Backoff{Delay: "1s", Max: "20s", Factor: 3, Jitter: true}, fetch.Backoff)
+	assert.Equal(t, &RetryOn{ExitCodes: []int{6, 7, 28}, Stderr: "connection (reset|refused)"}, fetch.RetryOn)
+	assert.Equal(t, &RetryOn{Timeout: true}, cfg.GroupByName("test").RetryOn)
+	assert.Equal(t, RetryScopeCommand, cfg.GroupByName("test").RetryScope)
+	assert.Empty(t, fetch.RetryScope, "empty means group")
+
+	entry := cfg.Flows["f"].Run[1]
+	assert.Equal(t, "5m", entry.Timeout)
+	assert.Equal(t, 2, entry.Retries)
+	assert.Equal(t, &Backoff{Delay: "2s"}, entry.Backoff)
+}
+
+func TestCheckRetry_Rejects(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name  string
+		group string
+		flow  string
+		want  string
+	}{
+		{"group retries", "{name: g, command: x, retries: -1}", "{steps: [{run: [g]}]}", `group "g": retries must be >= 0`},
+		{"group timeout", "{name: g, command: x, timeout: soon}", "{steps: [{run: [g]}]}", `invalid timeout "soon"`},
+		{"factor", "{name: g, command: x, backoff: {factor: 0.5}}", "{steps: [{run: [g]}]}", "backoff.factor must be >= 1"},
+		{"max", "{name: g, command: x, backoff: {max: -1s}}", "{steps: [{run: [g]}]}", `backoff.max "-1s" must not be negative`},
+		{"empty retry-on", "{name: g, command: x, retry-on: {}}", "{steps: [{run: [g]}]}", "retry-on: set exit-codes, stderr, or timeout"},
+		{"retry-on shorthand", "{name: g, command: x, retry-on: always}", "{steps: [{run: [g]}]}", `retry-on: unknown shorthand "always"`},
+		{"exit code 0", "{name: g, command: x, retry-on: {exit-codes: [0]}}", "{steps: [{run: [g]}]}", "exit code 0 is not a failure"},
+		{"stderr regex", "{name: g, command: x, retry-on: {stderr: '('}}", "{steps: [{run: [g]}]}", "retry-on: stderr:"},
+		{"retry-scope", "{name: g, command: x, retry-scope: step}", "{steps: [{run: [g]}]}", `unknown retry-scope "step"`},
+		{"service", "{name: g, command: x, service: true, retries: 2}", "{steps: [{run: [g]}]}", "a service cannot declare timeout:, retries:"},
+		{"step", "{name: g, command: x}", "{steps: [{run: [g], retry-on: {}}]}", `flow "f" step 1: retry-on: set exit-codes`},
+		{"run entry", "{name: g, command: x}", "{mode: dag, run: [{group: g, timeout: x}]}", `flow "f": g: invalid timeout "x"`},
+		{
+			"embedded flow", "{name: g, command: x}", "{mode: dag, run: [{flow: sub, retries: 2}]}",
+			`embedded flow "sub" runs under its own timeout and retries`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			body := "version: 2\ngroups:\n  - " + tc.group + "\nflows:\n  sub: {steps: [{run: [g]}]}\n  f: " + tc.flow + "\n"
+			_, err := NewConfig([]byte(body))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
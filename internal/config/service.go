@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+)
+
+// Ready tells when a service group is up. At most one check may be set:
+//   - Command: a predicate, run like skip-if until it exits 0
+//   - TCP: a host:port that must accept a connection
+//   - Log: a regular expression matched against each line of stdout
+//
+// With none set the service counts as ready once it has started. Timeout
+// bounds the wait; an empty value means the engine's default.
+type Ready struct {
+	Command string `yaml:"command,omitempty"`
+	TCP     string `yaml:"tcp,omitempty"`
+	Log     string `yaml:"log,omitempty"`
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// validateService checks a service group and its ready: block.
+func validateService(g *Group) error {
+	if !g.Service {
+		if g.Ready != nil {
+			return fmt.Errorf("group %q: ready: requires service: true", g.Name)
+		}
+		return nil
+	}
+	if err := checkServiceFields(g); err != nil {
+		return fmt.Errorf("group %q: %w", g.Name, err)
+	}
+	if g.Ready == nil {
+		return nil
+	}
+	if err := validateReady(g.Ready); err != nil {
+		return fmt.Errorf("group %q: %w", g.Name, err)
+	}
+	return nil
+}
+
+// checkServiceFields rejects what a service cannot use. It runs one
+// long-lived command, reads no input and produces nothing worth caching, so
+// commands:, stdin: and cache: are rejected. Its output is bounded by the
+// engine, so capture: is rejected too, and it is never retried.
+func checkServiceFields(g *Group) error {
+	if len(g.Commands) > 0 {
+		return fmt.Errorf("a service runs a single command; use command:, not commands:")
+	}
+	for _, f := range []struct {
+		key string
+		set bool
+	}{
+		{"cache:", g.Cache != nil},
+		{"stdin:", g.Stdin != nil},
+		{"outputs:", g.Outputs != nil},
+		{"capture:", g.Capture != nil},
+	} {
+		if f.set {
+			return fmt.Errorf("a service cannot declare %s", f.key)
+		}
+	}
+	if g.Timeout != "" || g.Retries != 0 || g.Backoff != nil || g.RetryOn != nil || g.RetryScope != "" {
+		return fmt.Errorf("a service cannot declare timeout:, retries:, backoff:, retry-on: or retry-scope:")
+	}
+	return nil
+}
+
+// validateReady checks that at most one probe is set and that it parses.
+func validateReady(r *Ready) error {
+	set := 0
+	for _, v := range []string{r.Command, r.TCP, r.Log} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("ready: set only one of command, tcp, log")
+	}
+	if err := checkTCPProbe(r.TCP); err != nil {
+		return err
+	}
+	if err := checkLogProbe(r.Log); err != nil {
+		return err
+	}
+	return checkDuration("ready.timeout", r.Timeout)
+}
+
+// checkTCPProbe checks a ready.tcp address; empty means unset.
+func checkTCPProbe(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("ready.tcp: %w", err)
+	}
+	return nil
+}
+
+// checkLogProbe checks a ready.log pattern; empty means unset.
+func checkLogProbe(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("ready.log: %w", err)
+	}
+	return nil
+}
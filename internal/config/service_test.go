@@ -0,0 +1,71 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfig_Service(t *testing.T) {
+	t.Parallel()
+	cfg, err := NewConfig([]byte(`
+version: 2
+groups:
+  - name: db
+    command: postgres
+    service: true
+    ready: {tcp: "localhost:5432", timeout: 1m}
+  - {name: test, command: go}
+flows:
+  f:
+    steps:
+      - run: [db]
+      - run: [test]
+`))
+	require.NoError(t, err)
+	db := cfg.GroupByName("db")
+	assert.True(t, db.Service)
+	assert.Equal(t, &Ready{TCP: "localhost:5432", Timeout: "1m"}, db.Ready)
+}
+
+func TestValidateService_Rejects(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name  string
+		group string
+		want  string
+	}{
+		{"ready without service", "{name: g, command: echo, ready: {log: up}}", "ready: requires service: true"},
+		{"commands", "{name: g, service: true, commands: [{command: a}, {command: b}]}", "a service runs a single command"},
+		{"cache", "{name: g, command: echo, service: true, cache: {reads: [x]}}", "a service cannot declare cache:"},
+		{"capture", "{name: g, command: echo, service: true, capture: 1KiB}", "a service cannot declare capture:"},
+		{"two checks", "{name: g, command: echo, service: true, ready: {log: up, tcp: 'h:1'}}", "set only one of command, tcp, log"},
+		{"bad address", "{name: g, command: echo, service: true, ready: {tcp: localhost}}", "ready.tcp"},
+		{"bad pattern", "{name: g, command: echo, service: true, ready: {log: '('}}", "ready.log"},
+		{"bad timeout", "{name: g, command: echo, service: true, ready: {timeout: soon}}", `invalid ready.timeout "soon"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			body := "version: 2\ngroups:\n  - " + tc.group + "\nflows:\n  f:\n    steps:\n      - run: [g]\n"
+			_, err := NewConfig([]byte(body))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+
+	_, err := NewConfig([]byte(`
+version: 2
+groups:
+  - {name: db, command: postgres, service: true}
+  - {name: a, command: echo}
+flows:
+  f:
+    steps:
+      - run: [a]
+    finally: [db]
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `group "db" is a service and cannot be a hook`)
+}
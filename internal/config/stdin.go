@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Stdin feeds a group's first command. Exactly one source is set:
+//   - Template: text rendered like params; a bare string is shorthand for it
+//   - File: a path (templated) relative to the group's working directory
+//   - From: another group's stdout
+//
+// From reads the other group's stored output, which makes it a dependency
+// like {{ output "x" }}. With Stream set it instead pipes that group's stdout
+// live while both run; the producer must then run alongside the consumer
+// (in the same step, in step mode).
+type Stdin struct {
+	Template string `yaml:"template,omitempty"`
+	File     string `yaml:"file,omitempty"`
+	From     string `yaml:"from,omitempty"`
+	Stream   bool   `yaml:"stream,omitempty"`
+}
+
+// UnmarshalYAML accepts a scalar (a template) or a {template|file|from,
+// stream} mapping.
+func (s *Stdin) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		s.Template = node.Value
+		return nil
+	}
+	type plain Stdin
+	if err := node.Decode((*plain)(s)); err != nil {
+		return fmt.Errorf("stdin: %w", err)
+	}
+	return nil
+}
+
+// StreamSource returns the qualified name of the group whose stdout is
+// streamed into g, or "" when g does not stream its stdin.
+func (g *Group) StreamSource() string {
+	if g.Stdin == nil || !g.Stdin.Stream {
+		return ""
+	}
+	return g.Qualify(g.Stdin.From)
+}
+
+// validateStdin checks a group's stdin: block on its own; validateStreams
+// checks streams against the flows that run them.
+func validateStdin(g *Group) error {
+	s := g.Stdin
+	if s == nil {
+		return nil
+	}
+	set := 0
+	for _, v := range []string{s.Template, s.File, s.From} {
+		if v != "" {
+			set++
+		}
+	}
+	switch {
+	case set != 1:
+		return fmt.Errorf("group %q: stdin: set exactly one of template, file, from", g.Name)
+	case s.Stream && s.From == "":
+		return fmt.Errorf("group %q: stdin: stream requires from", g.Name)
+	case s.From != "" && g.Qualify(s.From) == g.Name:
+		return fmt.Errorf("group %q: stdin: a group cannot read its own output", g.Name)
+	case s.Stream && g.Cache != nil:
+		return fmt.Errorf("group %q: stdin: a group reading a stream cannot declare cache:", g.Name)
+	}
+	return nil
+}
+
+// validateStreams checks the streams among a flow's groups: each producer
+// must be a group of the flow that is not a service, and in step mode it
+// must run in the consumer's step. A group reading a stream cannot be a
+// hook, since hooks run one at a time.
+func validateStreams(name string, f *Flow, groups map[string]*Group) error {
+	for _, h := range f.Hooks() {
+		if groups[h.Group].StreamSource() != "" {
+			return fmt.Errorf("flow %q %s: group %q reads a stream and cannot be a hook", name, h.Phase, h.Group)
+		}
+	}
+	c := streamCheck{name: name, flow: f, groups: groups, members: f.Members(), step: make(map[string]int)}
+	for i, s := range f.Steps {
+		for _, m := range s.Run {
+			c.step[m] = i
+		}
+	}
+	subs := f.subFlowSet()
+	for _, m := range c.members {
+		if subs[m] {
+			continue
+		}
+		if src := groups[m].StreamSource(); src != "" {
+			if err := c.source(m, src); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// streamCheck holds what validateStreams knows of the flow: its members and
+// the step each one runs in.
+type streamCheck struct {
+	name    string
+	flow    *Flow
+	groups  map[string]*Group
+	members []string
+	step    map[string]int
+}
+
+// source checks that group m may stream stdin from src.
+func (c *streamCheck) source(m, src string) error {
+	producer, ok := c.groups[src]
+	if !ok || !slices.Contains(c.members, src) {
+		return fmt.Errorf("flow %q: group %q streams stdin from %q, which is not part of this flow", c.name, m, src)
+	}
+	if producer.Service {
+		return fmt.Errorf("flow %q: group %q streams stdin from %q, which is a service", c.name, m, src)
+	}
+	for next, hops := src, 0; next != "" && hops < len(c.members); hops++ {
+		if next == m {
+			return fmt.Errorf("flow %q: group %q streams stdin from %q, which waits for it in turn", c.name, m, src)
+		}
+		if g, ok := c.groups[next]; ok {
+			next = g.StreamSource()
+		} else {
+			next = ""
+		}
+	}
+	if c.flow.Mode == ModeStep && c.step[src] != c.step[m] {
+		return fmt.Errorf("flow %q step %d: group %q streams stdin from %q, which must run in the same step",
+			c.name, c.step[m]+1, m, src)
+	}
+	return nil
+}
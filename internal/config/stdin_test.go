@@ -0,0 +1,103 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfig_Stdin(t *testing.T) {
+	t.Parallel()
+	cfg, err := NewConfig([]byte(`
+version: 2
+groups:
+  - {name: gen, command: seq}
+  - {name: hello, command: cat, stdin: 'hi {{ env "USER" }}'}
+  - {name: fixture, command: cat, stdin: {file: testdata/in.json}}
+  - {name: count, command: wc, stdin: {from: gen}}
+  - {name: tail, command: tail, stdin: {from: gen, stream: true}}
+flows:
+  f:
+    steps:
+      - run: [gen, hello, fixture, tail]
+      - run: [count]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &Stdin{Template: `hi {{ env "USER" }}`}, cfg.GroupByName("hello").Stdin)
+	assert.Equal(t, &Stdin{File: "testdata/in.json"}, cfg.GroupByName("fixture").Stdin)
+	assert.Equal(t, "", cfg.GroupByName("count").StreamSource())
+	assert.Equal(t, "gen", cfg.GroupByName("tail").StreamSource())
+
+	refs, err := ExtractRefs(cfg.GroupByName("count"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gen"}, refs, "a stored from: is a data dependency")
+	refs, err = ExtractRefs(cfg.GroupByName("tail"))
+	require.NoError(t, err)
+	assert.Empty(t, refs, "a stream is not")
+}
+
+func TestValidateStdin_Rejects(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name  string
+		group string
+		want  string
+	}{
+		{"two sources", "{name: g, command: cat, stdin: {template: a, file: b}}", "set exactly one of template, file, from"},
+		{"no source", "{name: g, command: cat, stdin: {stream: true}}", "set exactly one of template, file, from"},
+		{"stream without from", "{name: g, command: cat, stdin: {file: a, stream: true}}", "stream requires from"},
+		{"own output", "{name: g, command: cat, stdin: {from: g}}", "cannot read its own output"},
+		{"stream with cache", "{name: g, command: cat, stdin: {from: x, stream: true}, cache: {reads: [a]}}", "cannot declare cache:"},
+		{"service", "{name: g, command: nc, service: true, stdin: hi}", "a service cannot declare stdin:"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			body := "version: 2\ngroups:\n  - " + tc.group + "\nflows:\n  f:\n    steps:\n      - run: [g]\n"
+			_, err := NewConfig([]byte(body))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
+
+func TestValidateStreams_Rejects(t *testing.T) {
+	t.Parallel()
+	const groups = `
+version: 2
+groups:
+  - {name: gen, command: seq}
+  - {name: db, command: postgres, service: true}
+  - {name: tail, command: tail, stdin: {from: gen, stream: true}}
+  - {name: logs, command: grep, stdin: {from: db, stream: true}}
+  - {name: a, command: cat, stdin: {from: b, stream: true}}
+  - {name: b, command: cat, stdin: {from: a, stream: true}}
+  - {name: c, command: cat, params: ['{{ output "d" }}']}
+  - {name: d, command: cat, stdin: {from: c, stream: true}}
+flows:
+`
+	cases := []struct {
+		name string
+		flow string
+		want string
+	}{
+		{"producer missing", "{steps: [{run: [tail]}]}", `streams stdin from "gen", which is not part of this flow`},
+		{"other step", "{steps: [{run: [gen]}, {run: [tail]}]}", "which must run in the same step"},
+		{"service", "{steps: [{run: [db, logs]}]}", `streams stdin from "db", which is a service`},
+		{"stream cycle", "{steps: [{run: [a, b]}]}", "which waits for it in turn"},
+		{"dag cycle", "{mode: dag, run: [c, d]}", "cycle detected"},
+		{"hook", "{steps: [{run: [gen]}], finally: [tail]}", `group "tail" reads a stream and cannot be a hook`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := NewConfig([]byte(groups + "  f: " + tc.flow + "\n"))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+
+	_, err := NewConfig([]byte(groups + "  f: {mode: dag, run: [tail, gen]}\n"))
+	assert.NoError(t, err, "dag producers and consumers run side by side")
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+func TestEngine_AllowFailure_StepModeContinues(t *testing.T) {
+	t.Parallel()
+	cfg := stepFlowCfg(t, []config.Group{
+		{Name: "lint", Command: "golangci-lint", AllowFailure: true},
+		{Name: "report", Command: "echo", Params: []string{`{{ (out "lint").ExitCode }}`}},
+	}, [][]string{{"lint"}, {"report"}})
+	r := &fakeRunner{errs: map[string]error{"lint": errors.New("exit status 1")}}
+	var buf bytes.Buffer
+	e := New(cfg, WithRunner(r), WithEmitter(NewJSONEmitter(&buf)))
+
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"lint:", "report:1"}, r.calls)
+
+	got, ok := e.Outputs().Get("lint")
+	require.True(t, ok)
+	assert.Equal(t, result.StatusFailed, got.Status)
+	assert.Equal(t, 1, got.ExitCode)
+	assert.Equal(t, []string{"lint"}, e.SoftFailures())
+
+	evs := decodeEvents(t, buf.Bytes())
+	assert.Equal(t, StatusSoftFailed, statusOf(evs, "lint"))
+	last := evs[len(evs)-1]
+	assert.Equal(t, EventFlowEnd, last.Event)
+	assert.Equal(t, StatusSoftFailed, last.Status)
+	assert.Contains(t, last.Reason, "lint")
+}
+
+func TestEngine_AllowFailure_DAGRunEntryFeedsWhen(t *testing.T) {
+	t.Parallel()
+	cfg := &config.Config{
+		Version: config.SchemaVersion,
+		Groups: []config.Group{
+			{Name: "lint", Command: "golangci-lint"},
+			{Name: "fix", Command: "gofmt"},
+			{Name: "ship", Command: "echo"},
+		},
+		Flows: map[string]config.Flow{"f": {Mode: config.ModeDAG, Run: []config.RunEntry{
+			{Group: "lint", AllowFailure: true},
+			{Group: "fix", When: `{{ ne (out "lint").ExitCode 0 }}`},
+			{Group: "ship", When: `{{ eq (out "lint").Status "ok" }}`},
+		}}},
+	}
+	r := &fakeRunner{errs: map[string]error{"lint": errors.New("exit status 1")}}
+	e := New(cfg, WithRunner(r))
+
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.ElementsMatch(t, []string{"lint:", "fix:"}, r.calls)
+	got, _ := e.Outputs().Get("ship")
+	assert.Equal(t, result.StatusSkipped, got.Status)
+}
+
+func TestEngine_AllowFailure_NotSetStillAborts(t *testing.T) {
+	t.Parallel()
+	cfg := stepFlowCfg(t, []config.Group{
+		{Name: "lint", Command: "golangci-lint"},
+		{Name: "report", Command: "echo"},
+	}, [][]string{{"lint"}, {"report"}})
+	r := &fakeRunner{errs: map[string]error{"lint": errors.New("exit status 1")}}
+	e := New(cfg, WithRunner(r))
+
+	require.Error(t, e.RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"lint:"}, r.calls)
+	assert.Empty(t, e.SoftFailures())
+}
+
+func TestEngine_AllowFailure_MissingExitStatusIsNegative(t *testing.T) {
+	t.Parallel()
+	cfg := stepFlowCfg(t, []config.Group{
+		{Name: "a", Command: "x", AllowFailure: true},
+	}, [][]string{{"a"}})
+	withStepEnvelope(cfg, "10ms", 0)
+	e := New(cfg, WithRunner(&blockingRunner{}))
+
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	got, _ := e.Outputs().Get("a")
+	assert.Equal(t, result.StatusFailed, got.Status)
+	assert.Equal(t, -1, got.ExitCode)
+}
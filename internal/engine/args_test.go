@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+)
+
+func argsFlowCfg(t *testing.T, readPath string) *config.Config {
+	t.Helper()
+	cfg, err := config.NewConfig([]byte(`
+version: 2
+groups:
+  - name: tag
+    command: git
+    params: [tag, 'v{{ arg "version" }}', '{{ arg "channel" }}']
+  - name: build
+    command: go
+    params: [build, '-ldflags=-X main.version={{ arg "version" }}']
+    cache: {reads: ['` + readPath + `']}
+flows:
+  release:
+    args:
+      - {name: version, required: true}
+      - {name: channel, type: enum, values: [stable, beta], default: stable}
+    steps:
+      - run: [build]
+      - run: [tag]
+`))
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestEngine_ArgsRenderIntoTemplates(t *testing.T) {
+	t.Parallel()
+	cfg := argsFlowCfg(t, filepath.Join(t.TempDir(), "missing"))
+	r := &fakeRunner{}
+	e := New(cfg, WithRunner(r), WithNoCache(true), WithArgs(map[string]string{"version": "1.4.0"}))
+	require.NoError(t, e.RunFlow(context.Background(), "release"))
+	assert.Contains(t, r.calls, "tag:tag,v1.4.0,stable")
+}
+
+func TestEngine_ArgsCheckedBeforeRunning(t *testing.T) {
+	t.Parallel()
+	cfg := argsFlowCfg(t, filepath.Join(t.TempDir(), "missing"))
+	r := &fakeRunner{}
+	err := New(cfg, WithRunner(r), WithArgs(map[string]string{"version": "1", "channel": "nightly"})).
+		RunFlow(context.Background(), "release")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `flow "release": arg "channel": "nightly" is not one of stable, beta`)
+	assert.Empty(t, r.calls)
+
+	err = New(cfg, WithRunner(r)).RunFlow(context.Background(), "release")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `missing required arg "version"`)
+}
+
+func TestEngine_ArgsSeparateCacheEntries(t *testing.T) {
+	dir := t.TempDir()
+	readPath := filepath.Join(dir, "main.go")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	cfg := argsFlowCfg(t, readPath)
+	store := cache.NewFileStore(filepath.Join(dir, "cache"))
+	built := func(version, channel string) bool {
+		r := &fakeRunner{}
+		e := New(cfg, WithRunner(r), WithCache(store), WithArgs(map[string]string{"version": version, "channel": channel}))
+		require.NoError(t, e.RunFlow(context.Background(), "release"))
+		return slices.Contains(r.calls, "build:build,-ldflags=-X main.version="+version)
+	}
+
+	assert.True(t, built("1.4.0", "stable"))
+	assert.False(t, built("1.4.0", "stable"), "same args hit the cache")
+	assert.False(t, built("1.4.0", "beta"), "an arg build never reads does not bust it")
+	assert.True(t, built("1.5.0", "beta"), "a different version misses it")
+}
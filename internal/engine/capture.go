@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+// captureLimit bounds what the runner keeps of a command's output (see
+// config.Capture): max bytes of each stream, the first ones when head is
+// set and the last ones otherwise. log, when set, receives the complete
+// combined output.
+type captureLimit struct {
+	max  int64
+	head bool
+	log  io.Writer
+}
+
+type captureKey struct{}
+
+// withCapture asks the runner to keep at most what c allows of the
+// command's output. ShellRunner honors it; a Runner that ignores it keeps
+// everything.
+func withCapture(ctx context.Context, c captureLimit) context.Context {
+	return context.WithValue(ctx, captureKey{}, c)
+}
+
+// captureFrom returns the limit set by withCapture.
+func captureFrom(ctx context.Context) (captureLimit, bool) {
+	c, ok := ctx.Value(captureKey{}).(captureLimit)
+	return c, ok
+}
+
+// buffer returns an empty capture buffer holding what c keeps.
+func (c captureLimit) buffer() *safeBuf {
+	return &safeBuf{limit: c.max, limited: true, head: c.head}
+}
+
+// keep cuts s down to what c keeps and reports whether it had to.
+func (c captureLimit) keep(s string) (string, bool) {
+	if int64(len(s)) <= c.max {
+		return s, false
+	}
+	if c.head {
+		return s[:c.max], true
+	}
+	return s[int64(len(s))-c.max:], true
+}
+
+// openCapture applies a group's capture: limit to the commands run under
+// the returned context, spilling their combined output to the group's log
+// file. finish closes the log and trims the aggregated result, which may
+// have outgrown the limit across several commands; it keeps the log only
+// when something was cut, recording its path in the result.
+func (e *Engine) openCapture(ctx context.Context, group *config.Group) (context.Context, func(*result.RunResult)) {
+	limit := captureLimit{max: group.Capture.Limit(), head: group.Capture.Keep == config.CaptureHead}
+	path, err := filepath.Abs(cache.NewFileStore(e.cfg.CacheDir()).LogPath(group.Name))
+	var log *os.File
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+			log, err = os.Create(path) //nolint:gosec // path derives from the cache dir and group name
+		}
+	}
+	if err != nil {
+		e.log.Warn("cannot open capture log; truncated output is lost", "group", group.Name, "err", err.Error())
+	} else {
+		limit.log = log
+	}
+	finish := func(out *result.RunResult) {
+		for _, s := range []*string{&out.Stdout, &out.Stderr, &out.Output} {
+			var cut bool
+			if *s, cut = limit.keep(*s); cut {
+				out.Truncated = true
+			}
+		}
+		if log == nil {
+			return
+		}
+		_ = log.Close()
+		if out.Truncated {
+			out.Log = path
+			return
+		}
+		_ = os.Remove(path)
+	}
+	return withCapture(ctx, limit), finish
+}
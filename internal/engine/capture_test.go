@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/config"
+)
+
+func TestSafeBuf_Limits(t *testing.T) {
+	t.Parallel()
+	write := func(b *safeBuf) {
+		for _, s := range []string{"abc", "def", "ghi"} {
+			n, err := b.Write([]byte(s))
+			require.NoError(t, err)
+			require.Equal(t, len(s), n, "a limited buffer never fails the command's writes")
+		}
+	}
+
+	tail := captureLimit{max: 4}.buffer()
+	write(tail)
+	assert.Equal(t, "fghi", tail.String())
+	assert.True(t, tail.truncated())
+
+	head := captureLimit{max: 4, head: true}.buffer()
+	write(head)
+	assert.Equal(t, "abcd", head.String())
+	assert.True(t, head.truncated())
+
+	none := captureLimit{}.buffer()
+	write(none)
+	assert.Empty(t, none.String())
+
+	roomy := captureLimit{max: 9}.buffer()
+	write(roomy)
+	assert.Equal(t, "abcdefghi", roomy.String())
+	assert.False(t, roomy.truncated())
+}
+
+func TestEngine_Capture(t *testing.T) {
+	skipOnWindows(t)
+	t.Parallel()
+	groups := []config.Group{
+		{Name: "tail", Command: "seq", Params: []string{"1", "10000"}, Capture: &config.Capture{Max: "11", Keep: config.CaptureTail}},
+		{Name: "head", Command: "seq", Params: []string{"1", "10000"}, Capture: &config.Capture{Max: "4", Keep: config.CaptureHead}},
+		{Name: "none", Command: "seq", Params: []string{"1", "10000"}, Capture: &config.Capture{Keep: config.CaptureNone}},
+		{Name: "small", Command: "seq", Params: []string{"1", "3"}, Capture: &config.Capture{Max: "1KB", Keep: config.CaptureTail}},
+		{Name: "multi", Capture: &config.Capture{Max: "6", Keep: config.CaptureTail}, Commands: []config.CommandSpec{
+			{Command: "echo", Params: []string{"first"}},
+			{Command: "echo", Params: []string{"second"}},
+		}},
+		{Name: "report", Command: "echo", Params: []string{
+			`{{ (out "tail").Truncated }} {{ output "tail" }} {{ (out "small").Truncated }}`,
+		}},
+	}
+	cfg := stepFlowCfg(t, groups, [][]string{{"tail", "head", "none", "small", "multi"}, {"report"}})
+	cfg.Settings.CacheDir = t.TempDir()
+	var buf bytes.Buffer
+	e := New(cfg, WithRunner(&ShellRunner{Stdout: io.Discard, Stderr: io.Discard}), WithEmitter(NewJSONEmitter(&buf)))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+
+	get := func(name string) string {
+		out, ok := e.Outputs().Get(name)
+		require.True(t, ok, name)
+		return out.Stdout
+	}
+	assert.Equal(t, "9999\n10000\n", get("tail"))
+	assert.Equal(t, "1\n2\n", get("head"))
+	assert.Empty(t, get("none"))
+	assert.Equal(t, "1\n2\n3\n", get("small"))
+	assert.Equal(t, "econd\n", get("multi"), "the limit bounds the whole group")
+	assert.Equal(t, "true 9999\n10000 false\n", get("report"))
+
+	tail, _ := e.Outputs().Get("tail")
+	require.NotEmpty(t, tail.Log)
+	full, err := os.ReadFile(tail.Log)
+	require.NoError(t, err)
+	assert.Equal(t, 10000, strings.Count(string(full), "\n"), "the log keeps the complete output")
+
+	small, _ := e.Outputs().Get("small")
+	assert.False(t, small.Truncated)
+	assert.Empty(t, small.Log)
+	_, err = os.Stat(strings.TrimSuffix(tail.Log, "tail.log") + "small.log")
+	assert.ErrorIs(t, err, os.ErrNotExist, "an untruncated run keeps no log")
+
+	for _, ev := range decodeEvents(t, buf.Bytes()) {
+		if ev.Event == EventGroupEnd {
+			out, _ := e.Outputs().Get(ev.Group)
+			assert.Equal(t, out.Log, ev.Log, "group.end reports the log of %q", ev.Group)
+		}
+	}
+}
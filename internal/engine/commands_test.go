@@ -1,7 +1,9 @@
 package engine
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"sync"
 	"testing"
@@ -93,6 +95,44 @@ func TestEngine_MultiCommand_RunsInOrderAndCombines(t *testing.T) {
 	assert.Equal(t, result.StatusOK, got.Status)
 }
 
+func TestEngine_MultiCommand_PerCommandResults(t *testing.T) {
+	cfg := stepFlowCfg(t, []config.Group{multiGroup()}, [][]string{{"m"}})
+	r := &specRunner{
+		outputs: map[string]string{"alpha": "A\n", "beta line": "B\n"},
+		errs:    map[string]error{"beta line": assert.AnError},
+	}
+	var buf bytes.Buffer
+	e := New(cfg, WithRunner(r), WithEmitter(NewJSONEmitter(&buf)))
+
+	out, err := e.execWithEnvelope(context.Background(), &cfg.Groups[0], cfg.Groups[0].CommandList(), envelope{})
+	require.Error(t, err)
+	assert.Equal(t, []result.CommandResult{
+		{Command: "alpha", Params: []string{"-a"}, Stdout: "A\n", Output: "A\n", DurationMs: 1},
+		{Command: "beta line", Stdout: "B\n", Output: "B\n", ExitCode: 1, DurationMs: 1},
+	}, out.Commands, "one entry per command that ran")
+
+	var got []string
+	for _, ev := range decodeEvents(t, buf.Bytes()) {
+		assert.Equal(t, "m", ev.Group)
+		got = append(got, fmt.Sprintf("%s %d %s %d", ev.Event, ev.Command, ev.Status, ev.ExitCode))
+	}
+	assert.Equal(t, []string{
+		"command.start 1  0", "command.end 1 ok 0",
+		"command.start 2  0", "command.end 2 failed 1",
+	}, got)
+}
+
+func TestEngine_SingleCommand_NoPerCommandResults(t *testing.T) {
+	cfg := stepFlowCfg(t, []config.Group{{Name: "s", Command: "alpha"}}, [][]string{{"s"}})
+	var buf bytes.Buffer
+	e := New(cfg, WithRunner(&specRunner{}), WithEmitter(NewJSONEmitter(&buf)))
+
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	got, _ := e.Outputs().Get("s")
+	assert.Nil(t, got.Commands)
+	assert.NotContains(t, buf.String(), EventCommandStart)
+}
+
 func TestEngine_MultiCommand_ArgvEntryIgnoresShell(t *testing.T) {
 	cfg := stepFlowCfg(t, []config.Group{multiGroup()}, [][]string{{"m"}})
 	r := &specRunner{outputs: map[string]string{}}
@@ -134,7 +174,7 @@ func TestRunSequence_AggregatesExitCodeAndOutput(t *testing.T) {
 	e := New(cfg, WithRunner(r))
 
 	specs := g.CommandList()
-	out, err := e.runSequence(context.Background(), &g, specs)
+	out, err := e.execWithEnvelope(context.Background(), &g, specs, envelope{})
 	require.Error(t, err)
 	assert.Equal(t, "A\n", out.Output, "combined output covers commands that ran")
 	assert.Equal(t, 1, out.ExitCode, "exit code is the first failing command's")
@@ -160,6 +200,59 @@ func TestEngine_MultiCommand_RetryReplaysWholeSequence(t *testing.T) {
 		r.commandSeq(), "retry restarts from the first command")
 }
 
+func TestEngine_MultiCommand_RetryScopeCommandResumes(t *testing.T) {
+	g := multiGroup()
+	g.RetryScope = config.RetryScopeCommand
+	cfg := stepFlowCfg(t, []config.Group{g}, [][]string{{"m"}})
+	cfg.Flows["f"] = config.Flow{
+		Mode:    config.ModeStep,
+		Steps:   []config.Step{{Run: []string{"m"}}},
+		Retries: 1,
+	}
+	r := &specRunner{
+		outputs:  map[string]string{"alpha": "A\n", "beta line": "B\n", "gamma one\ngamma two\n": "C\n"},
+		failOnce: map[string]error{"beta line": assert.AnError},
+	}
+	e := New(cfg, WithRunner(r), WithRetryBackoff(0))
+
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.Equal(t,
+		[]string{"alpha", "beta line", "beta line", "gamma one\ngamma two\n"},
+		r.commandSeq(), "retry resumes at the failed command")
+	got, ok := e.Outputs().Get("m")
+	require.True(t, ok)
+	assert.Equal(t, "A\nB\nC\n", got.Output, "the failed attempt's output is dropped")
+	assert.Equal(t, 0, got.ExitCode)
+	assert.Equal(t, int64(3), got.DurationMs)
+	assert.Equal(t, []int{1, 2, 1}, got.Attempts)
+	require.Len(t, got.Commands, 3, "one entry per command, the retried one's last attempt")
+	assert.Equal(t, 0, got.Commands[1].ExitCode)
+}
+
+func TestEngine_MultiCommand_RetryScopeCommandExhausted(t *testing.T) {
+	g := multiGroup()
+	g.RetryScope = config.RetryScopeCommand
+	g.AllowFailure = true
+	cfg := stepFlowCfg(t, []config.Group{g}, [][]string{{"m"}})
+	cfg.Flows["f"] = config.Flow{
+		Mode:    config.ModeStep,
+		Steps:   []config.Step{{Run: []string{"m"}}},
+		Retries: 2,
+	}
+	r := &specRunner{
+		outputs: map[string]string{"alpha": "A\n", "beta line": "B\n"},
+		errs:    map[string]error{"beta line": assert.AnError},
+	}
+	e := New(cfg, WithRunner(r), WithRetryBackoff(0))
+
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"alpha", "beta line", "beta line", "beta line"}, r.commandSeq())
+	got, _ := e.Outputs().Get("m")
+	assert.Equal(t, result.StatusFailed, got.Status)
+	assert.Equal(t, "A\nB\n", got.Output, "only the last attempt's output of the failed command")
+	assert.Equal(t, []int{1, 3, 0}, got.Attempts)
+}
+
 func TestEngine_MultiCommand_DryRunRunsNothing(t *testing.T) {
 	cfg := stepFlowCfg(t, []config.Group{multiGroup()}, [][]string{{"m"}})
 	r := &specRunner{}
@@ -222,7 +315,7 @@ func TestRunSequence_StickyNonOKStatus(t *testing.T) {
 	e := New(cfg, WithRunner(r))
 
 	specs := g.CommandList()
-	out, err := e.runSequence(context.Background(), &g, specs)
+	out, err := e.execWithEnvelope(context.Background(), &g, specs, envelope{})
 	require.NoError(t, err, "nil error: sequence continues when runner returns nil error")
 	assert.Equal(t, "failed", out.Status, "first non-ok status must survive a later ok command")
 	assert.Equal(t, 2, out.ExitCode, "exit code of first failing command is preserved")
@@ -337,7 +430,7 @@ func TestEngine_MultiCommand_RealRunner(t *testing.T) {
 		cfg := stepFlowCfg(t, []config.Group{g}, [][]string{{"g"}})
 		e := New(cfg, WithRunner(&ShellRunner{Stdout: io.Discard, Stderr: io.Discard}))
 
-		out, err := e.runSequence(context.Background(), &g, g.CommandList())
+		out, err := e.execWithEnvelope(context.Background(), &g, g.CommandList(), envelope{})
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "command 2 of 3")
 		assert.Equal(t, "ran\n", out.Output)
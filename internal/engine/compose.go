@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/plan"
+	"github.com/quike/keepup/internal/result"
+)
+
+// runMember runs one plan member: a group, or the flow it embeds.
+func (e *Engine) runMember(ctx context.Context, p *plan.Plan, name string, baseline map[string]result.RunResult, env envelope) error {
+	if p.SubFlows[name] {
+		return e.runSubFlow(ctx, name, env)
+	}
+	group := e.groups[name]
+	return e.runGroup(ctx, &group, baseline, env)
+}
+
+// runSubFlow runs an embedded flow as a single member. The flow runs on a
+// child engine with an output store of its own, so its groups neither see
+// nor clobber the parent's outputs, under its own timeout/retries envelope
+// and hooks. Once it finishes, every output it published is copied into the
+// parent as "name/<output>", and the member itself publishes a result whose
+// Status summarizes the run. A run entry's allow-failure turns a failed
+// sub-flow into a soft failure. The child starts with the env exported by
+// the groups before the member; what its own groups export stays within it.
+func (e *Engine) runSubFlow(ctx context.Context, name string, env envelope) error {
+	child := &Engine{
+		cfg:            e.cfg,
+		groups:         e.groups,
+		runner:         e.runner,
+		prober:         e.prober,
+		outputs:        NewMemoryOutputStore(),
+		expander:       e.expander,
+		cache:          e.cache,
+		emitter:        e.emitter,
+		log:            e.log,
+		maxConcurrency: e.maxConcurrency,
+		resources:      e.resources,
+		history:        e.history,
+		tools:          e.tools,
+		dryRun:         e.dryRun,
+		noCache:        e.noCache,
+		retryBackoff:   e.retryBackoff,
+		hookTimeout:    e.hookTimeout,
+		parent:         e.flowID,
+	}
+	child.inherited = e.exportsFor(name)
+	err := child.RunFlow(ctx, name)
+	for k, v := range child.outputs.Snapshot() {
+		e.outputs.Set(name+config.SubFlowSep+k, v)
+	}
+	for _, soft := range child.SoftFailures() {
+		e.recordSoftFailure(name + config.SubFlowSep + soft)
+	}
+
+	out := result.RunResult{Status: result.StatusOK}
+	switch {
+	case e.dryRun:
+		out.Status = result.StatusDryRun
+	case err != nil && env.allowFailure && ctx.Err() == nil:
+		e.softFail(name, &out, err)
+		return nil
+	case err != nil:
+		return fmt.Errorf("flow %q: %w", name, err)
+	}
+	e.outputs.Set(name, out)
+	return nil
+}
+
+// subFlowOutputs returns the outputs an embedded flow member published into
+// snap under its "name/" prefix.
+func subFlowOutputs(snap map[string]result.RunResult, name string) map[string]result.RunResult {
+	out := make(map[string]result.RunResult)
+	for k, v := range snap {
+		if strings.HasPrefix(k, name+config.SubFlowSep) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// flowDefinition lists what an embedded flow runs: the flow itself, every
+// group it (or a flow it embeds) runs, and its hook groups. Resume hashes it
+// to notice a changed sub-flow.
+func (e *Engine) flowDefinition(name string) []any {
+	f := e.cfg.Flows[name]
+	def := []any{f}
+	subs := make(map[string]bool)
+	for _, s := range f.SubFlows() {
+		subs[s] = true
+	}
+	for _, m := range f.Members() {
+		if subs[m] {
+			def = append(def, e.flowDefinition(m)...)
+		} else {
+			def = append(def, e.groups[m])
+		}
+	}
+	for _, h := range f.Hooks() {
+		def = append(def, e.groups[h.Group])
+	}
+	return def
+}
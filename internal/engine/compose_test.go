@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+const composeFlowCfg = `
+version: 2
+groups:
+  - {name: vet, command: go, params: [vet]}
+  - {name: build, command: go, params: ['{{ output "lint/vet" }}', '{{ (out "lint").Status }}']}
+flows:
+  lint:
+    steps:
+      - run: [vet]
+  ci:
+    steps:
+      - run: [vet, {flow: lint}]
+      - run: [build]
+  release:
+    mode: dag
+    run:
+      - build
+      - {flow: lint, allow-failure: true}
+`
+
+func TestEngine_EmbeddedFlowPublishesOutputs(t *testing.T) {
+	t.Parallel()
+	cfg, err := config.NewConfig([]byte(composeFlowCfg))
+	require.NoError(t, err)
+	r := &fakeRunner{outputs: map[string]string{"vet": "clean"}}
+	var buf bytes.Buffer
+	e := New(cfg, WithRunner(r), WithEmitter(NewJSONEmitter(&buf)))
+	require.NoError(t, e.RunFlow(context.Background(), "ci"))
+
+	assert.Contains(t, r.calls, "build:clean,ok")
+	assert.Len(t, r.calls, 3, "vet runs once for ci and once inside lint")
+	got, ok := e.Outputs().Get("lint/vet")
+	require.True(t, ok)
+	assert.Equal(t, "clean", got.Output)
+
+	var nested []Event
+	for _, ev := range decodeEvents(t, buf.Bytes()) {
+		if ev.Event == EventFlowStart || ev.Event == EventFlowEnd {
+			nested = append(nested, ev)
+		}
+		if ev.Event == EventGroupEnd && ev.ID == "ci/lint" {
+			assert.Equal(t, "vet", ev.Group)
+		}
+	}
+	require.Len(t, nested, 4)
+	assert.Equal(t, Event{Event: EventFlowStart, ID: "ci", Flow: "ci", Mode: "step"}, stripTime(nested[0]))
+	assert.Equal(t, "ci/lint", nested[1].ID)
+	assert.Equal(t, "ci", nested[1].Parent)
+	assert.Equal(t, EventFlowEnd, nested[2].Event)
+	assert.Equal(t, "ci", nested[2].Parent)
+	assert.Empty(t, nested[3].Parent)
+}
+
+func TestEngine_EmbeddedFlowFailure(t *testing.T) {
+	t.Parallel()
+	cfg, err := config.NewConfig([]byte(composeFlowCfg))
+	require.NoError(t, err)
+
+	t.Run("fails the embedding flow", func(t *testing.T) {
+		t.Parallel()
+		r := &fakeRunner{errs: map[string]error{"vet": errors.New("boom")}}
+		err := New(cfg, WithRunner(r)).RunFlow(context.Background(), "ci")
+		require.Error(t, err)
+		assert.NotContains(t, r.calls, "build:,")
+	})
+
+	t.Run("allow-failure soft-fails the node", func(t *testing.T) {
+		t.Parallel()
+		r := &fakeRunner{errs: map[string]error{"vet": errors.New("boom")}}
+		e := New(cfg, WithRunner(r))
+		require.NoError(t, e.RunFlow(context.Background(), "release"))
+		assert.Contains(t, r.calls, "build:,failed")
+		assert.Equal(t, []string{"lint"}, e.SoftFailures())
+		got, _ := e.Outputs().Get("lint")
+		assert.Equal(t, result.StatusFailed, got.Status)
+	})
+}
+
+// stripTime drops the timestamp so an event can be compared whole.
+func stripTime(ev Event) Event { //nolint:gocritic // value in, value out
+	ev.Time = time.Time{}
+	return ev
+}
+
+func TestEngine_ResumeReplaysEmbeddedFlow(t *testing.T) {
+	t.Parallel()
+	store := cache.NewFileStore(filepath.Join(t.TempDir(), "cache"))
+	cfg, err := config.NewConfig([]byte(composeFlowCfg))
+	require.NoError(t, err)
+
+	first := &fakeRunner{
+		outputs: map[string]string{"vet": "clean"},
+		errs:    map[string]error{"build": errors.New("boom")},
+	}
+	require.Error(t, New(cfg, WithRunner(first), WithRunStore(store)).RunFlow(context.Background(), "ci"))
+
+	second := &fakeRunner{}
+	require.NoError(t, New(cfg, WithRunner(second), WithRunStore(store), WithResume(true)).RunFlow(context.Background(), "ci"))
+	assert.Equal(t, []string{"build:clean,ok"}, second.calls, "lint and its outputs are replayed")
+}
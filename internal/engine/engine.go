@@ -7,6 +7,11 @@ package engine
 import (
 	"context"
 	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/quike/keepup/internal/cache"
@@ -26,18 +31,81 @@ type Engine struct {
 	outputs        OutputStore
 	expander       template.Expander
 	cache          cache.Store
+	runs           cache.RunStore
+	durations      cache.DurationStore
 	emitter        Emitter
 	log            logger.Logger
 	maxConcurrency int
+	resources      *resourcePool
 	dryRun         bool
 	noCache        bool
+	resume         bool
+	selection      plan.Selection
 	retryBackoff   time.Duration
+	hookTimeout    time.Duration
+	args           map[string]string
+
+	// replayed holds the members a --resume reuses from saved run state;
+	// set by loadResume before scheduling and read-only afterwards.
+	replayed map[string]bool
+
+	// softMu guards softFailed, the groups that failed under allow-failure
+	// during the current RunFlow.
+	softMu     sync.Mutex
+	softFailed []string
+
+	// services are the service groups the current RunFlow started; they run
+	// until it stops them once its hooks have run.
+	services serviceSet
+
+	// streams are the current RunFlow's live stdout pipes between groups.
+	streams *streamSet
+
+	// exports holds the env each member of the current RunFlow exported
+	// through KEEPUP_ENV, by member. inherited is what the embedding flow
+	// exported before this one started (see runSubFlow); exportScope lists
+	// the members whose exports each member sees and exportOrder all of
+	// them, in the order they layer (see scopeExports). exportMu guards
+	// all four.
+	exportMu    sync.Mutex
+	exports     map[string]map[string]string
+	inherited   map[string]string
+	exportScope map[string][]string
+	exportOrder []string
+
+	// flow is the template-visible state of the current RunFlow. It is only
+	// written between scheduler phases (before launch, after Wait), so
+	// workers read it without locking.
+	flow template.FlowState
+
+	// history holds the group durations the schedulers rank members by; a
+	// top-level RunFlow loads it and shares it with the flows it embeds.
+	history *runHistory
+
+	// tools holds the cache.tools output of the current run (see toolMemo).
+	tools *toolMemo
+
+	// flowArgs are the current RunFlow's args, resolved from args against
+	// the flow's declarations before planning.
+	flowArgs map[string]string
+
+	// flowID identifies the current RunFlow on the event stream: the flow's
+	// name, prefixed by parent (the embedding flow's id, "ci/lint") when the
+	// engine runs a flow embedded in another.
+	flowID string
+	parent string
 }
 
-// DefaultRetryBackoff is the base delay between retry attempts; the delay for
-// attempt N is DefaultRetryBackoff * N.
+// DefaultRetryBackoff is the base delay between retry attempts: the wait
+// before a retry is DefaultRetryBackoff times the attempts failed so far,
+// unless the group sets backoff: (see envelope.delay).
 const DefaultRetryBackoff = 250 * time.Millisecond
 
+// DefaultHookTimeout bounds each attempt of a hook group when neither the
+// flow nor the group sets a timeout. Hooks outlive a Ctrl-C, so a hung one
+// would otherwise hold the run until it is killed.
+const DefaultHookTimeout = 10 * time.Minute
+
 // Option configures an Engine.
 type Option func(*Engine)
 
@@ -59,6 +127,23 @@ func WithCache(s cache.Store) Option { return func(e *Engine) { e.cache = s } }
 // WithNoCache disables cache reads/writes for this run.
 func WithNoCache(disable bool) Option { return func(e *Engine) { e.noCache = disable } }
 
+// WithRunStore enables run-state persistence: a failed RunFlow records what
+// completed so a later run can resume. Without it nothing is persisted.
+func WithRunStore(s cache.RunStore) Option { return func(e *Engine) { e.runs = s } }
+
+// WithDurationStore records how long each group runs and loads that history
+// on the next run, so the schedulers start the longest chains first. Without
+// it every group is assumed to cost the same.
+func WithDurationStore(s cache.DurationStore) Option { return func(e *Engine) { e.durations = s } }
+
+// WithResume replays the flow's last failed run instead of starting over.
+// It requires WithRunStore.
+func WithResume(resume bool) Option { return func(e *Engine) { e.resume = resume } }
+
+// WithSelection runs only a slice of the flow (see plan.Selection). Outputs
+// the slice needs from unselected groups are taken from the cache.
+func WithSelection(sel plan.Selection) Option { return func(e *Engine) { e.selection = sel } }
+
 // WithLogger overrides the default no-op Logger.
 func WithLogger(l logger.Logger) Option { return func(e *Engine) { e.log = l } }
 
@@ -68,10 +153,18 @@ func WithDryRun(dry bool) Option { return func(e *Engine) { e.dryRun = dry } }
 // WithEmitter sets the structured event emitter (default: discard).
 func WithEmitter(em Emitter) Option { return func(e *Engine) { e.emitter = em } }
 
-// WithRetryBackoff overrides the base retry backoff (delay for attempt N is
-// base*N). Primarily useful in tests to avoid real sleeps.
+// WithRetryBackoff overrides the base retry backoff (see DefaultRetryBackoff).
+// Primarily useful in tests to avoid real sleeps.
 func WithRetryBackoff(d time.Duration) Option { return func(e *Engine) { e.retryBackoff = d } }
 
+// WithHookTimeout overrides DefaultHookTimeout.
+func WithHookTimeout(d time.Duration) Option { return func(e *Engine) { e.hookTimeout = d } }
+
+// WithArgs sets the values given for the flow's declared args (--arg
+// name=value). RunFlow checks them against the flow before planning; an
+// embedded flow always runs with its defaults.
+func WithArgs(args map[string]string) Option { return func(e *Engine) { e.args = args } }
+
 // New constructs an Engine. The config pointer is held by reference; do not
 // mutate it for the lifetime of the Engine.
 func New(cfg *config.Config, opts ...Option) *Engine {
@@ -79,10 +172,7 @@ func New(cfg *config.Config, opts ...Option) *Engine {
 	for i := range cfg.Groups {
 		groups[cfg.Groups[i].Name] = cfg.Groups[i]
 	}
-	cacheDir := cfg.Settings.CacheDir
-	if cacheDir == "" {
-		cacheDir = config.DefaultCacheDir
-	}
+	cacheDir := cfg.CacheDir()
 	e := &Engine{
 		cfg:            cfg,
 		groups:         groups,
@@ -94,8 +184,11 @@ func New(cfg *config.Config, opts ...Option) *Engine {
 		emitter:        nopEmitter{},
 		log:            logger.Nop(),
 		maxConcurrency: cfg.Settings.MaxConcurrency,
+		resources:      newResourcePool(cfg.Settings.Resources),
 		dryRun:         cfg.Settings.DryRun,
 		retryBackoff:   DefaultRetryBackoff,
+		hookTimeout:    DefaultHookTimeout,
+		tools:          &toolMemo{},
 	}
 	for _, opt := range opts {
 		opt(e)
@@ -106,21 +199,52 @@ func New(cfg *config.Config, opts ...Option) *Engine {
 // Outputs returns the captured outputs (populated after RunFlow completes).
 func (e *Engine) Outputs() OutputStore { return e.outputs }
 
+// SoftFailures returns the sorted names of groups that failed under
+// allow-failure during the last RunFlow.
+func (e *Engine) SoftFailures() []string {
+	e.softMu.Lock()
+	defer e.softMu.Unlock()
+	out := append([]string(nil), e.softFailed...)
+	sort.Strings(out)
+	return out
+}
+
+func (e *Engine) recordSoftFailure(name string) {
+	e.softMu.Lock()
+	defer e.softMu.Unlock()
+	e.softFailed = append(e.softFailed, name)
+}
+
 // RunFlow executes the named Flow, honoring ctx cancellation.
 func (e *Engine) RunFlow(ctx context.Context, flowName string) error {
-	if flowName == "" {
-		if e.cfg.Default == "" {
-			return fmt.Errorf("no flow specified and no default declared")
-		}
-		flowName = e.cfg.Default
+	flowName, flow, err := e.resolveFlow(flowName)
+	if err != nil {
+		return err
 	}
-	p, err := plan.Build(e.cfg, flowName)
+	// Reset before planning: a selection seeds the exports of the cached
+	// groups it does not run, fingerprinting them with this run's tools.
+	if e.parent == "" {
+		e.exportMu.Lock()
+		e.exports = nil
+		e.exportMu.Unlock()
+		e.tools = &toolMemo{}
+	}
+	p, err := e.buildPlan(ctx, flowName)
 	if err != nil {
 		return err
 	}
-	flow := e.cfg.Flows[flowName]
+	e.resetRun(flowName)
+	if e.resume && !e.dryRun {
+		if err := e.loadResume(p, &flow); err != nil {
+			return err
+		}
+	}
+	if e.streams, err = e.openStreams(p); err != nil {
+		return err
+	}
+	defer e.streams.close()
 	e.log.Info("starting flow", "flow", flowName, "mode", string(p.Mode))
-	e.emitter.Emit(Event{Event: EventFlowStart, Flow: flowName, Mode: string(p.Mode)})
+	e.emit(Event{Event: EventFlowStart, Flow: flowName, Parent: e.parent, Mode: string(p.Mode)})
 
 	start := time.Now()
 	switch p.Mode {
@@ -131,40 +255,142 @@ func (e *Engine) RunFlow(ctx context.Context, flowName string) error {
 	default:
 		err = fmt.Errorf("flow %q: unknown mode %q", flowName, p.Mode)
 	}
-	status := StatusOK
+	if e.runs != nil && !e.dryRun && e.selection.Empty() {
+		e.saveRunState(p, &flow, err)
+	}
+	err = e.runHooks(ctx, &flow, err)
+	e.stopServices()
+	if e.parent == "" {
+		e.history.save(e.durations, e.log)
+	}
+	e.endFlow(flowName, start, err)
+	return err
+}
+
+// resolveFlow returns the named flow, or the default one when name is
+// empty, and binds its args for the run.
+func (e *Engine) resolveFlow(name string) (string, config.Flow, error) {
+	if name == "" {
+		if e.cfg.Default == "" {
+			return "", config.Flow{}, fmt.Errorf("no flow specified and no default declared")
+		}
+		name = e.cfg.Default
+	}
+	flow, ok := e.cfg.Flows[name]
+	if !ok {
+		return "", config.Flow{}, fmt.Errorf("flow %q not found", name)
+	}
+	args, err := flow.ResolveArgs(e.args)
 	if err != nil {
+		return "", config.Flow{}, fmt.Errorf("flow %q: %w", name, err)
+	}
+	e.flowArgs = args
+	return name, flow, nil
+}
+
+// resetRun clears what the last RunFlow left behind and names this one.
+func (e *Engine) resetRun(flowName string) {
+	e.softMu.Lock()
+	e.softFailed = nil
+	e.softMu.Unlock()
+	e.flow = template.FlowState{Name: flowName, Status: flowRunning}
+	e.flowID = flowName
+	if e.parent != "" {
+		e.flowID = e.parent + config.SubFlowSep + flowName
+	}
+	e.replayed = nil
+	if e.parent == "" {
+		e.history = newRunHistory(e.durations)
+	}
+}
+
+// endFlow emits flow.end: failed on err, soft-failed when groups failed
+// under allow-failure, ok otherwise.
+func (e *Engine) endFlow(flowName string, start time.Time, err error) {
+	status, reason := StatusOK, ""
+	soft := e.SoftFailures()
+	switch {
+	case err != nil:
 		status = StatusFailed
+	case len(soft) > 0:
+		status = StatusSoftFailed
+		reason = "allowed failures: " + strings.Join(soft, ", ")
+		e.log.Warn("flow finished with allowed failures", "flow", flowName, "groups", soft)
 	}
-	e.emitter.Emit(Event{
-		Event: EventFlowEnd, Flow: flowName, Status: status,
-		DurationMS: msSince(start), Err: errString(err),
+	e.emit(Event{
+		Event: EventFlowEnd, Flow: flowName, Parent: e.parent, Status: status,
+		DurationMS: msSince(start), Err: errString(err), Reason: reason,
 	})
-	return err
+}
+
+// buildPlan plans the flow and narrows it to the engine's selection. A
+// partial run cannot resume, and neither reads nor writes run state: the
+// saved state always describes a whole-flow attempt.
+func (e *Engine) buildPlan(ctx context.Context, flowName string) (*plan.Plan, error) {
+	p, err := plan.Build(e.cfg, flowName)
+	if err != nil {
+		return nil, err
+	}
+	e.scopeExports(p)
+	if e.selection.Empty() {
+		return p, nil
+	}
+	if e.resume {
+		return nil, fmt.Errorf("flow %q: cannot resume a partial run; drop the selection or --resume", flowName)
+	}
+	if p, err = p.Select(e.cfg, e.selection); err != nil {
+		return nil, err
+	}
+	if err := e.seedExternal(ctx, p); err != nil {
+		return nil, err
+	}
+	e.log.Info("running selected groups", "flow", flowName, "groups", p.Members)
+	return p, nil
 }
 
 // envelope is the resolved control envelope for a group's command execution.
+// backoff spaces out retries and retryOn picks the failures that are retried
+// (see config.Backoff, config.RetryOn).
+// allowFailure carries a flow-level (dag run entry) soft-fail override; the
+// group's own allow-failure is OR-ed in by runGroup.
+// entry is the dag run entry of the group, whose settings override the
+// group's own (see forGroup).
+// phase tags the group's events when it runs as an on-success / on-failure /
+// finally hook.
 type envelope struct {
-	timeout time.Duration
-	retries int
+	timeout      time.Duration
+	retries      int
+	backoff      *config.Backoff
+	retryOn      *config.RetryOn
+	allowFailure bool
+	entry        *config.RunEntry
+	phase        string
 }
 
-// resolveEnvelope computes the effective timeout/retries for a wave. A step's
-// non-empty timeout / non-zero retries override the flow defaults; otherwise
-// the flow values apply. step may be nil (dag mode).
+// resolveEnvelope computes the effective envelope for a wave. A step's
+// non-empty settings override the flow defaults; otherwise the flow values
+// apply. step may be nil (dag mode). runGroup layers the group's own
+// settings on top (see forGroup).
 func resolveEnvelope(flow *config.Flow, step *config.Step) envelope {
-	timeout, retries := flow.Timeout, flow.Retries
+	env := envelope{}.overlay(flow.Timeout, flow.Retries, flow.Backoff, flow.RetryOn)
 	if step != nil {
-		if step.Timeout != "" {
-			timeout = step.Timeout
-		}
-		if step.Retries != 0 {
-			retries = step.Retries
+		env = env.overlay(step.Timeout, step.Retries, step.Backoff, step.RetryOn)
+	}
+	return env
+}
+
+// templateData is the render context for a group or predicate: the given
+// output snapshot plus the current flow state and, for group (nil for a step
+// predicate), its global env, matrix axis values, and import namespace.
+func (e *Engine) templateData(baseline map[string]result.RunResult, group *config.Group) template.Data {
+	data := template.Data{Outputs: baseline, Env: e.envFor(group), Flow: e.flow, Args: e.flowArgs}
+	if group != nil {
+		data.Matrix = group.MatrixValues
+		if group.Scope != nil {
+			data.Namespace = group.Scope.Namespace
 		}
 	}
-	// Durations are validated at config load, so a parse error here is
-	// impossible; treat any residual error as "no timeout".
-	d, _ := time.ParseDuration(timeout)
-	return envelope{timeout: d, retries: retries}
+	return data
 }
 
 // expandCommands renders every command in the group's normalized list against
@@ -190,6 +416,25 @@ func (e *Engine) expandCommands(group *config.Group, data template.Data) ([]conf
 	return expanded, nil
 }
 
+// resolveGroup renders the group's dir: template and resolves it against
+// the config's working directory, then resolves its stdin: (see
+// resolveStdin). The returned copy of the group carries the final path in
+// Dir, which the runner, prober, and cache all consume.
+func (e *Engine) resolveGroup(group *config.Group, data template.Data) (config.Group, error) {
+	resolved := *group
+	dir, err := e.expander.Expand(group.Dir, data)
+	if err != nil {
+		return resolved, fmt.Errorf("group %q: expand dir: %w", group.Name, err)
+	}
+	resolved.Dir = e.cfg.ResolveGroupDir(group, dir)
+	if group.Stdin != nil {
+		if resolved.Stdin, err = e.resolveStdin(&resolved, data); err != nil {
+			return resolved, err
+		}
+	}
+	return resolved, nil
+}
+
 // runGroup decides whether and how to execute a group. The decision order is:
 //
 //  1. dry-run    → log intent, do nothing else (gating/cache are not evaluated)
@@ -200,148 +445,330 @@ func (e *Engine) expandCommands(group *config.Group, data template.Data) ([]conf
 //
 // Skipped or cache-hit groups still publish an output value so downstream
 // {{ output.X }} references resolve. The command run (only) is wrapped with the
-// envelope's per-attempt timeout and bounded retries.
+// envelope's per-attempt timeout and bounded retries. When the group (or its
+// run entry) allows failure, a failed run is published with Status "failed"
+// and its exit code instead of aborting the flow. A service group starts in
+// the background instead, outside the envelope, and completes once it is
+// ready (see startService).
+//
+// A group whose stdout others stream (see streamSet) writes it to the stream
+// as it runs and closes it when it ends. It is never retried: its consumers
+// have already read the failed attempt's output.
 func (e *Engine) runGroup(ctx context.Context, group *config.Group, baseline map[string]result.RunResult, env envelope) (err error) {
 	start := time.Now()
-	e.emitter.Emit(Event{Event: EventGroupStart, Group: group.Name})
-	status := StatusOK
+	e.emit(Event{Event: EventGroupStart, Group: group.Name, Phase: env.phase})
+	status, termination, logPath := StatusOK, "", ""
 	defer func() {
 		if err != nil {
 			status = StatusFailed
 		}
-		e.emitter.Emit(Event{
-			Event: EventGroupEnd, Group: group.Name, Status: status,
-			DurationMS: msSince(start), Err: errString(err),
+		e.streams.finish(group.Name, status)
+		e.emit(Event{
+			Event: EventGroupEnd, Group: group.Name, Phase: env.phase, Status: status,
+			DurationMS: msSince(start), Err: errString(err), Termination: termination,
+			Log: logPath,
 		})
 	}()
 
-	data := template.Data{Outputs: baseline, Env: e.cfg.Env}
+	env = env.forGroup(group)
+	if st := e.streams.get(group.Name); st != nil {
+		ctx = withStdoutTap(ctx, st)
+		env.retries = 0
+	}
+	data := e.templateData(baseline, group)
 
 	expanded, err := e.expandCommands(group, data)
 	if err != nil {
 		return err
 	}
+	resolved, err := e.resolveGroup(group, data)
+	if err != nil {
+		return err
+	}
+	group = &resolved
 
-	if e.dryRun {
-		for _, s := range expanded {
-			e.log.Info("[dry-run] would run",
-				"group", group.Name, "command", s.Command, "params", s.Params, "shell", s.IsShell)
+	if settled, err := e.settleWithoutRun(ctx, group, expanded); err != nil || settled != "" {
+		if settled != "" {
+			status = settled
 		}
-		e.outputs.Set(group.Name, result.RunResult{Status: result.StatusDryRun})
-		status = StatusDryRun
+		return err
+	}
+
+	out, err := e.execute(ctx, group, expanded, env)
+	termination, logPath = out.Termination, out.Log
+	if err != nil && (group.AllowFailure || env.allowFailure) && ctx.Err() == nil {
+		e.softFail(group.Name, &out, err)
+		status = StatusSoftFailed
 		return nil
 	}
+	if err != nil {
+		e.log.Error("group failed", "group", group.Name, "err", err.Error(), "output", out.Output)
+		return err
+	}
+	e.publish(ctx, group, expanded, &out)
+	return nil
+}
 
-	if group.Require != "" {
-		if err = e.prober.Probe(ctx, group.Require, e.cfg.Env); err != nil {
-			return fmt.Errorf("group %q: requirement %q not met: %w", group.Name, group.Require, err)
-		}
+// settleWithoutRun returns the status of a group that need not run: a dry
+// run, a skip-if: that holds, or a cache hit. It returns "" when the group
+// must run.
+func (e *Engine) settleWithoutRun(ctx context.Context, group *config.Group, expanded []config.CommandSpec) (string, error) {
+	if e.dryRun {
+		e.dryRunGroup(group, expanded)
+		return StatusDryRun, nil
 	}
+	skip, err := e.checkPredicates(ctx, group)
+	if err != nil {
+		return "", err
+	}
+	if skip {
+		return StatusSkipped, nil
+	}
+	hit, err := e.replayCacheHit(ctx, group, expanded)
+	if err != nil || !hit {
+		return "", err
+	}
+	return StatusCacheHit, nil
+}
 
-	if group.SkipIf != "" {
-		if err = e.prober.Probe(ctx, group.SkipIf, e.cfg.Env); err == nil {
-			e.outputs.Set(group.Name, result.RunResult{Status: result.StatusSkipped})
-			e.log.Info("group skipped", "group", group.Name, "reason", "skip-if", "predicate", group.SkipIf)
-			status = StatusSkipped
-			return nil
+// dryRunGroup logs the commands group would run and records it as a dry run.
+func (e *Engine) dryRunGroup(group *config.Group, expanded []config.CommandSpec) {
+	for _, s := range expanded {
+		e.log.Info("[dry-run] would run",
+			"group", group.Name, "command", s.Command, "params", s.Params, "shell", s.IsShell, "dir", group.Dir)
+	}
+	e.outputs.Set(group.Name, result.RunResult{Status: result.StatusDryRun})
+}
+
+// checkPredicates probes the group's require: and skip-if: commands. It
+// reports skip once skip-if: succeeds, recording the group as skipped, and
+// fails when require: does not.
+func (e *Engine) checkPredicates(ctx context.Context, group *config.Group) (skip bool, err error) {
+	if group.Require != "" {
+		if err := e.prober.Probe(ctx, group.Require, group.Dir, e.envFor(group)); err != nil {
+			return false, fmt.Errorf("group %q: requirement %q not met: %w", group.Name, group.Require, err)
 		}
 	}
+	if group.SkipIf == "" {
+		return false, nil
+	}
+	if err := e.prober.Probe(ctx, group.SkipIf, group.Dir, e.envFor(group)); err != nil {
+		return false, nil
+	}
+	e.outputs.Set(group.Name, result.RunResult{Status: result.StatusSkipped})
+	e.log.Info("group skipped", "group", group.Name, "reason", "skip-if", "predicate", group.SkipIf)
+	return true, nil
+}
 
-	if fp, hit := e.cacheLookup(group, expanded); hit {
-		cached := fp.Result
-		cached.Status = result.StatusCached
-		e.outputs.Set(group.Name, cached)
-		e.log.Info("cache hit", "group", group.Name, "fingerprint", fp.Fingerprint)
-		status = StatusCacheHit
-		return nil
+// replayCacheHit publishes the group's cached result when its fingerprint
+// hits (see cacheLookup): the values parsed afresh, its exports, and its
+// stdout to any stream that reads it.
+func (e *Engine) replayCacheHit(ctx context.Context, group *config.Group, expanded []config.CommandSpec) (bool, error) {
+	fp, hit := e.cacheLookup(ctx, group, expanded)
+	if !hit {
+		return false, nil
+	}
+	cached := fp.Result
+	cached.Status = result.StatusCached
+	// Parse afresh: outputs: is not part of the fingerprint.
+	if err := parseValues(group, &cached); err != nil {
+		return false, err
 	}
+	e.exportEnv(group.Name, cached.EnvFile)
+	if st := e.streams.get(group.Name); st != nil {
+		_, _ = io.WriteString(st, cached.Stdout)
+	}
+	e.outputs.Set(group.Name, cached)
+	e.log.Info("cache hit", "group", group.Name, "fingerprint", fp.Fingerprint)
+	return true, nil
+}
 
+// execute runs the group: a service is started and left running until the
+// flow ends (see startService), anything else runs its commands under env.
+// The result's values are parsed once the run succeeds.
+func (e *Engine) execute(
+	ctx context.Context, group *config.Group, expanded []config.CommandSpec, env envelope,
+) (out result.RunResult, err error) {
 	for _, s := range expanded {
 		e.log.Info("running group", "group", group.Name, "command", s.Command, "params", s.Params)
 	}
-	out, err := e.execWithEnvelope(ctx, group, expanded, env)
-	if err != nil {
-		e.log.Error("group failed", "group", group.Name, "err", err.Error(), "output", out.Output)
-		return err
+	if group.Service {
+		out, err = e.startService(ctx, group, expanded[0])
+	} else {
+		out, err = e.execWithEnvelope(ctx, group, expanded, env)
 	}
+	if err == nil {
+		err = parseValues(group, &out)
+	}
+	return out, err
+}
+
+// publish records a successful run: its result for (out "name"), its cache
+// entry, its exports to later groups, and its duration.
+func (e *Engine) publish(ctx context.Context, group *config.Group, expanded []config.CommandSpec, out *result.RunResult) {
 	e.log.Trace("group output", "group", group.Name, "output", out.Output)
 	// Runner sets Status to result.StatusOK on success; trust it so a future
 	// soft-fail Runner can return Status:"failed" without engine clobbering.
-	e.outputs.Set(group.Name, out)
-	e.cacheStore(group, expanded, &out)
-	return nil
+	e.outputs.Set(group.Name, *out)
+	// Store before exporting: the group's own exports must not leak into
+	// the cache.env values of its fingerprint.
+	e.cacheStore(ctx, group, expanded, out)
+	e.exportEnv(group.Name, out.EnvFile)
+	e.history.observe(group.Name, out.DurationMs)
+}
+
+// softFail publishes a failed run under allow-failure. A result without an
+// exit status (the process never started, or was killed) records -1 so
+// `(out "x").ExitCode` is non-zero for every failure.
+func (e *Engine) softFail(name string, out *result.RunResult, err error) {
+	out.Status = result.StatusFailed
+	if out.ExitCode == 0 {
+		out.ExitCode = -1
+	}
+	e.log.Warn("group failed; continuing (allow-failure)",
+		"group", name, "exitCode", out.ExitCode, "err", err.Error())
+	e.outputs.Set(name, *out)
+	e.recordSoftFailure(name)
 }
 
 // execWithEnvelope runs the group's command sequence, applying a per-attempt
-// timeout and retrying up to env.retries additional times on failure. A retry
-// replays the whole sequence from the first command. Backoff between attempts
+// timeout and retrying up to env.retries additional times on a failure that
+// env.retryOn accepts. A retry replays the whole sequence from the first
+// command, or resumes at the command that failed under retry-scope: command,
+// and is announced by a group.retry event. Backoff between attempts
 // respects ctx cancellation.
+//
+// The attempts share the group's capture: log and its KEEPUP_OUTPUT and
+// KEEPUP_ENV files, read into the result once they end (see envFiles).
 func (e *Engine) execWithEnvelope(
 	ctx context.Context, group *config.Group, commands []config.CommandSpec, env envelope,
-) (result.RunResult, error) {
+) (out result.RunResult, err error) {
+	if group.Capture != nil {
+		var finish func(*result.RunResult)
+		ctx, finish = e.openCapture(ctx, group)
+		defer func() { finish(&out) }()
+	}
+	files, err := openEnvFiles()
+	if err != nil {
+		return out, fmt.Errorf("group %q: %w", group.Name, err)
+	}
+	defer files.remove()
+	defer func() {
+		var ferr error
+		if out.OutputFile, out.EnvFile, ferr = files.read(); ferr != nil && err == nil {
+			err = fmt.Errorf("group %q: %w", group.Name, ferr)
+		}
+	}()
+	seq := &sequence{
+		group: group, commands: commands, phase: env.phase,
+		files: files, attempts: make([]int, len(commands)),
+	}
+	resume := group.RetryScope == config.RetryScopeCommand
+	if resume {
+		defer func() { out.Attempts = seq.attempts }()
+	}
+
 	attempts := 1 + env.retries
-	var (
-		out result.RunResult
-		err error
-	)
 	for attempt := 1; attempt <= attempts; attempt++ {
+		if !resume {
+			seq.restart()
+		}
 		runCtx := ctx
 		cancel := context.CancelFunc(func() {})
 		if env.timeout > 0 {
 			runCtx, cancel = context.WithTimeout(ctx, env.timeout)
 		}
-		out, err = e.runSequence(runCtx, group, commands)
+		out, err = e.runSequence(runCtx, seq)
 		cancel()
 		if err == nil {
 			return out, nil
 		}
-		if attempt < attempts {
-			e.log.Warn("group attempt failed; retrying",
-				"group", group.Name, "attempt", attempt, "of", attempts, "err", err.Error())
-			select {
-			case <-ctx.Done():
-				return out, ctx.Err()
-			case <-time.After(e.retryBackoff * time.Duration(attempt)):
-			}
+		if attempt == attempts {
+			break
+		}
+		if ctx.Err() != nil {
+			return out, ctx.Err()
+		}
+		if !env.retryable(&out) {
+			e.log.Info("group failed; not retrying (no retry-on match)",
+				"group", group.Name, "attempt", attempt, "err", err.Error())
+			break
+		}
+		delay := env.delay(e.retryBackoff, attempt+1)
+		e.log.Warn("group attempt failed; retrying",
+			"group", group.Name, "attempt", attempt, "of", attempts, "delay", delay.String(), "err", err.Error())
+		e.emit(Event{
+			Event: EventGroupRetry, Group: group.Name, Phase: env.phase,
+			Attempt: attempt + 1, DelayMS: delay.Milliseconds(), Err: err.Error(),
+		})
+		select {
+		case <-ctx.Done():
+			return out, ctx.Err()
+		case <-time.After(delay):
 		}
 	}
 	return out, err
 }
 
-// runSequence executes the group's expanded commands in declared order,
-// stopping at the first failure (like set -e). The returned RunResult
-// aggregates the whole sequence: concatenated streams, summed duration, the
-// exit code of the first failing command (0 when all succeed), and the last
-// runner-reported status. Each command goes to the runner as a self-contained
-// copy of the group with exactly one command set; argv-form entries clear
-// Shell so they always safe-exec, string-form entries keep the group's shell.
-func (e *Engine) runSequence(ctx context.Context, group *config.Group, commands []config.CommandSpec) (result.RunResult, error) {
-	var agg result.RunResult
-	for i, s := range commands {
+// sequence tracks a group's commands across the attempts at running them:
+// next is the first command still to run, done the aggregate of those
+// before it, and mark where they left the env files. attempts counts the
+// runs of each command.
+type sequence struct {
+	group    *config.Group
+	commands []config.CommandSpec
+	phase    string
+	files    *envFiles
+	next     int
+	done     result.RunResult
+	mark     envMark
+	attempts []int
+}
+
+// restart sends seq back to its first command.
+func (seq *sequence) restart() {
+	seq.next, seq.done, seq.mark = 0, result.RunResult{}, envMark{}
+}
+
+// runSequence executes the group's expanded commands in declared order from
+// seq.next, stopping at the first failure (like set -e). The returned
+// RunResult aggregates the whole sequence: concatenated streams, summed
+// duration, the exit code of the first failing command (0 when all
+// succeed), and the last runner-reported status. Commands that succeeded
+// in an earlier attempt count with their results from then, and the env
+// files lose whatever the failed attempt wrote after them.
+//
+// Each command goes to the runner as a self-contained copy of the group
+// (see commandGroup); the first one reads the group's stdin:, opened afresh
+// for every attempt. In a multi-command group each command is also
+// recorded in the result's Commands and bracketed by command.start and
+// command.end events.
+func (e *Engine) runSequence(ctx context.Context, seq *sequence) (result.RunResult, error) {
+	group, commands := seq.group, seq.commands
+	multi := len(commands) > 1
+	agg := seq.done
+	agg.Commands = slices.Clip(agg.Commands)
+	if err := seq.files.rewind(seq.mark); err != nil {
+		return agg, fmt.Errorf("group %q: %w", group.Name, err)
+	}
+	env := seq.files.env(e.envFor(group))
+	for i := seq.next; i < len(commands); i++ {
 		if err := ctx.Err(); err != nil {
 			return agg, err
 		}
-		sg := *group
-		sg.Command = s.Command
-		sg.Params = s.Params
-		sg.Commands = nil // the copy presents exactly one command
-		if !s.IsShell {
-			sg.Shell = "" // {command, params} entries are always safe argv exec
-		}
-		out, err := e.runner.Run(ctx, &sg, s.Params, e.cfg.Env)
-		agg.Stdout += out.Stdout
-		agg.Stderr += out.Stderr
-		agg.Output += out.Output
-		agg.DurationMs += out.DurationMs
-		// First non-ok status wins (mirroring ExitCode): a soft-fail Runner's
-		// Status:"failed" must survive later successful commands, per the
-		// trust-the-runner contract in runGroup.
-		if agg.Status == "" || agg.Status == result.StatusOK {
-			agg.Status = out.Status
+		runCtx, done, err := e.commandStdin(ctx, group, i)
+		if err != nil {
+			return agg, err
 		}
-		if agg.ExitCode == 0 {
-			agg.ExitCode = out.ExitCode
+		out, err := e.runCommand(runCtx, seq, i, env, done)
+		if multi {
+			agg.Commands = append(agg.Commands, result.CommandResult{
+				Command: commands[i].Command, Params: commands[i].Params,
+				Stdout: out.Stdout, Stderr: out.Stderr, Output: out.Output,
+				ExitCode: out.ExitCode, DurationMs: out.DurationMs, Termination: out.Termination,
+			})
 		}
+		accumulate(&agg, &out)
 		if err != nil {
 			// Keep singular-group error strings identical to the pre-multi
 			// behavior; only decorate when there is a sequence to point into.
@@ -350,33 +777,131 @@ func (e *Engine) runSequence(ctx context.Context, group *config.Group, commands
 			}
 			return agg, err
 		}
+		if seq.mark, err = seq.files.mark(); err != nil {
+			return agg, fmt.Errorf("group %q: %w", group.Name, err)
+		}
+		seq.next, seq.done = i+1, agg
 	}
 	return agg, nil
 }
 
+// commandStdin returns the context command i runs under: the first one
+// reads the group's stdin:, and done closes it once the command ends.
+func (e *Engine) commandStdin(ctx context.Context, group *config.Group, i int) (context.Context, func() error, error) {
+	if i != 0 || group.Stdin == nil {
+		return ctx, func() error { return nil }, nil
+	}
+	in, finish, err := e.openStdin(group)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("group %q: stdin: %w", group.Name, err)
+	}
+	return withStdin(ctx, in), finish, nil
+}
+
+// runCommand runs command i of seq, bracketed by command.start and
+// command.end events in a multi-command group. done is called once it ends.
+func (e *Engine) runCommand(
+	ctx context.Context, seq *sequence, i int, env map[string]string, done func() error,
+) (result.RunResult, error) {
+	group, multi := seq.group, len(seq.commands) > 1
+	s := seq.commands[i]
+	sg := e.commandGroup(group, s)
+	seq.attempts[i]++
+	if multi {
+		e.emit(Event{Event: EventCommandStart, Group: group.Name, Phase: seq.phase, Command: i + 1})
+	}
+	out, err := e.runner.Run(ctx, &sg, s.Params, env)
+	if derr := done(); derr != nil && err == nil {
+		err = fmt.Errorf("stdin: %w", derr)
+	}
+	if multi {
+		status := StatusOK
+		if err != nil {
+			status = StatusFailed
+		}
+		e.emit(Event{
+			Event: EventCommandEnd, Group: group.Name, Phase: seq.phase, Command: i + 1,
+			Status: status, DurationMS: out.DurationMs, ExitCode: out.ExitCode,
+			Termination: out.Termination, Err: errString(err),
+		})
+	}
+	return out, err
+}
+
+// accumulate folds one command's result into the sequence's aggregate.
+func accumulate(agg, out *result.RunResult) {
+	agg.Stdout += out.Stdout
+	agg.Stderr += out.Stderr
+	agg.Output += out.Output
+	agg.DurationMs += out.DurationMs
+	// First non-ok status wins (mirroring ExitCode): a soft-fail Runner's
+	// Status:"failed" must survive later successful commands, per the
+	// trust-the-runner contract in runGroup.
+	if agg.Status == "" || agg.Status == result.StatusOK {
+		agg.Status = out.Status
+	}
+	if agg.ExitCode == 0 {
+		agg.ExitCode = out.ExitCode
+	}
+	agg.Termination = out.Termination
+	agg.Truncated = agg.Truncated || out.Truncated
+}
+
+// commandGroup returns the copy of group the runner sees for one of its
+// commands: exactly one command set, and the effective kill-grace.
+// Argv-form entries clear Shell so they always safe-exec; string-form entries
+// keep the group's shell.
+func (e *Engine) commandGroup(group *config.Group, s config.CommandSpec) config.Group {
+	sg := *group
+	sg.Command = s.Command
+	sg.Params = s.Params
+	sg.Commands = nil
+	if !s.IsShell {
+		sg.Shell = ""
+	}
+	if sg.KillGrace == "" {
+		sg.KillGrace = e.cfg.Settings.KillGrace
+	}
+	return sg
+}
+
 // cacheLookup returns the stored entry when caching is enabled for the group,
-// the fingerprint matches, and all declared writes still exist.
-func (e *Engine) cacheLookup(group *config.Group, commands []config.CommandSpec) (*cache.Entry, bool) {
+// the fingerprint matches, and all declared writes exist once the entry's
+// stored outputs are restored.
+func (e *Engine) cacheLookup(ctx context.Context, group *config.Group, commands []config.CommandSpec) (*cache.Entry, bool) {
 	if e.noCache || group.Cache == nil {
 		return nil, false
 	}
-	fp, err := cache.Compute(group.Cache, group.Shell, commands)
+	fp, err := e.fingerprint(ctx, group, commands)
 	if err != nil {
 		e.log.Warn("cache fingerprint failed; running group", "group", group.Name, "err", err.Error())
 		return nil, false
 	}
-	entry, ok := e.cache.Load(group.Name)
-	if !ok || entry.Fingerprint != fp {
+	entry, ok := cache.Find(e.cache, group.Name, fp)
+	if !ok {
 		return nil, false
 	}
-	if !cache.WritesPresent(group.Cache) {
+	if blobs, ok := e.cache.(cache.BlobStore); ok && len(entry.Artifacts) > 0 {
+		n, err := cache.Restore(group.Cache, group.Dir, entry.Artifacts, blobs)
+		if err != nil {
+			e.log.Warn("cache restore failed; running group", "group", group.Name, "err", err.Error())
+			return nil, false
+		}
+		if n > 0 {
+			e.log.Info("restored cached outputs", "group", group.Name, "files", n)
+		}
+	}
+	if !cache.WritesPresent(group.Cache, group.Dir) {
 		return nil, false
 	}
 	return entry, true
 }
 
-// cacheStore persists a fresh cache entry after a successful run.
-func (e *Engine) cacheStore(group *config.Group, commands []config.CommandSpec, out *result.RunResult) {
+// cacheStore persists a fresh cache entry after a successful run, with the
+// group's declared writes when the store keeps blobs.
+func (e *Engine) cacheStore(
+	ctx context.Context, group *config.Group, commands []config.CommandSpec, out *result.RunResult,
+) {
 	if e.noCache || group.Cache == nil {
 		return
 	}
@@ -384,17 +909,24 @@ func (e *Engine) cacheStore(group *config.Group, commands []config.CommandSpec,
 	// have rewritten its own cache.reads inputs (e.g. a formatter), and the
 	// stored fingerprint must reflect the post-run input state so the next
 	// run can hit.
-	fp, err := cache.Compute(group.Cache, group.Shell, commands)
+	fp, err := e.fingerprint(ctx, group, commands)
 	if err != nil {
 		e.log.Warn("cache fingerprint failed; not caching", "group", group.Name, "err", err.Error())
 		return
 	}
 	entry := &cache.Entry{
+		Group:       group.Name,
 		Fingerprint: fp,
 		Result:      *out,
 		Commands:    commands,
 		UpdatedAt:   time.Now(),
 	}
+	if blobs, ok := e.cache.(cache.BlobStore); ok && len(group.Cache.Writes) > 0 {
+		if entry.Artifacts, err = cache.Collect(group.Cache, group.Dir, blobs); err != nil {
+			e.log.Warn("cache outputs not stored; not caching", "group", group.Name, "err", err.Error())
+			return
+		}
+	}
 	if err := e.cache.Save(group.Name, entry); err != nil {
 		e.log.Warn("cache save failed", "group", group.Name, "err", err.Error())
 	}
@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"errors"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -300,3 +301,32 @@ func TestOutputStore_Snapshot(t *testing.T) {
 	v, _ := s.Get("a")
 	assert.Equal(t, "1", v.Output)
 }
+
+// dirRunner records the working directory each group was handed.
+type dirRunner struct {
+	mu   sync.Mutex
+	dirs map[string]string
+}
+
+func (r *dirRunner) Run(_ context.Context, g *config.Group, _ []string, _ map[string]string) (result.RunResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dirs[g.Name] = g.Dir
+	return result.RunResult{Stdout: "web\n", Output: "web\n", Status: result.StatusOK}, nil
+}
+
+func TestEngine_GroupDirResolvesAgainstWorkingDir(t *testing.T) {
+	cfg := stepFlowCfg(t, []config.Group{
+		{Name: "pick", Command: "echo"},
+		{Name: "inherit", Command: "echo"},
+		{Name: "build", Command: "make", Dir: `pkgs/{{ output "pick" }}`},
+	}, [][]string{{"pick", "inherit"}, {"build"}})
+	cfg.BaseDir = filepath.FromSlash("/repo")
+	cfg.Settings.WorkingDir = "src"
+	r := &dirRunner{dirs: map[string]string{}}
+	e := New(cfg, WithRunner(r))
+
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.Equal(t, filepath.FromSlash("/repo/src"), r.dirs["inherit"])
+	assert.Equal(t, filepath.FromSlash("/repo/src/pkgs/web"), r.dirs["build"])
+}
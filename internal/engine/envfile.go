@@ -0,0 +1,273 @@
+package engine
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/plan"
+)
+
+// Environment variables naming the files a command writes outputs and
+// exported env to (see envFiles).
+const (
+	EnvOutputFile = "KEEPUP_OUTPUT"
+	EnvExportFile = "KEEPUP_ENV"
+)
+
+// envFiles are the KEEPUP_OUTPUT and KEEPUP_ENV files of a group's run.
+// Every command of the sequence gets both paths and may append name=value
+// lines to them, or multi-line values as
+//
+//	name<<DELIM
+//	...
+//	DELIM
+//
+// KEEPUP_OUTPUT lines become the group's output values; KEEPUP_ENV lines
+// are exported to the groups that come after it (see scopeExports).
+type envFiles struct {
+	output, export string
+}
+
+// openEnvFiles creates a run's empty files.
+func openEnvFiles() (*envFiles, error) {
+	f := &envFiles{}
+	for _, p := range []*string{&f.output, &f.export} {
+		tmp, err := os.CreateTemp("", "keepup-env-*")
+		if err != nil {
+			f.remove()
+			return nil, err
+		}
+		*p = tmp.Name()
+		_ = tmp.Close()
+	}
+	return f, nil
+}
+
+// env returns base plus the files' variables.
+func (f *envFiles) env(base map[string]string) map[string]string {
+	out := make(map[string]string, len(base)+2)
+	maps.Copy(out, base)
+	out[EnvOutputFile] = f.output
+	out[EnvExportFile] = f.export
+	return out
+}
+
+// read parses both files.
+func (f *envFiles) read() (outputs, env map[string]string, err error) {
+	if outputs, err = readEnvFile(f.output); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", EnvOutputFile, err)
+	}
+	if env, err = readEnvFile(f.export); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", EnvExportFile, err)
+	}
+	return outputs, env, nil
+}
+
+// envMark records how far the files were written at some point of a
+// sequence.
+type envMark struct {
+	output, export int64
+}
+
+// mark returns the files' current sizes.
+func (f *envFiles) mark() (envMark, error) {
+	var m envMark
+	for p, n := range map[string]*int64{f.output: &m.output, f.export: &m.export} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return envMark{}, err
+		}
+		*n = fi.Size()
+	}
+	return m, nil
+}
+
+// rewind drops whatever was written to the files after m.
+func (f *envFiles) rewind(m envMark) error {
+	if err := os.Truncate(f.output, m.output); err != nil {
+		return err
+	}
+	return os.Truncate(f.export, m.export)
+}
+
+func (f *envFiles) remove() {
+	for _, p := range []string{f.output, f.export} {
+		if p != "" {
+			_ = os.Remove(p)
+		}
+	}
+}
+
+// readEnvFile parses a KEEPUP_OUTPUT / KEEPUP_ENV file; nil when it is
+// empty. A later line for the same name wins.
+func readEnvFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // a file keepup created
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]string
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var name, value string
+		eq, hd := strings.Index(line, "="), strings.Index(line, "<<")
+		switch {
+		case hd >= 0 && (eq < 0 || hd < eq):
+			delim := line[hd+2:]
+			name = line[:hd]
+			if delim == "" {
+				return nil, fmt.Errorf("line %d: %q has no delimiter", i+1, line)
+			}
+			start := i
+			var body []string
+			for i++; i < len(lines) && lines[i] != delim; i++ {
+				body = append(body, lines[i])
+			}
+			if i == len(lines) {
+				return nil, fmt.Errorf("line %d: %q is not closed by %q", start+1, name, delim)
+			}
+			value = strings.Join(body, "\n")
+		case eq >= 0:
+			name, value = line[:eq], line[eq+1:]
+		default:
+			return nil, fmt.Errorf("line %d: %q is not name=value", i+1, line)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("line %d: missing name", i+1)
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[name] = value
+	}
+	return out, nil
+}
+
+// envFor returns the env a group runs with: the config's env for it with
+// what the groups before it exported on top (see exportsFor). The group's
+// own env: still overrides both. group is nil for a step's when:.
+func (e *Engine) envFor(group *config.Group) map[string]string {
+	base := e.cfg.EnvFor(group)
+	name := ""
+	if group != nil {
+		name = group.Name
+	}
+	exported := e.exportsFor(name)
+	if len(exported) == 0 {
+		return base
+	}
+	out := make(map[string]string, len(base)+len(exported))
+	maps.Copy(out, base)
+	maps.Copy(out, exported)
+	return out
+}
+
+// exportsFor layers the env a member sees exported: what the embedding flow
+// passed down, then the exports of the members in its scope, in order (see
+// scopeExports). A hook, or a step's when: (name ""), sees every member's
+// exports so far. It returns nil when nothing applies.
+func (e *Engine) exportsFor(name string) map[string]string {
+	e.exportMu.Lock()
+	defer e.exportMu.Unlock()
+	scope, ok := e.exportScope[name]
+	if !ok {
+		scope = e.exportOrder
+	}
+	var out map[string]string
+	layer := func(env map[string]string) {
+		if len(env) == 0 {
+			return
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		maps.Copy(out, env)
+	}
+	layer(e.inherited)
+	for _, m := range scope {
+		layer(e.exports[m])
+	}
+	return out
+}
+
+// exportEnv records the KEEPUP_ENV variables a finished member exported.
+func (e *Engine) exportEnv(name string, env map[string]string) {
+	if len(env) == 0 {
+		return
+	}
+	e.exportMu.Lock()
+	defer e.exportMu.Unlock()
+	if e.exports == nil {
+		e.exports = map[string]map[string]string{}
+	}
+	e.exports[name] = env
+}
+
+// scopeExports sets which members' exports each member of p sees: in step
+// mode those of earlier steps, in dag mode those of its transitive
+// predecessors. Exports never cross between groups that may run at the
+// same time, so a group's env does not depend on scheduling. They layer in
+// plan order, a step's or a dag level's members in declaration order, so a
+// later exporter wins. p must be the whole flow, before any selection: the
+// unselected groups it seeds keep their place.
+func (e *Engine) scopeExports(p *plan.Plan) {
+	rank := make(map[string]int, len(p.Members))
+	if p.Mode == config.ModeDAG {
+		for _, m := range p.Members {
+			dagDepth(p, m, rank)
+		}
+	} else {
+		for i, wave := range p.Waves {
+			for _, m := range wave {
+				rank[m] = i
+			}
+		}
+	}
+	order := slices.Clone(p.Members)
+	slices.SortStableFunc(order, func(a, b string) int { return rank[a] - rank[b] })
+
+	scope := make(map[string][]string, len(order))
+	for _, m := range order {
+		before := func(o string) bool { return rank[o] < rank[m] }
+		if p.Mode == config.ModeDAG {
+			up := map[string]bool{}
+			ancestors(p, m, up)
+			before = func(o string) bool { return up[o] }
+		}
+		scope[m] = slices.DeleteFunc(slices.Clone(order), func(o string) bool { return !before(o) })
+	}
+	e.exportMu.Lock()
+	e.exportOrder, e.exportScope = order, scope
+	e.exportMu.Unlock()
+}
+
+// dagDepth returns the length of the longest path from a root to m,
+// memoized in depth.
+func dagDepth(p *plan.Plan, m string, depth map[string]int) int {
+	if d, ok := depth[m]; ok {
+		return d
+	}
+	d := 0
+	for _, pred := range p.Predecessors[m] {
+		d = max(d, dagDepth(p, pred, depth)+1)
+	}
+	depth[m] = d
+	return d
+}
+
+// ancestors adds m's transitive predecessors to seen.
+func ancestors(p *plan.Plan, m string, seen map[string]bool) {
+	for _, pred := range p.Predecessors[m] {
+		if !seen[pred] {
+			seen[pred] = true
+			ancestors(p, pred, seen)
+		}
+	}
+}
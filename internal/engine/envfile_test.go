@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "empty", data: "", want: nil},
+		{name: "pairs", data: "a=1\n\nb=x=y\na=2\n", want: map[string]string{"a": "2", "b": "x=y"}},
+		{name: "empty value", data: "a=\n", want: map[string]string{"a": ""}},
+		{name: "heredoc", data: "notes<<EOF\nline 1\n\nline=3\nEOF\nb=2\n", want: map[string]string{"notes": "line 1\n\nline=3", "b": "2"}},
+		{name: "crlf", data: "a=1\r\nn<<E\r\nx\r\nE\r\n", want: map[string]string{"a": "1", "n": "x"}},
+		{name: "heredoc marker in a value", data: "a=b<<c\n", want: map[string]string{"a": "b<<c"}},
+		{name: "unclosed heredoc", data: "n<<EOF\nx\n", wantErr: `line 1: "n" is not closed by "EOF"`},
+		{name: "no delimiter", data: "n<<\n", wantErr: "has no delimiter"},
+		{name: "no equals", data: "a=1\njunk\n", wantErr: `line 2: "junk" is not name=value`},
+		{name: "no name", data: "=1\n", wantErr: "line 1: missing name"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "out")
+			require.NoError(t, os.WriteFile(path, []byte(tc.data), 0o600))
+			got, err := readEnvFile(path)
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestEngine_EnvFiles(t *testing.T) {
+	skipOnWindows(t)
+	t.Parallel()
+	store := cache.NewFileStore(t.TempDir())
+	cfg := stepFlowCfg(t, []config.Group{
+		{
+			Name: "meta", Shell: "/bin/sh", Cache: &config.Cache{Method: config.CacheHash, Reads: []string{}},
+			Command: `echo noise; echo tag=v1 >> "$KEEPUP_OUTPUT"; ` +
+				`printf 'notes<<EOF\nl1\nl2\nEOF\n' >> "$KEEPUP_OUTPUT"; echo GREETING=hi >> "$KEEPUP_ENV"`,
+		},
+		{Name: "use", Shell: "/bin/sh", Command: `echo '{{ (out "meta").Values.tag }} {{ env "GREETING" }}' "$GREETING"`},
+		{Name: "own", Shell: "/bin/sh", Command: `echo "$GREETING"`, Env: map[string]string{"GREETING": "own"}},
+	}, [][]string{{"meta"}, {"use", "own"}})
+	run := func() *Engine {
+		e := New(cfg, WithRunner(&ShellRunner{Stdout: io.Discard, Stderr: io.Discard}), WithCache(store))
+		require.NoError(t, e.RunFlow(context.Background(), "f"))
+		return e
+	}
+	get := func(e *Engine, name string) string {
+		out, ok := e.Outputs().Get(name)
+		require.True(t, ok, name)
+		return out.Stdout
+	}
+
+	e := run()
+	meta, _ := e.Outputs().Get("meta")
+	assert.Equal(t, "noise\n", meta.Stdout, "values stay out of stdout")
+	assert.Equal(t, map[string]any{"tag": "v1", "notes": "l1\nl2"}, meta.Values)
+	assert.Equal(t, map[string]string{"GREETING": "hi"}, meta.EnvFile)
+	assert.Equal(t, "v1 hi hi\n", get(e, "use"))
+	assert.Equal(t, "own\n", get(e, "own"), "a group's env: overrides exports")
+
+	e = run()
+	meta, _ = e.Outputs().Get("meta")
+	require.Equal(t, "cached", meta.Status)
+	assert.Equal(t, "v1 hi hi\n", get(e, "use"), "a cache hit replays values and exports")
+}
+
+func TestEngine_EnvFilesExportAlongDAGEdges(t *testing.T) {
+	skipOnWindows(t)
+	t.Parallel()
+	cfg := dagFlowCfg(t, []config.Group{
+		{Name: "slow", Shell: "/bin/sh", Command: `sleep 0.2; echo TAG=slow >> "$KEEPUP_ENV"`},
+		{Name: "side", Shell: "/bin/sh", Command: `echo LEAK=1 >> "$KEEPUP_ENV"; echo TAG=side >> "$KEEPUP_ENV"`},
+		{Name: "mid", Shell: "/bin/sh", Command: `: {{ (out "slow").Status }}; echo TAG=mid >> "$KEEPUP_ENV"`},
+		{Name: "last", Shell: "/bin/sh", Command: `: {{ (out "mid").Status }}; echo "$TAG ${LEAK:-none}"`},
+	}, []string{"slow", "side", "mid", "last"})
+	e := New(cfg, WithRunner(&ShellRunner{Stdout: io.Discard, Stderr: io.Discard}))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	last, _ := e.Outputs().Get("last")
+	assert.Equal(t, "mid none\n", last.Stdout,
+		"the nearest exporter upstream wins and the parallel branch does not leak in")
+}
+
+func TestEngine_EnvFilesPerAttempt(t *testing.T) {
+	skipOnWindows(t)
+	t.Parallel()
+	marker := filepath.Join(t.TempDir(), "tried")
+	cfg := stepFlowCfg(t, []config.Group{{
+		Name: "flaky", Shell: "/bin/sh",
+		Command: `echo try=$(test -e ` + marker + ` && echo 2 || echo 1) >> "$KEEPUP_OUTPUT"; ` +
+			`test -e ` + marker + ` || { touch ` + marker + `; exit 1; }`,
+	}}, [][]string{{"flaky"}})
+	cfg.Flows["f"] = config.Flow{Mode: config.ModeStep, Retries: 1, Steps: cfg.Flows["f"].Steps}
+	e := New(cfg, WithRunner(&ShellRunner{Stdout: io.Discard, Stderr: io.Discard}), WithRetryBackoff(0))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	out, _ := e.Outputs().Get("flaky")
+	assert.Equal(t, map[string]string{"try": "2"}, out.OutputFile, "each attempt starts with empty files")
+}
+
+func TestEngine_EnvFilesResumeRewinds(t *testing.T) {
+	skipOnWindows(t)
+	t.Parallel()
+	marker := filepath.Join(t.TempDir(), "tried")
+	cfg := stepFlowCfg(t, []config.Group{{
+		Name: "flaky", Shell: "/bin/sh", RetryScope: config.RetryScopeCommand,
+		Commands: []config.CommandSpec{
+			{Command: `echo setup=1 >> "$KEEPUP_OUTPUT"`, IsShell: true},
+			{
+				Command: `test -e ` + marker + ` || { echo stale=1 >> "$KEEPUP_OUTPUT"; touch ` + marker + `; exit 1; }; ` +
+					`echo done=1 >> "$KEEPUP_OUTPUT"`,
+				IsShell: true,
+			},
+		},
+	}}, [][]string{{"flaky"}})
+	cfg.Flows["f"] = config.Flow{Mode: config.ModeStep, Retries: 1, Steps: cfg.Flows["f"].Steps}
+	e := New(cfg, WithRunner(&ShellRunner{Stdout: io.Discard, Stderr: io.Discard}), WithRetryBackoff(0))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	out, _ := e.Outputs().Get("flaky")
+	assert.Equal(t, map[string]string{"setup": "1", "done": "1"}, out.OutputFile,
+		"the resumed command's failed attempt is rewound; the earlier command's lines stay")
+	assert.Equal(t, []int{1, 2}, out.Attempts)
+}
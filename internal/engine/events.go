@@ -13,30 +13,70 @@ const (
 	EventFlowEnd      = "flow.end"
 	EventGroupStart   = "group.start"
 	EventGroupEnd     = "group.end"
+	EventGroupRetry   = "group.retry"
+	EventCommandStart = "command.start"
+	EventCommandEnd   = "command.end"
+	EventHookStart    = "hook.start"
+	EventHookEnd      = "hook.end"
 	EventWatchTrigger = "watch.trigger"
+	EventServiceStop  = "service.stop"
 )
 
-// Group end statuses.
+// Group end statuses. StatusSoftFailed marks a group (or, on flow.end, a
+// flow) that failed only under allow-failure; StatusResumed marks a group
+// whose output was replayed from a saved run by --resume.
 const (
-	StatusOK       = "ok"
-	StatusFailed   = "failed"
-	StatusSkipped  = "skipped"
-	StatusCacheHit = "cache-hit"
-	StatusDryRun   = "dry-run"
+	StatusOK         = "ok"
+	StatusFailed     = "failed"
+	StatusSoftFailed = "soft-failed"
+	StatusSkipped    = "skipped"
+	StatusCacheHit   = "cache-hit"
+	StatusDryRun     = "dry-run"
+	StatusResumed    = "resumed"
 )
 
 // Event is a single structured run event for machine consumption (CI tooling).
+//
+// ID names the flow run an event belongs to. For a top-level flow it is the
+// flow's name; a flow embedded in another gets the embedding run's id plus
+// its own name ("ci/lint"), and its flow.start / flow.end carry that parent
+// id in Parent.
+//
+// Termination is set on the group.end of a group whose command was stopped
+// before it finished: "timeout", "cancel", or "signal". Log is set on the
+// group.end of a group whose capture: limit cut its output: the path of the
+// file holding all of it.
+//
+// group.retry precedes each retry of a failed group: Attempt is the attempt
+// about to start, DelayMS the wait before it, and Err why the last one
+// failed.
+//
+// command.start and command.end bracket each command of a multi-command
+// group, numbered from 1 in Command; command.end carries the command's own
+// Status, DurationMS, ExitCode, Termination and Err.
+//
+// A service group ends (group.end) once it is ready; service.stop follows
+// when the flow stops it, failed if the service had already exited.
 type Event struct {
-	Event      string    `json:"event"`
-	Flow       string    `json:"flow,omitempty"`
-	Group      string    `json:"group,omitempty"`
-	Mode       string    `json:"mode,omitempty"`
-	Status     string    `json:"status,omitempty"`
-	DurationMS int64     `json:"durationMs,omitempty"`
-	Err        string    `json:"err,omitempty"`
-	Reason     string    `json:"reason,omitempty"`
-	Files      []string  `json:"files,omitempty"`
-	Time       time.Time `json:"time"`
+	Event       string    `json:"event"`
+	ID          string    `json:"id,omitempty"`
+	Parent      string    `json:"parent,omitempty"`
+	Flow        string    `json:"flow,omitempty"`
+	Group       string    `json:"group,omitempty"`
+	Phase       string    `json:"phase,omitempty"`
+	Mode        string    `json:"mode,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	DurationMS  int64     `json:"durationMs,omitempty"`
+	Err         string    `json:"err,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Termination string    `json:"termination,omitempty"`
+	Log         string    `json:"log,omitempty"`
+	Attempt     int       `json:"attempt,omitempty"`
+	DelayMS     int64     `json:"delayMs,omitempty"`
+	Command     int       `json:"command,omitempty"`
+	ExitCode    int       `json:"exitCode,omitempty"`
+	Files       []string  `json:"files,omitempty"`
+	Time        time.Time `json:"time"`
 }
 
 // Emitter receives lifecycle events. Implementations must be safe for
@@ -74,6 +114,14 @@ func (e *JSONEmitter) Emit(ev Event) { //nolint:gocritic // value receiver keeps
 	_, _ = e.w.Write(append(b, '\n'))
 }
 
+// emit stamps ev with the current flow run's id and hands it to the emitter.
+func (e *Engine) emit(ev Event) { //nolint:gocritic // mirrors Emitter.Emit
+	if ev.ID == "" {
+		ev.ID = e.flowID
+	}
+	e.emitter.Emit(ev)
+}
+
 func msSince(start time.Time) int64 { return time.Since(start).Milliseconds() }
 
 func errString(err error) string {
@@ -12,6 +12,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
 )
 
 func decodeEvents(t *testing.T, b []byte) []Event {
@@ -172,3 +173,28 @@ func TestEvent_FilesOmitEmpty(t *testing.T) {
 	assert.NotContains(t, string(b), `"files"`,
 		"flow.start (and every other non-watch event) must omit files from JSON")
 }
+
+// stoppedRunner fails every group as if its process had been stopped.
+type stoppedRunner struct{ reason string }
+
+func (s stoppedRunner) Run(context.Context, *config.Group, []string, map[string]string) (result.RunResult, error) {
+	return result.RunResult{ExitCode: -1, Termination: s.reason}, errors.New("stopped")
+}
+
+func TestJSONEmitter_Termination(t *testing.T) {
+	t.Parallel()
+	cfg := stepFlowCfg(t, []config.Group{{Name: "a", Command: "sleep"}}, [][]string{{"a"}})
+	var buf bytes.Buffer
+	e := New(cfg, WithRunner(stoppedRunner{reason: result.TerminationTimeout}), WithEmitter(NewJSONEmitter(&buf)))
+	require.Error(t, e.RunFlow(context.Background(), "f"))
+
+	evs := decodeEvents(t, buf.Bytes())
+	for i := range evs {
+		if evs[i].Event == EventGroupEnd {
+			assert.Equal(t, StatusFailed, evs[i].Status)
+			assert.Equal(t, result.TerminationTimeout, evs[i].Termination)
+		}
+	}
+	assert.NotContains(t, strings.Split(buf.String(), "\n")[0], `"termination"`,
+		"events other than a stopped group.end omit termination")
+}
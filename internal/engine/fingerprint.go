@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+)
+
+// fingerprint computes a resolved group's cache fingerprint for commands,
+// covering the flow args it reads, the group's stdin:, and its env and tool
+// inputs besides its cache.reads.
+func (e *Engine) fingerprint(ctx context.Context, group *config.Group, commands []config.CommandSpec) (string, error) {
+	in := cache.Inputs{Args: e.cacheArgs(group), Env: e.cacheEnv(group)}
+	switch s := group.Stdin; {
+	case s == nil:
+	case s.File != "":
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", fmt.Errorf("stdin: %w", err)
+		}
+		in.Stdin = cache.Digest(data)
+	default:
+		in.Stdin = cache.Digest([]byte(s.Template))
+	}
+	for _, tool := range group.Cache.Tools {
+		out, err := e.toolOutput(ctx, group, tool)
+		if err != nil {
+			return "", err
+		}
+		if in.Tools == nil {
+			in.Tools = map[string]string{}
+		}
+		in.Tools[tool] = out
+	}
+	return cache.Compute(group.Cache, group.Dir, group.Shell, commands, in)
+}
+
+// cacheArgs returns the flow args a group's fingerprint covers: those its
+// templates read, so an arg it never reads does not bust its entry. A group
+// that computes an arg's name covers them all.
+func (e *Engine) cacheArgs(group *config.Group) map[string]string {
+	names, all, err := group.ArgRefs()
+	if err != nil || all {
+		return e.flowArgs
+	}
+	var out map[string]string
+	for _, name := range names {
+		v, ok := e.flowArgs[name]
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[name] = v
+	}
+	return out
+}
+
+// cacheEnv returns the env a group's fingerprint covers: its own env: map,
+// plus the value each cache.env variable has when the group runs. A
+// variable that is set nowhere is left out.
+func (e *Engine) cacheEnv(group *config.Group) map[string]string {
+	out := maps.Clone(group.Env)
+	if len(group.Cache.Env) == 0 {
+		return out
+	}
+	base := e.envFor(group)
+	for _, name := range group.Cache.Env {
+		v, ok := group.Env[name]
+		if !ok {
+			v, ok = base[name]
+		}
+		if !ok {
+			v, ok = os.LookupEnv(name)
+		}
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[name] = v
+	}
+	return out
+}
+
+// toolMemo holds the output of the cache.tools commands run so far, by
+// working directory, shell, env and command. A top-level RunFlow starts it
+// afresh and shares it with the flows it embeds, so each tool runs once per
+// run for the groups that would run it the same way.
+type toolMemo struct {
+	mu   sync.Mutex
+	runs map[string]*toolRun
+}
+
+type toolRun struct {
+	once sync.Once
+	out  string
+	err  error
+}
+
+// toolOutput returns the combined output of a cache.tools command, run
+// through the group's shell in its directory and env the first time a
+// group of this run asks for it that way. A failing tool fails the
+// fingerprint.
+func (e *Engine) toolOutput(ctx context.Context, group *config.Group, tool string) (string, error) {
+	shell := pickShell(group.Shell)
+	env := make(map[string]string)
+	maps.Copy(env, e.envFor(group))
+	maps.Copy(env, group.Env)
+	key := toolKey(group.Dir, shell, tool, env)
+	e.tools.mu.Lock()
+	if e.tools.runs == nil {
+		e.tools.runs = map[string]*toolRun{}
+	}
+	run, ok := e.tools.runs[key]
+	if !ok {
+		run = &toolRun{}
+		e.tools.runs[key] = run
+	}
+	e.tools.mu.Unlock()
+
+	run.once.Do(func() {
+		cmd := exec.CommandContext(ctx, shell, shellFlag(), tool) //nolint:gosec // user-declared tool command
+		cmd.Dir = group.Dir
+		cmd.Env = mergeEnvs(os.Environ(), env)
+		out, err := cmd.CombinedOutput()
+		run.out = string(out)
+		if err != nil {
+			run.err = fmt.Errorf("cache.tools: %q: %w", tool, err)
+		}
+	})
+	return run.out, run.err
+}
+
+// toolKey identifies a tool run in the toolMemo. The process env is the
+// same for every group, so only the env keepup layers on top counts.
+func toolKey(dir, shell, tool string, env map[string]string) string {
+	var b strings.Builder
+	for _, s := range []string{dir, shell, tool} {
+		b.WriteString(s)
+		b.WriteByte(0)
+	}
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		b.WriteString(k + "=" + env[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/cache/cachetest"
 	"github.com/quike/keepup/internal/config"
 )
 
@@ -31,7 +32,7 @@ type scriptedProber struct {
 	calls   []string
 }
 
-func (p *scriptedProber) Probe(_ context.Context, script string, _ map[string]string) error {
+func (p *scriptedProber) Probe(_ context.Context, script, _ string, _ map[string]string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.calls = append(p.calls, script)
@@ -97,13 +98,15 @@ func TestEngine_SkipIf(t *testing.T) {
 	})
 }
 
-// cacheGroupCfg builds a one-group step flow whose group caches readPath.
+// cacheGroupCfg builds a one-group step flow whose group caches readPath,
+// running in the directory that holds it.
 func cacheGroupCfg(t *testing.T, readPath string, writes ...string) *config.Config {
 	t.Helper()
 	return stepFlowCfg(t, []config.Group{
 		{
 			Name:    "build",
 			Command: "echo",
+			Dir:     filepath.Dir(readPath),
 			Cache:   &config.Cache{Method: config.CacheHash, Reads: []string{readPath}, Writes: writes},
 		},
 	}, [][]string{{"build"}})
@@ -145,6 +148,104 @@ func TestEngine_Cache_InputChangeBusts(t *testing.T) {
 	assert.Equal(t, []string{"build:"}, r2.calls, "changed input must re-run")
 }
 
+func TestEngine_Cache_EnvAndToolsBust(t *testing.T) {
+	skipOnWindows(t)
+	dir := t.TempDir()
+	readPath := filepath.Join(dir, "main.go")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	version := filepath.Join(dir, "version")
+	require.NoError(t, writeF(version, "1.0\n"))
+	store := cache.NewFileStore(filepath.Join(dir, "cache"))
+
+	run := func(mutate func(*config.Config)) []string {
+		cfg := cacheGroupCfg(t, readPath)
+		g := &cfg.Groups[0]
+		g.Env = map[string]string{"GOFLAGS": "-trimpath"}
+		g.Cache.Env = []string{"KEEPUP_TEST_TARGET"}
+		g.Cache.Tools = []string{"cat " + version}
+		if mutate != nil {
+			mutate(cfg)
+		}
+		r := &fakeRunner{}
+		require.NoError(t, New(cfg, WithRunner(r), WithCache(store)).RunFlow(context.Background(), "f"))
+		return r.calls
+	}
+
+	assert.Equal(t, []string{"build:"}, run(nil), "first run misses")
+	assert.Empty(t, run(nil), "unchanged env and tools hit")
+	assert.Equal(t, []string{"build:"}, run(func(c *config.Config) {
+		c.Groups[0].Env["GOFLAGS"] = "-race"
+	}), "the group's own env: is an input")
+	assert.Equal(t, []string{"build:"}, run(func(c *config.Config) {
+		c.Env = map[string]string{"KEEPUP_TEST_TARGET": "linux"}
+	}), "a cache.env variable is an input")
+	assert.Empty(t, run(func(c *config.Config) {
+		c.Env = map[string]string{"KEEPUP_TEST_TARGET": "linux", "UNLISTED": "x"}
+	}), "an unlisted variable is not")
+	require.NoError(t, writeF(version, "1.1\n"))
+	assert.Equal(t, []string{"build:"}, run(nil), "a tool's output is an input")
+}
+
+func TestEngine_Cache_ToolsRunOncePerRun(t *testing.T) {
+	skipOnWindows(t)
+	dir := t.TempDir()
+	readPath := filepath.Join(dir, "main.go")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	count := filepath.Join(dir, "count")
+	tool := "echo x >> " + count + "; echo v1"
+	groups := []config.Group{
+		{Name: "a", Command: "echo", Cache: &config.Cache{Method: config.CacheHash, Reads: []string{readPath}, Tools: []string{tool}}},
+		{Name: "b", Command: "echo", Cache: &config.Cache{Method: config.CacheHash, Reads: []string{readPath}, Tools: []string{tool}}},
+	}
+	e := New(stepFlowCfg(t, groups, [][]string{{"a", "b"}}),
+		WithRunner(&fakeRunner{}), WithCache(cache.NewFileStore(filepath.Join(dir, "cache"))))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	data, err := os.ReadFile(count)
+	require.NoError(t, err)
+	assert.Equal(t, "x\n", string(data), "lookups and stores of both groups share one tool run")
+
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	data, err = os.ReadFile(count)
+	require.NoError(t, err)
+	assert.Equal(t, "x\nx\n", string(data), "the next run asks again")
+}
+
+func TestEngine_Cache_ToolsRunPerEnv(t *testing.T) {
+	skipOnWindows(t)
+	dir := t.TempDir()
+	readPath := filepath.Join(dir, "main.go")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	count := filepath.Join(dir, "count")
+	group := func(name, target string) config.Group {
+		return config.Group{
+			Name: name, Command: "echo", Env: map[string]string{"TARGET": target},
+			Cache: &config.Cache{Method: config.CacheHash, Reads: []string{readPath}, Tools: []string{`echo "$TARGET" >> ` + count}},
+		}
+	}
+	groups := []config.Group{group("a", "linux"), group("b", "darwin"), group("c", "linux")}
+	e := New(stepFlowCfg(t, groups, [][]string{{"a"}, {"b"}, {"c"}}),
+		WithRunner(&fakeRunner{}), WithCache(cache.NewFileStore(filepath.Join(dir, "cache"))))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	data, err := os.ReadFile(count)
+	require.NoError(t, err)
+	assert.Equal(t, "linux\ndarwin\n", string(data), "a tool runs again under another env, not under the same one")
+}
+
+func TestEngine_Cache_FailingToolRuns(t *testing.T) {
+	skipOnWindows(t)
+	dir := t.TempDir()
+	readPath := filepath.Join(dir, "main.go")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	cfg := cacheGroupCfg(t, readPath)
+	cfg.Groups[0].Cache.Tools = []string{"exit 3"}
+	store := cache.NewFileStore(filepath.Join(dir, "cache"))
+	for range 2 {
+		r := &fakeRunner{}
+		require.NoError(t, New(cfg, WithRunner(r), WithCache(store)).RunFlow(context.Background(), "f"))
+		assert.Equal(t, []string{"build:"}, r.calls, "no fingerprint: the group always runs")
+	}
+}
+
 func TestEngine_Cache_NoCacheBypasses(t *testing.T) {
 	dir := t.TempDir()
 	readPath := filepath.Join(dir, "main.go")
@@ -160,13 +261,16 @@ func TestEngine_Cache_NoCacheBypasses(t *testing.T) {
 	assert.Equal(t, []string{"build:"}, r2.calls, "--no-cache forces a run")
 }
 
+// entryStore is a cache.Store that keeps no blobs.
+type entryStore struct{ cache.Store }
+
 func TestEngine_Cache_MissingWriteInvalidates(t *testing.T) {
 	dir := t.TempDir()
 	readPath := filepath.Join(dir, "main.go")
 	writePath := filepath.Join(dir, "bin", "app")
 	require.NoError(t, writeF(readPath, "package main\n"))
 	require.NoError(t, writeF(writePath, "binary"))
-	store := cache.NewFileStore(filepath.Join(dir, "cache"))
+	store := entryStore{cache.NewFileStore(filepath.Join(dir, "cache"))}
 	cfg := cacheGroupCfg(t, readPath, writePath)
 
 	r1 := &fakeRunner{outputs: map[string]string{"build": "x\n"}}
@@ -177,7 +281,127 @@ func TestEngine_Cache_MissingWriteInvalidates(t *testing.T) {
 	r2 := &fakeRunner{outputs: map[string]string{"build": "x\n"}}
 	e2 := New(cfg, WithRunner(r2), WithCache(store))
 	require.NoError(t, e2.RunFlow(context.Background(), "f"))
-	assert.Equal(t, []string{"build:"}, r2.calls, "missing write must invalidate the cache")
+	assert.Equal(t, []string{"build:"}, r2.calls, "without stored outputs a missing write invalidates the cache")
+}
+
+func TestEngine_Cache_RestoresWrites(t *testing.T) {
+	dir := t.TempDir()
+	readPath := filepath.Join(dir, "main.go")
+	binPath := filepath.Join(dir, "bin", "app")
+	docPath := filepath.Join(dir, "dist", "docs", "index.html")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	require.NoError(t, writeF(binPath, "binary"))
+	require.NoError(t, writeF(docPath, "<html>"))
+	store := cache.NewFileStore(filepath.Join(dir, "cache"))
+	cfg := cacheGroupCfg(t, readPath, binPath, filepath.Join(dir, "dist"))
+
+	r1 := &fakeRunner{outputs: map[string]string{"build": "x\n"}}
+	require.NoError(t, New(cfg, WithRunner(r1), WithCache(store)).RunFlow(context.Background(), "f"))
+	entry, ok := store.Load("build")
+	require.True(t, ok)
+	require.Len(t, entry.Artifacts, 2, "a matched directory stores the files under it")
+
+	// A fresh checkout: outputs gone or stale.
+	require.NoError(t, removeF(binPath))
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "dist")))
+
+	r2 := &fakeRunner{}
+	require.NoError(t, New(cfg, WithRunner(r2), WithCache(store)).RunFlow(context.Background(), "f"))
+	assert.Empty(t, r2.calls, "restored outputs make a hit")
+	for path, want := range map[string]string{binPath: "binary", docPath: "<html>"} {
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	}
+}
+
+func TestEngine_Cache_CorruptBlobRuns(t *testing.T) {
+	dir := t.TempDir()
+	readPath := filepath.Join(dir, "main.go")
+	binPath := filepath.Join(dir, "bin", "app")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	require.NoError(t, writeF(binPath, "binary"))
+	cacheDir := filepath.Join(dir, "cache")
+	store := cache.NewFileStore(cacheDir)
+	cfg := cacheGroupCfg(t, readPath, binPath)
+
+	require.NoError(t, New(cfg, WithRunner(&fakeRunner{}), WithCache(store)).RunFlow(context.Background(), "f"))
+	blobs, err := filepath.Glob(filepath.Join(cacheDir, "blobs", "*", "*"))
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	require.NoError(t, writeF(blobs[0], "tampered"))
+	require.NoError(t, removeF(binPath))
+
+	r2 := &fakeRunner{}
+	require.NoError(t, New(cfg, WithRunner(r2), WithCache(store)).RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"build:"}, r2.calls, "a blob that fails its digest is a miss")
+}
+
+func TestEngine_Cache_OutsideWriteHits(t *testing.T) {
+	root := t.TempDir()
+	readPath := filepath.Join(root, "work", "main.go")
+	gen := filepath.Join(root, "gen.txt")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	require.NoError(t, writeF(gen, "generated"))
+	store := cache.NewFileStore(filepath.Join(root, "cache"))
+	cfg := cacheGroupCfg(t, readPath, gen)
+
+	require.NoError(t, New(cfg, WithRunner(&fakeRunner{}), WithCache(store)).RunFlow(context.Background(), "f"))
+	r2 := &fakeRunner{}
+	require.NoError(t, New(cfg, WithRunner(r2), WithCache(store)).RunFlow(context.Background(), "f"))
+	assert.Empty(t, r2.calls, "a write outside the group's directory still caches")
+
+	require.NoError(t, removeF(gen))
+	r3 := &fakeRunner{}
+	require.NoError(t, New(cfg, WithRunner(r3), WithCache(store)).RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"build:"}, r3.calls, "but is not restored once gone")
+}
+
+func TestEngine_Cache_HostileEntryRuns(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "work")
+	readPath := filepath.Join(dir, "main.go")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	require.NoError(t, writeF(filepath.Join(dir, "bin", "app"), "binary"))
+	store := cache.NewFileStore(filepath.Join(root, "cache"))
+	cfg := cacheGroupCfg(t, readPath, "bin")
+
+	require.NoError(t, New(cfg, WithRunner(&fakeRunner{}), WithCache(store)).RunFlow(context.Background(), "f"))
+	entry, ok := store.Load("build")
+	require.True(t, ok)
+	require.Len(t, entry.Artifacts, 1)
+	entry.Artifacts = append(entry.Artifacts, cache.Artifact{Path: "../escaped", Digest: entry.Artifacts[0].Digest})
+	require.NoError(t, store.Save("build", entry))
+
+	r2 := &fakeRunner{}
+	require.NoError(t, New(cfg, WithRunner(r2), WithCache(store)).RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"build:"}, r2.calls, "an entry writing outside the group's writes is a miss")
+	assert.NoFileExists(t, filepath.Join(root, "escaped"))
+}
+
+func TestEngine_Cache_RemoteSharesHits(t *testing.T) {
+	dir := t.TempDir()
+	readPath := filepath.Join(dir, "main.go")
+	binPath := filepath.Join(dir, "bin", "app")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	require.NoError(t, writeF(binPath, "binary"))
+	srv := cachetest.NewServer(t)
+	cfg := cacheGroupCfg(t, readPath, binPath)
+	tiered := func() cache.Store {
+		remote := cache.NewHTTPCache(srv.URL, cache.HTTPOptions{})
+		return cache.NewTiered(context.Background(), cache.NewFileStore(t.TempDir()), remote, false, nil)
+	}
+
+	require.NoError(t, New(cfg, WithRunner(&fakeRunner{}), WithCache(tiered())).RunFlow(context.Background(), "f"))
+	require.NoError(t, removeF(binPath))
+
+	// A second machine with an empty local cache.
+	r2 := &fakeRunner{}
+	require.NoError(t, New(cfg, WithRunner(r2), WithCache(tiered())).RunFlow(context.Background(), "f"))
+	assert.Empty(t, r2.calls, "the remote entry is a hit")
+	got, err := os.ReadFile(binPath)
+	require.NoError(t, err)
+	assert.Equal(t, "binary", string(got), "outputs come from the remote blobs")
 }
 
 func TestEngine_Cache_DryRunSkipsGatingAndCache(t *testing.T) {
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/quike/keepup/internal/config"
+)
+
+// flowRunning is the template-visible flow status while the main plan runs.
+const flowRunning = "running"
+
+// runHooks runs the flow's on-success / on-failure and finally groups after
+// the main plan has finished with mainErr. Hooks run one at a time in
+// declared order, each against a fresh output snapshot, so a teardown can
+// read what the plan (and earlier hooks) produced. A failing hook does not
+// stop the remaining hooks; its error is joined onto mainErr.
+//
+// Hooks exist for cleanup, so they run on a context detached from ctx's
+// cancellation: a Ctrl-C that aborted the plan still tears down. The flow's
+// timeout/retries envelope applies to each hook group; without a timeout
+// from the flow or the group, each attempt is bounded by e.hookTimeout.
+func (e *Engine) runHooks(ctx context.Context, flow *config.Flow, mainErr error) error {
+	hooks := flow.Hooks()
+	if len(hooks) == 0 {
+		return mainErr
+	}
+	e.flow.Status, e.flow.Err = StatusOK, errString(mainErr)
+	switch {
+	case mainErr != nil:
+		e.flow.Status = StatusFailed
+	case len(e.SoftFailures()) > 0:
+		e.flow.Status = StatusSoftFailed
+	}
+
+	hctx := context.WithoutCancel(ctx)
+	base := resolveEnvelope(flow, nil)
+	if base.timeout == 0 {
+		base.timeout = e.hookTimeout
+	}
+	var hookErrs []error
+	for _, phase := range []string{config.PhaseOnSuccess, config.PhaseOnFailure, config.PhaseFinally} {
+		var names []string
+		for _, h := range hooks {
+			if h.Phase == phase {
+				names = append(names, h.Group)
+			}
+		}
+		if len(names) == 0 || !phaseApplies(phase, mainErr) {
+			continue
+		}
+		hookErrs = append(hookErrs, e.runHookPhase(hctx, phase, names, base))
+	}
+	if herr := errors.Join(hookErrs...); herr != nil {
+		return errors.Join(mainErr, herr)
+	}
+	return mainErr
+}
+
+// runHookPhase runs one hook list and reports it as a hook.start / hook.end
+// pair around the member groups' own events.
+func (e *Engine) runHookPhase(ctx context.Context, phase string, names []string, base envelope) error {
+	e.log.Info("running hooks", "phase", phase, "groups", names)
+	e.emit(Event{Event: EventHookStart, Flow: e.flow.Name, Phase: phase})
+	start := time.Now()
+	env := base
+	env.phase = phase
+	var errs []error
+	for _, name := range names {
+		group := e.groups[name]
+		if err := e.runGroup(ctx, &group, e.outputs.Snapshot(), env); err != nil {
+			e.log.Error("hook failed", "phase", phase, "group", name, "err", err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", phase, err))
+		}
+	}
+	err := errors.Join(errs...)
+	status := StatusOK
+	if err != nil {
+		status = StatusFailed
+	}
+	e.emit(Event{
+		Event: EventHookEnd, Flow: e.flow.Name, Phase: phase, Status: status,
+		DurationMS: msSince(start), Err: errString(err),
+	})
+	return err
+}
+
+// phaseApplies reports whether a hook phase runs for the main plan's outcome.
+// Soft failures count as success.
+func phaseApplies(phase string, mainErr error) bool {
+	switch phase {
+	case config.PhaseOnSuccess:
+		return mainErr == nil
+	case config.PhaseOnFailure:
+		return mainErr != nil
+	default:
+		return true
+	}
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/config"
+)
+
+// hookCfg builds a two-step flow (up, test) with the given hook lists over the
+// db-down / notify-ok / notify-fail groups.
+func hookCfg(onSuccess, onFailure, finally []string) *config.Config {
+	return &config.Config{
+		Version: config.SchemaVersion,
+		Groups: []config.Group{
+			{Name: "up", Command: "docker"},
+			{Name: "test", Command: "go"},
+			{Name: "db-down", Command: "docker", Params: []string{`{{ (flow).Status }}`, `{{ (out "test").Status }}`}},
+			{Name: "notify-ok", Command: "notify"},
+			{Name: "notify-fail", Command: "notify", Params: []string{`{{ (flow).Err }}`}},
+		},
+		Flows: map[string]config.Flow{"f": {
+			Mode:      config.ModeStep,
+			Steps:     []config.Step{{Run: []string{"up"}}, {Run: []string{"test"}}},
+			OnSuccess: onSuccess,
+			OnFailure: onFailure,
+			Finally:   finally,
+		}},
+	}
+}
+
+func TestEngine_Hooks_SuccessPath(t *testing.T) {
+	t.Parallel()
+	cfg := hookCfg([]string{"notify-ok"}, []string{"notify-fail"}, []string{"db-down"})
+	r := &fakeRunner{}
+	var buf bytes.Buffer
+	e := New(cfg, WithRunner(r), WithEmitter(NewJSONEmitter(&buf)))
+
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"up:", "test:", "notify-ok:", "db-down:ok,ok"}, r.calls)
+
+	evs := decodeEvents(t, buf.Bytes())
+	var phases []string
+	for _, ev := range evs {
+		if ev.Event == EventHookStart {
+			phases = append(phases, ev.Phase)
+		}
+	}
+	assert.Equal(t, []string{config.PhaseOnSuccess, config.PhaseFinally}, phases)
+	assert.Equal(t, EventFlowEnd, evs[len(evs)-1].Event, "flow.end comes after the hooks")
+}
+
+func TestEngine_Hooks_FailurePathStillTearsDown(t *testing.T) {
+	t.Parallel()
+	cfg := hookCfg([]string{"notify-ok"}, []string{"notify-fail"}, []string{"db-down"})
+	boom := errors.New("boom")
+	r := &fakeRunner{errs: map[string]error{"test": boom}}
+	e := New(cfg, WithRunner(r))
+
+	err := e.RunFlow(context.Background(), "f")
+	require.ErrorIs(t, err, boom)
+	require.Len(t, r.calls, 4)
+	assert.Equal(t, []string{"up:", "test:"}, r.calls[:2])
+	assert.Contains(t, r.calls[2], "notify-fail:step 2:")
+	assert.Equal(t, "db-down:failed,", r.calls[3], "a failed member never reached the store")
+}
+
+func TestEngine_Hooks_FailingHookFailsFlowButOthersRun(t *testing.T) {
+	t.Parallel()
+	cfg := hookCfg(nil, nil, []string{"notify-ok", "db-down"})
+	cleanup := errors.New("cleanup failed")
+	r := &fakeRunner{errs: map[string]error{"notify-ok": cleanup}}
+	var buf bytes.Buffer
+	e := New(cfg, WithRunner(r), WithEmitter(NewJSONEmitter(&buf)))
+
+	err := e.RunFlow(context.Background(), "f")
+	require.ErrorIs(t, err, cleanup)
+	assert.Contains(t, err.Error(), "finally:")
+	assert.Equal(t, []string{"up:", "test:", "notify-ok:", "db-down:ok,ok"}, r.calls)
+
+	evs := decodeEvents(t, buf.Bytes())
+	assert.Equal(t, StatusFailed, evs[len(evs)-1].Status)
+}
+
+func TestEngine_Hooks_RunAfterCancellation(t *testing.T) {
+	t.Parallel()
+	cfg := hookCfg(nil, nil, []string{"db-down"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := &fakeRunner{}
+	e := New(cfg, WithRunner(r))
+
+	require.Error(t, e.RunFlow(ctx, "f"))
+	assert.Contains(t, r.calls, "db-down:failed,")
+}
+
+func TestEngine_Hooks_DefaultTimeout(t *testing.T) {
+	t.Parallel()
+	cfg := hookCfg(nil, nil, []string{"db-down", "notify-ok"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := &fakeRunner{delays: map[string]time.Duration{"db-down": time.Minute}}
+	e := New(cfg, WithRunner(r), WithHookTimeout(20*time.Millisecond))
+
+	start := time.Now()
+	require.Error(t, e.RunFlow(ctx, "f"))
+	assert.Less(t, time.Since(start), 10*time.Second, "a hung hook is stopped")
+	assert.Contains(t, r.calls, "notify-ok:", "the later hooks still run")
+}
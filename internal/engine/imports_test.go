@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+// scopeRunner records the dir, env and params each group ran with.
+type scopeRunner struct {
+	mu     sync.Mutex
+	dirs   map[string]string
+	envs   map[string]string
+	params map[string][]string
+}
+
+func (r *scopeRunner) Run(_ context.Context, g *config.Group, params []string, env map[string]string) (result.RunResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dirs[g.Name] = g.Dir
+	r.envs[g.Name] = env["WHO"]
+	r.params[g.Name] = params
+	return result.RunResult{Output: g.Name + "-out", Status: result.StatusOK}, nil
+}
+
+func TestEngine_ImportedGroupsRunInTheirScope(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "web"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "keepup.yml"), []byte(`version: 2
+imports: {web: web/keepup.yml}
+env: {WHO: root}
+groups:
+  - name: deploy
+    command: echo
+    params: ['{{ output "web:build" }}', '{{ env "WHO" }}']
+flows:
+  ship:
+    mode: dag
+    run: [web:lib, web:build, deploy]
+`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "web", "keepup.yml"), []byte(`version: 2
+env: {WHO: web}
+groups:
+  - name: lib
+    command: echo
+  - name: build
+    command: echo
+    dir: src
+    params: ['{{ output "lib" }}', '{{ env "WHO" }}']
+flows:
+  all:
+    mode: dag
+    run: [lib, build]
+`), 0o600))
+
+	cfg, err := config.LoadConfig(filepath.Join(dir, "keepup.yml"))
+	require.NoError(t, err)
+	r := &scopeRunner{dirs: map[string]string{}, envs: map[string]string{}, params: map[string][]string{}}
+	require.NoError(t, New(cfg, WithRunner(r)).RunFlow(context.Background(), "ship"))
+
+	assert.Equal(t, []string{"web:lib-out", "web"}, r.params["web:build"])
+	assert.Equal(t, filepath.Join(dir, "web", "src"), r.dirs["web:build"])
+	assert.Equal(t, filepath.Join(dir, "web"), r.dirs["web:lib"])
+	assert.Equal(t, "web", r.envs["web:build"])
+
+	assert.Equal(t, []string{"web:build-out", "root"}, r.params["deploy"])
+	assert.Empty(t, r.dirs["deploy"], "root groups keep the process working directory")
+	assert.Equal(t, "root", r.envs["deploy"])
+}
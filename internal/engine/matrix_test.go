@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/plan"
+)
+
+const matrixFlowCfg = `
+version: 2
+groups:
+  - name: test
+    command: go
+    params: ['{{ matrix "go" }}']
+    matrix:
+      go: ["1.22", "1.23"]
+  - name: report
+    command: echo
+    params: ['{{ output "test[go=1.23]" }}']
+flows:
+  f:
+    mode: dag
+    run: [test, report]
+`
+
+func TestEngine_MatrixInstancesRunWithTheirValues(t *testing.T) {
+	t.Parallel()
+	cfg, err := config.NewConfig([]byte(matrixFlowCfg))
+	require.NoError(t, err)
+	r := &fakeRunner{outputs: map[string]string{"test[go=1.23]": "PASS"}}
+	require.NoError(t, New(cfg, WithRunner(r)).RunFlow(context.Background(), "f"))
+
+	calls := append([]string(nil), r.calls...)
+	sort.Strings(calls)
+	assert.Equal(t, []string{"report:PASS", "test[go=1.22]:1.22", "test[go=1.23]:1.23"}, calls)
+}
+
+func TestEngine_MatrixSelectionByBaseName(t *testing.T) {
+	t.Parallel()
+	cfg, err := config.NewConfig([]byte(matrixFlowCfg))
+	require.NoError(t, err)
+	r := &fakeRunner{}
+	e := New(cfg, WithRunner(r), WithSelection(plan.Selection{Only: []string{"test"}}))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.Len(t, r.calls, 2)
+	assert.NotContains(t, r.calls, "report:")
+}
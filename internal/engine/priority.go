@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/logger"
+	"github.com/quike/keepup/internal/plan"
+)
+
+// runHistory holds how long each group typically takes. It is loaded once
+// per top-level RunFlow, shared with the flows it embeds, and saved back
+// with the durations observed during the run folded in.
+type runHistory struct {
+	mu       sync.Mutex
+	known    map[string]int64 // smoothed milliseconds from earlier runs
+	observed map[string]int64 // milliseconds measured by this run
+	fallback int64            // expected cost of a group with no history
+}
+
+func newRunHistory(store cache.DurationStore) *runHistory {
+	h := &runHistory{known: map[string]int64{}, observed: map[string]int64{}, fallback: 1}
+	if store == nil {
+		return h
+	}
+	h.known = store.LoadDurations()
+	if len(h.known) > 0 {
+		var sum int64
+		for _, ms := range h.known {
+			sum += ms
+		}
+		h.fallback = max(sum/int64(len(h.known)), 1)
+	}
+	return h
+}
+
+// observe records a group's run time. Only groups whose commands actually
+// ran are observed; cache hits, skips, and dry runs would skew the history.
+func (h *runHistory) observe(name string, ms int64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.observed[name] = ms
+}
+
+// expected returns a group's expected run time: its history, or the mean of
+// all known groups when it has none.
+func (h *runHistory) expected(name string) int64 {
+	if h == nil {
+		return 1
+	}
+	if ms, ok := h.known[name]; ok {
+		return max(ms, 1)
+	}
+	return h.fallback
+}
+
+// save folds this run's observations into the history, averaging each with
+// its previous value so one slow run does not swing the ranking, and writes
+// it to store.
+func (h *runHistory) save(store cache.DurationStore, log logger.Logger) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if store == nil || len(h.observed) == 0 {
+		return
+	}
+	for name, ms := range h.observed {
+		if prev, ok := h.known[name]; ok {
+			ms = (prev + ms) / 2
+		}
+		h.known[name] = ms
+	}
+	h.observed = make(map[string]int64)
+	if err := store.SaveDurations(h.known); err != nil {
+		log.Warn("save group durations failed", "err", err.Error())
+	}
+}
+
+// memberOrder ranks plan members for launch: a higher priority: first, then
+// the longer remaining path to the end of the flow (the member's expected
+// run time plus that of its longest chain of successors), then declaration
+// order.
+type memberOrder struct {
+	priority map[string]int
+	rank     map[string]int64
+	index    map[string]int
+}
+
+func (e *Engine) memberOrder(p *plan.Plan) *memberOrder {
+	o := &memberOrder{
+		priority: make(map[string]int, len(p.Members)),
+		rank:     make(map[string]int64, len(p.Members)),
+		index:    make(map[string]int, len(p.Members)),
+	}
+	var visit func(name string) int64
+	visit = func(name string) int64 {
+		if r, ok := o.rank[name]; ok {
+			return r
+		}
+		var tail int64
+		for _, succ := range p.Successors[name] {
+			tail = max(tail, visit(succ))
+		}
+		o.rank[name] = e.memberCost(p, name) + tail
+		return o.rank[name]
+	}
+	for i, m := range p.Members {
+		o.index[m] = i
+		o.priority[m] = e.groups[m].Priority
+		visit(m)
+	}
+	return o
+}
+
+// memberCost is a member's expected run time. An embedded flow costs the sum
+// of its groups, as if they ran one after another.
+func (e *Engine) memberCost(p *plan.Plan, name string) int64 {
+	if p.SubFlows[name] {
+		return e.flowCost(name)
+	}
+	return e.history.expected(name)
+}
+
+func (e *Engine) flowCost(name string) int64 {
+	f := e.cfg.Flows[name]
+	subs := f.SubFlows()
+	var sum int64
+	for _, m := range f.Members() {
+		if slices.Contains(subs, m) {
+			sum += e.flowCost(m)
+		} else {
+			sum += e.history.expected(m)
+		}
+	}
+	return sum
+}
+
+// sort orders names for launch, best first.
+func (o *memberOrder) sort(names []string) {
+	slices.SortFunc(names, func(a, b string) int {
+		if c := cmp.Compare(o.priority[b], o.priority[a]); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(o.rank[b], o.rank[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(o.index[a], o.index[b])
+	})
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+// memDurations is an in-memory cache.DurationStore.
+type memDurations struct {
+	mu sync.Mutex
+	d  map[string]int64
+}
+
+func (m *memDurations) LoadDurations() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]int64, len(m.d))
+	for k, v := range m.d {
+		out[k] = v
+	}
+	return out
+}
+
+func (m *memDurations) SaveDurations(d map[string]int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.d = d
+	return nil
+}
+
+// timedRunner records launch order and reports a fixed duration per group.
+type timedRunner struct {
+	mu    sync.Mutex
+	order []string
+	ms    map[string]int64
+}
+
+func (r *timedRunner) Run(_ context.Context, g *config.Group, _ []string, _ map[string]string) (result.RunResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.order = append(r.order, g.Name)
+	return result.RunResult{Status: result.StatusOK, DurationMs: r.ms[g.Name]}, nil
+}
+
+const priorityCfg = `
+version: 2
+settings:
+  max-concurrency: 1
+groups:
+  - {name: quick1, command: echo}
+  - {name: quick2, command: echo}
+  - {name: head, command: echo}
+  - {name: tail, command: echo, params: ['{{ output "head" }}']}
+  - {name: urgent, command: echo, priority: 10}
+flows:
+  dag:
+    mode: dag
+    run: [quick1, quick2, head, tail]
+  urgent:
+    mode: dag
+    run: [quick1, head, tail, urgent]
+  step:
+    steps:
+      - run: [quick1, quick2, head, urgent]
+`
+
+func TestEngine_DAGStartsLongestPathFirst(t *testing.T) {
+	t.Parallel()
+	cfg, err := config.NewConfig([]byte(priorityCfg))
+	require.NoError(t, err)
+	history := map[string]int64{"quick1": 50, "quick2": 80, "head": 100, "tail": 500, "urgent": 1}
+
+	cases := []struct {
+		flow string
+		want []string
+	}{
+		{"dag", []string{"head", "tail", "quick2", "quick1"}},
+		{"urgent", []string{"urgent", "head", "tail", "quick1"}},
+		{"step", []string{"urgent", "head", "quick2", "quick1"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.flow, func(t *testing.T) {
+			t.Parallel()
+			store := &memDurations{d: history}
+			r := &timedRunner{}
+			require.NoError(t, New(cfg, WithRunner(r), WithDurationStore(store)).RunFlow(context.Background(), tc.flow))
+			assert.Equal(t, tc.want, r.order)
+		})
+	}
+}
+
+func TestEngine_DAGOrderWithoutHistory(t *testing.T) {
+	t.Parallel()
+	cfg, err := config.NewConfig([]byte(priorityCfg))
+	require.NoError(t, err)
+	r := &timedRunner{}
+	require.NoError(t, New(cfg, WithRunner(r)).RunFlow(context.Background(), "dag"))
+	assert.Equal(t, []string{"head", "quick1", "quick2", "tail"}, r.order,
+		"the head of the longer chain first, then declaration order")
+}
+
+func TestEngine_RecordsDurations(t *testing.T) {
+	t.Parallel()
+	cfg, err := config.NewConfig([]byte(priorityCfg))
+	require.NoError(t, err)
+	store := &memDurations{d: map[string]int64{"head": 100}}
+	r := &timedRunner{ms: map[string]int64{"quick1": 40, "quick2": 60, "head": 300, "tail": 20}}
+	require.NoError(t, New(cfg, WithRunner(r), WithDurationStore(store)).RunFlow(context.Background(), "dag"))
+	assert.Equal(t, map[string]int64{"quick1": 40, "quick2": 60, "head": 200, "tail": 20}, store.LoadDurations(),
+		"a new measurement is averaged with the previous one")
+
+	dry := &memDurations{d: map[string]int64{}}
+	require.NoError(t, New(cfg, WithRunner(r), WithDurationStore(dry), WithDryRun(true)).RunFlow(context.Background(), "dag"))
+	assert.Empty(t, dry.LoadDurations(), "a dry run records nothing")
+}
@@ -11,9 +11,10 @@ import (
 // succeeded (exit code 0); a non-nil error means it failed.
 //
 // Predicates are short shell snippets (e.g. "test -f bin/app"), so they always
-// run through a shell.
+// run through a shell. dir is the group's resolved working directory ("" means
+// the process cwd), so relative paths in a predicate match the command's view.
 type Prober interface {
-	Probe(ctx context.Context, script string, env map[string]string) error
+	Probe(ctx context.Context, script, dir string, env map[string]string) error
 }
 
 // ShellProber runs predicates through the platform shell. Output is discarded;
@@ -22,8 +23,9 @@ type ShellProber struct{}
 
 // Probe runs script via the platform shell and returns its exit status as an
 // error (nil on success).
-func (ShellProber) Probe(ctx context.Context, script string, env map[string]string) error {
+func (ShellProber) Probe(ctx context.Context, script, dir string, env map[string]string) error {
 	cmd := exec.CommandContext(ctx, pickShell(""), shellFlag(), script) //nolint:gosec // user-declared predicate
+	cmd.Dir = dir
 	cmd.Env = mergeEnvs(os.Environ(), env)
 	cmd.Stdout = io.Discard
 	cmd.Stderr = io.Discard
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package engine
+
+import (
+	"os/exec"
+	"time"
+)
+
+// procGroup falls back to stopping only the direct child where process
+// groups are not available; grace still bounds how long Wait blocks on
+// output pipes a surviving grandchild holds open.
+type procGroup struct{}
+
+func newProcGroup(cmd *exec.Cmd, grace time.Duration) *procGroup {
+	cmd.WaitDelay = grace
+	return &procGroup{}
+}
+
+func (*procGroup) reap() {}
@@ -0,0 +1,67 @@
+//go:build unix
+
+package engine
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// procGroup runs a command as the leader of a process group of its own, so
+// stopping it reaches everything it spawned: `shell: bash` running `npm
+// test` leaves no grandchild holding a port. Stopping sends SIGTERM to the
+// whole group, and SIGKILL to whatever is left once grace has passed (at
+// once for a zero grace).
+type procGroup struct {
+	cmd    *exec.Cmd
+	grace  time.Duration
+	termAt atomic.Int64 // unix nanos of the SIGTERM; 0 while running
+}
+
+func newProcGroup(cmd *exec.Cmd, grace time.Duration) *procGroup {
+	pg := &procGroup{cmd: cmd, grace: grace}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = pg.terminate
+	// Past grace, Wait stops waiting for the leader and for the output
+	// pipes a surviving grandchild may hold open.
+	cmd.WaitDelay = grace
+	return pg
+}
+
+// terminate is the command's Cancel hook.
+func (pg *procGroup) terminate() error {
+	pg.termAt.Store(time.Now().UnixNano())
+	sig := syscall.SIGTERM
+	if pg.grace <= 0 {
+		sig = syscall.SIGKILL
+	}
+	if err := syscall.Kill(-pg.cmd.Process.Pid, sig); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			return os.ErrProcessDone
+		}
+		return err
+	}
+	return nil
+}
+
+// reap completes a termination once Wait has returned: members of the group
+// still alive get the rest of grace to exit, then are killed.
+func (pg *procGroup) reap() {
+	at := pg.termAt.Load()
+	if at == 0 || pg.cmd.Process == nil {
+		return
+	}
+	pgid := -pg.cmd.Process.Pid
+	deadline := time.Unix(0, at).Add(pg.grace)
+	for time.Now().Before(deadline) {
+		if syscall.Kill(pgid, 0) != nil {
+			return // the whole group is gone
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	_ = syscall.Kill(pgid, syscall.SIGKILL)
+}
@@ -0,0 +1,146 @@
+//go:build unix
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+// alive reports whether pid still exists as a running (non-zombie) process.
+func alive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	return err != nil || !strings.Contains(string(stat), ") Z")
+}
+
+func TestShellRunner_TimeoutKillsGrandchildren(t *testing.T) {
+	t.Parallel()
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	r := &ShellRunner{Stdout: io.Discard, Stderr: io.Discard}
+	g := &config.Group{Name: "g", Shell: "/bin/sh", Command: "sleep 30 & echo $! > " + pidFile + "; wait", KillGrace: "1s"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	rr, err := r.Run(ctx, g, nil, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "stopped by timeout")
+	assert.Equal(t, result.TerminationTimeout, rr.Termination)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	data, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return !alive(pid) }, 2*time.Second, 20*time.Millisecond,
+		"the grandchild must not outlive the group")
+}
+
+func TestShellRunner_KillGraceEscalates(t *testing.T) {
+	t.Parallel()
+	r := &ShellRunner{Stdout: io.Discard, Stderr: io.Discard}
+
+	t.Run("SIGTERM lets the command clean up", func(t *testing.T) {
+		t.Parallel()
+		var out strings.Builder
+		r := &ShellRunner{Stdout: &out, Stderr: io.Discard}
+		g := &config.Group{Name: "g", Shell: "/bin/sh", Command: "trap 'echo bye; exit 3' TERM; while :; do sleep 0.05; done", KillGrace: "5s"}
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(200*time.Millisecond, cancel)
+		rr, err := r.Run(ctx, g, nil, nil)
+		require.Error(t, err)
+		assert.Equal(t, result.TerminationCancel, rr.Termination)
+		assert.Contains(t, rr.Stdout, "bye")
+	})
+
+	t.Run("SIGKILL after grace", func(t *testing.T) {
+		t.Parallel()
+		g := &config.Group{Name: "g", Shell: "/bin/sh", Command: "trap '' TERM; while :; do sleep 0.05; done", KillGrace: "300ms"}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		rr, err := r.Run(ctx, g, nil, nil)
+		require.Error(t, err)
+		assert.Equal(t, result.TerminationTimeout, rr.Termination)
+		assert.GreaterOrEqual(t, time.Since(start), 350*time.Millisecond, "the command gets its grace")
+		assert.Less(t, time.Since(start), 3*time.Second)
+	})
+}
+
+func TestShellRunner_ExternalSignal(t *testing.T) {
+	t.Parallel()
+	r := &ShellRunner{Stdout: io.Discard, Stderr: io.Discard}
+	rr, err := r.Run(context.Background(), &config.Group{Name: "g", Shell: "/bin/sh", Command: "kill -9 $$"}, nil, nil)
+	require.Error(t, err)
+	assert.Equal(t, result.TerminationSignal, rr.Termination)
+	assert.Equal(t, -1, rr.ExitCode)
+}
+
+func TestShellRunner_BackgroundChildDoesNotHang(t *testing.T) {
+	t.Parallel()
+	r := &ShellRunner{Stdout: io.Discard, Stderr: io.Discard}
+	g := &config.Group{Name: "g", Shell: "/bin/sh", Command: "sleep 2 & echo started", KillGrace: "100ms"}
+	start := time.Now()
+	rr, err := r.Run(context.Background(), g, nil, nil)
+	require.NoError(t, err, "a background process holding stdout does not fail the group")
+	assert.Empty(t, rr.Termination)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestEngine_ServiceProcessTreeStopped(t *testing.T) {
+	t.Parallel()
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	cfg := stepFlowCfg(t, []config.Group{
+		{
+			Name: "server", Shell: "/bin/sh", Service: true, KillGrace: "1s",
+			Command: "sleep 30 & echo $! > " + pidFile + "; echo listening on 127.0.0.1:9; wait",
+			Ready:   &config.Ready{Log: `listening on (\S+)`},
+		},
+		{Name: "early", Shell: "/bin/sh", Service: true, Command: "echo up; exec sleep 0.1", Ready: &config.Ready{Log: "up"}},
+		{Name: "client", Command: "echo", Params: []string{`{{ output "server" }}`}},
+		{Name: "wait", Command: "sleep", Params: []string{"0.5"}},
+	}, [][]string{{"server", "early"}, {"client", "wait"}})
+
+	var buf bytes.Buffer
+	e := New(cfg, WithRunner(&ShellRunner{Stdout: io.Discard, Stderr: io.Discard}), WithEmitter(NewJSONEmitter(&buf)))
+	start := time.Now()
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	client, _ := e.Outputs().Get("client")
+	assert.Equal(t, "127.0.0.1:9", strings.TrimSpace(client.Stdout))
+
+	data, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return !alive(pid) }, 2*time.Second, 20*time.Millisecond,
+		"stopping a service stops what it started")
+
+	stops := map[string]Event{}
+	for _, ev := range decodeEvents(t, buf.Bytes()) {
+		if ev.Event == EventServiceStop {
+			stops[ev.Group] = ev
+		}
+	}
+	assert.Equal(t, StatusOK, stops["server"].Status)
+	assert.Equal(t, StatusFailed, stops["early"].Status, "a service that exits on its own is reported")
+	assert.Contains(t, stops["early"].Err, "exited before the flow ended")
+}
@@ -0,0 +1,66 @@
+package engine
+
+import "sync"
+
+// resourcePool tracks the free units of the pools declared in
+// settings.resources. It is shared by an engine and the child engines of
+// the flows it embeds, so a limit holds across the whole run. A nil pool (no
+// resources declared) grants every request.
+type resourcePool struct {
+	mu   sync.Mutex
+	free map[string]int
+	// wake is closed and replaced on every release, waking every scheduler
+	// waiting for capacity.
+	wake chan struct{}
+}
+
+func newResourcePool(capacity map[string]int) *resourcePool {
+	if len(capacity) == 0 {
+		return nil
+	}
+	free := make(map[string]int, len(capacity))
+	for name, n := range capacity {
+		free[name] = n
+	}
+	return &resourcePool{free: free, wake: make(chan struct{})}
+}
+
+// tryAcquire takes every unit uses asks for, or none of them. When it cannot,
+// it also returns a channel closed by the next release, taken under the same
+// lock so a release racing the failed attempt is never missed.
+func (r *resourcePool) tryAcquire(uses map[string]int) (bool, <-chan struct{}) {
+	if r == nil || len(uses) == 0 {
+		return true, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, n := range uses {
+		if r.free[name] < n {
+			return false, r.wake
+		}
+	}
+	for name, n := range uses {
+		r.free[name] -= n
+	}
+	return true, nil
+}
+
+// release returns units taken by tryAcquire and wakes every waiter.
+func (r *resourcePool) release(uses map[string]int) {
+	if r == nil || len(uses) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, n := range uses {
+		r.free[name] += n
+	}
+	close(r.wake)
+	r.wake = make(chan struct{})
+}
+
+// uses returns the resource units a plan member holds while it runs. An
+// embedded flow holds none itself; its groups claim their own.
+func (e *Engine) uses(name string) map[string]int {
+	return e.groups[name].Uses
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+// overlapRunner records the peak number of concurrently running groups per
+// name prefix ("it" for integration suites, "lint" for linters).
+type overlapRunner struct {
+	mu     sync.Mutex
+	active map[string]int
+	peak   map[string]int
+}
+
+func (o *overlapRunner) Run(ctx context.Context, g *config.Group, _ []string, _ map[string]string) (result.RunResult, error) {
+	kind := strings.TrimRight(g.Name, "0123456789")
+	o.mu.Lock()
+	o.active[kind]++
+	o.peak[kind] = max(o.peak[kind], o.active[kind])
+	o.mu.Unlock()
+	select {
+	case <-time.After(30 * time.Millisecond):
+	case <-ctx.Done():
+	}
+	o.mu.Lock()
+	o.active[kind]--
+	o.mu.Unlock()
+	return result.RunResult{Status: result.StatusOK}, nil
+}
+
+const resourcesCfg = `
+version: 2
+settings:
+  resources: {db: 1, cpu: 4}
+groups:
+  - {name: it1, command: go, uses: {db: 1, cpu: 2}}
+  - {name: it2, command: go, uses: {db: 1, cpu: 2}}
+  - {name: it3, command: go, uses: {db: 1}}
+  - {name: lint1, command: lint}
+  - {name: lint2, command: lint}
+  - {name: lint3, command: lint}
+flows:
+  step:
+    steps:
+      - run: [it1, lint1, it2, lint2, it3, lint3]
+  dag:
+    mode: dag
+    run: [it1, it2, lint1, lint2, lint3, {flow: nested}]
+  nested:
+    steps:
+      - run: [it3]
+`
+
+func TestEngine_ResourcesSerializeUsers(t *testing.T) {
+	t.Parallel()
+	cfg, err := config.NewConfig([]byte(resourcesCfg))
+	require.NoError(t, err)
+	for _, flow := range []string{"step", "dag"} {
+		t.Run(flow, func(t *testing.T) {
+			t.Parallel()
+			r := &overlapRunner{active: map[string]int{}, peak: map[string]int{}}
+			require.NoError(t, New(cfg, WithRunner(r)).RunFlow(context.Background(), flow))
+			assert.Equal(t, 1, r.peak["it"], "groups sharing the db pool never overlap")
+			assert.Equal(t, 3, r.peak["lint"], "groups without uses: fan out freely")
+		})
+	}
+}
+
+func TestResourcePool(t *testing.T) {
+	t.Parallel()
+	var none *resourcePool
+	ok, _ := none.tryAcquire(map[string]int{"db": 5})
+	assert.True(t, ok, "a nil pool grants everything")
+
+	pool := newResourcePool(map[string]int{"db": 1, "cpu": 4})
+	ok, _ = pool.tryAcquire(map[string]int{"db": 1, "cpu": 2})
+	require.True(t, ok)
+	ok, wake := pool.tryAcquire(map[string]int{"cpu": 4})
+	require.False(t, ok)
+	ok, _ = pool.tryAcquire(map[string]int{"cpu": 2})
+	require.True(t, ok, "a failed request takes nothing")
+
+	pool.release(map[string]int{"db": 1, "cpu": 2})
+	select {
+	case <-wake:
+	default:
+		t.Fatal("release must wake waiters")
+	}
+	ok, _ = pool.tryAcquire(map[string]int{"db": 1})
+	assert.True(t, ok)
+}
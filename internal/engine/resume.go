@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/plan"
+	"github.com/quike/keepup/internal/result"
+)
+
+// completed reports whether a stored status means the group reached a
+// terminal state worth keeping across a resume. Soft failures count: the
+// flow already moved past them.
+func completed(status string) bool {
+	switch status {
+	case result.StatusOK, result.StatusCached, result.StatusSkipped, result.StatusFailed:
+		return true
+	}
+	return false
+}
+
+// flowHash fingerprints the parts of a flow that shape its plan and outputs:
+// mode, steps/run entries (with their when: predicates), the resolved args,
+// the global env, and the working directory. Envelope settings and hooks are
+// left out — changing them does not invalidate outputs already produced.
+func flowHash(cfg *config.Config, flow *config.Flow, args map[string]string) string {
+	return hashJSON(struct {
+		Mode    config.Mode
+		Steps   []config.Step
+		Run     []config.RunEntry
+		Args    map[string]string `json:",omitempty"`
+		Env     map[string]string
+		WorkDir string
+	}{flow.Mode, flow.Steps, flow.Run, args, cfg.Env, cfg.WorkDir()})
+}
+
+//nolint:gocritic // config.Group is hashed by value on purpose
+func groupHash(g config.Group) string { return hashJSON(g) }
+
+// memberHash fingerprints a member's definition: its group, or everything an
+// embedded flow runs (see flowDefinition).
+func (e *Engine) memberHash(p *plan.Plan, name string) string {
+	if p.SubFlows[name] {
+		return hashJSON(e.flowDefinition(name))
+	}
+	return groupHash(e.groups[name])
+}
+
+func hashJSON(v any) string {
+	// json.Marshal on config structs cannot fail (no channels/funcs).
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// loadResume seeds the output store from the flow's saved run state and
+// records which members the schedulers must replay instead of run.
+//
+// Step mode replays every wave before the first one that did not fully
+// complete, and reruns from there. Dag mode replays every node that ran to
+// completion; skipped nodes are re-decided, since a skip is cheap and its
+// cascade must be recomputed. A missing state file means "run from the
+// start". A changed flow, or a changed definition of any group about to be
+// replayed, is refused: its stored output would no longer be trustworthy. An
+// embedded flow replays as a whole, along with the outputs it published. A
+// service is never replayed: the groups after it need it running, so it
+// starts again.
+func (e *Engine) loadResume(p *plan.Plan, flow *config.Flow) error {
+	if e.runs == nil {
+		return fmt.Errorf("cannot resume flow %q: no run-state store configured", p.Flow)
+	}
+	st, ok := e.runs.LoadRun(p.Flow)
+	if !ok {
+		e.log.Info("no saved run state; running from the start", "flow", p.Flow)
+		return nil
+	}
+	if st.FlowHash != flowHash(e.cfg, flow, e.flowArgs) {
+		return fmt.Errorf(
+			"cannot resume flow %q: its steps, args, env, or working directory changed since the saved run; rerun without --resume",
+			p.Flow)
+	}
+	replay := replayable(p, st)
+	for name := range replay {
+		if !p.SubFlows[name] && e.groups[name].Service {
+			delete(replay, name)
+		}
+	}
+	for name := range replay {
+		if st.Groups[name] != e.memberHash(p, name) {
+			return fmt.Errorf(
+				"cannot resume flow %q: group %q changed since the saved run; rerun without --resume",
+				p.Flow, name)
+		}
+	}
+	for name := range replay {
+		e.replayOutput(name, st.Outputs[name])
+		e.exportEnv(name, st.Outputs[name].EnvFile)
+		if p.SubFlows[name] {
+			for k, v := range subFlowOutputs(st.Outputs, name) {
+				e.replayOutput(k, v)
+			}
+		}
+	}
+	e.replayed = replay
+	e.log.Info("resuming flow", "flow", p.Flow, "replayed", len(replay), "of", len(p.Members))
+	return nil
+}
+
+// replayOutput restores one saved output. A group that soft-failed in the
+// saved run still counts toward the flow's soft-failed status.
+func (e *Engine) replayOutput(name string, rr result.RunResult) {
+	e.outputs.Set(name, rr)
+	if rr.Status == result.StatusFailed {
+		e.recordSoftFailure(name)
+	}
+}
+
+// replayable picks the members whose saved outputs a resume reuses.
+func replayable(p *plan.Plan, st *cache.RunState) map[string]bool {
+	out := make(map[string]bool)
+	if p.Mode == config.ModeDAG {
+		for _, m := range p.Members {
+			if rr, ok := st.Outputs[m]; ok && completed(rr.Status) && rr.Status != result.StatusSkipped {
+				out[m] = true
+			}
+		}
+		return out
+	}
+	for _, wave := range p.Waves {
+		for _, m := range wave {
+			if rr, ok := st.Outputs[m]; !ok || !completed(rr.Status) {
+				return out
+			}
+		}
+		for _, m := range wave {
+			out[m] = true
+		}
+	}
+	return out
+}
+
+// saveRunState persists the outcome of a run for a later --resume. A
+// successful run clears the state; a failed one records every member that
+// completed, including those replayed from an earlier attempt.
+func (e *Engine) saveRunState(p *plan.Plan, flow *config.Flow, runErr error) {
+	if runErr == nil {
+		if err := e.runs.ClearRun(p.Flow); err != nil {
+			e.log.Warn("clear run state failed", "flow", p.Flow, "err", err.Error())
+		}
+		return
+	}
+	st := &cache.RunState{
+		Flow:      p.Flow,
+		FlowHash:  flowHash(e.cfg, flow, e.flowArgs),
+		Groups:    make(map[string]string, len(p.Members)),
+		Outputs:   make(map[string]result.RunResult, len(p.Members)),
+		Err:       runErr.Error(),
+		UpdatedAt: time.Now(),
+	}
+	snap := e.outputs.Snapshot()
+	for _, m := range p.Members {
+		st.Groups[m] = e.memberHash(p, m)
+		if rr, ok := snap[m]; ok && completed(rr.Status) {
+			st.Outputs[m] = rr
+			if p.SubFlows[m] {
+				maps.Copy(st.Outputs, subFlowOutputs(snap, m))
+			}
+		}
+	}
+	if err := e.runs.SaveRun(p.Flow, st); err != nil {
+		e.log.Warn("save run state failed", "flow", p.Flow, "err", err.Error())
+	}
+}
+
+// emitGroupResumed reports a member replayed from saved run state.
+func (e *Engine) emitGroupResumed(name string) {
+	e.log.Info("group replayed from saved run", "group", name)
+	e.emit(Event{Event: EventGroupEnd, Group: name, Status: StatusResumed, Reason: "resume"})
+}
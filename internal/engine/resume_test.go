@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+)
+
+func resumeGroups() []config.Group {
+	return []config.Group{
+		{Name: "a", Command: "echo"},
+		{Name: "b", Command: "echo"},
+		{Name: "c", Command: "echo", Params: []string{`{{ output "a" }}`}},
+	}
+}
+
+func TestEngine_ResumeStepReplaysCompletedWaves(t *testing.T) {
+	t.Parallel()
+	store := cache.NewFileStore(filepath.Join(t.TempDir(), "cache"))
+	cfg := stepFlowCfg(t, resumeGroups(), [][]string{{"a"}, {"b"}, {"c"}})
+
+	first := &fakeRunner{
+		outputs: map[string]string{"a": "A"},
+		errs:    map[string]error{"b": errors.New("boom")},
+	}
+	require.Error(t, New(cfg, WithRunner(first), WithRunStore(store)).RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"a:", "b:"}, first.calls)
+
+	second := &fakeRunner{}
+	e := New(cfg, WithRunner(second), WithRunStore(store), WithResume(true))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	// a is replayed, so c still sees its stored output.
+	assert.Equal(t, []string{"b:", "c:A"}, second.calls)
+
+	_, ok := store.LoadRun("f")
+	assert.False(t, ok, "a successful run clears the saved state")
+}
+
+func TestEngine_ResumeDAGReplaysCompletedNodes(t *testing.T) {
+	t.Parallel()
+	store := cache.NewFileStore(filepath.Join(t.TempDir(), "cache"))
+	// b reads a's output, so a has finished before b fails.
+	groups := resumeGroups()
+	groups[1].Params = []string{`{{ output "a" }}`}
+	cfg := dagFlowCfg(t, groups, []string{"a", "b", "c"})
+
+	first := &fakeRunner{
+		outputs: map[string]string{"a": "A"},
+		errs:    map[string]error{"b": errors.New("boom")},
+	}
+	require.Error(t, New(cfg, WithRunner(first), WithRunStore(store)).RunFlow(context.Background(), "f"))
+
+	second := &fakeRunner{}
+	e := New(cfg, WithRunner(second), WithRunStore(store), WithResume(true))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.NotContains(t, second.calls, "a:")
+	assert.Contains(t, second.calls, "b:A")
+	rr, ok := e.Outputs().Get("a")
+	require.True(t, ok)
+	assert.Equal(t, "A", rr.Stdout)
+}
+
+func TestEngine_ResumeKeepsSoftFailures(t *testing.T) {
+	t.Parallel()
+	store := cache.NewFileStore(filepath.Join(t.TempDir(), "cache"))
+	groups := resumeGroups()
+	groups[0].AllowFailure = true
+	cfg := stepFlowCfg(t, groups, [][]string{{"a"}, {"b"}, {"c"}})
+
+	first := &fakeRunner{errs: map[string]error{"a": errors.New("lint"), "b": errors.New("boom")}}
+	require.Error(t, New(cfg, WithRunner(first), WithRunStore(store)).RunFlow(context.Background(), "f"))
+
+	var buf bytes.Buffer
+	e := New(cfg, WithRunner(&fakeRunner{}), WithRunStore(store), WithResume(true), WithEmitter(NewJSONEmitter(&buf)))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"a"}, e.SoftFailures(), "the replayed soft failure still counts")
+	evs := decodeEvents(t, buf.Bytes())
+	last := evs[len(evs)-1]
+	assert.Equal(t, EventFlowEnd, last.Event)
+	assert.Equal(t, StatusSoftFailed, last.Status)
+}
+
+func TestEngine_ResumeRefusesChangedConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		mutate  func(cfg *config.Config)
+		wantErr string
+	}{
+		{
+			name:    "replayed group changed",
+			mutate:  func(cfg *config.Config) { cfg.Groups[0].Command = "printf" },
+			wantErr: `group "a" changed`,
+		},
+		{
+			name:    "flow env changed",
+			mutate:  func(cfg *config.Config) { cfg.Env = map[string]string{"X": "1"} },
+			wantErr: "changed since the saved run",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := cache.NewFileStore(filepath.Join(t.TempDir(), "cache"))
+			cfg := stepFlowCfg(t, resumeGroups(), [][]string{{"a"}, {"b"}, {"c"}})
+			first := &fakeRunner{errs: map[string]error{"b": errors.New("boom")}}
+			require.Error(t, New(cfg, WithRunner(first), WithRunStore(store)).RunFlow(context.Background(), "f"))
+
+			changed := stepFlowCfg(t, resumeGroups(), [][]string{{"a"}, {"b"}, {"c"}})
+			tc.mutate(changed)
+			second := &fakeRunner{}
+			err := New(changed, WithRunner(second), WithRunStore(store), WithResume(true)).
+				RunFlow(context.Background(), "f")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.wantErr)
+			assert.Empty(t, second.calls)
+		})
+	}
+}
+
+func TestEngine_ResumeWithoutStateRunsEverything(t *testing.T) {
+	t.Parallel()
+	store := cache.NewFileStore(filepath.Join(t.TempDir(), "cache"))
+	cfg := stepFlowCfg(t, resumeGroups(), [][]string{{"a"}, {"b"}})
+	r := &fakeRunner{}
+	require.NoError(t, New(cfg, WithRunner(r), WithRunStore(store), WithResume(true)).
+		RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"a:", "b:"}, r.calls)
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"math"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+// overlay returns env with the non-empty settings of one envelope level
+// (flow, step, group, run entry) on top.
+func (env envelope) overlay(timeout string, retries int, backoff *config.Backoff, retryOn *config.RetryOn) envelope {
+	if timeout != "" {
+		// Durations are validated at config load, so a parse error here is
+		// impossible; treat any residual error as "no timeout".
+		env.timeout, _ = time.ParseDuration(timeout)
+	}
+	if retries != 0 {
+		env.retries = retries
+	}
+	if backoff != nil {
+		env.backoff = backoff
+	}
+	if retryOn != nil {
+		env.retryOn = retryOn
+	}
+	return env
+}
+
+// forGroup returns env with the group's own settings on top, then those of
+// the dag run entry that runs it in this flow.
+func (env envelope) forGroup(group *config.Group) envelope {
+	env = env.overlay(group.Timeout, group.Retries, group.Backoff, group.RetryOn)
+	if r := env.entry; r != nil {
+		env = env.overlay(r.Timeout, r.Retries, r.Backoff, r.RetryOn)
+	}
+	return env
+}
+
+// delay returns the wait before retry attempt (2 for the first retry).
+// Without a backoff: it is base times the number of failed attempts.
+func (env envelope) delay(base time.Duration, attempt int) time.Duration {
+	b := env.backoff
+	if b == nil {
+		return base * time.Duration(attempt-1)
+	}
+	d := base
+	if b.Delay != "" {
+		d, _ = time.ParseDuration(b.Delay)
+	}
+	factor := b.Factor
+	if factor == 0 {
+		factor = 2
+	}
+	wait := float64(d) * math.Pow(factor, float64(attempt-2))
+	if b.Max != "" {
+		limit, _ := time.ParseDuration(b.Max)
+		wait = math.Min(wait, float64(limit))
+	}
+	wait = math.Min(wait, math.MaxInt64>>1) // stay clear of overflowing time.Duration
+	if b.Jitter {
+		wait = wait/2 + rand.Float64()*wait/2 //nolint:gosec // spreading retries needs no crypto
+	}
+	return time.Duration(wait)
+}
+
+// retryable reports whether a failed attempt matches the retry-on filter;
+// every failure does without one.
+func (env envelope) retryable(out *result.RunResult) bool {
+	on := env.retryOn
+	if on == nil {
+		return true
+	}
+	switch {
+	case on.Timeout && out.Termination == result.TerminationTimeout:
+		return true
+	case out.ExitCode > 0 && slices.Contains(on.ExitCodes, out.ExitCode):
+		return true
+	case on.Stderr != "" && regexp.MustCompile(on.Stderr).MatchString(out.Stderr):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+// scriptedRunner fails with results[i] on call i while there are results
+// left, then succeeds.
+type scriptedRunner struct {
+	results []result.RunResult
+	calls   int32
+}
+
+func (r *scriptedRunner) Run(ctx context.Context, _ *config.Group, _ []string, _ map[string]string) (result.RunResult, error) {
+	n := int(atomic.AddInt32(&r.calls, 1))
+	if n > len(r.results) {
+		return result.RunResult{Status: result.StatusOK}, nil
+	}
+	out := r.results[n-1]
+	if out.Termination == result.TerminationTimeout {
+		<-ctx.Done()
+	}
+	return out, errors.New("attempt failed")
+}
+
+func TestEnvelope_Overlay(t *testing.T) {
+	t.Parallel()
+	flow := &config.Flow{Timeout: "1m", Retries: 1, RetryOn: &config.RetryOn{Timeout: true}}
+	step := &config.Step{Retries: 2, Backoff: &config.Backoff{Delay: "1s"}}
+	group := &config.Group{Timeout: "10s", RetryOn: &config.RetryOn{ExitCodes: []int{75}}}
+
+	env := resolveEnvelope(flow, step).forGroup(group)
+	assert.Equal(t, 10*time.Second, env.timeout, "the group overrides the flow")
+	assert.Equal(t, 2, env.retries, "the step applies where the group is silent")
+	assert.Equal(t, "1s", env.backoff.Delay)
+	assert.Equal(t, []int{75}, env.retryOn.ExitCodes)
+
+	env = resolveEnvelope(flow, nil)
+	env.entry = &config.RunEntry{Group: "g", Retries: 5, Timeout: "2s"}
+	env = env.forGroup(group)
+	assert.Equal(t, 2*time.Second, env.timeout, "a run entry overrides the group")
+	assert.Equal(t, 5, env.retries)
+}
+
+func TestEnvelope_Delay(t *testing.T) {
+	t.Parallel()
+	base := 100 * time.Millisecond
+	linear := envelope{}
+	assert.Equal(t, base, linear.delay(base, 2))
+	assert.Equal(t, 3*base, linear.delay(base, 4), "linear without backoff:")
+
+	exp := envelope{backoff: &config.Backoff{Delay: "1s", Max: "5s"}}
+	var got []time.Duration
+	for attempt := 2; attempt <= 6; attempt++ {
+		got = append(got, exp.delay(base, attempt))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, got)
+
+	tripled := envelope{backoff: &config.Backoff{Factor: 3}}
+	assert.Equal(t, 9*base, tripled.delay(base, 4), "delay defaults to the engine's base")
+
+	jitter := envelope{backoff: &config.Backoff{Delay: "1s", Jitter: true}}
+	for range 50 {
+		d := jitter.delay(base, 3)
+		assert.GreaterOrEqual(t, d, time.Second)
+		assert.LessOrEqual(t, d, 2*time.Second)
+	}
+
+	huge := envelope{backoff: &config.Backoff{Delay: "1h"}}
+	assert.Positive(t, huge.delay(base, 200), "a long run of retries does not overflow")
+}
+
+func TestEnvelope_Retryable(t *testing.T) {
+	t.Parallel()
+	on := &config.RetryOn{ExitCodes: []int{75}, Stderr: `connection reset`, Timeout: true}
+	env := envelope{retryOn: on}
+	assert.True(t, env.retryable(&result.RunResult{ExitCode: 75}))
+	assert.True(t, env.retryable(&result.RunResult{ExitCode: 1, Stderr: "read: connection reset by peer"}))
+	assert.True(t, env.retryable(&result.RunResult{ExitCode: -1, Termination: result.TerminationTimeout}))
+	assert.False(t, env.retryable(&result.RunResult{ExitCode: 1, Stderr: "FAIL TestParse"}))
+	assert.False(t, env.retryable(&result.RunResult{ExitCode: -1, Termination: result.TerminationCancel}))
+	assert.True(t, envelope{}.retryable(&result.RunResult{ExitCode: 1}), "no retry-on retries everything")
+}
+
+func TestEngine_GroupRetries(t *testing.T) {
+	t.Parallel()
+	transient := result.RunResult{ExitCode: 7, Stderr: "curl: (7) connection refused"}
+	real := result.RunResult{ExitCode: 1, Stderr: "--- FAIL: TestParse"}
+	cases := []struct {
+		name    string
+		group   config.Group
+		results []result.RunResult
+		calls   int32
+		ok      bool
+	}{
+		{"group retries", config.Group{Retries: 2}, []result.RunResult{transient, transient}, 3, true},
+		{
+			"retry-on match", config.Group{Retries: 3, RetryOn: &config.RetryOn{ExitCodes: []int{7}}},
+			[]result.RunResult{transient, transient}, 3, true,
+		},
+		{"fail fast", config.Group{Retries: 3, RetryOn: &config.RetryOn{ExitCodes: []int{7}}}, []result.RunResult{transient, real}, 2, false},
+		{"stderr", config.Group{Retries: 3, RetryOn: &config.RetryOn{Stderr: "FAIL"}}, []result.RunResult{real}, 2, true},
+		{
+			"timeout only",
+			config.Group{Timeout: "20ms", Retries: 3, RetryOn: &config.RetryOn{Timeout: true}},
+			[]result.RunResult{{ExitCode: -1, Termination: result.TerminationTimeout}, transient},
+			2, false,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			g := tc.group
+			g.Name, g.Command = "g", "x"
+			cfg := stepFlowCfg(t, []config.Group{g}, [][]string{{"g"}})
+			r := &scriptedRunner{results: tc.results}
+			var buf bytes.Buffer
+			e := New(cfg, WithRunner(r), WithRetryBackoff(time.Millisecond), WithEmitter(NewJSONEmitter(&buf)))
+			err := e.RunFlow(context.Background(), "f")
+			assert.Equal(t, tc.ok, err == nil, "err: %v", err)
+			assert.Equal(t, tc.calls, atomic.LoadInt32(&r.calls))
+
+			var attempts []int
+			for _, ev := range decodeEvents(t, buf.Bytes()) {
+				if ev.Event == EventGroupRetry {
+					assert.Equal(t, "g", ev.Group)
+					assert.Equal(t, "attempt failed", ev.Err)
+					attempts = append(attempts, ev.Attempt)
+				}
+			}
+			want := make([]int, 0)
+			for a := 2; a <= int(tc.calls); a++ {
+				want = append(want, a)
+			}
+			assert.Equal(t, want, append(make([]int, 0), attempts...), "one group.retry per retry")
+		})
+	}
+}
+
+func TestEngine_RunEntryEnvelope(t *testing.T) {
+	t.Parallel()
+	cfg := dagFlowCfg(t, []config.Group{
+		{Name: "a", Command: "x", Retries: 5},
+	}, []string{"a"})
+	f := cfg.Flows["f"]
+	f.Run[0].Retries = 1
+	cfg.Flows["f"] = f
+
+	r := &scriptedRunner{results: []result.RunResult{{ExitCode: 1}, {ExitCode: 1}, {ExitCode: 1}}}
+	e := New(cfg, WithRunner(r), WithRetryBackoff(time.Millisecond))
+	require.Error(t, e.RunFlow(context.Background(), "f"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&r.calls), "the run entry's retries: 1 beats the group's 5")
+}
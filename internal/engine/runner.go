@@ -3,6 +3,7 @@ package engine
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"maps"
@@ -22,13 +23,48 @@ const (
 	defaultPosixSh = "/bin/sh"
 )
 
+// DefaultKillGrace is how long a stopped command's processes get between
+// SIGTERM and SIGKILL when neither the group nor settings set kill-grace.
+const DefaultKillGrace = 5 * time.Second
+
 // Runner executes a single group and returns its structured RunResult. The
 // params argument is authoritative for the command's arguments; implementations
-// must not read g.Params or g.Commands.
+// must not read g.Params or g.Commands. g.Dir carries the already-rendered,
+// resolved working directory ("" means the process cwd).
 type Runner interface {
 	Run(ctx context.Context, g *config.Group, params []string, globalEnv map[string]string) (result.RunResult, error)
 }
 
+type stdoutTapKey struct{}
+
+// withStdoutTap asks the runner to also copy the command's stdout to w as it
+// is written. The engine watches a service's ready: log line and feeds
+// streams through it; ShellRunner honors it, and a Runner that ignores it
+// only loses that check and leaves streams empty.
+func withStdoutTap(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, stdoutTapKey{}, w)
+}
+
+// stdoutTap returns the writer set by withStdoutTap, or nil.
+func stdoutTap(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(stdoutTapKey{}).(io.Writer)
+	return w
+}
+
+type stdinKey struct{}
+
+// withStdin gives the command r as its standard input: a group's stdin:.
+// ShellRunner honors it; without it a command reads no input.
+func withStdin(ctx context.Context, r io.Reader) context.Context {
+	return context.WithValue(ctx, stdinKey{}, r)
+}
+
+// stdinFrom returns the reader set by withStdin, or nil.
+func stdinFrom(ctx context.Context) io.Reader {
+	r, _ := ctx.Value(stdinKey{}).(io.Reader)
+	return r
+}
+
 // ShellRunner executes a group via os/exec, optionally through a system shell.
 //
 // By default (group.Shell == false) it spawns Command directly with Params as
@@ -49,16 +85,29 @@ func NewShellRunner() *ShellRunner {
 
 // Run honors ctx for cancellation. It captures stdout, stderr, and the
 // chronologically interleaved combined stream into three buffers populated on
-// the returned RunResult; ExitCode and DurationMs are also filled in.
+// the returned RunResult; ExitCode and DurationMs are also filled in. Under a
+// capture limit (see withCapture) each buffer keeps only its share, and
+// Truncated reports whether one had to drop output.
+//
+// On timeout or cancellation the command's whole process tree is stopped:
+// SIGTERM first, SIGKILL once g.KillGrace (default DefaultKillGrace) has
+// passed. RunResult.Termination records why a command was stopped.
 //
 // The command and arguments come from user-supplied configuration; that is
 // the point of this tool. gosec G204 is suppressed for the exec call.
 func (r *ShellRunner) Run(ctx context.Context, g *config.Group, params []string, globalEnv map[string]string) (result.RunResult, error) {
 	cmd := r.buildCmd(ctx, g, params, globalEnv)
+	pg := newProcGroup(cmd, killGrace(g))
 
-	captureStdout := &safeBuf{}
-	captureStderr := &safeBuf{}
-	captureCombined := &safeBuf{}
+	captureStdout, captureStderr, captureCombined := &safeBuf{}, &safeBuf{}, &safeBuf{}
+	combined := io.Writer(captureCombined)
+	if limit, ok := captureFrom(ctx); ok {
+		captureStdout, captureStderr, captureCombined = limit.buffer(), limit.buffer(), limit.buffer()
+		combined = captureCombined
+		if limit.log != nil {
+			combined = io.MultiWriter(captureCombined, limit.log)
+		}
+	}
 	stdout := r.Stdout
 	if stdout == nil {
 		stdout = os.Stdout
@@ -67,12 +116,22 @@ func (r *ShellRunner) Run(ctx context.Context, g *config.Group, params []string,
 	if stderr == nil {
 		stderr = os.Stderr
 	}
-	cmd.Stdout = io.MultiWriter(stdout, captureStdout, captureCombined)
-	cmd.Stderr = io.MultiWriter(stderr, captureStderr, captureCombined)
+	cmd.Stdout = io.MultiWriter(stdout, captureStdout, combined)
+	if tap := stdoutTap(ctx); tap != nil {
+		cmd.Stdout = io.MultiWriter(stdout, captureStdout, combined, tap)
+	}
+	cmd.Stderr = io.MultiWriter(stderr, captureStderr, combined)
+	cmd.Stdin = stdinFrom(ctx)
 
 	start := time.Now()
 	runErr := cmd.Run()
+	pg.reap()
 	durationMs := time.Since(start).Milliseconds()
+	if errors.Is(runErr, exec.ErrWaitDelay) && ctx.Err() == nil {
+		// The command succeeded; only a background process it left behind
+		// still held the output pipes.
+		runErr = nil
+	}
 
 	exitCode := 0
 	if cmd.ProcessState != nil {
@@ -85,15 +144,43 @@ func (r *ShellRunner) Run(ctx context.Context, g *config.Group, params []string,
 		ExitCode:   exitCode,
 		DurationMs: durationMs,
 		Status:     result.StatusOK,
+		Truncated:  captureStdout.truncated() || captureStderr.truncated() || captureCombined.truncated(),
 	}
 	if runErr != nil {
+		rr.Termination = terminationReason(ctx, cmd.ProcessState)
+		if rr.Termination != "" {
+			return rr, fmt.Errorf("run %q: stopped by %s: %w", g.Name, rr.Termination, runErr)
+		}
 		return rr, fmt.Errorf("run %q: %w", g.Name, runErr)
 	}
 	return rr, nil
 }
 
+// terminationReason tells why a failed command was stopped: keepup's timeout
+// or cancellation, or a signal from elsewhere. It is empty for a command
+// that exited on its own.
+func terminationReason(ctx context.Context, state *os.ProcessState) string {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return result.TerminationTimeout
+	case ctx.Err() != nil:
+		return result.TerminationCancel
+	case state != nil && state.ExitCode() == -1:
+		return result.TerminationSignal
+	}
+	return ""
+}
+
+// killGrace returns the group's kill-grace, already validated at load.
+func killGrace(g *config.Group) time.Duration {
+	if d, err := time.ParseDuration(g.KillGrace); err == nil {
+		return d
+	}
+	return DefaultKillGrace
+}
+
 // buildCmd assembles the exec.Cmd for a group invocation, honoring shell
-// opt-in and the layered environment.
+// opt-in, the resolved working directory, and the layered environment.
 func (r *ShellRunner) buildCmd(ctx context.Context, g *config.Group, params []string, globalEnv map[string]string) *exec.Cmd {
 	var cmd *exec.Cmd
 	if g.UseShell() {
@@ -106,22 +193,51 @@ func (r *ShellRunner) buildCmd(ctx context.Context, g *config.Group, params []st
 	} else {
 		cmd = exec.CommandContext(ctx, g.Command, params...) //nolint:gosec // user-declared command
 	}
+	cmd.Dir = g.Dir
 	cmd.Env = mergeEnvs(os.Environ(), globalEnv, g.Env)
 	return cmd
 }
 
 // safeBuf is a goroutine-safe wrapper around bytes.Buffer; os/exec writes
 // stdout and stderr from independent goroutines, so the capture buffer must
-// be synchronized.
+// be synchronized. A limited buffer keeps at most limit bytes: the first
+// ones when head is set, the last ones otherwise.
 type safeBuf struct {
-	mu  sync.Mutex
-	buf bytes.Buffer
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	limit   int64
+	limited bool
+	head    bool
+	cut     bool
 }
 
 func (s *safeBuf) Write(p []byte) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.buf.Write(p)
+	n := len(p)
+	switch {
+	case !s.limited:
+		return s.buf.Write(p)
+	case s.head:
+		if room := s.limit - int64(s.buf.Len()); int64(len(p)) > room {
+			p, s.cut = p[:max(room, 0)], true
+		}
+		s.buf.Write(p)
+	default:
+		s.buf.Write(p)
+		if over := int64(s.buf.Len()) - s.limit; over > 0 {
+			s.buf.Next(int(over))
+			s.cut = true
+		}
+	}
+	return n, nil
+}
+
+// truncated reports whether a limited buffer dropped output.
+func (s *safeBuf) truncated() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cut
 }
 
 func (s *safeBuf) String() string {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
@@ -207,3 +208,51 @@ func TestShellRunner_SeparatesStdoutAndStderr(t *testing.T) {
 	assert.Equal(t, 0, rr.ExitCode)
 	assert.GreaterOrEqual(t, rr.DurationMs, int64(0))
 }
+
+func TestShellRunner_HonorsDir(t *testing.T) {
+	skipOnWindows(t)
+	t.Parallel()
+	dir := t.TempDir()
+	r := &ShellRunner{Stdout: io.Discard, Stderr: io.Discard}
+	out, err := r.Run(context.Background(), &config.Group{Name: "g", Command: "pwd", Dir: dir}, nil, nil)
+	require.NoError(t, err)
+	want, err := filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+	got, err := filepath.EvalSymlinks(strings.TrimSpace(out.Stdout))
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestShellRunner_Stdin(t *testing.T) {
+	skipOnWindows(t)
+	t.Parallel()
+	r := &ShellRunner{Stdout: io.Discard, Stderr: io.Discard}
+	ctx := withStdin(context.Background(), strings.NewReader("b\na\n"))
+	out, err := r.Run(ctx, &config.Group{Name: "g", Command: "sort"}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "a\nb\n", out.Stdout)
+}
+
+func TestShellRunner_StreamBetweenGroups(t *testing.T) {
+	skipOnWindows(t)
+	t.Parallel()
+	cfg := stepFlowCfg(t, []config.Group{
+		{Name: "gen", Command: "seq 1 100000", Shell: "/bin/sh"},
+		{Name: "count", Command: "wc", Params: []string{"-l"}, Stdin: &config.Stdin{From: "gen", Stream: true}},
+		{Name: "first", Command: "head", Params: []string{"-n", "1"}, Stdin: &config.Stdin{From: "gen", Stream: true}},
+	}, [][]string{{"gen", "count", "first"}})
+	e := New(cfg, WithRunner(&ShellRunner{Stdout: io.Discard, Stderr: io.Discard}))
+
+	done := make(chan error, 1)
+	go func() { done <- e.RunFlow(context.Background(), "f") }()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("flow did not finish")
+	}
+	count, _ := e.Outputs().Get("count")
+	assert.Equal(t, "100000", strings.TrimSpace(count.Stdout))
+	first, _ := e.Outputs().Get("first")
+	assert.Equal(t, "1\n", first.Stdout, "a consumer may stop reading early")
+}
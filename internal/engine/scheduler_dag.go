@@ -35,6 +35,15 @@ type dagScheduler struct {
 	// ready is the worklist of groups whose predecessors are all terminal and
 	// that still need a skip-or-launch decision.
 	ready []string
+	// waiting holds the nodes cleared to run but not launched yet: their
+	// uses: are not free, or max-concurrency nodes are already running. They
+	// launch best first by order; wake is closed by the next release of any
+	// resource.
+	waiting []string
+	wake    <-chan struct{}
+	order   *memberOrder
+	running int
+	limit   int
 
 	// remaining is the count of groups not yet at a terminal state; the run
 	// is complete when it reaches zero.
@@ -72,16 +81,21 @@ func (s *dagScheduler) onDone(name string, wasSkipped bool) {
 type decision int
 
 const (
-	decisionRun  decision = iota // launch the group on a worker goroutine
-	decisionSkip                 // skip (own when: falsey, or cascade-poisoned)
-	decisionErr                  // predicate render error recorded in schedErr
+	decisionRun    decision = iota // launch the group on a worker goroutine
+	decisionSkip                   // skip (own when: falsey, or cascade-poisoned)
+	decisionErr                    // predicate render error recorded in schedErr
+	decisionReplay                 // --resume restored the group's output
 )
 
-// decide evaluates a ready node. A cascade-poisoned node short-circuits to
+// decide evaluates a ready node. A node restored by --resume short-circuits
+// to decisionReplay; a cascade-poisoned node short-circuits to
 // decisionSkip. A node with a when: predicate evaluates it against a stable
 // snapshot; render errors record schedErr, cancel the run, and return
 // decisionErr so the worklist unwinds quickly.
 func (s *dagScheduler) decide(name string) decision {
+	if s.engine.replayed[name] {
+		return decisionReplay
+	}
 	if s.skipped[name] {
 		return decisionSkip
 	}
@@ -89,7 +103,8 @@ func (s *dagScheduler) decide(name string) decision {
 	if expr == "" {
 		return decisionRun
 	}
-	run, err := s.engine.evalWhen(expr, s.baseline())
+	group := s.engine.groups[name]
+	run, err := s.engine.evalWhen(expr, s.baseline(), &group)
 	if err != nil {
 		s.schedErr = fmt.Errorf("flow %q: group %q: when: %w", s.plan.Flow, name, err)
 		s.cancel()
@@ -102,9 +117,10 @@ func (s *dagScheduler) decide(name string) decision {
 	return decisionRun
 }
 
-// drainReady decides each ready node: skip (cascading synchronously) or launch.
-// A skip can make successors ready, which may themselves skip, so this loops
-// until the worklist is empty or a predicate error aborts the run.
+// drainReady decides each ready node: skip (cascading synchronously) or queue
+// it to launch. A skip can make successors ready, which may themselves skip,
+// so this loops until the worklist is empty or a predicate error aborts the
+// run; the queued nodes then launch best first.
 func (s *dagScheduler) drainReady() {
 	for len(s.ready) > 0 {
 		name := s.ready[len(s.ready)-1]
@@ -116,10 +132,55 @@ func (s *dagScheduler) drainReady() {
 		case decisionSkip:
 			s.skipped[name] = true
 			s.engine.outputs.Set(name, result.RunResult{Status: result.StatusSkipped})
+			s.engine.streams.finish(name, StatusSkipped)
 			s.engine.emitGroupSkipped(name, s.skipReason[name])
 			s.onDone(name, true)
+		case decisionReplay:
+			s.engine.emitGroupResumed(name)
+			s.onDone(name, false)
 		case decisionRun:
-			s.launch(name)
+			s.waiting = append(s.waiting, name)
+		}
+	}
+	s.retryWaiting()
+}
+
+// tryLaunch launches a node when a concurrency slot and its uses: are free,
+// and the producer of any stream it reads has begun; it parks the node in
+// waiting otherwise.
+func (s *dagScheduler) tryLaunch(name string) {
+	if (s.limit > 0 && s.running >= s.limit) || s.engine.streamPending(name) {
+		s.waiting = append(s.waiting, name)
+		return
+	}
+	ok, wake := s.engine.resources.tryAcquire(s.engine.uses(name))
+	if !ok {
+		if s.wake == nil {
+			s.wake = wake
+		}
+		s.engine.log.Debug("waiting for resources", "group", name)
+		s.waiting = append(s.waiting, name)
+		return
+	}
+	s.running++
+	s.engine.streams.begin(name)
+	s.launch(name)
+}
+
+// retryWaiting offers every parked node, best first, a chance to launch.
+// A launch can clear a stream's consumers to start, so it goes over them
+// again until a pass launches nothing.
+func (s *dagScheduler) retryWaiting() {
+	for {
+		parked := s.waiting
+		s.waiting, s.wake = nil, nil
+		s.order.sort(parked)
+		running := s.running
+		for _, name := range parked {
+			s.tryLaunch(name)
+		}
+		if s.running == running || len(s.waiting) == 0 {
+			return
 		}
 	}
 }
@@ -130,6 +191,10 @@ func (s *dagScheduler) drainReady() {
 // is skipped, and every group downstream of it cascades to skipped too — a
 // consumer cannot run on a producer's missing output.
 //
+// When more nodes are ready than max-concurrency or their uses: allow, the
+// scheduler starts the one with the longest expected path to the end of the
+// flow first (see memberOrder), unless a priority: says otherwise.
+//
 // All skip decisions and bookkeeping live in this single scheduler goroutine
 // (the dagScheduler value); workers only run groups, publish outputs under
 // snapMu, and signal completion on doneCh. That keeps the conditional logic
@@ -142,9 +207,6 @@ func (e *Engine) runDAGPlan(ctx context.Context, p *plan.Plan, flow *config.Flow
 
 	doneCh := make(chan string, len(p.Members))
 	g, gctx := errgroup.WithContext(ctx)
-	if e.maxConcurrency > 0 {
-		g.SetLimit(e.maxConcurrency)
-	}
 
 	var (
 		snapMu sync.RWMutex
@@ -157,17 +219,28 @@ func (e *Engine) runDAGPlan(ctx context.Context, p *plan.Plan, flow *config.Flow
 		return cloneSnapshot(snap)
 	}
 
+	entries := make(map[string]*config.RunEntry, len(flow.Run))
+	for i := range flow.Run {
+		entries[flow.Run[i].Member()] = &flow.Run[i]
+	}
 	launch := func(name string) {
-		group := e.groups[name]
+		genv := env
+		genv.allowFailure = p.AllowFailure[name]
+		genv.entry = entries[name]
 		g.Go(func() error {
-			if err := e.runGroup(gctx, &group, baseline(), env); err != nil {
+			err := e.runMember(gctx, p, name, baseline(), genv)
+			e.resources.release(e.uses(name))
+			if err != nil {
 				return err
 			}
+			snapMu.Lock()
 			if v, ok := e.outputs.Get(name); ok {
-				snapMu.Lock()
 				snap[name] = v
-				snapMu.Unlock()
 			}
+			if p.SubFlows[name] {
+				maps.Copy(snap, subFlowOutputs(e.outputs.Snapshot(), name))
+			}
+			snapMu.Unlock()
 			select {
 			case doneCh <- name:
 			case <-gctx.Done():
@@ -184,6 +257,8 @@ func (e *Engine) runDAGPlan(ctx context.Context, p *plan.Plan, flow *config.Flow
 		skipReason:      make(map[string]string, len(p.Members)),
 		ready:           append(make([]string, 0, len(p.Members)), p.Roots...),
 		remaining:       len(p.Members),
+		order:           e.memberOrder(p),
+		limit:           e.maxConcurrency,
 		launch:          launch,
 		cancel:          cancel,
 		baseline:        baseline,
@@ -205,17 +280,22 @@ func (e *Engine) runDAGPlan(ctx context.Context, p *plan.Plan, flow *config.Flow
 
 // pump pumps completed groups from doneCh back into the scheduler until every
 // group has terminated (run or skipped), a predicate error aborts, or the
-// context is canceled. Lives on dagScheduler because every operation it
-// performs is scheduler-state mutation.
+// context is canceled. A release of resources by any group, including one
+// of another flow sharing the pool, retries the parked nodes. Lives on
+// dagScheduler because every operation it performs is scheduler-state
+// mutation.
 func (s *dagScheduler) pump(gctx context.Context, doneCh <-chan string) {
 	for s.remaining > 0 {
 		select {
 		case finished := <-doneCh:
+			s.running--
 			s.onDone(finished, false)
 			s.drainReady()
 			if s.schedErr != nil {
 				return
 			}
+		case <-s.wake:
+			s.retryWaiting()
 		case <-gctx.Done():
 			return
 		}
@@ -226,7 +306,7 @@ func (s *dagScheduler) pump(gctx context.Context, doneCh <-chan string) {
 // and a group.end event marking it skipped on the structured stream.
 func (e *Engine) emitGroupSkipped(name, reason string) {
 	e.log.Info("group skipped", "group", name, "reason", reason)
-	e.emitter.Emit(Event{Event: EventGroupEnd, Group: name, Status: StatusSkipped, Reason: reason})
+	e.emit(Event{Event: EventGroupEnd, Group: name, Status: StatusSkipped, Reason: reason})
 }
 
 func cloneSnapshot(m map[string]result.RunResult) map[string]result.RunResult {
@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 
 	"golang.org/x/sync/errgroup"
@@ -10,13 +11,14 @@ import (
 	"github.com/quike/keepup/internal/config"
 	"github.com/quike/keepup/internal/plan"
 	"github.com/quike/keepup/internal/result"
-	"github.com/quike/keepup/internal/template"
 )
 
-// evalWhen renders a step's `when` predicate and reports whether the step
+// evalWhen renders a `when` predicate and reports whether its step or group
 // should run. The result is falsey (skip) for "", "false", "0", "no", "off".
-func (e *Engine) evalWhen(expr string, baseline map[string]result.RunResult) (bool, error) {
-	out, err := e.expander.Expand(expr, template.Data{Outputs: baseline, Env: e.cfg.Env})
+// group is the dag entry's group (nil for a step), whose matrix values and
+// namespace the predicate may use.
+func (e *Engine) evalWhen(expr string, baseline map[string]result.RunResult, group *config.Group) (bool, error) {
+	out, err := e.expander.Expand(expr, e.templateData(baseline, group))
 	if err != nil {
 		return false, err
 	}
@@ -33,19 +35,26 @@ func (e *Engine) evalWhen(expr string, baseline map[string]result.RunResult) (bo
 // sees a baseline snapshot of outputs from prior waves only, and runs under
 // the envelope resolved from its step (overriding the flow defaults).
 func (e *Engine) runStepPlan(ctx context.Context, p *plan.Plan, flow *config.Flow) error {
+	order := e.memberOrder(p)
 	for waveIdx, wave := range p.Waves {
 		step := &flow.Steps[waveIdx]
+		if len(wave) == 0 {
+			continue // every group of this step is outside the selection
+		}
+		if wave = e.resumeWave(wave); len(wave) == 0 {
+			continue
+		}
 		e.log.Info("step", "step", waveIdx+1, "groups", wave)
 
 		baseline := e.outputs.Snapshot()
 		if step.When != "" {
-			run, err := e.evalWhen(step.When, baseline)
+			run, err := e.evalWhen(step.When, baseline, nil)
 			if err != nil {
 				return fmt.Errorf("step %d: when: %w", waveIdx+1, err)
 			}
 			if !run {
 				e.log.Info("step skipped", "step", waveIdx+1, "reason", "when", "predicate", step.When)
-				for _, name := range step.Run {
+				for _, name := range wave {
 					e.outputs.Set(name, result.RunResult{Status: result.StatusSkipped})
 				}
 				continue
@@ -53,18 +62,94 @@ func (e *Engine) runStepPlan(ctx context.Context, p *plan.Plan, flow *config.Flo
 		}
 
 		env := resolveEnvelope(flow, step)
-		g, gctx := errgroup.WithContext(ctx)
-		if e.maxConcurrency > 0 {
-			g.SetLimit(e.maxConcurrency)
-		}
-		for _, name := range wave {
-			group := e.groups[name]
-			g.Go(func() error { return e.runGroup(gctx, &group, baseline, env) })
-		}
-		if err := g.Wait(); err != nil {
+		if err := e.runWave(ctx, p, wave, order, baseline, env); err != nil {
 			return fmt.Errorf("step %d: %w", waveIdx+1, err)
 		}
 		e.log.Info("step completed", "step", waveIdx+1)
 	}
 	return nil
 }
+
+// runWave runs the groups of one wave in parallel, starting them in order
+// (priority: first, then the longest expected run time) so that under
+// max-concurrency the slowest groups do not start last. A group whose uses:
+// are not free waits, without holding a concurrency slot, until a running
+// group releases them; the rest of the wave starts meanwhile. A group that
+// reads a stream starts only once its producer has.
+func (e *Engine) runWave(ctx context.Context, p *plan.Plan, wave []string, order *memberOrder,
+	baseline map[string]result.RunResult, env envelope,
+) error {
+	g, gctx := errgroup.WithContext(ctx)
+	if e.maxConcurrency > 0 {
+		g.SetLimit(e.maxConcurrency)
+	}
+	pending := slices.Clone(wave)
+	order.sort(pending)
+	for len(pending) > 0 {
+		var wake <-chan struct{}
+		launched := false
+		parked := pending[:0]
+		for _, name := range pending {
+			if e.streamPending(name) {
+				parked = append(parked, name)
+				continue
+			}
+			uses := e.uses(name)
+			ok, w := e.resources.tryAcquire(uses)
+			if !ok {
+				if wake == nil {
+					wake = w
+				}
+				parked = append(parked, name)
+				continue
+			}
+			e.streams.begin(name)
+			launched = true
+			g.Go(func() error {
+				defer e.resources.release(uses)
+				return e.runMember(gctx, p, name, baseline, env)
+			})
+		}
+		pending = parked
+		if len(pending) == 0 {
+			break
+		}
+		if launched {
+			continue // a producer may have started: offer its consumers a slot
+		}
+		e.log.Debug("waiting for resources", "groups", pending)
+		select {
+		case <-wake:
+		case <-gctx.Done():
+			if err := g.Wait(); err != nil {
+				return err
+			}
+			return gctx.Err()
+		}
+	}
+	return g.Wait()
+}
+
+// resumeWave reports the groups of a wave that --resume restored and returns
+// the rest, which still have to run: the whole wave, none of it, or only
+// its services, which a resume always starts again.
+func (e *Engine) resumeWave(wave []string) []string {
+	if len(e.replayed) == 0 {
+		return wave
+	}
+	var rest []string
+	for _, name := range wave {
+		if !e.replayed[name] {
+			rest = append(rest, name)
+		}
+	}
+	if len(rest) == len(wave) {
+		return wave
+	}
+	for _, name := range wave {
+		if e.replayed[name] {
+			e.emitGroupResumed(name)
+		}
+	}
+	return rest
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/plan"
+	"github.com/quike/keepup/internal/result"
+)
+
+// seedExternal publishes the outputs of groups outside a selected slice that
+// the slice references. Each comes from the group's cache entry, accepted
+// only while its cache.reads inputs still match the fingerprint the entry
+// was saved with; anything else is an error naming the consumer, since
+// running the slice without it would render an empty reference. Dry runs
+// publish a dry-run placeholder instead.
+func (e *Engine) seedExternal(ctx context.Context, p *plan.Plan) error {
+	for _, name := range p.External {
+		if e.dryRun {
+			e.outputs.Set(name, result.RunResult{Status: result.StatusDryRun})
+			continue
+		}
+		group := e.groups[name]
+		entry, ok := e.externalEntry(ctx, &group)
+		if !ok {
+			return fmt.Errorf(
+				"flow %q: the selection needs the output of %q, which is not selected and has no valid cache entry; "+
+					"add it to the selection or run it once with a cache: block",
+				p.Flow, name)
+		}
+		cached := entry.Result
+		cached.Status = result.StatusCached
+		e.outputs.Set(name, cached)
+		e.exportEnv(name, cached.EnvFile)
+		e.log.Info("using cached output of unselected group", "group", name, "fingerprint", entry.Fingerprint)
+	}
+	return nil
+}
+
+// externalEntry loads a group's cache entry and re-fingerprints the commands
+// it was stored with against the current inputs.
+func (e *Engine) externalEntry(ctx context.Context, group *config.Group) (*cache.Entry, bool) {
+	if e.noCache || group.Cache == nil {
+		return nil, false
+	}
+	entry, ok := e.cache.Load(group.Name)
+	if !ok {
+		return nil, false
+	}
+	resolved, err := e.resolveGroup(group, e.templateData(e.outputs.Snapshot(), group))
+	if err != nil {
+		return nil, false
+	}
+	fp, err := e.fingerprint(ctx, &resolved, entry.Commands)
+	if err != nil || fp != entry.Fingerprint {
+		return nil, false
+	}
+	return entry, true
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/plan"
+)
+
+// sliceCfg is a two-step flow where "ship" consumes the cached "build".
+func sliceCfg(t *testing.T, readPath string) *config.Config {
+	t.Helper()
+	return stepFlowCfg(t, []config.Group{
+		{
+			Name:    "build",
+			Command: "echo",
+			Cache:   &config.Cache{Method: config.CacheHash, Reads: []string{readPath}},
+		},
+		{Name: "lint", Command: "echo"},
+		{Name: "ship", Command: "echo", Params: []string{`{{ output "build" }}`}},
+	}, [][]string{{"build", "lint"}, {"ship"}})
+}
+
+func TestEngine_SelectionRunsOnlyTheSlice(t *testing.T) {
+	t.Parallel()
+	cfg := stepFlowCfg(t, []config.Group{
+		{Name: "a", Command: "echo"},
+		{Name: "b", Command: "echo"},
+		{Name: "c", Command: "echo"},
+	}, [][]string{{"a"}, {"b"}, {"c"}})
+	r := &fakeRunner{}
+	e := New(cfg, WithRunner(r), WithSelection(plan.Selection{From: "b"}))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"b:", "c:"}, r.calls)
+}
+
+func TestEngine_SelectionSeedsExternalFromCache(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	readPath := filepath.Join(dir, "main.go")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	store := cache.NewFileStore(filepath.Join(dir, "cache"))
+
+	r1 := &fakeRunner{outputs: map[string]string{"build": "bin"}}
+	require.NoError(t, New(sliceCfg(t, readPath), WithRunner(r1), WithCache(store)).RunFlow(context.Background(), "f"))
+
+	r2 := &fakeRunner{}
+	e := New(sliceCfg(t, readPath), WithRunner(r2), WithCache(store),
+		WithSelection(plan.Selection{Only: []string{"ship"}}))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"ship:bin"}, r2.calls)
+}
+
+func TestEngine_SelectionSeedsExternalExports(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	readPath := filepath.Join(dir, "main.go")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	store := cache.NewFileStore(filepath.Join(dir, "cache"))
+
+	r1 := &fakeRunner{outputs: map[string]string{"build": "bin"}}
+	require.NoError(t, New(sliceCfg(t, readPath), WithRunner(r1), WithCache(store)).RunFlow(context.Background(), "f"))
+	entry, ok := store.Load("build")
+	require.True(t, ok)
+	entry.Result.EnvFile = map[string]string{"IMAGE": "app:1"}
+	require.NoError(t, store.Save("build", entry))
+
+	e := New(sliceCfg(t, readPath), WithRunner(&fakeRunner{}), WithCache(store),
+		WithSelection(plan.Selection{Only: []string{"ship"}}))
+	require.NoError(t, e.RunFlow(context.Background(), "f"))
+	assert.Equal(t, "app:1", e.envFor(&config.Group{Name: "ship"})["IMAGE"], "the seeded group's exports survive")
+}
+
+func TestEngine_SelectionFailsWithoutExternalOutput(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	readPath := filepath.Join(dir, "main.go")
+	require.NoError(t, writeF(readPath, "package main\n"))
+	store := cache.NewFileStore(filepath.Join(dir, "cache"))
+
+	r1 := &fakeRunner{outputs: map[string]string{"build": "bin"}}
+	require.NoError(t, New(sliceCfg(t, readPath), WithRunner(r1), WithCache(store)).RunFlow(context.Background(), "f"))
+	// The cached entry no longer matches its inputs.
+	require.NoError(t, writeF(readPath, "package main // changed\n"))
+
+	r2 := &fakeRunner{}
+	err := New(sliceCfg(t, readPath), WithRunner(r2), WithCache(store),
+		WithSelection(plan.Selection{Only: []string{"ship"}})).RunFlow(context.Background(), "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `needs the output of "build"`)
+	assert.Empty(t, r2.calls)
+}
+
+func TestEngine_SelectionRefusesResume(t *testing.T) {
+	t.Parallel()
+	cfg := stepFlowCfg(t, []config.Group{{Name: "a", Command: "echo"}}, [][]string{{"a"}})
+	store := cache.NewFileStore(filepath.Join(t.TempDir(), "cache"))
+	e := New(cfg, WithRunner(&fakeRunner{}), WithRunStore(store), WithResume(true),
+		WithSelection(plan.Selection{Only: []string{"a"}}))
+	err := e.RunFlow(context.Background(), "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot resume a partial run")
+}
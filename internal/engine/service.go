@@ -0,0 +1,251 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+// DefaultReadyTimeout is how long a service gets to pass its ready: check
+// when it sets no ready.timeout.
+const DefaultReadyTimeout = 30 * time.Second
+
+// readyPollInterval spaces the ready: command and tcp checks.
+const readyPollInterval = 100 * time.Millisecond
+
+// service is a service group's command running in the background.
+type service struct {
+	name  string
+	start time.Time
+	stop  context.CancelFunc
+	done  chan struct{} // closed once the command has exited
+	out   result.RunResult
+	err   error // the command's error; read only after done is closed
+}
+
+// serviceSet holds the services an Engine started during the current
+// RunFlow, in the order they became ready.
+type serviceSet struct {
+	mu      sync.Mutex
+	running []*service
+}
+
+func (s *serviceSet) add(svc *service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = append(s.running, svc)
+}
+
+func (s *serviceSet) take() []*service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	running := s.running
+	s.running = nil
+	return running
+}
+
+// serviceCapture is how much of a service's output the runner keeps: the
+// last bytes of each stream, reported only when the service fails to come
+// up. A service runs as long as the flow, so keeping all of it would grow
+// without bound.
+const serviceCapture = 64 << 10
+
+// startService runs a service group's command in the background and waits
+// for its ready: check. The command outlives ctx, which only bounds the
+// wait: it runs until stopServices ends it with the flow. The published
+// result carries the stdout written until the service became ready, and
+// Output holds the ready value (see readyValue).
+func (e *Engine) startService(ctx context.Context, group *config.Group, spec config.CommandSpec) (result.RunResult, error) {
+	sg := e.commandGroup(group, spec)
+	watch := newReadyWatch(group.Ready)
+	svcCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	svc := &service{name: group.Name, start: time.Now(), stop: stop, done: make(chan struct{})}
+	go func() {
+		defer close(svc.done)
+		runCtx := withCapture(withStdoutTap(svcCtx, watch), captureLimit{max: serviceCapture})
+		svc.out, svc.err = e.runner.Run(runCtx, &sg, spec.Params, e.envFor(group))
+	}()
+
+	value, err := e.awaitReady(ctx, group, svc, watch)
+	if err != nil {
+		svc.stop()
+		<-svc.done
+		return svc.out, err
+	}
+	e.services.add(svc)
+	e.log.Info("service ready", "group", group.Name, "value", value)
+	return result.RunResult{
+		Stdout:     watch.stdout(),
+		Output:     value,
+		DurationMs: msSince(svc.start),
+		Status:     result.StatusOK,
+	}, nil
+}
+
+// awaitReady polls the service's ready: check until it passes, the service
+// exits, ready.timeout passes, or ctx is canceled.
+func (e *Engine) awaitReady(ctx context.Context, group *config.Group, svc *service, watch *readyWatch) (string, error) {
+	r := group.Ready
+	if r == nil {
+		r = &config.Ready{}
+	}
+	timeout := DefaultReadyTimeout
+	if d, err := time.ParseDuration(r.Timeout); err == nil {
+		timeout = d
+	}
+	rctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	tick := time.NewTicker(readyPollInterval)
+	defer tick.Stop()
+	for {
+		if value, ok := e.readyValue(rctx, group, r, watch); ok {
+			return value, nil
+		}
+		select {
+		case <-svc.done:
+			if svc.err != nil {
+				return "", fmt.Errorf("group %q: service exited before it was ready: %w", group.Name, svc.err)
+			}
+			return "", fmt.Errorf("group %q: service exited before it was ready", group.Name)
+		case <-watch.matched:
+		case <-tick.C:
+		case <-rctx.Done():
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("group %q: service not ready after %s", group.Name, timeout)
+		}
+	}
+}
+
+// readyValue runs one ready: check and, once it passes, returns the value
+// the service publishes as its output: the tcp address, the log match (its
+// first capture group when the pattern has one), or else the stdout written
+// so far.
+func (e *Engine) readyValue(ctx context.Context, group *config.Group, r *config.Ready, watch *readyWatch) (string, bool) {
+	switch {
+	case r.Command != "":
+		if e.prober.Probe(ctx, r.Command, group.Dir, e.envFor(group)) != nil {
+			return "", false
+		}
+	case r.TCP != "":
+		d := net.Dialer{Timeout: time.Second}
+		conn, err := d.DialContext(ctx, "tcp", r.TCP)
+		if err != nil {
+			return "", false
+		}
+		_ = conn.Close()
+		return r.TCP, true
+	case r.Log != "":
+		return watch.match()
+	}
+	return strings.TrimSpace(watch.stdout()), true
+}
+
+// stopServices stops the services the current RunFlow started, newest first,
+// once the plan and its hooks no longer need them. A service that already
+// exited on its own is reported as failed but does not fail the flow: the
+// groups that needed it have failed already if it mattered.
+func (e *Engine) stopServices() {
+	running := e.services.take()
+	for i := len(running) - 1; i >= 0; i-- {
+		svc := running[i]
+		var exited error
+		select {
+		case <-svc.done:
+			exited = svc.err
+			if exited == nil {
+				exited = errors.New("exited before the flow ended")
+			}
+		default:
+		}
+		e.log.Info("stopping service", "group", svc.name)
+		svc.stop()
+		<-svc.done
+		ev := Event{Event: EventServiceStop, Group: svc.name, Status: StatusOK, DurationMS: msSince(svc.start)}
+		if exited != nil {
+			e.log.Warn("service stopped early", "group", svc.name, "err", exited.Error())
+			ev.Status, ev.Err = StatusFailed, exited.Error()
+		}
+		e.emit(ev)
+	}
+}
+
+// readyWatch receives a service's stdout. It keeps what is written until
+// the service is ready and, for a ready: log check, matches each complete
+// line against the pattern; matched is closed on the first match.
+type readyWatch struct {
+	mu      sync.Mutex
+	re      *regexp.Regexp
+	buf     bytes.Buffer
+	line    []byte
+	value   string
+	hit     bool
+	frozen  bool
+	matched chan struct{}
+}
+
+func newReadyWatch(r *config.Ready) *readyWatch {
+	w := &readyWatch{}
+	if r != nil && r.Log != "" {
+		// Validated at config load.
+		w.re = regexp.MustCompile(r.Log)
+		w.matched = make(chan struct{})
+	}
+	return w
+}
+
+func (w *readyWatch) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.frozen {
+		return len(p), nil
+	}
+	w.buf.Write(p)
+	if w.re == nil || w.hit {
+		return len(p), nil
+	}
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.line[:i]), "\r")
+		w.line = w.line[i+1:]
+		if m := w.re.FindStringSubmatch(line); m != nil {
+			w.value = m[0]
+			if len(m) > 1 {
+				w.value = m[1]
+			}
+			w.hit, w.line = true, nil
+			close(w.matched)
+			break
+		}
+	}
+	return len(p), nil
+}
+
+func (w *readyWatch) match() (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.value, w.hit
+}
+
+// stdout returns what the service wrote so far. Once the service is ready
+// the watch stops keeping output, so a long-lived service does not grow it.
+func (w *readyWatch) stdout() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.frozen = true
+	return w.buf.String()
+}
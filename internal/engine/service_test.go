@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+// serviceRunner runs service groups until they are stopped and records, in
+// order, what it started, ran, and stopped. It doubles as the Prober: the
+// predicate "up:<name>" passes once that service has started.
+type serviceRunner struct {
+	mu      sync.Mutex
+	log     []string
+	started map[string]bool
+	limits  map[string]int64 // the capture limit each service ran under
+	fail    map[string]error // services that exit with this error at once
+}
+
+func newServiceRunner() *serviceRunner {
+	return &serviceRunner{started: map[string]bool{}, limits: map[string]int64{}, fail: map[string]error{}}
+}
+
+func (r *serviceRunner) record(entry string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.log = append(r.log, entry)
+}
+
+func (r *serviceRunner) entries() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.log...)
+}
+
+func (r *serviceRunner) Run(ctx context.Context, g *config.Group, params []string, _ map[string]string) (result.RunResult, error) {
+	if !g.Service {
+		r.record("run " + g.Name + " " + strings.Join(params, ","))
+		if g.Command == "false" {
+			return result.RunResult{ExitCode: 1}, errors.New("exit status 1")
+		}
+		return result.RunResult{Status: result.StatusOK}, nil
+	}
+	if err, ok := r.fail[g.Name]; ok {
+		return result.RunResult{ExitCode: 1}, err
+	}
+	if g.Ready != nil && g.Ready.TCP != "" {
+		ln, err := net.Listen("tcp", g.Ready.TCP)
+		if err != nil {
+			return result.RunResult{}, err
+		}
+		defer ln.Close()
+	}
+	r.record("start " + g.Name)
+	r.mu.Lock()
+	r.started[g.Name] = true
+	if limit, ok := captureFrom(ctx); ok {
+		r.limits[g.Name] = limit.max
+	}
+	r.mu.Unlock()
+	if tap := stdoutTap(ctx); tap != nil {
+		fmt.Fprintf(tap, "booting\nlistening on %s:80\n", g.Name)
+	}
+	<-ctx.Done()
+	r.record("stop " + g.Name)
+	return result.RunResult{}, ctx.Err()
+}
+
+func (r *serviceRunner) Probe(_ context.Context, script, _ string, _ map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if name, ok := strings.CutPrefix(script, "up:"); ok && r.started[name] {
+		return nil
+	}
+	return errors.New("not up")
+}
+
+const servicesCfg = `
+version: 2
+groups:
+  - {name: db, command: postgres, service: true, ready: {log: 'listening on (\S+)'}}
+  - {name: web, command: server, service: true, ready: {command: 'up:web'}}
+  - {name: test, command: go, params: ['{{ output "db" }}', '{{ (out "web").Status }}']}
+  - {name: crash, command: nope, service: true, ready: {command: 'up:crash'}}
+  - {name: hang, command: wait, service: true, ready: {command: never, timeout: 100ms}}
+  - {name: broken, command: "false"}
+flows:
+  step:
+    steps:
+      - run: [db]
+      - run: [web]
+      - run: [test]
+  dag:
+    mode: dag
+    run: [test, web, db]
+  crash:
+    steps:
+      - run: [crash]
+      - run: [broken]
+  hang:
+    steps:
+      - run: [hang]
+  fails:
+    steps:
+      - run: [db]
+      - run: [broken]
+`
+
+func TestEngine_ServicesRunAlongsideTheFlow(t *testing.T) {
+	t.Parallel()
+	cfg, err := config.NewConfig([]byte(servicesCfg))
+	require.NoError(t, err)
+
+	t.Run("step", func(t *testing.T) {
+		t.Parallel()
+		r := newServiceRunner()
+		var buf bytes.Buffer
+		e := New(cfg, WithRunner(r), WithProber(r), WithEmitter(NewJSONEmitter(&buf)))
+		require.NoError(t, e.RunFlow(context.Background(), "step"))
+		assert.Equal(t, []string{"start db", "start web", "run test db:80,ok", "stop web", "stop db"}, r.entries(),
+			"services stay up for later steps and stop newest first")
+
+		db, _ := e.Outputs().Get("db")
+		assert.Equal(t, "db:80", db.Output)
+		assert.Contains(t, db.Stdout, "listening on db:80")
+		var stops []string
+		for _, ev := range decodeEvents(t, buf.Bytes()) {
+			if ev.Event == EventServiceStop {
+				assert.Equal(t, StatusOK, ev.Status)
+				stops = append(stops, ev.Group)
+			}
+		}
+		assert.Equal(t, []string{"web", "db"}, stops)
+		assert.Equal(t, map[string]int64{"db": serviceCapture, "web": serviceCapture}, r.limits,
+			"a service's output is kept under a fixed bound")
+	})
+
+	t.Run("dag", func(t *testing.T) {
+		t.Parallel()
+		r := newServiceRunner()
+		require.NoError(t, New(cfg, WithRunner(r), WithProber(r)).RunFlow(context.Background(), "dag"))
+		log := r.entries()
+		require.Len(t, log, 5)
+		assert.ElementsMatch(t, []string{"start db", "start web"}, log[:2])
+		assert.Equal(t, "run test db:80,ok", log[2], "dependents wait until their services are ready")
+		assert.ElementsMatch(t, []string{"stop db", "stop web"}, log[3:])
+	})
+}
+
+func TestEngine_ServiceNotReady(t *testing.T) {
+	t.Parallel()
+	cfg, err := config.NewConfig([]byte(servicesCfg))
+	require.NoError(t, err)
+
+	t.Run("exits before ready", func(t *testing.T) {
+		t.Parallel()
+		r := newServiceRunner()
+		r.fail["crash"] = errors.New("exit status 2")
+		err := New(cfg, WithRunner(r), WithProber(r)).RunFlow(context.Background(), "crash")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `group "crash": service exited before it was ready: exit status 2`)
+		assert.Empty(t, r.entries(), "later steps never run")
+	})
+
+	t.Run("ready timeout", func(t *testing.T) {
+		t.Parallel()
+		r := newServiceRunner()
+		err := New(cfg, WithRunner(r), WithProber(r)).RunFlow(context.Background(), "hang")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `group "hang": service not ready after 100ms`)
+		assert.Equal(t, []string{"start hang", "stop hang"}, r.entries())
+	})
+
+	t.Run("stopped when the flow fails", func(t *testing.T) {
+		t.Parallel()
+		r := newServiceRunner()
+		require.Error(t, New(cfg, WithRunner(r), WithProber(r)).RunFlow(context.Background(), "fails"))
+		assert.Equal(t, []string{"start db", "run broken ", "stop db"}, r.entries())
+	})
+}
+
+func TestEngine_ServiceTCPReady(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	cfg := stepFlowCfg(t, []config.Group{
+		{Name: "api", Command: "serve", Service: true, Ready: &config.Ready{TCP: addr}},
+		{Name: "smoke", Command: "curl", Params: []string{`{{ output "api" }}`}},
+	}, [][]string{{"api"}, {"smoke"}})
+	r := newServiceRunner()
+	require.NoError(t, New(cfg, WithRunner(r), WithProber(r)).RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"start api", "run smoke " + addr, "stop api"}, r.entries())
+}
+
+func TestEngine_ResumeRestartsServices(t *testing.T) {
+	t.Parallel()
+	store := cache.NewFileStore(filepath.Join(t.TempDir(), "cache"))
+	cfg := stepFlowCfg(t, []config.Group{
+		{Name: "db", Command: "postgres", Service: true, Ready: &config.Ready{Log: "listening"}},
+		{Name: "migrate", Command: "migrate"},
+		{Name: "test", Command: "false"},
+	}, [][]string{{"db", "migrate"}, {"test"}})
+
+	first := newServiceRunner()
+	require.Error(t, New(cfg, WithRunner(first), WithRunStore(store)).RunFlow(context.Background(), "f"))
+	assert.Contains(t, first.entries(), "run test ")
+
+	cfg.Groups[2].Command = "go"
+	second := newServiceRunner()
+	require.NoError(t, New(cfg, WithRunner(second), WithRunStore(store), WithResume(true)).RunFlow(context.Background(), "f"))
+	assert.Equal(t, []string{"start db", "run test ", "stop db"}, second.entries(),
+		"migrate is replayed, db starts again for the rerun")
+}
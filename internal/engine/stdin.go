@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/template"
+)
+
+// resolveStdin renders a group's stdin: for one run. The result holds the
+// text to feed in Template (a rendered template, or the stored stdout of a
+// from: group), a path in File resolved against the group's directory, or
+// the producer of a stream open in this RunFlow. A stream whose producer
+// does not run here (not selected, or restored by --resume) is read from
+// that group's stored stdout instead.
+func (e *Engine) resolveStdin(group *config.Group, data template.Data) (*config.Stdin, error) {
+	in := group.Stdin
+	switch {
+	case in.Template != "":
+		text, err := e.expander.Expand(in.Template, data)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: expand stdin: %w", group.Name, err)
+		}
+		return &config.Stdin{Template: text}, nil
+	case in.File != "":
+		path, err := e.expander.Expand(in.File, data)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: expand stdin.file: %w", group.Name, err)
+		}
+		if !filepath.IsAbs(path) && group.Dir != "" {
+			path = filepath.Join(group.Dir, path)
+		}
+		return &config.Stdin{File: path}, nil
+	}
+	src := group.Qualify(in.From)
+	if !in.Stream {
+		return &config.Stdin{Template: data.Outputs[src].Stdout}, nil
+	}
+	if e.streams.get(src) != nil {
+		return &config.Stdin{From: src, Stream: true}, nil
+	}
+	stored, _ := e.outputs.Get(src)
+	return &config.Stdin{Template: stored.Stdout}, nil
+}
+
+// openStdin opens a resolved stdin: for the group's first command. done must
+// be called once the command exited; its error fails the command.
+func (e *Engine) openStdin(group *config.Group) (r io.Reader, done func() error, err error) {
+	in := group.Stdin
+	switch {
+	case in.Stream:
+		return e.streams.get(in.From).open()
+	case in.File != "":
+		f, err := os.Open(in.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, func() error { _ = f.Close(); return nil }, nil
+	}
+	return strings.NewReader(in.Template), func() error { return nil }, nil
+}
@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/plan"
+	"github.com/quike/keepup/internal/result"
+)
+
+// stdinRunner records the stdin each group read. A group whose command is
+// "gen" writes its params to stdout one line at a time, pausing between
+// them, so its consumers can only keep up if they read it live; "fail"
+// exits 1 after writing. Any other command echoes its stdin.
+type stdinRunner struct {
+	mu    sync.Mutex
+	read  map[string]string
+	calls int
+}
+
+func (r *stdinRunner) Run(ctx context.Context, g *config.Group, params []string, _ map[string]string) (result.RunResult, error) {
+	r.mu.Lock()
+	r.calls++
+	r.mu.Unlock()
+	if g.Command == "gen" || g.Command == "fail" {
+		var out strings.Builder
+		for _, p := range params {
+			line := p + "\n"
+			out.WriteString(line)
+			if tap := stdoutTap(ctx); tap != nil {
+				_, _ = io.WriteString(tap, line)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if g.Command == "fail" {
+			return result.RunResult{Stdout: out.String(), ExitCode: 1}, errors.New("exit status 1")
+		}
+		return result.RunResult{Stdout: out.String(), Output: out.String(), Status: result.StatusOK}, nil
+	}
+	var in []byte
+	if r := stdinFrom(ctx); r != nil {
+		in, _ = io.ReadAll(r)
+	}
+	if tap := stdoutTap(ctx); tap != nil {
+		_, _ = tap.Write(in)
+	}
+	r.mu.Lock()
+	r.read[g.Name] = string(in)
+	r.mu.Unlock()
+	return result.RunResult{Stdout: string(in), Status: result.StatusOK}, nil
+}
+
+func (r *stdinRunner) stdin(name string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.read[name]
+}
+
+func TestEngine_Stdin(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "in.txt"), []byte("from a file\n"), 0o600))
+	cfg := stepFlowCfg(t, []config.Group{
+		{Name: "gen", Command: "gen", Params: []string{"a", "b"}},
+		{Name: "greet", Command: "cat", Stdin: &config.Stdin{Template: `hello {{ output "gen" | len }}`}},
+		{Name: "file", Command: "cat", Dir: dir, Stdin: &config.Stdin{File: "in.txt"}},
+		{Name: "count", Command: "wc", Stdin: &config.Stdin{From: "gen"}},
+		{Name: "plain", Command: "cat"},
+	}, [][]string{{"gen"}, {"greet", "file", "count", "plain"}})
+
+	r := &stdinRunner{read: map[string]string{}}
+	require.NoError(t, New(cfg, WithRunner(r)).RunFlow(context.Background(), "f"))
+	assert.Equal(t, "hello 3", r.stdin("greet"))
+	assert.Equal(t, "from a file\n", r.stdin("file"))
+	assert.Equal(t, "a\nb\n", r.stdin("count"))
+	assert.Empty(t, r.stdin("plain"))
+}
+
+func TestEngine_StdinStream(t *testing.T) {
+	t.Parallel()
+	lines := make([]string, 50)
+	for i := range lines {
+		lines[i] = fmt.Sprint(i)
+	}
+	want := strings.Join(lines, "\n") + "\n"
+	groups := []config.Group{
+		{Name: "gen", Command: "gen", Params: lines},
+		{Name: "first", Command: "cat", Stdin: &config.Stdin{From: "gen", Stream: true}},
+		{Name: "second", Command: "cat", Stdin: &config.Stdin{From: "first", Stream: true}},
+	}
+
+	for name, cfg := range map[string]*config.Config{
+		"step": stepFlowCfg(t, groups, [][]string{{"second", "first", "gen"}}),
+		"dag":  dagFlowCfg(t, groups, []string{"second", "first", "gen"}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			for _, limit := range []int{0, 1} {
+				r := &stdinRunner{read: map[string]string{}}
+				cfg := *cfg
+				cfg.Settings.MaxConcurrency = limit
+				require.NoError(t, New(&cfg, WithRunner(r)).RunFlow(context.Background(), "f"))
+				assert.Equal(t, want, r.stdin("first"), "max-concurrency %d", limit)
+				assert.Equal(t, want, r.stdin("second"), "streams chain")
+			}
+		})
+	}
+}
+
+func TestEngine_StdinStreamProducerFails(t *testing.T) {
+	t.Parallel()
+	cfg := stepFlowCfg(t, []config.Group{
+		{Name: "gen", Command: "fail", Params: []string{"partial"}, AllowFailure: true},
+		{Name: "read", Command: "cat", Stdin: &config.Stdin{From: "gen", Stream: true}},
+	}, [][]string{{"gen", "read"}})
+	cfg.Flows["f"] = config.Flow{Mode: config.ModeStep, Retries: 2, Steps: cfg.Flows["f"].Steps}
+
+	r := &stdinRunner{read: map[string]string{}}
+	err := New(cfg, WithRunner(r), WithRetryBackoff(0)).RunFlow(context.Background(), "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `stdin: group "gen" failed`)
+	assert.Equal(t, "partial\n", r.stdin("read"), "the consumer read what was written")
+	assert.Equal(t, 4, r.calls, "the producer is not retried; its consumer is, from the start")
+}
+
+func TestEngine_StdinStreamFromStoredOutput(t *testing.T) {
+	t.Parallel()
+	store := cache.NewFileStore(t.TempDir())
+	cfg := stepFlowCfg(t, []config.Group{
+		{Name: "gen", Command: "gen", Params: []string{"x"}, Cache: &config.Cache{Method: config.CacheHash, Reads: []string{}}},
+		{Name: "read", Command: "cat", Stdin: &config.Stdin{From: "gen", Stream: true}},
+	}, [][]string{{"gen", "read"}})
+	r := &stdinRunner{read: map[string]string{}}
+	require.NoError(t, New(cfg, WithRunner(r), WithCache(store)).RunFlow(context.Background(), "f"))
+
+	t.Run("cache hit", func(t *testing.T) {
+		r := &stdinRunner{read: map[string]string{}}
+		require.NoError(t, New(cfg, WithRunner(r), WithCache(store)).RunFlow(context.Background(), "f"))
+		assert.Equal(t, "x\n", r.stdin("read"))
+		assert.Equal(t, 1, r.calls, "gen replayed its cached stdout into the stream")
+	})
+
+	t.Run("producer not selected", func(t *testing.T) {
+		r := &stdinRunner{read: map[string]string{}}
+		e := New(cfg, WithRunner(r), WithCache(store), WithSelection(plan.Selection{Only: []string{"read"}}))
+		require.NoError(t, e.RunFlow(context.Background(), "f"))
+		assert.Equal(t, "x\n", r.stdin("read"))
+	})
+}
+
+func TestEngine_StdinFingerprint(t *testing.T) {
+	t.Parallel()
+	store := cache.NewFileStore(t.TempDir())
+	group := config.Group{
+		Name: "hash", Command: "sha256sum", Stdin: &config.Stdin{Template: `{{ env "INPUT" }}`},
+		Cache: &config.Cache{Method: config.CacheHash, Reads: []string{}},
+	}
+	cfg := stepFlowCfg(t, []config.Group{group}, [][]string{{"hash"}})
+	run := func(input string) int {
+		r := &stdinRunner{read: map[string]string{}}
+		cfg.Env = map[string]string{"INPUT": input}
+		require.NoError(t, New(cfg, WithRunner(r), WithCache(store)).RunFlow(context.Background(), "f"))
+		return r.calls
+	}
+	assert.Equal(t, 1, run("a"))
+	assert.Equal(t, 0, run("a"), "same stdin hits")
+	assert.Equal(t, 1, run("b"), "changed stdin runs again")
+}
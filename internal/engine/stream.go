@@ -0,0 +1,246 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/quike/keepup/internal/plan"
+)
+
+// stream carries a producer group's stdout to the groups that read it with
+// stdin: {from, stream: true} while it still runs. It spools to a temporary
+// file, so the producer never waits on a slow consumer and the data is not
+// held in memory, and every consumer reads it from the start at its own
+// pace (a retried consumer too).
+type stream struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	file *os.File
+	size int64
+	done bool
+	err  error // why the producer did not finish cleanly; set with done
+}
+
+func newStream() (*stream, error) {
+	f, err := os.CreateTemp("", "keepup-stream-*")
+	if err != nil {
+		return nil, fmt.Errorf("create stream spool: %w", err)
+	}
+	s := &stream{file: f}
+	s.cond = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+// Write appends the producer's output and wakes the readers waiting for it.
+// Output written after the stream closed is dropped.
+func (s *stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return len(p), nil
+	}
+	n, err := s.file.WriteAt(p, s.size)
+	s.size += int64(n)
+	s.cond.Broadcast()
+	return n, err
+}
+
+// close ends the stream: readers get EOF once they have read everything, and
+// err, when set, fails the consumers that read it (see open).
+func (s *stream) close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.done, s.err = true, err
+	s.cond.Broadcast()
+}
+
+// remove closes the stream and deletes its spool.
+func (s *stream) remove() {
+	s.close(fmt.Errorf("flow ended"))
+	_ = s.file.Close()
+	_ = os.Remove(s.file.Name())
+}
+
+// open returns a pipe a consumer's command reads the stream from, fed from
+// the start of the spool. finish must be called once the command exited: it
+// releases the pipe and returns the producer's error if it failed, which
+// fails the consumer too.
+func (s *stream) open() (*os.File, func() error, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("open stream: %w", err)
+	}
+	r := &streamReader{s: s}
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		// A write error means the consumer stopped reading; that is its call.
+		_, _ = io.Copy(pw, r)
+		_ = pw.Close()
+	}()
+	finish := func() error {
+		r.stop()
+		_ = pr.Close()
+		<-copied
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.err
+	}
+	return pr, finish, nil
+}
+
+// streamReader reads a stream from the start, blocking until the producer
+// writes more or the stream closes.
+type streamReader struct {
+	s       *stream
+	off     int64
+	stopped bool // guarded by s.mu
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	s := r.s
+	s.mu.Lock()
+	for r.off >= s.size && !s.done && !r.stopped {
+		s.cond.Wait()
+	}
+	avail := s.size - r.off
+	if r.stopped {
+		avail = 0
+	}
+	s.mu.Unlock()
+	if avail == 0 {
+		return 0, io.EOF
+	}
+	n, err := s.file.ReadAt(p[:min(int64(len(p)), avail)], r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// stop ends a blocked Read once the consumer no longer needs the stream.
+func (r *streamReader) stop() {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.stopped = true
+	r.s.cond.Broadcast()
+}
+
+// streamSet holds the streams of the current RunFlow, keyed by producer. The
+// schedulers mark each producer begun when they launch or skip it; a
+// consumer does not start before that, so it never holds a concurrency slot
+// its producer is waiting for.
+type streamSet struct {
+	mu      sync.Mutex
+	streams map[string]*stream
+	begun   map[string]bool
+}
+
+// openStreams creates a stream for every producer in the plan that some
+// member reads live. A producer outside the plan (not selected), restored by
+// --resume, or only dry-run gets none: its consumers read its stored stdout.
+func (e *Engine) openStreams(p *plan.Plan) (*streamSet, error) {
+	set := &streamSet{streams: map[string]*stream{}, begun: map[string]bool{}}
+	if e.dryRun {
+		return set, nil
+	}
+	members := make(map[string]bool, len(p.Members))
+	for _, m := range p.Members {
+		members[m] = !p.SubFlows[m]
+	}
+	for _, m := range p.Members {
+		if !members[m] {
+			continue
+		}
+		g := e.groups[m]
+		src := g.StreamSource()
+		if src == "" || !members[src] || e.replayed[src] || set.streams[src] != nil {
+			continue
+		}
+		st, err := newStream()
+		if err != nil {
+			set.close()
+			return nil, err
+		}
+		set.streams[src] = st
+	}
+	return set, nil
+}
+
+// get returns the stream a producer writes, or nil.
+func (s *streamSet) get(producer string) *stream {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[producer]
+}
+
+// begin marks a producer launched.
+func (s *streamSet) begin(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.begun[name] = true
+}
+
+// pending reports whether producer has a stream but has not begun yet.
+func (s *streamSet) pending(producer string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[producer] != nil && !s.begun[producer]
+}
+
+// finish closes a producer's stream once the group has ended with status.
+// Only a group that ran to completion (or replayed a cached result) ends it
+// cleanly; its consumers fail otherwise.
+func (s *streamSet) finish(name, status string) {
+	st := s.get(name)
+	if st == nil {
+		return
+	}
+	s.begin(name)
+	switch status {
+	case StatusOK, StatusCacheHit, StatusDryRun:
+		st.close(nil)
+	case StatusSkipped:
+		st.close(fmt.Errorf("group %q did not run", name))
+	default:
+		st.close(fmt.Errorf("group %q failed", name))
+	}
+}
+
+// close removes every stream.
+func (s *streamSet) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range s.streams {
+		st.remove()
+	}
+}
+
+// streamPending reports whether name reads a stream whose producer has not
+// begun yet, so the scheduler must hold it back.
+func (e *Engine) streamPending(name string) bool {
+	g, ok := e.groups[name]
+	if !ok {
+		return false
+	}
+	src := g.StreamSource()
+	return src != "" && e.streams.pending(src)
+}
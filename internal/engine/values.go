@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+// parseValues fills out.Values: the fields the group's outputs: block
+// parses out of out.Stdout, then the pairs written to KEEPUP_OUTPUT. An
+// error fails the group: stdout that does not parse, a declared key neither
+// provides, a regex that matches nothing, or stdout cut by capture:.
+func parseValues(group *config.Group, out *result.RunResult) error {
+	values := map[string]any{}
+	o := group.Outputs
+	if o != nil && (o.Parse != "" || len(o.Regex) > 0) {
+		if out.Truncated {
+			return fmt.Errorf("group %q: outputs: stdout was truncated by capture:", group.Name)
+		}
+		var err error
+		if values, err = parseStdout(o.Parse, out.Stdout); err != nil {
+			return fmt.Errorf("group %q: outputs: %w", group.Name, err)
+		}
+		for name, pattern := range o.Regex {
+			m := regexp.MustCompile(pattern).FindStringSubmatch(out.Stdout)
+			if m == nil {
+				return fmt.Errorf("group %q: outputs: regex %q matched nothing", group.Name, name)
+			}
+			values[name] = m[min(1, len(m)-1)]
+		}
+	}
+	for k, v := range out.OutputFile {
+		values[k] = v
+	}
+	if o != nil {
+		for _, k := range o.Keys {
+			if _, ok := values[k]; !ok {
+				return fmt.Errorf("group %q: outputs: key %q is missing from stdout and %s", group.Name, k, EnvOutputFile)
+			}
+		}
+	}
+	out.Values = nil
+	if len(values) > 0 {
+		out.Values = values
+	}
+	return nil
+}
+
+// parseStdout decodes stdout in the given format; "" yields an empty map.
+func parseStdout(format, stdout string) (map[string]any, error) {
+	values := map[string]any{}
+	switch format {
+	case config.ParseJSON:
+		dec := json.NewDecoder(strings.NewReader(stdout))
+		dec.UseNumber()
+		if err := dec.Decode(&values); err != nil {
+			return nil, fmt.Errorf("stdout is not a JSON object: %w", err)
+		}
+		if dec.More() {
+			return nil, errors.New("stdout holds more than one JSON value")
+		}
+		return numbers(values).(map[string]any), nil
+	case config.ParseYAML:
+		if err := yaml.Unmarshal([]byte(stdout), &values); err != nil {
+			return nil, fmt.Errorf("stdout is not a YAML mapping: %w", err)
+		}
+		if values == nil {
+			values = map[string]any{} // an empty document
+		}
+	case config.ParseDotenv:
+		return parseDotenv(stdout)
+	}
+	return values, nil
+}
+
+// numbers replaces the json.Numbers of a decoded value with an int64 when
+// the number is integral and fits, a float64 otherwise, so templates print
+// 1000000 rather than 1e+06 and compare numbers with gt and lt.
+func numbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = numbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = numbers(e)
+		}
+	}
+	return v
+}
+
+// parseDotenv reads KEY=value lines. Blank lines and # comments are
+// skipped, an export prefix is allowed, and a value wrapped in matching
+// single or double quotes is unwrapped.
+func parseDotenv(stdout string) (map[string]any, error) {
+	values := map[string]any{}
+	sc := bufio.NewScanner(strings.NewReader(stdout))
+	sc.Buffer(nil, len(stdout)+1)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("stdout line %d is not KEY=value", n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/cache"
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/result"
+)
+
+func TestParseValues(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name    string
+		outputs config.Outputs
+		stdout  string
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name:    "json keeps types",
+			outputs: config.Outputs{Parse: config.ParseJSON},
+			stdout:  `{"vpc_id": "vpc-1", "count": 1000000, "ratio": 0.5, "ok": true, "subnets": ["a", "b"], "tags": {"env": "prod"}}`,
+			want: map[string]any{
+				"vpc_id": "vpc-1", "count": int64(1000000), "ratio": 0.5, "ok": true,
+				"subnets": []any{"a", "b"}, "tags": map[string]any{"env": "prod"},
+			},
+		},
+		{
+			name:    "yaml",
+			outputs: config.Outputs{Parse: config.ParseYAML},
+			stdout:  "region: eu-west-1\nreplicas: 3\n",
+			want:    map[string]any{"region": "eu-west-1", "replicas": 3},
+		},
+		{
+			name:    "dotenv",
+			outputs: config.Outputs{Parse: config.ParseDotenv},
+			stdout:  "# generated\nexport TAG=v1.2.3\n\nIMAGE = \"ghcr.io/x/y\"\nEMPTY=\nURL='http://a?b=c'\n",
+			want:    map[string]any{"TAG": "v1.2.3", "IMAGE": "ghcr.io/x/y", "EMPTY": "", "URL": "http://a?b=c"},
+		},
+		{
+			name:    "regex with and without a capture group",
+			outputs: config.Outputs{Regex: map[string]string{"version": `go version go(\S+)`, "arch": `\w+/\w+`}},
+			stdout:  "go version go1.25.1 linux/amd64\n",
+			want:    map[string]any{"version": "1.25.1", "arch": "linux/amd64"},
+		},
+		{
+			name:    "parse and regex",
+			outputs: config.Outputs{Parse: config.ParseDotenv, Keys: []string{"A"}, Regex: map[string]string{"b": `B=(\d)`}},
+			stdout:  "A=1\nB=2\n",
+			want:    map[string]any{"A": "1", "B": "2", "b": "2"},
+		},
+		{name: "not json", outputs: config.Outputs{Parse: config.ParseJSON}, stdout: "done\n", wantErr: "stdout is not a JSON object"},
+		{name: "json array", outputs: config.Outputs{Parse: config.ParseJSON}, stdout: "[1]", wantErr: "stdout is not a JSON object"},
+		{name: "two json values", outputs: config.Outputs{Parse: config.ParseJSON}, stdout: "{}\n{}\n", wantErr: "more than one JSON value"},
+		{name: "yaml scalar", outputs: config.Outputs{Parse: config.ParseYAML}, stdout: "just text", wantErr: "stdout is not a YAML mapping"},
+		{
+			name: "bad dotenv line", outputs: config.Outputs{Parse: config.ParseDotenv},
+			stdout: "A=1\nnope\n", wantErr: "stdout line 2 is not KEY=value",
+		},
+		{
+			name: "missing key", outputs: config.Outputs{Parse: config.ParseJSON, Keys: []string{"vpc_id"}},
+			stdout: `{"vpc": 1}`, wantErr: `key "vpc_id" is missing from stdout`,
+		},
+		{
+			name: "regex without match", outputs: config.Outputs{Regex: map[string]string{"v": `v(\d+)`}},
+			stdout: "none", wantErr: `regex "v" matched nothing`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			group := &config.Group{Name: "g", Outputs: &tc.outputs}
+			out := result.RunResult{Stdout: tc.stdout}
+			err := parseValues(group, &out)
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, out.Values)
+		})
+	}
+
+	out := result.RunResult{Stdout: `{"a"`, Truncated: true}
+	err := parseValues(&config.Group{Name: "g", Outputs: &config.Outputs{Parse: config.ParseJSON}}, &out)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "truncated by capture:")
+}
+
+func TestEngine_OutputValues(t *testing.T) {
+	t.Parallel()
+	store := cache.NewFileStore(t.TempDir())
+	tf := config.Group{
+		Name: "tf", Command: "terraform", Outputs: &config.Outputs{Parse: config.ParseJSON, Keys: []string{"vpc_id"}},
+		Cache: &config.Cache{Method: config.CacheHash, Reads: []string{}},
+	}
+	cfg := stepFlowCfg(t, []config.Group{
+		tf,
+		{Name: "deploy", Command: "deploy", Params: []string{
+			`{{ (out "tf").Values.vpc_id }}`, `{{ if gt (out "tf").Values.replicas 2 }}big{{ end }}`,
+		}},
+	}, [][]string{{"tf"}, {"deploy"}})
+	runner := func() *fakeRunner {
+		return &fakeRunner{outputs: map[string]string{"tf": `{"vpc_id": "vpc-9", "replicas": 3}`}}
+	}
+
+	r := runner()
+	require.NoError(t, New(cfg, WithRunner(r), WithCache(store)).RunFlow(context.Background(), "f"))
+	assert.Contains(t, r.calls, "deploy:vpc-9,big")
+
+	t.Run("cache hit parses the replayed stdout", func(t *testing.T) {
+		r := runner()
+		require.NoError(t, New(cfg, WithRunner(r), WithCache(store)).RunFlow(context.Background(), "f"))
+		assert.Equal(t, []string{"deploy:vpc-9,big"}, r.calls)
+	})
+
+	t.Run("unparsable stdout fails the group", func(t *testing.T) {
+		cfg := stepFlowCfg(t, []config.Group{
+			{Name: "tf", Command: "terraform", Outputs: &config.Outputs{Parse: config.ParseJSON}},
+		}, [][]string{{"tf"}})
+		r := &fakeRunner{outputs: map[string]string{"tf": "Error: no state"}}
+		err := New(cfg, WithRunner(r)).RunFlow(context.Background(), "f")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `group "tf": outputs: stdout is not a JSON object`)
+	})
+}
@@ -17,7 +17,11 @@ import (
 // and parallelism). For dag mode, Waves is nil and Predecessors / Successors
 // drive a Kahn-style scheduler; Roots is the initial ready set.
 // When carries the raw when: predicate for each group that declares one
-// (dag mode only; absent = unconditional).
+// (dag mode only; absent = unconditional). AllowFailure marks run entries
+// that soft-fail in this flow (dag mode only; the group's own allow-failure
+// applies in both modes). External is only set on a plan narrowed by Select.
+// SubFlows marks the members that embed another flow; the engine runs those
+// as a nested flow rather than a group.
 type Plan struct {
 	Flow         string
 	Mode         config.Mode
@@ -27,6 +31,9 @@ type Plan struct {
 	Successors   map[string][]string // dag mode only
 	Roots        []string            // dag mode only
 	When         map[string]string   // dag mode only: group -> when predicate (absent = unconditional)
+	AllowFailure map[string]bool     // dag mode only: run entries declaring allow-failure
+	External     []string            // unselected members whose outputs selected members reference
+	SubFlows     map[string]bool     // members that embed another flow
 }
 
 // Build returns a Plan for the named flow.
@@ -39,7 +46,10 @@ func Build(cfg *config.Config, flowName string) (*Plan, error) {
 	if !ok {
 		return nil, fmt.Errorf("flow %q not found", flowName)
 	}
-	p := &Plan{Flow: flowName, Mode: flow.Mode, Members: flow.Members()}
+	p := &Plan{Flow: flowName, Mode: flow.Mode, Members: flow.Members(), SubFlows: make(map[string]bool)}
+	for _, sub := range flow.SubFlows() {
+		p.SubFlows[sub] = true
+	}
 	switch flow.Mode {
 	case config.ModeStep:
 		p.Waves = make([][]string, len(flow.Steps))
@@ -57,20 +67,25 @@ func Build(cfg *config.Config, flowName string) (*Plan, error) {
 func buildDAGEdges(cfg *config.Config, flow *config.Flow, p *Plan) {
 	memberSet := make(map[string]struct{}, len(flow.Run))
 	for i := range flow.Run {
-		memberSet[flow.Run[i].Group] = struct{}{}
+		memberSet[flow.Run[i].Member()] = struct{}{}
 	}
 	p.Predecessors = make(map[string][]string, len(flow.Run))
 	p.Successors = make(map[string][]string, len(flow.Run))
 	p.When = make(map[string]string)
+	p.AllowFailure = make(map[string]bool)
 
 	for i := range flow.Run {
-		m := flow.Run[i].Group
+		m := flow.Run[i].Member()
 		w := flow.Run[i].When
 		if w != "" {
 			p.When[m] = w
 		}
+		if flow.Run[i].AllowFailure {
+			p.AllowFailure[m] = true
+		}
 		seenPred := make(map[string]struct{})
 		addEdge := func(ref string) {
+			ref = cfg.RefOwner(flow, ref)
 			if _, in := memberSet[ref]; !in {
 				return // ValidateReferences already rejected out-of-flow refs
 			}
@@ -81,11 +96,12 @@ func buildDAGEdges(cfg *config.Config, flow *config.Flow, p *Plan) {
 			p.Predecessors[m] = append(p.Predecessors[m], ref)
 			p.Successors[ref] = append(p.Successors[ref], m)
 		}
-		g := cfg.GroupByName(m)
-		// Refs cannot error here: NewConfig validated every template already.
-		refs, _ := config.ExtractRefs(g)
-		for _, ref := range refs {
-			addEdge(ref)
+		if flow.Run[i].Flow == "" {
+			// Refs cannot error here: NewConfig validated every template already.
+			refs, _ := config.ExtractRefs(cfg.GroupByName(m))
+			for _, ref := range refs {
+				addEdge(ref)
+			}
 		}
 		if w != "" {
 			whenRefs, _ := template.Refs(w)
@@ -95,7 +111,7 @@ func buildDAGEdges(cfg *config.Config, flow *config.Flow, p *Plan) {
 		}
 	}
 	for i := range flow.Run {
-		m := flow.Run[i].Group
+		m := flow.Run[i].Member()
 		if len(p.Predecessors[m]) == 0 {
 			p.Roots = append(p.Roots, m)
 		}
@@ -170,3 +170,42 @@ flows:
 		t.Fatalf("roots = %v, want [a c]", p.Roots)
 	}
 }
+
+func TestBuildDAGAllowFailure(t *testing.T) {
+	cfg := validCfg(t, `
+version: 2
+groups:
+  - { name: lint, command: echo }
+  - { name: test, command: echo }
+flows:
+  f:
+    mode: dag
+    run:
+      - { group: lint, allow-failure: true }
+      - test
+`)
+	p, err := Build(cfg, "f")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"lint": true}, p.AllowFailure)
+}
+
+func TestBuild_DAGMode_EmbeddedFlow(t *testing.T) {
+	cfg := validCfg(t, `
+version: 2
+groups:
+  - { name: vet, command: go }
+  - { name: build, command: echo, params: ['{{ output "lint/vet" }}'] }
+flows:
+  lint:
+    steps:
+      - run: [vet]
+  f:
+    mode: dag
+    run: [build, { flow: lint }]
+`)
+	p, err := Build(cfg, "f")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"lint": true}, p.SubFlows)
+	assert.Equal(t, []string{"lint"}, p.Roots)
+	assert.Equal(t, []string{"lint"}, p.Predecessors["build"])
+}
@@ -0,0 +1,295 @@
+package plan
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/quike/keepup/internal/config"
+	"github.com/quike/keepup/internal/template"
+)
+
+// Selection narrows a flow to a slice of its members. Each non-empty field is
+// a filter and the slice is their intersection:
+//
+//   - Only keeps exactly the named groups; a matrix group's name stands for
+//     all of its instances, here and in Targets.
+//   - From keeps the named group and everything after it: later steps in
+//     step mode, its transitive downstream in dag mode.
+//   - Until keeps the named group and everything before it: earlier steps in
+//     step mode, its transitive upstream in dag mode.
+//   - Targets keeps each named group plus the groups whose outputs it
+//     transitively references (in dag mode this is its upstream closure).
+type Selection struct {
+	Only    []string
+	From    string
+	Until   string
+	Targets []string
+}
+
+// Empty reports whether the selection keeps the whole flow.
+func (s Selection) Empty() bool {
+	return len(s.Only) == 0 && s.From == "" && s.Until == "" && len(s.Targets) == 0
+}
+
+// Select returns a copy of p restricted to the members kept by sel.
+//
+// Step mode keeps every wave (so wave i still lines up with the flow's step
+// i) but drops unselected groups from it; a wave may end up empty. Dag mode
+// drops unselected nodes and the edges touching them and recomputes Roots.
+// External lists, in declaration order, the unselected members whose outputs
+// a selected member references; the engine must provide them before running.
+func (p *Plan) Select(cfg *config.Config, sel Selection) (*Plan, error) {
+	if sel.Empty() {
+		return p, nil
+	}
+	sel.Only = expandMatrixNames(cfg, sel.Only)
+	sel.Targets = expandMatrixNames(cfg, sel.Targets)
+	refs := memberRefs(cfg, p)
+	keep, err := p.selected(sel, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Plan{Flow: p.Flow, Mode: p.Mode, SubFlows: make(map[string]bool)}
+	for _, m := range p.Members {
+		if keep[m] {
+			out.Members = append(out.Members, m)
+			if p.SubFlows[m] {
+				out.SubFlows[m] = true
+			}
+		}
+	}
+	if p.Mode == config.ModeDAG {
+		p.selectDAG(out, keep)
+	} else {
+		out.Waves = make([][]string, len(p.Waves))
+		for i, wave := range p.Waves {
+			out.Waves[i] = filter(wave, keep)
+		}
+	}
+
+	external := make(map[string]bool)
+	for _, m := range out.Members {
+		for _, ref := range refs[m] {
+			if !keep[ref] {
+				external[ref] = true
+			}
+		}
+	}
+	for _, m := range p.Members {
+		if external[m] {
+			out.External = append(out.External, m)
+		}
+	}
+	return out, nil
+}
+
+// selected computes the kept member set for sel: the members every one of
+// its --only, --from, --until and --target filters keeps.
+func (p *Plan) selected(sel Selection, refs map[string][]string) (map[string]bool, error) {
+	if err := p.checkSelected(sel); err != nil {
+		return nil, err
+	}
+	var sets []map[string]bool
+	if len(sel.Only) > 0 {
+		sets = append(sets, setOf(sel.Only))
+	}
+	if sel.From != "" {
+		sets = append(sets, p.from(sel.From))
+	}
+	if sel.Until != "" {
+		sets = append(sets, p.until(sel.Until, refs))
+	}
+	if len(sel.Targets) > 0 {
+		targets := make(map[string]bool)
+		for _, name := range sel.Targets {
+			closure(name, refs, targets)
+		}
+		sets = append(sets, targets)
+	}
+	keep := intersect(sets)
+	if len(keep) == 0 {
+		return nil, fmt.Errorf("flow %q: the selection matches no groups", p.Flow)
+	}
+	return keep, nil
+}
+
+// checkSelected fails on the first group sel names that is not a member.
+func (p *Plan) checkSelected(sel Selection) error {
+	names := slices.Concat(sel.Only, []string{sel.From, sel.Until}, sel.Targets)
+	for _, name := range names {
+		if name != "" && !slices.Contains(p.Members, name) {
+			return fmt.Errorf("flow %q: selected group %q is not part of the flow", p.Flow, name)
+		}
+	}
+	return nil
+}
+
+// setOf returns names as a set.
+func setOf(names []string) map[string]bool {
+	out := make(map[string]bool, len(names))
+	for _, name := range names {
+		out[name] = true
+	}
+	return out
+}
+
+// intersect narrows the first of sets to the members all of them share.
+func intersect(sets []map[string]bool) map[string]bool {
+	keep := sets[0]
+	for _, s := range sets[1:] {
+		for m := range keep {
+			if !s[m] {
+				delete(keep, m)
+			}
+		}
+	}
+	return keep
+}
+
+// from returns name plus everything scheduled after it.
+func (p *Plan) from(name string) map[string]bool {
+	out := make(map[string]bool)
+	if p.Mode == config.ModeDAG {
+		closure(name, p.Successors, out)
+		return out
+	}
+	reached := false
+	for _, wave := range p.Waves {
+		if !reached && slices.Contains(wave, name) {
+			reached = true
+		}
+		if reached {
+			for _, m := range wave {
+				out[m] = true
+			}
+		}
+	}
+	return out
+}
+
+// until returns name plus everything scheduled before it.
+func (p *Plan) until(name string, refs map[string][]string) map[string]bool {
+	out := make(map[string]bool)
+	if p.Mode == config.ModeDAG {
+		closure(name, refs, out)
+		return out
+	}
+	for _, wave := range p.Waves {
+		for _, m := range wave {
+			out[m] = true
+		}
+		if slices.Contains(wave, name) {
+			break
+		}
+	}
+	return out
+}
+
+// selectDAG copies the dag bookkeeping of p into out for the kept members.
+func (p *Plan) selectDAG(out *Plan, keep map[string]bool) {
+	out.Predecessors = make(map[string][]string, len(out.Members))
+	out.Successors = make(map[string][]string, len(out.Members))
+	out.When = make(map[string]string)
+	out.AllowFailure = make(map[string]bool)
+	for _, m := range out.Members {
+		if preds := filter(p.Predecessors[m], keep); len(preds) > 0 {
+			out.Predecessors[m] = preds
+		} else {
+			out.Roots = append(out.Roots, m)
+		}
+		if succs := filter(p.Successors[m], keep); len(succs) > 0 {
+			out.Successors[m] = succs
+		}
+		if w, ok := p.When[m]; ok {
+			out.When[m] = w
+		}
+		if p.AllowFailure[m] {
+			out.AllowFailure[m] = true
+		}
+	}
+}
+
+// memberRefs maps each member to the members whose outputs it references,
+// from its command/params/dir templates, its stdin: (a streamed one too),
+// and its when: predicate (the step's in step mode, the run entry's in dag
+// mode).
+func memberRefs(cfg *config.Config, p *Plan) map[string][]string {
+	flow := cfg.Flows[p.Flow]
+	when := make(map[string]string)
+	if p.Mode == config.ModeDAG {
+		for i := range flow.Run {
+			when[flow.Run[i].Member()] = flow.Run[i].When
+		}
+	} else {
+		for _, s := range flow.Steps {
+			for _, m := range s.Run {
+				when[m] = s.When
+			}
+		}
+	}
+	inFlow := make(map[string]bool, len(p.Members))
+	for _, m := range p.Members {
+		inFlow[m] = true
+	}
+
+	out := make(map[string][]string, len(p.Members))
+	for _, m := range p.Members {
+		// Refs cannot error here: NewConfig validated every template already.
+		// An embedded flow reads nothing from this one.
+		var refs []string
+		if !p.SubFlows[m] {
+			g := cfg.GroupByName(m)
+			refs, _ = config.ExtractRefs(g)
+			if src := g.StreamSource(); src != "" {
+				refs = append(refs, src)
+			}
+		}
+		if w := when[m]; w != "" {
+			whenRefs, _ := template.Refs(w)
+			refs = append(refs, whenRefs...)
+		}
+		seen := make(map[string]bool)
+		for _, r := range refs {
+			if r = cfg.RefOwner(&flow, r); inFlow[r] && r != m && !seen[r] {
+				seen[r] = true
+				out[m] = append(out[m], r)
+			}
+		}
+	}
+	return out
+}
+
+// expandMatrixNames replaces matrix group names with all of their instances.
+func expandMatrixNames(cfg *config.Config, names []string) []string {
+	var out []string
+	for _, n := range names {
+		if inst := cfg.MatrixInstances(n); len(inst) > 0 {
+			out = append(out, inst...)
+		} else {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// closure adds name and everything reachable from it through edges to into.
+func closure(name string, edges map[string][]string, into map[string]bool) {
+	if into[name] {
+		return
+	}
+	into[name] = true
+	for _, next := range edges[name] {
+		closure(next, edges, into)
+	}
+}
+
+func filter(names []string, keep map[string]bool) []string {
+	var out []string
+	for _, n := range names {
+		if keep[n] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
@@ -0,0 +1,153 @@
+package plan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/quike/keepup/internal/config"
+)
+
+const selectStepCfg = `
+version: 2
+groups:
+  - { name: gen, command: echo }
+  - { name: lint, command: echo }
+  - { name: build, command: echo, params: ["{{ output.gen }}"] }
+  - { name: test, command: echo }
+  - { name: package, command: echo, params: ["{{ output.build }}"] }
+flows:
+  f:
+    mode: step
+    steps:
+      - run: [gen, lint]
+      - run: [build, test]
+      - run: [package]
+`
+
+const selectDAGCfg = `
+version: 2
+groups:
+  - { name: gen, command: echo }
+  - { name: lint, command: echo }
+  - { name: build, command: echo, params: ["{{ output.gen }}"] }
+  - { name: test, command: echo, params: ["{{ output.build }}"] }
+  - { name: package, command: echo, params: ["{{ output.build }}"] }
+flows:
+  f:
+    mode: dag
+    run: [gen, lint, build, test, package]
+`
+
+func TestSelect_StepMode(t *testing.T) {
+	t.Parallel()
+	cfg := validCfg(t, selectStepCfg)
+	p, err := Build(cfg, "f")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		sel          Selection
+		wantWaves    [][]string
+		wantExternal []string
+	}{
+		{"only", Selection{Only: []string{"package"}}, [][]string{nil, nil, {"package"}}, []string{"build"}},
+		{"from", Selection{From: "test"}, [][]string{nil, {"build", "test"}, {"package"}}, []string{"gen"}},
+		{"until", Selection{Until: "build"}, [][]string{{"gen", "lint"}, {"build", "test"}, nil}, nil},
+		{"target follows references", Selection{Targets: []string{"package"}},
+			[][]string{{"gen"}, {"build"}, {"package"}}, nil},
+		{"filters intersect", Selection{From: "build", Until: "build"},
+			[][]string{nil, {"build", "test"}, nil}, []string{"gen"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := p.Select(cfg, tc.sel)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantWaves, got.Waves)
+			assert.Equal(t, tc.wantExternal, got.External)
+		})
+	}
+}
+
+func TestSelect_DAGMode(t *testing.T) {
+	t.Parallel()
+	cfg := validCfg(t, selectDAGCfg)
+	p, err := Build(cfg, "f")
+	require.NoError(t, err)
+
+	t.Run("target is the upstream closure", func(t *testing.T) {
+		got, err := p.Select(cfg, Selection{Targets: []string{"test"}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"gen", "build", "test"}, got.Members)
+		assert.Equal(t, []string{"gen"}, got.Roots)
+		assert.Empty(t, got.External)
+		assert.Equal(t, []string{"test"}, got.Successors["build"], "edges to unselected nodes are dropped")
+	})
+
+	t.Run("from is the downstream closure", func(t *testing.T) {
+		got, err := p.Select(cfg, Selection{From: "build"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"build", "test", "package"}, got.Members)
+		assert.Equal(t, []string{"build"}, got.Roots)
+		assert.Equal(t, []string{"gen"}, got.External)
+	})
+
+	t.Run("only several groups", func(t *testing.T) {
+		got, err := p.Select(cfg, Selection{Only: []string{"lint", "package"}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"lint", "package"}, got.Members)
+		assert.Equal(t, []string{"lint", "package"}, got.Roots)
+		assert.Equal(t, []string{"build"}, got.External)
+	})
+}
+
+func TestSelect_Errors(t *testing.T) {
+	t.Parallel()
+	cfg := validCfg(t, selectDAGCfg)
+	p, err := Build(cfg, "f")
+	require.NoError(t, err)
+
+	_, err = p.Select(cfg, Selection{Only: []string{"ghost"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `selected group "ghost" is not part of the flow`)
+
+	_, err = p.Select(cfg, Selection{From: "package", Until: "gen"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "matches no groups")
+}
+
+func TestSelect_EmptyKeepsPlan(t *testing.T) {
+	t.Parallel()
+	cfg := validCfg(t, selectStepCfg)
+	p, err := Build(cfg, "f")
+	require.NoError(t, err)
+	got, err := p.Select(cfg, Selection{})
+	require.NoError(t, err)
+	assert.Same(t, p, got)
+	assert.Equal(t, config.ModeStep, got.Mode)
+}
+
+func TestSelect_StreamProducer(t *testing.T) {
+	t.Parallel()
+	cfg := validCfg(t, `
+version: 2
+groups:
+  - { name: gen, command: seq }
+  - { name: count, command: wc, stdin: { from: gen, stream: true } }
+flows:
+  f:
+    steps:
+      - run: [gen, count]
+`)
+	p, err := Build(cfg, "f")
+	require.NoError(t, err)
+
+	got, err := p.Select(cfg, Selection{Only: []string{"count"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gen"}, got.External, "a streamed producer is an input like a referenced one")
+
+	got, err = p.Select(cfg, Selection{Targets: []string{"count"}})
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"gen", "count"}}, got.Waves)
+}
@@ -9,7 +9,7 @@ package result
 //
 // The zero value is meaningful: Status == "" appears only for groups that
 // were never declared or never reached the store. Every declared, evaluated
-// group ends up with one of the four known statuses ("ok", "skipped",
+// group ends up with one of the known statuses ("ok", "failed", "skipped",
 // "cached", "dry-run").
 type RunResult struct {
 	// Stdout is the captured stdout only (independent of Stderr).
@@ -20,9 +20,10 @@ type RunResult struct {
 	// matching the historical (pre-structured-outputs) capture behavior.
 	// The `output "x"` template function returns strings.TrimSpace(Output).
 	Output string `json:"output,omitempty"`
-	// ExitCode is the process exit code. Always 0 in stored results today
-	// (non-zero aborts the flow before storage). Laid down for a future
-	// soft-fail / continue-on-error feature.
+	// ExitCode is the process exit code. Non-zero only for groups that
+	// soft-failed under allow-failure (a hard failure aborts the flow before
+	// storage); -1 when the process never produced an exit status (it failed
+	// to start or was killed).
 	ExitCode int `json:"exitCode,omitempty"`
 	// DurationMs is wall-clock milliseconds for the command run. 0 for
 	// skipped and cache-hit groups.
@@ -30,11 +31,56 @@ type RunResult struct {
 	// Status is one of the Status* constants below. An empty Status indicates
 	// a never-stored group.
 	Status string `json:"status,omitempty"`
+	// Termination says why a command was stopped before it finished on its
+	// own: one of the Termination* constants, or empty.
+	Termination string `json:"termination,omitempty"`
+	// Attempts counts the runs of each of the group's commands, in order,
+	// under retry-scope: command; 0 for a command that never ran. Nil for
+	// other groups.
+	Attempts []int `json:"attempts,omitempty"`
+	// Commands holds the outcome of each command of a multi-command group,
+	// in the order they ran; nil for a single-command group. The streams
+	// above are these commands' streams concatenated.
+	Commands []CommandResult `json:"commands,omitempty"`
+	// Truncated is set when a group's capture: limit cut Stdout, Stderr or
+	// Output; the fields then hold only the part it keeps.
+	Truncated bool `json:"truncated,omitempty"`
+	// Log is the file holding the complete combined output of a truncated
+	// run; empty when nothing was cut.
+	Log string `json:"log,omitempty"`
+	// Values holds the group's named output values, read as
+	// (out "x").Values.key: the fields its outputs: block parsed out of
+	// Stdout, then OutputFile. JSON and YAML values keep their types
+	// (numbers, lists, nested maps); the others are strings. Nil when there
+	// are none.
+	Values map[string]any `json:"values,omitempty"`
+	// OutputFile holds the name=value pairs the group's commands wrote to
+	// $KEEPUP_OUTPUT. They are merged into Values, over any parsed from
+	// stdout.
+	OutputFile map[string]string `json:"outputFile,omitempty"`
+	// EnvFile holds the variables the group wrote to $KEEPUP_ENV, exported
+	// to the groups of the flow that start after it.
+	EnvFile map[string]string `json:"envFile,omitempty"`
+}
+
+// CommandResult is the outcome of one command of a multi-command group, read
+// as (out "x").Commands. Command and Params are the expanded entry as run.
+// A capture: limit applies to each command's streams as to the group's.
+type CommandResult struct {
+	Command     string   `json:"command"`
+	Params      []string `json:"params,omitempty"`
+	Stdout      string   `json:"stdout,omitempty"`
+	Stderr      string   `json:"stderr,omitempty"`
+	Output      string   `json:"output,omitempty"`
+	ExitCode    int      `json:"exitCode,omitempty"`
+	DurationMs  int64    `json:"durationMs,omitempty"`
+	Termination string   `json:"termination,omitempty"`
 }
 
 // Status values a RunResult may carry. External Runner implementations set
 // StatusOK on a normal run; the engine overrides with the appropriate value
-// at storage time for cached / skipped / dry-run paths.
+// at storage time for failed (allow-failure) / cached / skipped / dry-run
+// paths.
 //
 // Note: these are intentionally distinct from the event-layer status
 // constants in internal/engine/events.go (e.g. "cache-hit" vs "cached"). The
@@ -42,7 +88,15 @@ type RunResult struct {
 // model layer here is what user templates read via `(out "x").Status`.
 const (
 	StatusOK      = "ok"
+	StatusFailed  = "failed"
 	StatusSkipped = "skipped"
 	StatusCached  = "cached"
 	StatusDryRun  = "dry-run"
 )
+
+// Termination reasons a RunResult may carry.
+const (
+	TerminationTimeout = "timeout" // the envelope's timeout expired
+	TerminationCancel  = "cancel"  // the run was canceled (Ctrl-C, a failed sibling)
+	TerminationSignal  = "signal"  // something outside keepup killed the process
+)
@@ -20,78 +20,165 @@ import (
 // Only string-literal arguments are extractable; a dynamically-computed name
 // (e.g. output (printf "g%d" 1)) cannot be resolved statically and is ignored.
 func Refs(s string) ([]string, error) {
+	c, err := collect(s)
+	if err != nil {
+		return nil, err
+	}
+	return c.refs, nil
+}
+
+// ValueRef is a read of one parsed output value: (out "Group").Values.Key.
+type ValueRef struct {
+	Group string
+	Key   string
+}
+
+// ValueRefs returns the parsed output values a template reads in the field
+// form {{ (out "X").Values.key }}, in encounter order. Only the top-level key
+// is reported; reads through index or a variable are ignored.
+func ValueRefs(s string) ([]ValueRef, error) {
+	c, err := collect(s)
+	if err != nil {
+		return nil, err
+	}
+	return c.values, nil
+}
+
+// ArgRefs returns the flow args a template reads with {{ arg "name" }}, in
+// encounter order. all is set when an arg call takes a computed name, so
+// any arg may be read.
+func ArgRefs(s string) (names []string, all bool, err error) {
+	c, err := collect(s)
+	if err != nil {
+		return nil, false, err
+	}
+	return c.args, c.allArgs, nil
+}
+
+// collector accumulates what a walk over a parsed template finds.
+type collector struct {
+	refs    []string
+	values  []ValueRef
+	args    []string
+	allArgs bool
+}
+
+func collect(s string) (*collector, error) {
 	fm := sprig.TxtFuncMap()
 	// Stub the keepup functions so parsing succeeds without real data.
 	fm["output"] = func(string) string { return "" }
 	fm["out"] = func(string) result.RunResult { return result.RunResult{} }
 	fm["env"] = func(string) string { return "" }
+	fm["flow"] = func() FlowState { return FlowState{} }
+	fm["matrix"] = func(string) string { return "" }
+	fm["arg"] = func(string) string { return "" }
 
 	t, err := template.New("ref").Funcs(fm).Parse(normalize(s))
 	if err != nil {
 		return nil, fmt.Errorf("parse template %q: %w", s, err)
 	}
-	var refs []string
-	walk(t.Root, &refs)
-	return refs, nil
+	c := &collector{}
+	c.walk(t.Root)
+	return c, nil
 }
 
-func walk(n parse.Node, refs *[]string) {
+func (c *collector) walk(n parse.Node) {
 	switch v := n.(type) {
 	case *parse.ListNode:
 		if v == nil {
 			return
 		}
-		for _, c := range v.Nodes {
-			walk(c, refs)
+		for _, n := range v.Nodes {
+			c.walk(n)
 		}
 	case *parse.ActionNode:
-		walkPipe(v.Pipe, refs)
+		c.walkPipe(v.Pipe)
 	case *parse.IfNode:
-		walkBranch(&v.BranchNode, refs)
+		c.walkBranch(&v.BranchNode)
 	case *parse.RangeNode:
-		walkBranch(&v.BranchNode, refs)
+		c.walkBranch(&v.BranchNode)
 	case *parse.WithNode:
-		walkBranch(&v.BranchNode, refs)
+		c.walkBranch(&v.BranchNode)
 	case *parse.TemplateNode:
-		walkPipe(v.Pipe, refs)
+		c.walkPipe(v.Pipe)
 	}
 }
 
-func walkBranch(b *parse.BranchNode, refs *[]string) {
-	walkPipe(b.Pipe, refs)
-	walk(b.List, refs)
-	walk(b.ElseList, refs)
+func (c *collector) walkBranch(b *parse.BranchNode) {
+	c.walkPipe(b.Pipe)
+	c.walk(b.List)
+	c.walk(b.ElseList)
 }
 
-func walkPipe(p *parse.PipeNode, refs *[]string) {
+func (c *collector) walkPipe(p *parse.PipeNode) {
 	if p == nil {
 		return
 	}
 	for _, cmd := range p.Cmds {
-		walkCommand(cmd, refs)
+		c.walkCommand(cmd)
 	}
 }
 
-func walkCommand(c *parse.CommandNode, refs *[]string) {
-	if len(c.Args) >= 2 {
-		if id, ok := c.Args[0].(*parse.IdentifierNode); ok &&
-			(id.Ident == "output" || id.Ident == "out") {
-			if s, ok := c.Args[1].(*parse.StringNode); ok {
-				*refs = append(*refs, s.Text)
-			}
+func (c *collector) walkCommand(cmd *parse.CommandNode) {
+	if name, ok := refName(cmd); ok {
+		c.refs = append(c.refs, name)
+	}
+	if id, ok := cmd.Args[0].(*parse.IdentifierNode); ok && id.Ident == "arg" {
+		if s, ok := argAt(cmd, 1); ok {
+			c.args = append(c.args, s)
+		} else {
+			c.allArgs = true
 		}
 	}
 	// Recurse into parenthesized sub-pipelines and chain expressions,
 	// e.g. {{ if (output "x") }} or {{ (out "x").ExitCode }}.
-	for _, a := range c.Args {
+	for _, a := range cmd.Args {
 		switch n := a.(type) {
 		case *parse.PipeNode:
-			walkPipe(n, refs)
+			c.walkPipe(n)
 		case *parse.ChainNode:
 			// (out "x").Field — the chain base is itself a pipeline; walk into it.
-			if pipe, ok := n.Node.(*parse.PipeNode); ok {
-				walkPipe(pipe, refs)
+			pipe, ok := n.Node.(*parse.PipeNode)
+			if !ok {
+				continue
+			}
+			c.walkPipe(pipe)
+			if len(pipe.Cmds) != 1 || len(n.Field) < 2 || n.Field[0] != "Values" {
+				continue
+			}
+			if id, ok := pipe.Cmds[0].Args[0].(*parse.IdentifierNode); ok && id.Ident == "out" {
+				if name, ok := refName(pipe.Cmds[0]); ok {
+					c.values = append(c.values, ValueRef{Group: name, Key: n.Field[1]})
+				}
 			}
 		}
 	}
 }
+
+// argAt returns the string literal at position i of cmd's arguments.
+func argAt(cmd *parse.CommandNode, i int) (string, bool) {
+	if len(cmd.Args) <= i {
+		return "", false
+	}
+	s, ok := cmd.Args[i].(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+	return s.Text, true
+}
+
+// refName returns X for a command output "X" or out "X".
+func refName(cmd *parse.CommandNode) (string, bool) {
+	if len(cmd.Args) < 2 {
+		return "", false
+	}
+	id, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok || (id.Ident != "output" && id.Ident != "out") {
+		return "", false
+	}
+	s, ok := cmd.Args[1].(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+	return s.Text, true
+}
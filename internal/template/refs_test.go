@@ -49,3 +49,55 @@ func TestRefs_BadTemplateErrors(t *testing.T) {
 	_, err := Refs(`{{ output "x" `)
 	require.Error(t, err)
 }
+
+func TestValueRefs(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		in   string
+		want []ValueRef
+	}{
+		{"none", `{{ output "a" }}`, nil},
+		{"field", `{{ (out "tf").Values.vpc_id }}`, []ValueRef{{"tf", "vpc_id"}}},
+		{"nested", `{{ (out "tf").Values.vpc.id }}`, []ValueRef{{"tf", "vpc"}}},
+		{"in if and pipe", `{{ if gt (out "a").Values.n 2 }}{{ (out "b").Values.m | upper }}{{ end }}`,
+			[]ValueRef{{"a", "n"}, {"b", "m"}}},
+		{"whole map not a key", `{{ (out "a").Values | toJson }}`, nil},
+		{"other field", `{{ (out "a").Status }}`, nil},
+		{"index not resolved", `{{ index (out "a").Values "k" }}`, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ValueRefs(tc.in)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestArgRefs(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		in      string
+		want    []string
+		wantAll bool
+	}{
+		{"none", `{{ output "a" }}`, nil, false},
+		{"literal", `v{{ arg "version" }}`, []string{"version"}, false},
+		{"nested", `{{ if eq (arg "channel") "beta" }}{{ arg "version" | upper }}{{ end }}`,
+			[]string{"channel", "version"}, false},
+		{"computed name", `{{ arg (printf "%s" "version") }}`, nil, true},
+		{"piped name", `{{ "version" | arg }}`, nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, all, err := ArgRefs(tc.in)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.wantAll, all)
+		})
+	}
+}
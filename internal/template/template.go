@@ -3,11 +3,15 @@
 // It owns the Expander abstraction the engine depends on (dependency
 // inversion): the engine asks "expand this string against these outputs and
 // env" without knowing the implementation. The default implementation is Go's
-// text/template with the sprig function library, plus two keepup-specific
+// text/template with the sprig function library, plus keepup-specific
 // functions:
 //
 //	output "name"   → the captured stdout of a prior group
+//	out    "name"   → the structured result of a prior group
 //	env    "KEY"    → a value from the merged keepup environment
+//	flow            → the running flow's name, status, and error
+//	matrix "axis"   → the value of a matrix axis for the current group instance
+//	arg    "name"   → the value of one of the running flow's args
 //
 // A backward-compatibility shim rewrites the legacy "{{ output.X }}" form into
 // the function form "{{ output \"X\" }}" before parsing, so configs written
@@ -42,6 +46,29 @@ func normalize(s string) string {
 type Data struct {
 	Outputs map[string]result.RunResult // group name → structured run result
 	Env     map[string]string           // merged keepup environment
+	Flow    FlowState                   // the flow being run
+	Matrix  map[string]string           // axis → value for a matrix group instance
+	Args    map[string]string           // the running flow's resolved args
+	// Namespace qualifies output/out names for a group imported under an
+	// alias: in namespace "web", output "lib" reads "web:lib".
+	Namespace string
+}
+
+func (d *Data) qualify(name string) string {
+	if d.Namespace == "" {
+		return name
+	}
+	return d.Namespace + ":" + name
+}
+
+// FlowState describes the flow a template renders within. Status is
+// "running" while the main plan executes; on-success / on-failure / finally
+// hooks see the final "ok", "soft-failed", or "failed", with Err holding the
+// failure message. Templates read it as (flow).Status.
+type FlowState struct {
+	Name   string
+	Status string
+	Err    string
 }
 
 // Expander renders a templated string against Data. Implementations must be
@@ -79,13 +106,28 @@ func funcMap(data Data) template.FuncMap {
 	// output trims surrounding whitespace, matching the original substring
 	// expander so existing configs render identically.
 	fm["output"] = func(name string) string {
-		return strings.TrimSpace(data.Outputs[name].Output)
+		return strings.TrimSpace(data.Outputs[data.qualify(name)].Output)
 	}
 	// out returns the full RunResult so templates can read individual fields,
 	// e.g. (out "x").ExitCode or (out "x").Status.
 	fm["out"] = func(name string) result.RunResult {
-		return data.Outputs[name]
+		return data.Outputs[data.qualify(name)]
 	}
 	fm["env"] = func(key string) string { return data.Env[key] }
+	fm["flow"] = func() FlowState { return data.Flow }
+	fm["matrix"] = func(axis string) (string, error) {
+		v, ok := data.Matrix[axis]
+		if !ok {
+			return "", fmt.Errorf("matrix axis %q is not defined here", axis)
+		}
+		return v, nil
+	}
+	fm["arg"] = func(name string) (string, error) {
+		v, ok := data.Args[name]
+		if !ok {
+			return "", fmt.Errorf("arg %q is not declared by flow %q", name, data.Flow.Name)
+		}
+		return v, nil
+	}
 	return fm
 }
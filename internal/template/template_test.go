@@ -208,6 +208,10 @@ func TestOutNewFunction(t *testing.T) {
 				ExitCode:   0,
 				DurationMs: 73,
 				Status:     "ok",
+				Commands: []result.CommandResult{
+					{Command: "setup", DurationMs: 70},
+					{Command: "check", Stdout: "pass", DurationMs: 3},
+				},
 			},
 		},
 	}
@@ -221,6 +225,8 @@ func TestOutNewFunction(t *testing.T) {
 		{`{{ (out "test").ExitCode }}`, "0"},
 		{`{{ (out "test").DurationMs }}`, "73"},
 		{`{{ (out "test").Status }}`, "ok"},
+		{`{{ (index (out "test").Commands 1).Stdout }}`, "pass"},
+		{`{{ range (out "test").Commands }}{{ .Command }}={{ .DurationMs }} {{ end }}`, "setup=70 check=3 "},
 	}
 	for _, tc := range cases {
 		t.Run(tc.expr, func(t *testing.T) {
@@ -242,3 +248,50 @@ func TestOutForMissingGroup(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "", got, "missing group should yield zero RunResult, empty Status")
 }
+
+func TestExpand_FlowState(t *testing.T) {
+	t.Parallel()
+	data := Data{Flow: FlowState{Name: "ci", Status: "failed", Err: "step 2: boom"}}
+	got, err := NewExpander().Expand(`{{ (flow).Name }}:{{ if eq (flow).Status "failed" }}{{ (flow).Err }}{{ end }}`, data)
+	require.NoError(t, err)
+	assert.Equal(t, "ci:step 2: boom", got)
+
+	refs, err := Refs(`{{ (flow).Status }} {{ output "a" }}`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, refs)
+}
+
+func TestExpand_Matrix(t *testing.T) {
+	t.Parallel()
+	data := Data{Matrix: map[string]string{"go": "1.22"}}
+	got, err := NewExpander().Expand(`go{{ matrix "go" }}`, data)
+	require.NoError(t, err)
+	assert.Equal(t, "go1.22", got)
+
+	_, err = NewExpander().Expand(`{{ matrix "os" }}`, data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `matrix axis "os" is not defined`)
+}
+
+func TestExpand_NamespaceQualifiesOutputs(t *testing.T) {
+	t.Parallel()
+	data := Data{
+		Outputs:   map[string]result.RunResult{"web:lib": {Output: "lib\n"}, "lib": {Output: "root"}},
+		Namespace: "web",
+	}
+	got, err := NewExpander().Expand(`{{ output "lib" }}/{{ (out "lib").Output }}`, data)
+	require.NoError(t, err)
+	assert.Equal(t, "lib/lib\n", got)
+}
+
+func TestExpand_Arg(t *testing.T) {
+	t.Parallel()
+	data := Data{Flow: FlowState{Name: "release"}, Args: map[string]string{"version": "1.4.0"}}
+	got, err := NewExpander().Expand(`v{{ arg "version" }}`, data)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.4.0", got)
+
+	_, err = NewExpander().Expand(`{{ arg "channel" }}`, data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `arg "channel" is not declared by flow "release"`)
+}